@@ -8,13 +8,19 @@ import (
 	"os/signal"
 	"time"
 
+	"stockchallenge/backend/internal/alerts"
 	"stockchallenge/backend/internal/api"
 	"stockchallenge/backend/internal/config"
+	"stockchallenge/backend/internal/corpactions"
 	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/digest"
+	"stockchallenge/backend/internal/events"
 	"stockchallenge/backend/internal/ingest"
 	"stockchallenge/backend/internal/marketdata"
 	"stockchallenge/backend/internal/portfolio"
 	"stockchallenge/backend/internal/rec"
+	"stockchallenge/backend/internal/securities"
+	"stockchallenge/backend/internal/webhooks"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -51,10 +57,36 @@ func main() {
 	}
 
 	// Services
+	bus := events.NewBus(0)
 	ing := ingest.NewService(cfg.APIBase, cfg.APIToken, pool, sugar)
-	portSvc, err := portfolio.NewService(pool, sugar, cfg.GeminiAPIKey, cfg.GeminiModelID)
+	ing.SetEvents(bus)
+	interruptCtx, interruptCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if n, err := ing.MarkInterrupted(interruptCtx); err != nil {
+		sugar.Warnf("closing interrupted ingest runs: %v", err)
+	} else if n > 0 {
+		sugar.Infof("marked %d interrupted ingest run(s) as failed", n)
+	}
+	interruptCancel()
+	extractor, err := newExtractor(cfg)
 	if err != nil {
-		sugar.Fatalf("portfolio service error: %v", err)
+		// Screenshot upload is optional; the rest of the API must still come up.
+		sugar.Warnf("portfolio extractor %q unavailable, screenshot upload disabled: %v", cfg.PortfolioExtractor, err)
+		extractor = nil
+	} else if extractor == nil {
+		sugar.Infof("no portfolio extractor configured, screenshot upload disabled")
+	}
+	portSvc := portfolio.NewService(pool, sugar, extractor)
+	portSvc.SetBaseCurrency(cfg.BaseCurrency)
+	portSvc.SetFXProvider(marketdata.NewFrankfurterClient(cfg.FXAPIBase), cfg.FXTTL)
+	actions := corpactions.NewService(pool, sugar)
+	secs := securities.NewService(pool, sugar)
+	seedSecurities(secs, cfg.SecuritiesSeedFile, sugar)
+	portSvc.SetSectorSource(secs)
+	if cfg.FMPAPIKey != "" {
+		fmp := marketdata.NewFMPClient(cfg.FMPAPIKey)
+		portSvc.SetDividendProvider(fmp)
+		actions.SetProvider(fmp)
+		secs.SetProvider(fmp)
 	}
 	recommender := rec.NewService(pool)
 
@@ -76,7 +108,10 @@ func main() {
 	// Optionally run ingestion on start
 	if cfg.IngestOnStart {
 		go func() {
-			if err := ing.RunOnce(context.Background()); err != nil {
+			// Bounded like cron runs, so a hung upstream cannot hold the run slot forever.
+			ctx, cancel := context.WithTimeout(context.Background(), 2*cfg.IngestInterval)
+			defer cancel()
+			if _, err := ing.Run(ctx, ingest.TriggerStartup); err != nil {
 				sugar.Warnf("initial ingest error: %v", err)
 			}
 		}()
@@ -113,8 +148,57 @@ func main() {
 		}
 	}()
 
+	// Start daily portfolio snapshots (backfills from the ledger on startup)
+	snapshotStop := make(chan struct{})
+	go portfolio.StartSnapshotCron(portSvc, cfg.SnapshotInterval, sugar, snapshotStop)
+
+	// Sync dividends and record payments on held positions
+	dividendStop := make(chan struct{})
+	go portfolio.StartDividendCron(portSvc, cfg.DividendSyncInterval, sugar, dividendStop)
+
+	// Apply splits and ticker changes to positions, targets and cached prices
+	actionsStop := make(chan struct{})
+	go corpactions.StartCron(actions, cfg.CorporateActionsInterval, sugar, actionsStop)
+
+	// Fill in missing or stale sector/industry reference data from the provider
+	securitiesStop := make(chan struct{})
+	go securities.StartCron(secs, cfg.SecuritiesSyncInterval, sugar, securitiesStop)
+
+	// Publish cached quote and fundamentals updates, whoever wrote them, to /api/stream
+	watchStop := make(chan struct{})
+	go rec.StartCacheWatch(rec.NewCacheWatcher(pool, bus), cfg.EventsPollInterval, sugar, watchStop)
+
+	// Evaluate alert rules as ratings and quotes change
+	alertSvc := alerts.NewService(pool, sugar, recommender)
+	alertSvc.SetEvents(bus)
+	alertsStop := make(chan struct{})
+	go alerts.Run(alertSvc, bus, sugar, alertsStop)
+
+	// Queue events for webhook subscribers and deliver them, retrying failures
+	webhookSvc := webhooks.NewService(pool, sugar)
+	if err := webhookSvc.AllowNetworks(cfg.WebhookAllowedNetworks); err != nil {
+		sugar.Fatalf("invalid WEBHOOK_ALLOWED_NETWORKS: %v", err)
+	}
+	webhooksStop := make(chan struct{})
+	go webhooks.Run(webhookSvc, bus, cfg.WebhookRetryInterval, sugar, webhooksStop)
+
+	// Email digest: previewable always, sent daily when SMTP is configured
+	var mailer digest.Mailer
+	if cfg.SMTPHost != "" {
+		mailer = &digest.SMTPMailer{Host: cfg.SMTPHost, Port: cfg.SMTPPort, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.SMTPFrom}
+	} else {
+		sugar.Infof("SMTP_HOST not set, email digest disabled")
+	}
+	digestSvc := digest.NewService(pool, sugar, recommender, mailer, cfg.PublicURL)
+	digestSvc.Hour = cfg.DigestHour
+	digestStop := make(chan struct{})
+	if mailer != nil {
+		go digest.StartCron(digestSvc, 15*time.Minute, sugar, digestStop)
+	}
+
 	// HTTP router
-	router := api.NewRouter(pool, ing, recommender, portSvc, sugar, cfg.FundamentalsAPIBase)
+	router := api.NewRouter(pool, ing, recommender, portSvc, sugar, cfg.FundamentalsAPIBase, api.WithEvents(bus), api.WithDigest(digestSvc),
+		api.WithCorporateActions(actions), api.WithSecurities(secs), api.WithAlerts(alertSvc), api.WithWebhooks(webhookSvc))
 
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.BackendPort),
@@ -130,6 +214,14 @@ func main() {
 		<-c
 		close(cronStop)
 		close(warmStop)
+		close(snapshotStop)
+		close(dividendStop)
+		close(actionsStop)
+		close(securitiesStop)
+		close(watchStop)
+		close(alertsStop)
+		close(webhooksStop)
+		close(digestStop)
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		_ = srv.Shutdown(ctx)
@@ -143,3 +235,50 @@ func main() {
 
 	<-idleConnsClosed
 }
+
+// seedSecurities loads the bundled reference data and then the optional SECURITIES_SEED_FILE.
+// Existing rows are kept, so later imports and provider updates win. Failures are only logged.
+func seedSecurities(secs *securities.Service, path string, log *zap.SugaredLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := secs.SeedDefaults(ctx); err != nil {
+		log.Warnf("seed securities: %v", err)
+	}
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Warnf("open securities seed file: %v", err)
+		return
+	}
+	defer f.Close()
+	rows, err := securities.ParseCSV(f)
+	if err != nil {
+		log.Warnf("parse securities seed file %s: %v", path, err)
+		return
+	}
+	n, err := secs.Seed(ctx, rows)
+	if err != nil {
+		log.Warnf("seed securities from %s: %v", path, err)
+		return
+	}
+	log.Infof("seeded %d securities from %s", n, path)
+}
+
+// newExtractor builds the screenshot extractor selected by PORTFOLIO_EXTRACTOR.
+// A nil extractor with a nil error means extraction is intentionally disabled.
+func newExtractor(cfg *config.Config) (portfolio.Extractor, error) {
+	switch cfg.PortfolioExtractor {
+	case portfolio.ExtractorGemini:
+		return portfolio.NewGeminiExtractor(context.Background(), cfg.GeminiAPIKey, cfg.GeminiModelID)
+	case portfolio.ExtractorOpenAI:
+		return portfolio.NewOpenAIExtractor(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, cfg.OpenAIModel)
+	case portfolio.ExtractorFixture:
+		return portfolio.NewFixtureExtractor(cfg.ExtractorFixturesDir)
+	case portfolio.ExtractorNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown extractor %q", cfg.PortfolioExtractor)
+	}
+}
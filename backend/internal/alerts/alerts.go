@@ -0,0 +1,644 @@
+// Package alerts stores user-defined alert rules and evaluates them as ratings and prices
+// change. A background worker follows the event bus: quote and fundamentals updates and
+// ingest runs re-evaluate the price rules of the tickers concerned, and rating changes the
+// rating rules. Triggered alerts are recorded for the feed and published on the bus.
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/events"
+	"stockchallenge/backend/internal/rec"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Rule kinds.
+const (
+	// KindPriceAbove and KindPriceBelow trigger when the cached price crosses Threshold.
+	KindPriceAbove = "price_above"
+	KindPriceBelow = "price_below"
+	// KindUpsideAbove triggers when target_to / price - 1 rises above Threshold (0.2 = 20%).
+	KindUpsideAbove = "upside_above"
+	// KindBelowIntrinsic triggers when the price falls below the Graham intrinsic value,
+	// by at least the optional Threshold margin (0.1 = 10% below).
+	KindBelowIntrinsic = "below_intrinsic"
+	// KindRatingDowngrade triggers when Brokerage downgrades a stock.
+	KindRatingDowngrade = "rating_downgrade"
+	// KindNewCoverage triggers when a watchlist ticker gets its first rating or an initiation.
+	KindNewCoverage = "new_coverage"
+)
+
+// DefaultCooldown is how long a rule stays quiet for a ticker after triggering, unless the
+// rule sets its own.
+const DefaultCooldown = 24 * time.Hour
+
+var (
+	tickerPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-/]{0,11}$`)
+	uuidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Rule is an alert rule. Ticker is nil for rules that watch every ticker.
+type Rule struct {
+	ID              string    `json:"id"`
+	Kind            string    `json:"kind"`
+	Ticker          *string   `json:"ticker"`
+	Threshold       *float64  `json:"threshold"`
+	Brokerage       *string   `json:"brokerage"`
+	CooldownSeconds int       `json:"cooldown_seconds"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (r Rule) cooldown() time.Duration {
+	return time.Duration(r.CooldownSeconds) * time.Second
+}
+
+// InvalidRuleError reports why a rule was refused.
+type InvalidRuleError struct {
+	Reason string
+}
+
+func (e *InvalidRuleError) Error() string { return e.Reason }
+
+func (r *Rule) normalize() error {
+	r.Kind = strings.ToLower(strings.TrimSpace(r.Kind))
+	if r.Ticker != nil {
+		t := strings.ToUpper(strings.TrimSpace(*r.Ticker))
+		if t == "" {
+			r.Ticker = nil
+		} else if !tickerPattern.MatchString(t) {
+			return &InvalidRuleError{fmt.Sprintf("invalid ticker %q", *r.Ticker)}
+		} else {
+			r.Ticker = &t
+		}
+	}
+	if r.Brokerage != nil {
+		b := strings.TrimSpace(*r.Brokerage)
+		r.Brokerage = &b
+		if b == "" {
+			r.Brokerage = nil
+		}
+	}
+	if r.Threshold != nil && (math.IsNaN(*r.Threshold) || math.IsInf(*r.Threshold, 0)) {
+		return &InvalidRuleError{"threshold must be a number"}
+	}
+	switch r.Kind {
+	case KindPriceAbove, KindPriceBelow:
+		if r.Ticker == nil {
+			return &InvalidRuleError{r.Kind + " needs a ticker"}
+		}
+		if r.Threshold == nil || *r.Threshold <= 0 {
+			return &InvalidRuleError{r.Kind + " needs a positive threshold price"}
+		}
+	case KindUpsideAbove:
+		if r.Threshold == nil {
+			return &InvalidRuleError{"upside_above needs a threshold, e.g. 0.2 for 20%"}
+		}
+	case KindBelowIntrinsic:
+		if r.Threshold != nil && (*r.Threshold < 0 || *r.Threshold >= 1) {
+			return &InvalidRuleError{"below_intrinsic threshold is a margin between 0 and 1"}
+		}
+	case KindRatingDowngrade:
+		if r.Brokerage == nil {
+			return &InvalidRuleError{"rating_downgrade needs a brokerage"}
+		}
+	case KindNewCoverage:
+	default:
+		return &InvalidRuleError{"kind must be one of price_above, price_below, upside_above, below_intrinsic, rating_downgrade, new_coverage"}
+	}
+	if r.CooldownSeconds < 0 {
+		return &InvalidRuleError{"cooldown_seconds must not be negative"}
+	}
+	if r.CooldownSeconds == 0 {
+		r.CooldownSeconds = int(DefaultCooldown / time.Second)
+	}
+	return nil
+}
+
+// Event is a triggered alert.
+type Event struct {
+	ID          string    `json:"id"`
+	AlertID     string    `json:"alert_id"`
+	Kind        string    `json:"kind"`
+	Ticker      string    `json:"ticker"`
+	Message     string    `json:"message"`
+	Value       *float64  `json:"value"`
+	TriggeredAt time.Time `json:"triggered_at"`
+	// UserID owns the rule; it is not serialized, the feed is already per user.
+	UserID string `json:"-"`
+}
+
+// Valuer returns cached prices and valuations; implemented by rec.Service.
+type Valuer interface {
+	EnrichBatch(ctx context.Context, tickers []string) (map[string]rec.Enrichment, error)
+}
+
+// Service stores rules and evaluates them.
+type Service struct {
+	DB     db.DBTX
+	Log    *zap.SugaredLogger
+	Values Valuer
+	events events.Publisher
+	now    func() time.Time
+}
+
+func NewService(db db.DBTX, log *zap.SugaredLogger, values Valuer) *Service {
+	return &Service{DB: db, Log: log, Values: values, now: time.Now}
+}
+
+// SetEvents publishes an alert event for every triggered alert.
+func (s *Service) SetEvents(p events.Publisher) { s.events = p }
+
+const ruleCols = `id::STRING, kind, ticker, threshold, brokerage, cooldown_seconds, enabled, created_at`
+
+func scanRules(rows pgx.Rows) ([]Rule, error) {
+	defer rows.Close()
+	out := []Rule{}
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Ticker, &r.Threshold, &r.Brokerage, &r.CooldownSeconds, &r.Enabled, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// Create validates and stores a rule for userID.
+func (s *Service) Create(ctx context.Context, userID string, r Rule) (*Rule, error) {
+	if err := r.normalize(); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query(ctx, `
+INSERT INTO alerts (user_id, kind, ticker, threshold, brokerage, cooldown_seconds, enabled)
+VALUES ($1, $2, $3, $4, $5, $6, true)
+RETURNING `+ruleCols, userID, r.Kind, r.Ticker, r.Threshold, r.Brokerage, r.CooldownSeconds)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := scanRules(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &rules[0], nil
+}
+
+// List returns userID's rules, oldest first.
+func (s *Service) List(ctx context.Context, userID string) ([]Rule, error) {
+	rows, err := s.DB.Query(ctx, `SELECT `+ruleCols+` FROM alerts WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRules(rows)
+}
+
+// Delete removes a rule and its state. It returns pgx.ErrNoRows when userID has no such rule.
+func (s *Service) Delete(ctx context.Context, userID, id string) error {
+	if !uuidPattern.MatchString(id) {
+		return pgx.ErrNoRows
+	}
+	tag, err := s.DB.Exec(ctx, `DELETE FROM alerts WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	_, err = s.DB.Exec(ctx, `DELETE FROM alert_state WHERE alert_id = $1`, id)
+	return err
+}
+
+// EventQuery filters the alert feed.
+type EventQuery struct {
+	Since   time.Time // only alerts triggered after this
+	AlertID string
+	Limit   int
+}
+
+// Events returns userID's triggered alerts, newest first.
+func (s *Service) Events(ctx context.Context, userID string, q EventQuery) ([]Event, error) {
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	rows, err := s.DB.Query(ctx, `
+SELECT id::STRING, alert_id::STRING, kind, ticker, message, value, triggered_at
+FROM alert_events
+WHERE user_id = $1 AND triggered_at > $2 AND ($3 = '' OR alert_id::STRING = $3)
+ORDER BY triggered_at DESC, id
+LIMIT $4
+`, userID, q.Since, q.AlertID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.AlertID, &e.Kind, &e.Ticker, &e.Message, &e.Value, &e.TriggeredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// owned is a rule with the user it belongs to, as the evaluator reads them.
+type owned struct {
+	Rule
+	userID string
+}
+
+func (s *Service) rules(ctx context.Context, kinds []string, tickers []string) ([]owned, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT user_id::STRING, `+ruleCols+`
+FROM alerts
+WHERE enabled AND kind = ANY($1) AND (ticker IS NULL OR ticker = ANY($2))
+`, kinds, tickers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []owned
+	for rows.Next() {
+		var r owned
+		if err := rows.Scan(&r.userID, &r.ID, &r.Kind, &r.Ticker, &r.Threshold, &r.Brokerage, &r.CooldownSeconds, &r.Enabled, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+type stateKey struct{ alertID, ticker string }
+
+type state struct {
+	met         bool
+	triggeredAt *time.Time
+}
+
+func (s *Service) states(ctx context.Context, ids []string) (map[stateKey]state, error) {
+	out := map[stateKey]state{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := s.DB.Query(ctx, `SELECT alert_id::STRING, ticker, met, triggered_at FROM alert_state WHERE alert_id = ANY($1::UUID[])`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k stateKey
+		var st state
+		if err := rows.Scan(&k.alertID, &k.ticker, &st.met, &st.triggeredAt); err != nil {
+			return nil, err
+		}
+		out[k] = st
+	}
+	return out, rows.Err()
+}
+
+func (s *Service) saveState(ctx context.Context, k stateKey, st state) error {
+	_, err := s.DB.Exec(ctx, `
+UPSERT INTO alert_state (alert_id, ticker, met, triggered_at, updated_at)
+VALUES ($1, $2, $3, $4, now())
+`, k.alertID, k.ticker, st.met, st.triggeredAt)
+	return err
+}
+
+// trigger records an event unless one with the same dedup key exists, and publishes it. It
+// reports whether the event is new.
+func (s *Service) trigger(ctx context.Context, r owned, ticker, message string, value *float64, dedupKey string) (bool, error) {
+	rows, err := s.DB.Query(ctx, `
+INSERT INTO alert_events (alert_id, user_id, kind, ticker, message, value, dedup_key)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (alert_id, dedup_key) DO NOTHING
+RETURNING id::STRING, triggered_at
+`, r.ID, r.userID, r.Kind, ticker, message, value, dedupKey)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	e := Event{AlertID: r.ID, Kind: r.Kind, Ticker: ticker, Message: message, Value: value, UserID: r.userID}
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	if err := rows.Scan(&e.ID, &e.TriggeredAt); err != nil {
+		return false, err
+	}
+	if s.events != nil {
+		s.events.Publish(events.TypeAlert, ticker, e)
+	}
+	return true, nil
+}
+
+var levelKinds = []string{KindPriceAbove, KindPriceBelow, KindUpsideAbove, KindBelowIntrinsic}
+
+// EvaluateTickers checks the price rules of tickers against cached prices, valuations and
+// analyst targets. A rule triggers when its condition becomes true for a ticker, or is true
+// the first time it is checked, and not again until the condition has been false in between
+// and the cool-down has passed. It returns the number of alerts triggered.
+func (s *Service) EvaluateTickers(ctx context.Context, tickers []string) (int, error) {
+	if len(tickers) == 0 {
+		return 0, nil
+	}
+	rules, err := s.rules(ctx, levelKinds, tickers)
+	if err != nil || len(rules) == 0 {
+		return 0, err
+	}
+	values, err := s.Values.EnrichBatch(ctx, tickers)
+	if err != nil {
+		return 0, err
+	}
+	targets := map[string]*float64{}
+	for _, r := range rules {
+		if r.Kind == KindUpsideAbove {
+			if targets, err = s.targets(ctx, tickers); err != nil {
+				return 0, err
+			}
+			break
+		}
+	}
+	ids := make([]string, len(rules))
+	for i, r := range rules {
+		ids[i] = r.ID
+	}
+	states, err := s.states(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now().UTC()
+	n := 0
+	for _, r := range rules {
+		for _, ticker := range tickers {
+			if r.Ticker != nil && *r.Ticker != ticker {
+				continue
+			}
+			value, met, ok := condition(r.Rule, values[ticker], targets[ticker])
+			if !ok {
+				continue
+			}
+			k := stateKey{r.ID, ticker}
+			prev := states[k]
+			next := state{met: met, triggeredAt: prev.triggeredAt}
+			if met && !prev.met && (prev.triggeredAt == nil || now.Sub(*prev.triggeredAt) >= r.cooldown()) {
+				// One event per rule, ticker and minute, however many workers see the change.
+				key := ticker + ":" + now.Truncate(time.Minute).Format(time.RFC3339)
+				fired, err := s.trigger(ctx, r, ticker, levelMessage(r.Rule, ticker, value), &value, key)
+				if err != nil {
+					return n, err
+				}
+				if fired {
+					n++
+					next.triggeredAt = &now
+				}
+			}
+			if next != prev {
+				if err := s.saveState(ctx, k, next); err != nil {
+					return n, err
+				}
+				states[k] = next
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *Service) targets(ctx context.Context, tickers []string) (map[string]*float64, error) {
+	rows, err := s.DB.Query(ctx, `SELECT ticker, target_to FROM stocks WHERE ticker = ANY($1)`, tickers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]*float64{}
+	for rows.Next() {
+		var t string
+		var target *float64
+		if err := rows.Scan(&t, &target); err != nil {
+			return nil, err
+		}
+		out[t] = target
+	}
+	return out, rows.Err()
+}
+
+// condition returns the value r compares and whether its condition holds; ok is false when
+// the data it needs is missing.
+func condition(r Rule, e rec.Enrichment, target *float64) (value float64, met, ok bool) {
+	if e.Price == nil || *e.Price <= 0 {
+		return 0, false, false
+	}
+	price := *e.Price
+	threshold := 0.0
+	if r.Threshold != nil {
+		threshold = *r.Threshold
+	}
+	switch r.Kind {
+	case KindPriceAbove:
+		return price, price >= threshold, true
+	case KindPriceBelow:
+		return price, price <= threshold, true
+	case KindUpsideAbove:
+		up := e.Upside(target)
+		if up == nil {
+			return 0, false, false
+		}
+		return *up, *up >= threshold, true
+	case KindBelowIntrinsic:
+		if e.Intrinsic == nil || *e.Intrinsic <= 0 {
+			return 0, false, false
+		}
+		return price, price < *e.Intrinsic*(1-threshold), true
+	}
+	return 0, false, false
+}
+
+func levelMessage(r Rule, ticker string, value float64) string {
+	switch r.Kind {
+	case KindPriceAbove:
+		return fmt.Sprintf("%s rose to %.2f, at or above %.2f", ticker, value, *r.Threshold)
+	case KindPriceBelow:
+		return fmt.Sprintf("%s fell to %.2f, at or below %.2f", ticker, value, *r.Threshold)
+	case KindUpsideAbove:
+		return fmt.Sprintf("%s upside to the analyst target is %.1f%%, above %.1f%%", ticker, value*100, *r.Threshold*100)
+	default:
+		return fmt.Sprintf("%s at %.2f is below its Graham intrinsic value", ticker, value)
+	}
+}
+
+var ratingKinds = []string{KindRatingDowngrade, KindNewCoverage}
+
+// EvaluateRating checks the rating rules against one rating change, subject to the same
+// cool-down as price rules. It returns the number of alerts triggered.
+func (s *Service) EvaluateRating(ctx context.Context, c events.RatingChange) (int, error) {
+	downgrade := strings.Contains(strings.ToLower(c.Action), "downgrade")
+	coverage := c.New || strings.Contains(strings.ToLower(c.Action), "initiated")
+	if !downgrade && !coverage {
+		return 0, nil
+	}
+	rules, err := s.rules(ctx, ratingKinds, []string{c.Ticker})
+	if err != nil || len(rules) == 0 {
+		return 0, err
+	}
+	var watched *bool
+	ids := make([]string, len(rules))
+	for i, r := range rules {
+		ids[i] = r.ID
+	}
+	states, err := s.states(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now().UTC()
+	n := 0
+	for _, r := range rules {
+		var message string
+		switch {
+		case r.Kind == KindRatingDowngrade && downgrade && strings.EqualFold(*r.Brokerage, c.Brokerage):
+			message = fmt.Sprintf("%s downgraded %s from %s to %s", c.Brokerage, c.Ticker, c.RatingFrom, c.RatingTo)
+		case r.Kind == KindNewCoverage && coverage:
+			if watched == nil {
+				var on bool
+				if err := s.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM watchlist WHERE ticker = $1)`, c.Ticker).Scan(&on); err != nil {
+					return n, err
+				}
+				watched = &on
+			}
+			if !*watched {
+				continue
+			}
+			message = fmt.Sprintf("%s started covering %s with %s", c.Brokerage, c.Ticker, c.RatingTo)
+		default:
+			continue
+		}
+		k := stateKey{r.ID, c.Ticker}
+		prev := states[k]
+		if prev.triggeredAt != nil && now.Sub(*prev.triggeredAt) < r.cooldown() {
+			continue
+		}
+		changed := ""
+		if c.ChangedAt != nil {
+			changed = c.ChangedAt.UTC().Format(time.RFC3339)
+		}
+		key := strings.Join([]string{c.Ticker, c.Brokerage, c.RatingFrom, c.RatingTo, changed}, "|")
+		fired, err := s.trigger(ctx, r, c.Ticker, message, c.TargetTo, key)
+		if err != nil {
+			return n, err
+		}
+		if fired {
+			n++
+			next := state{met: prev.met, triggeredAt: &now}
+			if err := s.saveState(ctx, k, next); err != nil {
+				return n, err
+			}
+			states[k] = next
+		}
+	}
+	return n, nil
+}
+
+// allTickers returns every ticker a price rule can apply to: the rule's own, or every rated
+// stock for rules without one.
+func (s *Service) allTickers(ctx context.Context) ([]string, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT ticker FROM alerts WHERE enabled AND kind = ANY($1) AND ticker IS NOT NULL
+UNION
+SELECT ticker FROM stocks WHERE EXISTS (SELECT 1 FROM alerts WHERE enabled AND kind = ANY($1) AND ticker IS NULL)
+`, levelKinds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// EvaluateAll checks the price rules of every ticker they apply to, for startup and for
+// catching up after missed events.
+func (s *Service) EvaluateAll(ctx context.Context) (int, error) {
+	tickers, err := s.allTickers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.EvaluateTickers(ctx, tickers)
+}
+
+// Handle evaluates the rules an event can affect.
+func (s *Service) Handle(ctx context.Context, e events.Event) (int, error) {
+	switch e.Type {
+	case events.TypeQuote:
+		return s.EvaluateTickers(ctx, []string{e.Ticker})
+	case events.TypeRecommendationUpdate:
+		u, _ := e.Data.(events.RecommendationUpdate)
+		if len(u.Tickers) == 0 {
+			return s.EvaluateAll(ctx)
+		}
+		return s.EvaluateTickers(ctx, u.Tickers)
+	case events.TypeRatingChange:
+		c, ok := e.Data.(events.RatingChange)
+		if !ok {
+			return 0, errors.New("rating_change event without a RatingChange")
+		}
+		return s.EvaluateRating(ctx, c)
+	}
+	return 0, nil
+}
+
+// Run evaluates rules as events arrive on bus until stop is closed. It checks every rule on
+// startup and again whenever it fell behind and missed events.
+func Run(svc *Service, bus *events.Bus, log *zap.SugaredLogger, stop <-chan struct{}) {
+	sweep := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if n, err := svc.EvaluateAll(ctx); err != nil {
+			log.Warnf("alerts evaluation error: %v", err)
+		} else if n > 0 {
+			log.Infof("alerts: %d triggered", n)
+		}
+	}
+	sub := bus.Subscribe(0)
+	defer func() { sub.Close() }()
+	sweep()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				log.Warnf("alerts worker fell behind the event bus; re-evaluating all rules")
+				sub = bus.Subscribe(0)
+				sweep()
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := svc.Handle(ctx, e)
+			cancel()
+			if err != nil {
+				log.Warnf("alerts evaluation error for %s %s: %v", e.Type, e.Ticker, err)
+			} else if n > 0 {
+				log.Infof("alerts: %d triggered by %s %s", n, e.Type, e.Ticker)
+			}
+		case <-stop:
+			log.Infof("alerts worker stopped")
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package alerts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/events"
+	"stockchallenge/backend/internal/rec"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeValues map[string]rec.Enrichment
+
+func (f fakeValues) EnrichBatch(_ context.Context, tickers []string) (map[string]rec.Enrichment, error) {
+	out := map[string]rec.Enrichment{}
+	for _, t := range tickers {
+		if e, ok := f[t]; ok {
+			out[t] = e
+		}
+	}
+	return out, nil
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var ruleRowCols = []string{"user_id", "id", "kind", "ticker", "threshold", "brokerage", "cooldown_seconds", "enabled", "created_at"}
+
+func TestRuleValidation(t *testing.T) {
+	for _, tc := range []struct {
+		rule Rule
+		err  string
+	}{
+		{Rule{Kind: "Price_Above", Ticker: ptr(" aapl "), Threshold: ptr(200.0)}, ""},
+		{Rule{Kind: KindPriceBelow, Threshold: ptr(200.0)}, "price_below needs a ticker"},
+		{Rule{Kind: KindPriceAbove, Ticker: ptr("AAPL"), Threshold: ptr(-1.0)}, "price_above needs a positive threshold price"},
+		{Rule{Kind: KindUpsideAbove}, "upside_above needs a threshold, e.g. 0.2 for 20%"},
+		{Rule{Kind: KindBelowIntrinsic, Threshold: ptr(1.5)}, "below_intrinsic threshold is a margin between 0 and 1"},
+		{Rule{Kind: KindRatingDowngrade, Brokerage: ptr("  ")}, "rating_downgrade needs a brokerage"},
+		{Rule{Kind: KindNewCoverage, Ticker: ptr("not a ticker")}, `invalid ticker "not a ticker"`},
+		{Rule{Kind: KindNewCoverage, CooldownSeconds: -1}, "cooldown_seconds must not be negative"},
+		{Rule{Kind: "volume_spike"}, "kind must be one of price_above, price_below, upside_above, below_intrinsic, rating_downgrade, new_coverage"},
+	} {
+		err := tc.rule.normalize()
+		if tc.err == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		var invalid *InvalidRuleError
+		if assert.ErrorAs(t, err, &invalid) {
+			assert.Equal(t, tc.err, invalid.Reason)
+		}
+	}
+
+	r := Rule{Kind: "Price_Above", Ticker: ptr(" aapl "), Threshold: ptr(200.0)}
+	require.NoError(t, r.normalize())
+	assert.Equal(t, KindPriceAbove, r.Kind)
+	assert.Equal(t, "AAPL", *r.Ticker)
+	assert.Equal(t, 86400, r.CooldownSeconds)
+}
+
+func TestEvaluateTickersTriggersOnCrossing(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	values := fakeValues{}
+	svc := NewService(mock, zap.NewNop().Sugar(), values)
+	bus := events.NewBus(0)
+	svc.SetEvents(bus)
+	sub := bus.Subscribe(4)
+	defer sub.Close()
+	now := time.Date(2025, 3, 3, 15, 30, 20, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	const user, id = "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c", "0a3c2b9e-8f4e-4c55-9a4c-7c2b58a9d001"
+	rules := func() {
+		mock.ExpectQuery(`FROM alerts\s+WHERE enabled AND kind = ANY`).WithArgs(levelKinds, []string{"AAPL"}).
+			WillReturnRows(pgxmock.NewRows(ruleRowCols).AddRow(user, id, KindPriceAbove, ptr("AAPL"), ptr(200.0), (*string)(nil), 3600, true, now))
+	}
+	stateCols := []string{"alert_id", "ticker", "met", "triggered_at"}
+
+	// Below the level: nothing happens, and the unchanged state is not written.
+	values["AAPL"] = rec.Enrichment{Price: ptr(190.0)}
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{id}).WillReturnRows(pgxmock.NewRows(stateCols))
+	n, err := svc.EvaluateTickers(ctx, []string{"AAPL"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Crossing it triggers once.
+	values["AAPL"] = rec.Enrichment{Price: ptr(205.0)}
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{id}).WillReturnRows(pgxmock.NewRows(stateCols))
+	mock.ExpectQuery(`INSERT INTO alert_events`).
+		WithArgs(id, user, KindPriceAbove, "AAPL", "AAPL rose to 205.00, at or above 200.00", ptr(205.0), "AAPL:2025-03-03T15:30:00Z").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "triggered_at"}).AddRow("e1", now))
+	mock.ExpectExec(`UPSERT INTO alert_state`).WithArgs(id, "AAPL", true, &now).WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	n, err = svc.EvaluateTickers(ctx, []string{"AAPL"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	e := <-sub.C
+	assert.Equal(t, events.TypeAlert, e.Type)
+	assert.Equal(t, Event{ID: "e1", AlertID: id, Kind: KindPriceAbove, Ticker: "AAPL", Message: "AAPL rose to 205.00, at or above 200.00", Value: ptr(205.0), TriggeredAt: now, UserID: user}, e.Data)
+
+	// Staying above does not trigger again.
+	values["AAPL"] = rec.Enrichment{Price: ptr(210.0)}
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{id}).
+		WillReturnRows(pgxmock.NewRows(stateCols).AddRow(id, "AAPL", true, &now))
+	n, err = svc.EvaluateTickers(ctx, []string{"AAPL"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Crossing again within the cool-down only records the state.
+	earlier := now.Add(-30 * time.Minute)
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{id}).
+		WillReturnRows(pgxmock.NewRows(stateCols).AddRow(id, "AAPL", false, &earlier))
+	mock.ExpectExec(`UPSERT INTO alert_state`).WithArgs(id, "AAPL", true, &earlier).WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	n, err = svc.EvaluateTickers(ctx, []string{"AAPL"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCondition(t *testing.T) {
+	e := rec.Enrichment{Price: ptr(100.0), Intrinsic: ptr(150.0)}
+	for _, tc := range []struct {
+		rule   Rule
+		target *float64
+		value  float64
+		met    bool
+		ok     bool
+	}{
+		{Rule{Kind: KindPriceBelow, Threshold: ptr(100.0)}, nil, 100, true, true},
+		{Rule{Kind: KindUpsideAbove, Threshold: ptr(0.25)}, ptr(120.0), 0.2, false, true},
+		{Rule{Kind: KindUpsideAbove, Threshold: ptr(0.25)}, nil, 0, false, false},
+		{Rule{Kind: KindBelowIntrinsic}, nil, 100, true, true},
+		{Rule{Kind: KindBelowIntrinsic, Threshold: ptr(0.4)}, nil, 100, false, true},
+	} {
+		value, met, ok := condition(tc.rule, e, tc.target)
+		assert.InDelta(t, tc.value, value, 1e-9, tc.rule.Kind)
+		assert.Equal(t, tc.met, met, tc.rule.Kind)
+		assert.Equal(t, tc.ok, ok, tc.rule.Kind)
+	}
+	_, _, ok := condition(Rule{Kind: KindPriceAbove, Threshold: ptr(1.0)}, rec.Enrichment{}, nil)
+	assert.False(t, ok, "no cached price")
+}
+
+func TestEvaluateRating(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, zap.NewNop().Sugar(), fakeValues{})
+	now := time.Date(2025, 3, 3, 15, 30, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	const user, downgrade, coverage = "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c", "0a3c2b9e-8f4e-4c55-9a4c-7c2b58a9d001", "0a3c2b9e-8f4e-4c55-9a4c-7c2b58a9d002"
+	rules := func() {
+		mock.ExpectQuery(`FROM alerts\s+WHERE enabled AND kind = ANY`).WithArgs(ratingKinds, []string{"NVDA"}).
+			WillReturnRows(pgxmock.NewRows(ruleRowCols).
+				AddRow(user, downgrade, KindRatingDowngrade, (*string)(nil), (*float64)(nil), ptr("UBS"), 86400, true, now).
+				AddRow(user, coverage, KindNewCoverage, (*string)(nil), (*float64)(nil), (*string)(nil), 86400, true, now))
+	}
+	stateCols := []string{"alert_id", "ticker", "met", "triggered_at"}
+	changed := time.Date(2025, 3, 3, 13, 0, 0, 0, time.UTC)
+
+	// Only the named brokerage's downgrades count.
+	n, err := svc.EvaluateRating(ctx, events.RatingChange{Ticker: "NVDA", Brokerage: "UBS", Action: "upgraded by"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	c := events.RatingChange{Ticker: "NVDA", Brokerage: "ubs", Action: "downgraded by", RatingFrom: "Buy", RatingTo: "Neutral", ChangedAt: &changed}
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{downgrade, coverage}).WillReturnRows(pgxmock.NewRows(stateCols))
+	mock.ExpectQuery(`INSERT INTO alert_events`).
+		WithArgs(downgrade, user, KindRatingDowngrade, "NVDA", "ubs downgraded NVDA from Buy to Neutral", (*float64)(nil), "NVDA|ubs|Buy|Neutral|2025-03-03T13:00:00Z").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "triggered_at"}).AddRow("e1", now))
+	mock.ExpectExec(`UPSERT INTO alert_state`).WithArgs(downgrade, "NVDA", false, &now).WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	n, err = svc.EvaluateRating(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Within the cool-down another downgrade is ignored; new coverage needs the watchlist.
+	c.New = true
+	rules()
+	mock.ExpectQuery(`FROM alert_state`).WithArgs([]string{downgrade, coverage}).
+		WillReturnRows(pgxmock.NewRows(stateCols).AddRow(downgrade, "NVDA", false, &now))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM watchlist`).WithArgs("NVDA").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	n, err = svc.EvaluateRating(ctx, c)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
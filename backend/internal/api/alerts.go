@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"stockchallenge/backend/internal/alerts"
+
+	"github.com/gin-gonic/gin"
+)
+
+// alertIn is the request shape for createAlert; see alerts.Rule for what each kind needs.
+type alertIn struct {
+	Kind            string   `json:"kind" binding:"required"`
+	Ticker          *string  `json:"ticker"`
+	Threshold       *float64 `json:"threshold"`
+	Brokerage       *string  `json:"brokerage"`
+	CooldownSeconds int      `json:"cooldown_seconds"`
+}
+
+func (h *RouterDeps) listAlerts(c *gin.Context) {
+	items, err := h.Alerts.List(c.Request.Context(), defaultUserID)
+	if err != nil {
+		h.Log.Warnf("list alerts failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *RouterDeps) createAlert(c *gin.Context) {
+	var body alertIn
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, http.StatusBadRequest, "kind is required")
+		return
+	}
+	rule, err := h.Alerts.Create(c.Request.Context(), defaultUserID, alerts.Rule{
+		Kind: body.Kind, Ticker: body.Ticker, Threshold: body.Threshold, Brokerage: body.Brokerage, CooldownSeconds: body.CooldownSeconds,
+	})
+	var invalid *alerts.InvalidRuleError
+	if errors.As(err, &invalid) {
+		writeError(c, http.StatusBadRequest, invalid.Reason)
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("create alert failed: %v", err)
+		writeDBError(c, err, "failed to save alert")
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *RouterDeps) deleteAlert(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.Alerts.Delete(c.Request.Context(), defaultUserID, id); err != nil {
+		writeDBError(c, err, "failed to delete alert")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": "deleted"})
+}
+
+// listAlertEvents is the feed of triggered alerts, newest first: since= (YYYY-MM-DD or
+// RFC 3339) and alert_id= narrow it, limit= caps it (default 50, at most 200).
+func (h *RouterDeps) listAlertEvents(c *gin.Context) {
+	var q alerts.EventQuery
+	since, err := queryDate(c, "since")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if since != nil {
+		q.Since = *since
+	}
+	q.AlertID = c.Query("alert_id")
+	q.Limit = 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			writeError(c, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		q.Limit = n
+	}
+	items, err := h.Alerts.Events(c.Request.Context(), defaultUserID, q)
+	if err != nil {
+		h.Log.Warnf("list alert events failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
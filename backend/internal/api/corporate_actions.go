@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/corpactions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corporateActionIn is the request shape for a split or ticker change; ex_date is YYYY-MM-DD.
+type corporateActionIn struct {
+	Symbol    string   `json:"symbol" binding:"required"`
+	Type      string   `json:"type" binding:"required"`
+	ExDate    string   `json:"ex_date" binding:"required"`
+	RatioFrom *float64 `json:"ratio_from"`
+	RatioTo   *float64 `json:"ratio_to"`
+	NewSymbol *string  `json:"new_symbol"`
+}
+
+func (h *RouterDeps) listCorporateActions(c *gin.Context) {
+	items, err := h.Actions.List(c.Request.Context(), strings.ToLower(c.Query("status")))
+	if err != nil {
+		h.Log.Warnf("list corporate actions failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// addCorporateActions records one action or an array of them as pending.
+func (h *RouterDeps) addCorporateActions(c *gin.Context) {
+	raw, err := c.GetRawData()
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	var in []corporateActionIn
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(raw, &in)
+	} else {
+		var one corporateActionIn
+		err = json.Unmarshal(raw, &one)
+		in = append(in, one)
+	}
+	if err != nil || len(in) == 0 {
+		writeError(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	actions := make([]corpactions.Action, 0, len(in))
+	for i, a := range in {
+		d, err := time.Parse(dateLayout, a.ExDate)
+		if err != nil {
+			writeErrorDetails(c, http.StatusBadRequest, "ex_date must be YYYY-MM-DD", gin.H{"index": i})
+			return
+		}
+		actions = append(actions, corpactions.Action{Symbol: a.Symbol, Type: a.Type, ExDate: d, RatioFrom: a.RatioFrom, RatioTo: a.RatioTo, NewSymbol: a.NewSymbol})
+	}
+	h.saveCorporateActions(c, actions)
+}
+
+// importCorporateActions loads actions from a CSV (raw body or "file" multipart field).
+// See corpactions.ParseCSV for the columns.
+func (h *RouterDeps) importCorporateActions(c *gin.Context) {
+	data, err := readImportBody(c)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		writeError(c, http.StatusBadRequest, "csv file is required")
+		return
+	}
+	actions, err := corpactions.ParseCSV(bytes.NewReader(data))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.saveCorporateActions(c, actions)
+}
+
+func (h *RouterDeps) saveCorporateActions(c *gin.Context, actions []corpactions.Action) {
+	n, err := h.Actions.Add(c.Request.Context(), actions)
+	var invalid *corpactions.InvalidActionError
+	if errors.As(err, &invalid) {
+		writeErrorDetails(c, http.StatusBadRequest, invalid.Error(), gin.H{"index": invalid.Index})
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("save corporate actions failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save corporate actions")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"added": n, "received": len(actions)})
+}
+
+// applyCorporateActions applies every pending action whose ex-date has passed.
+func (h *RouterDeps) applyCorporateActions(c *gin.Context) {
+	applied, err := h.Actions.ApplyPending(c.Request.Context(), time.Now().UTC())
+	if err != nil {
+		h.Log.Warnf("apply corporate actions failed: %v", err)
+		writeErrorDetails(c, http.StatusInternalServerError, err.Error(), gin.H{"applied": applied})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"applied": applied})
+}
+
+func (h *RouterDeps) getCorporateActionLog(c *gin.Context) {
+	entries, err := h.Actions.AuditLog(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.Log.Warnf("corporate action log failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"stockchallenge/backend/internal/portfolio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getPortfolioSummary values holdings and cash in ?base= (defaults to BASE_CURRENCY).
+func (h *RouterDeps) getPortfolioSummary(c *gin.Context) {
+	sum, err := h.Portfolio.Summary(c.Request.Context(), defaultUserID, c.Query("base"))
+	if errors.Is(err, portfolio.ErrInvalidCurrency) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("portfolio summary failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to compute summary")
+		return
+	}
+	c.JSON(http.StatusOK, sum)
+}
+
+func (h *RouterDeps) getCashBalances(c *gin.Context) {
+	items, err := h.Portfolio.CashBalances(c.Request.Context(), defaultUserID)
+	if err != nil {
+		h.Log.Warnf("list cash failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// cashBalanceIn is the request shape for putCashBalance.
+type cashBalanceIn struct {
+	Amount *float64 `json:"amount" binding:"required"`
+}
+
+// putCashBalance sets the cash held in :currency. Body: {"amount": 1234.5}; 0 removes it.
+func (h *RouterDeps) putCashBalance(c *gin.Context) {
+	var body cashBalanceIn
+	if err := c.ShouldBindJSON(&body); err != nil || body.Amount == nil {
+		writeError(c, http.StatusBadRequest, "amount is required")
+		return
+	}
+	bal, err := h.Portfolio.SetCashBalance(c.Request.Context(), defaultUserID, c.Param("currency"), *body.Amount)
+	if errors.Is(err, portfolio.ErrInvalidCurrency) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("set cash failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save cash balance")
+		return
+	}
+	c.JSON(http.StatusOK, bal)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// stockCursor marks a position in the stock list: the sort value and id of the row to
+// continue from. Clients get it as an opaque token and pass it back with the same filters
+// and sort.
+type stockCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	// Value is the row's sort value as text, nil when it has none.
+	Value *string `json:"v"`
+	ID    string  `json:"id"`
+	// Prev walks backwards: the page ends just before this row.
+	Prev bool `json:"p,omitempty"`
+}
+
+func (c stockCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token string) (*stockCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c stockCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" || !sortable(c.Sort) {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// sortCasts turns cursor text back into the column's type so comparisons are exact.
+var sortCasts = map[string]string{
+	"updated_at":            "::TIMESTAMPTZ",
+	"last_rating_change_at": "::TIMESTAMPTZ",
+	"target_from":           "::DECIMAL",
+	"target_to":             "::DECIMAL",
+	"price_target_delta":    "::DECIMAL",
+	sortRelevance:           "::FLOAT8",
+}
+
+// nullableSortFields can be NULL and sort last whatever the order.
+var nullableSortFields = map[string]bool{"target_from": true, "target_to": true, "last_rating_change_at": true}
+
+func (q stockQuery) nullable() bool {
+	_, enriched := enrichedSortFields[q.Sort]
+	return enriched || nullableSortFields[q.Sort]
+}
+
+// keyset returns the condition for rows after q.Cursor in (sort, id) order, or before it for
+// a prev cursor. Placeholders continue from len(args).
+func (q stockQuery) keyset(args []any) (string, []any) {
+	c := q.Cursor
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	cmp := ">"
+	if (q.Order == "DESC") != c.Prev {
+		cmp = "<"
+	}
+	var v string
+	if c.Value != nil {
+		cast := sortCasts[q.Sort]
+		if _, ok := enrichedSortFields[q.Sort]; ok {
+			cast = "::DECIMAL"
+		}
+		v = arg(*c.Value) + cast
+	}
+	cond := "id " + cmp + " " + arg(c.ID) + "::UUID"
+	if c.Value != nil {
+		cond = "(" + q.Sort + " " + cmp + " " + v + " OR (" + q.Sort + " = " + v + " AND " + cond + "))"
+	}
+	if !q.nullable() {
+		return cond, args
+	}
+	// Missing values come last: moving forward from a row with a value takes them all in,
+	// moving back from a row without one takes in every row with a value.
+	switch {
+	case c.Value == nil && !c.Prev:
+		return q.Sort + " IS NULL AND " + cond, args
+	case c.Value == nil:
+		return "(" + q.Sort + " IS NOT NULL OR " + cond + ")", args
+	case !c.Prev:
+		return "(" + q.Sort + " IS NULL OR " + cond + ")", args
+	default:
+		return q.Sort + " IS NOT NULL AND " + cond, args
+	}
+}
+
+// cursorAt builds the cursor for an item. sortValue is the text form of a computed sort
+// value, which is not part of the item.
+func (q stockQuery) cursorAt(item map[string]any, sortValue *string, prev bool) *string {
+	c := stockCursor{Sort: q.Sort, Order: q.Order, Prev: prev}
+	c.ID, _ = item["id"].(string)
+	if q.computed() {
+		c.Value = sortValue
+	} else {
+		c.Value = cursorText(item[q.Sort])
+	}
+	token := c.encode()
+	return &token
+}
+
+func cursorText(v any) *string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		s = v.UTC().Format(time.RFC3339Nano)
+	case *float64:
+		if v == nil {
+			return nil
+		}
+		s = strconv.FormatFloat(*v, 'f', -1, 64)
+	default:
+		return nil
+	}
+	return &s
+}
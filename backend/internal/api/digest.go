@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"stockchallenge/backend/internal/digest"
+
+	"github.com/gin-gonic/gin"
+)
+
+type digestPreferencesIn struct {
+	Email      string `json:"email" binding:"required"`
+	Subscribed *bool  `json:"subscribed"` // default true
+}
+
+// digestPreview is the digest as it would be sent, and the data it was rendered from.
+type digestPreview struct {
+	To      string         `json:"to"`
+	Subject string         `json:"subject"`
+	HTML    string         `json:"html"`
+	Text    string         `json:"text"`
+	Digest  *digest.Digest `json:"digest"`
+}
+
+const unsubscribedPage = `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribed</title></head>` +
+	`<body><p>You will no longer receive the daily stock digest.</p></body></html>`
+
+// confirmUnsubscribePage asks before unsubscribing, so link scanners that fetch every URL in a
+// message do not unsubscribe anyone. Only its form's POST changes anything.
+var confirmUnsubscribePage = template.Must(template.New("confirm").Parse(
+	`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribe</title></head>` +
+		`<body><p>Stop receiving the daily stock digest?</p>` +
+		`<form method="post" action="?token={{.}}"><button type="submit">Unsubscribe</button></form></body></html>`))
+
+func (h *RouterDeps) getDigestPreferences(c *gin.Context) {
+	p, err := h.Digest.Preferences(c.Request.Context(), defaultUserID)
+	if err != nil {
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func (h *RouterDeps) putDigestPreferences(c *gin.Context) {
+	var body digestPreferencesIn
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, http.StatusBadRequest, "email is required")
+		return
+	}
+	subscribed := body.Subscribed == nil || *body.Subscribed
+	p, err := h.Digest.SetPreferences(c.Request.Context(), defaultUserID, body.Email, subscribed)
+	if errors.Is(err, digest.ErrInvalidEmail) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("save digest preferences failed: %v", err)
+		writeDBError(c, err, "failed to save preferences")
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// previewDigest renders today's digest without sending it: as JSON with both renderings, or
+// only the HTML or text with ?format=html or text.
+func (h *RouterDeps) previewDigest(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "html" && format != "text" {
+		writeError(c, http.StatusBadRequest, "format must be json, html or text")
+		return
+	}
+	d, err := h.Digest.Build(c.Request.Context(), defaultUserID)
+	if err != nil {
+		h.Log.Warnf("build digest failed: %v", err)
+		writeDBError(c, err, "failed to build digest")
+		return
+	}
+	html, text, err := d.Render()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to render digest")
+		return
+	}
+	switch format {
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+	default:
+		c.JSON(http.StatusOK, digestPreview{To: d.To, Subject: d.Subject, HTML: html, Text: text, Digest: d})
+	}
+}
+
+// sendDigest mails today's digest now, to check the SMTP setup.
+func (h *RouterDeps) sendDigest(c *gin.Context) {
+	d, err := h.Digest.Send(c.Request.Context(), defaultUserID)
+	switch {
+	case errors.Is(err, digest.ErrNotConfigured):
+		writeError(c, http.StatusServiceUnavailable, "email is not configured; set SMTP_HOST")
+	case errors.Is(err, digest.ErrNoEmail):
+		writeError(c, http.StatusBadRequest, err.Error())
+	case err != nil:
+		h.Log.Warnf("send digest failed: %v", err)
+		writeError(c, http.StatusBadGateway, "failed to send digest")
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "sent", "to": d.To})
+	}
+}
+
+// confirmUnsubscribeDigest is the link in each digest. It only shows a page whose button
+// POSTs to unsubscribeDigest; fetching it changes nothing.
+func (h *RouterDeps) confirmUnsubscribeDigest(c *gin.Context) {
+	var page bytes.Buffer
+	if err := confirmUnsubscribePage.Execute(&page, c.Query("token")); err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to render page")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// unsubscribeDigest turns the digest off. Mail clients that support one-click unsubscribe
+// (RFC 8058) POST here directly; the confirmation page's form does too and gets a page back.
+func (h *RouterDeps) unsubscribeDigest(c *gin.Context) {
+	if err := h.Digest.Unsubscribe(c.Request.Context(), c.Query("token")); err != nil {
+		writeDBError(c, err, "failed to unsubscribe")
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(unsubscribedPage))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "unsubscribed"})
+}
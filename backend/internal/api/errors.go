@@ -0,0 +1,119 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Error codes sent in apiError.Code. Clients branch on the code; the message is for people.
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeUnprocessable  = "unprocessable"
+	CodeInternal       = "internal"
+	CodeDatabase       = "database_error"
+	CodeUpstream       = "upstream_error"
+	CodeUnavailable    = "unavailable"
+	CodeLimitExceeded  = "limit_exceeded"
+	CodeTooLarge       = "payload_too_large"
+)
+
+// apiError is the error object every endpoint answers with, as {"error": {...}}.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// statusCodes is the default code for each status writeError is called with.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusMethodNotAllowed:      CodeInvalidRequest,
+	http.StatusUpgradeRequired:       CodeInvalidRequest,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusBadGateway:            CodeUpstream,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// writeError answers with status and an error object whose code follows from status.
+func writeError(c *gin.Context, status int, message string) {
+	writeErrorDetails(c, status, message, nil)
+}
+
+// writeErrorDetails is writeError with details, such as the failing row or validation issues.
+func writeErrorDetails(c *gin.Context, status int, message string, details any) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	respondError(c, status, code, message, details)
+}
+
+func respondError(c *gin.Context, status int, code, message string, details any) {
+	c.AbortWithStatusJSON(status, errorResponse{Error: apiError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	}})
+}
+
+// writeDBError answers 404 when err means no row matched and 500 database_error otherwise,
+// so a failing database is not reported as a missing resource.
+func writeDBError(c *gin.Context, err error, message string) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(c, http.StatusNotFound, "not found")
+		return
+	}
+	respondError(c, http.StatusInternalServerError, CodeDatabase, message, nil)
+}
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+)
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags every request with the caller's X-Request-ID, or a new one, and echoes it
+// back so a reported error can be found in the logs.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// deprecatedAlias marks the unversioned /api routes as deprecated in favour of /api/v1,
+// pointing at the successor with a Link header.
+func deprecatedAlias() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		successor := apiV1 + strings.TrimPrefix(c.Request.URL.Path, apiPrefix)
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"time"
+
+	"stockchallenge/backend/internal/export"
+	"stockchallenge/backend/internal/rec"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportBatch is how many rows are looked up and written together while streaming an export;
+// it bounds memory whatever the table size.
+const exportBatch = 500
+
+// securityColumns are the fields attachSecurities adds.
+var securityColumns = []string{"name", "exchange", "sector", "industry", "country", "market_cap"}
+
+// enrichColumns are the fields enrichItems adds.
+var enrichColumns = []string{"current_price", "percent_upside", "eps", "growth", "intrinsic_value", "intrinsic_value_2"}
+
+// exportFormat negotiates the response format from ?format= or Accept. It answers 400 and
+// reports false for an unknown ?format=.
+func exportFormat(c *gin.Context) (string, bool) {
+	format, err := export.Negotiate(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return format, true
+}
+
+// startExport sends the headers of a download named after name and returns a writer on the
+// response body. From here on errors can only be logged: the status is already sent.
+func startExport(c *gin.Context, format, name string, columns []string) (*itemExporter, error) {
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": export.Filename(name, format, time.Now()),
+	}))
+	c.Status(http.StatusOK)
+	w, err := export.NewWriter(c.Writer, format, name, columns)
+	if err != nil {
+		return nil, err
+	}
+	return &itemExporter{w: w, columns: columns}, nil
+}
+
+// itemExporter writes items (keyed like the JSON response) as rows of its columns. Items are
+// written in batches so prepare can look up extra fields for a whole batch at once.
+type itemExporter struct {
+	w       export.Writer
+	columns []string
+	prepare func(items []map[string]any)
+	items   []map[string]any
+}
+
+func (e *itemExporter) add(item map[string]any) error {
+	e.items = append(e.items, item)
+	if len(e.items) >= exportBatch {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *itemExporter) flush() error {
+	if len(e.items) == 0 {
+		return nil
+	}
+	if e.prepare != nil {
+		e.prepare(e.items)
+	}
+	row := make([]any, len(e.columns))
+	for _, it := range e.items {
+		for i, col := range e.columns {
+			row[i] = it[col]
+		}
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	e.items = e.items[:0]
+	return nil
+}
+
+// close writes what is left and completes the file.
+func (e *itemExporter) close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	return e.w.Close()
+}
+
+// exportStocks streams every stock matching q's filters in q's order; paging and cursors do
+// not apply. Rows are written as they are read from the database.
+func (h *RouterDeps) exportStocks(c *gin.Context, q stockQuery) {
+	filter, args := q.where()
+	where := ""
+	if filter != "" {
+		where = "WHERE " + filter + "\n"
+	}
+	from, _ := q.source()
+	rows, err := h.DB.Query(c, `
+SELECT `+stockColumns+`
+FROM `+from+`
+`+where+`ORDER BY `+q.orderBy(false)+`
+`, args...)
+	if err != nil {
+		h.Log.Warnf("stock export query error: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	defer rows.Close()
+
+	columns := append(append([]string{}, stockFields...), securityColumns...)
+	if q.Enrich {
+		columns = append(columns, enrichColumns...)
+	}
+	scan := func(r rowScanner) (map[string]any, error) {
+		item, _, err := scanStock(r, false)
+		return item, err
+	}
+	h.exportRows(c, q.Format, "stocks", columns, rows, scan, func(ctx context.Context, items []map[string]any) {
+		if q.Enrich && h.Recommender != nil {
+			h.enrichItems(ctx, items)
+		}
+		h.attachSecurities(ctx, items)
+	})
+}
+
+// recommendationColumns follow rec.Recommendation's JSON fields.
+var recommendationColumns = []string{
+	"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "price_target_delta",
+	"exchange", "sector", "industry", "market_cap", "current_price", "percent_upside", "eps", "growth",
+	"intrinsic_value", "intrinsic_value_2", "score", "reasons", "last_rating_change_at", "updated_at",
+}
+
+func (h *RouterDeps) exportRecommendations(c *gin.Context, format string, top []rec.Recommendation) {
+	e, err := startExport(c, format, "recommendations", recommendationColumns)
+	if err != nil {
+		h.Log.Warnf("recommendation export failed: %v", err)
+		return
+	}
+	for _, r := range top {
+		err = e.add(gin.H{
+			"ticker": r.Ticker, "company": r.Company, "brokerage": r.Brokerage,
+			"rating_from": r.RatingFrom, "rating_to": r.RatingTo,
+			"target_from": r.TargetFrom, "target_to": r.TargetTo, "price_target_delta": r.PriceDelta,
+			"exchange": r.Exchange, "sector": r.Sector, "industry": r.Industry, "market_cap": r.MarketCap,
+			"current_price": r.CurrentPrice, "percent_upside": r.PercentUpside, "eps": r.EPS, "growth": r.Growth,
+			"intrinsic_value": r.Intrinsic, "intrinsic_value_2": r.IntrinsicValue2,
+			"score": r.Score, "reasons": r.ScoreReasons, "last_rating_change_at": r.LastChange, "updated_at": r.UpdatedAt,
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = e.close()
+	}
+	if err != nil {
+		h.Log.Warnf("recommendation export failed: %v", err)
+	}
+}
+
+// rowScanner is the part of pgx.Rows the exports read from.
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// exportRows streams rows into a download as they are read. scan turns the current row into
+// an item keyed by columns; prepare, when set, adds fields to each batch before writing.
+func (h *RouterDeps) exportRows(c *gin.Context, format, name string, columns []string, rows rowScanner, scan func(rowScanner) (map[string]any, error), prepare func(context.Context, []map[string]any)) {
+	e, err := startExport(c, format, name, columns)
+	if err != nil {
+		h.Log.Warnf("%s export failed: %v", name, err)
+		return
+	}
+	if prepare != nil {
+		ctx := c.Request.Context()
+		e.prepare = func(items []map[string]any) { prepare(ctx, items) }
+	}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			h.Log.Warnf("scan error: %v", err)
+			continue
+		}
+		if err := e.add(item); err != nil {
+			h.Log.Warnf("%s export failed: %v", name, err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		h.Log.Warnf("%s export query error: %v", name, err)
+		return
+	}
+	if err := e.close(); err != nil {
+		h.Log.Warnf("%s export failed: %v", name, err)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getPortfolioIncome reports trailing-12-month dividends received, forward annual income
+// and yield on cost, in ?base= (defaults to BASE_CURRENCY).
+func (h *RouterDeps) getPortfolioIncome(c *gin.Context) {
+	rep, err := h.Portfolio.Income(c.Request.Context(), defaultUserID, c.Query("base"), time.Now().UTC())
+	if errors.Is(err, portfolio.ErrInvalidCurrency) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("portfolio income failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to compute income")
+		return
+	}
+	c.JSON(http.StatusOK, rep)
+}
+
+// syncDividends refreshes dividend data and records payments on held positions in the ledger.
+func (h *RouterDeps) syncDividends(c *gin.Context) {
+	n, err := h.Portfolio.RecordDividends(c.Request.Context(), defaultUserID, time.Now().UTC())
+	if err != nil {
+		h.Log.Warnf("dividend sync failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "dividend sync failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recorded": n})
+}
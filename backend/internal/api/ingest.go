@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"stockchallenge/backend/internal/ingest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// manualIngestTimeout bounds a run started from the admin endpoint, which outlives its request.
+const manualIngestTimeout = 10 * time.Minute
+
+// runIngest records a manual run and ingests in the background. The returned run_id is what
+// getIngestRun reports on; while another run is going it answers 409 with that run's id.
+func (h *RouterDeps) runIngest(c *gin.Context) {
+	run, err := h.Ingest.StartRun(c.Request.Context(), ingest.TriggerManual)
+	if errors.Is(err, ingest.ErrRunInProgress) {
+		writeErrorDetails(c, http.StatusConflict, err.Error(), gin.H{"run_id": run.ID})
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("start ingest run failed: %v", err)
+		writeDBError(c, err, "failed to start ingest run")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), manualIngestTimeout)
+		defer cancel()
+		_ = h.Ingest.Execute(ctx, run) // failures are logged and recorded on the run
+	}()
+	c.JSON(http.StatusAccepted, gin.H{"status": "ingest started", "run_id": run.ID})
+}
+
+// listIngestRuns is the run history, newest first; limit= caps it (default 20, at most 200).
+func (h *RouterDeps) listIngestRuns(c *gin.Context) {
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			writeError(c, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		limit = n
+	}
+	items, err := h.Ingest.Runs(c.Request.Context(), limit)
+	if err != nil {
+		h.Log.Warnf("list ingest runs failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *RouterDeps) getIngestRun(c *gin.Context) {
+	run, err := h.Ingest.GetRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
@@ -0,0 +1,493 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/alerts"
+	"stockchallenge/backend/internal/corpactions"
+	"stockchallenge/backend/internal/digest"
+	"stockchallenge/backend/internal/export"
+	"stockchallenge/backend/internal/ingest"
+	"stockchallenge/backend/internal/openapi"
+	"stockchallenge/backend/internal/portfolio"
+	"stockchallenge/backend/internal/portfolio/rebalance"
+	"stockchallenge/backend/internal/rec"
+	"stockchallenge/backend/internal/search"
+	"stockchallenge/backend/internal/securities"
+	"stockchallenge/backend/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response shapes of handlers that answer with gin.H. They exist for the OpenAPI document;
+// the contract test in router_test.go keeps the handlers' output in line with them.
+
+// securityInfo is the reference data attachSecurities adds when a ticker is known.
+type securityInfo struct {
+	Name      string   `json:"name,omitempty"`
+	Exchange  string   `json:"exchange,omitempty"`
+	Sector    string   `json:"sector,omitempty"`
+	Industry  string   `json:"industry,omitempty"`
+	Country   string   `json:"country,omitempty"`
+	MarketCap *float64 `json:"market_cap,omitempty"`
+}
+
+// stockItem is a stock in list responses; the valuation fields come with enrich=true.
+type stockItem struct {
+	ID                 string     `json:"id"`
+	Ticker             string     `json:"ticker"`
+	Company            string     `json:"company"`
+	Brokerage          string     `json:"brokerage"`
+	Action             string     `json:"action"`
+	RatingFrom         string     `json:"rating_from"`
+	RatingTo           string     `json:"rating_to"`
+	TargetFrom         *float64   `json:"target_from"`
+	TargetTo           *float64   `json:"target_to"`
+	LastRatingChangeAt *time.Time `json:"last_rating_change_at"`
+	PriceTargetDelta   *float64   `json:"price_target_delta"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	securityInfo
+	CurrentPrice    *float64 `json:"current_price,omitempty"`
+	PercentUpside   *float64 `json:"percent_upside,omitempty"`
+	EPS             *float64 `json:"eps,omitempty"`
+	Growth          *float64 `json:"growth,omitempty"`
+	IntrinsicValue  *float64 `json:"intrinsic_value,omitempty"`
+	IntrinsicValue2 *float64 `json:"intrinsic_value_2,omitempty"`
+}
+
+// stockPage is one page of the stock list; page is only set when paging by page number.
+type stockPage struct {
+	Items      []stockItem `json:"items"`
+	Page       int         `json:"page,omitempty"`
+	Limit      int         `json:"limit"`
+	Total      int64       `json:"total"`
+	Sort       string      `json:"sort"`
+	Order      string      `json:"order"`
+	NextCursor *string     `json:"next_cursor"`
+	PrevCursor *string     `json:"prev_cursor"`
+}
+
+// stockDetail is a single stock with its valuation, null where unknown.
+type stockDetail struct {
+	ID                 string     `json:"id"`
+	Ticker             string     `json:"ticker"`
+	Company            string     `json:"company"`
+	Brokerage          string     `json:"brokerage"`
+	Action             string     `json:"action"`
+	RatingFrom         string     `json:"rating_from"`
+	RatingTo           string     `json:"rating_to"`
+	TargetFrom         *float64   `json:"target_from"`
+	TargetTo           *float64   `json:"target_to"`
+	LastRatingChangeAt *time.Time `json:"last_rating_change_at"`
+	PriceTargetDelta   *float64   `json:"price_target_delta"`
+	CurrentPrice       *float64   `json:"current_price"`
+	PercentUpside      *float64   `json:"percent_upside"`
+	EPS                *float64   `json:"eps"`
+	Growth             *float64   `json:"growth"`
+	IntrinsicValue     *float64   `json:"intrinsic_value"`
+	IntrinsicValue2    *float64   `json:"intrinsic_value_2"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+type quoteOut struct {
+	Ticker       string  `json:"ticker"`
+	CurrentPrice float64 `json:"current_price"`
+}
+
+type watchlistItem struct {
+	Ticker  *string   `json:"ticker"`
+	Notes   *string   `json:"notes"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type positionItem struct {
+	Ticker       string  `json:"ticker"`
+	Position     float64 `json:"position"`
+	AveragePrice float64 `json:"average_price"`
+	Currency     string  `json:"currency"`
+	securityInfo
+}
+
+type tickerStatus struct {
+	Ticker string `json:"ticker"`
+	Status string `json:"status"`
+}
+
+type statementImport struct {
+	Format string                  `json:"format"`
+	DryRun bool                    `json:"dry_run"`
+	Rows   []portfolio.ImportRow   `json:"rows"`
+	Issues []portfolio.ImportIssue `json:"issues"`
+	Saved  int                     `json:"saved"`
+}
+
+type healthOut struct {
+	OK   bool      `json:"ok"`
+	Time time.Time `json:"time"`
+}
+
+// newSpec documents every route NewRouter registers. Query parameters are typed as the
+// handlers parse them; enums and bounds are only declared where a handler already rejects
+// other values, so validation never refuses a request the handler would have served.
+func newSpec() *openapi.Document {
+	d := openapi.New("Stock Info API", "1.0.0",
+		"Analyst ratings, recommendations, watchlist and portfolio tracking. Every path is also served without "+
+			"the /v1 segment as a deprecated alias. Errors are {\"error\": {code, message, details, request_id}}.")
+	errRef := d.Schema(errorResponse{})
+
+	items := func(v any) *openapi.Schema {
+		return &openapi.Schema{
+			Type:       "object",
+			Properties: map[string]*openapi.Schema{"items": {Type: "array", Items: d.Schema(v)}},
+			Required:   []string{"items"},
+		}
+	}
+	object := func(props map[string]*openapi.Schema) *openapi.Schema {
+		s := &openapi.Schema{Type: "object", Properties: props}
+		for name := range props {
+			s.Required = append(s.Required, name)
+		}
+		sort.Strings(s.Required)
+		return s
+	}
+	add := func(method, route, id, tag, summary string, op *openapi.Operation, status int, out any, errs ...int) {
+		op.OperationID, op.Tags, op.Summary = id, []string{tag}, summary
+		s, ok := out.(*openapi.Schema)
+		if !ok {
+			s = d.Schema(out)
+		}
+		op.Responses = map[string]*openapi.Response{
+			strconv.Itoa(status): {Description: http.StatusText(status), Content: map[string]*openapi.MediaType{"application/json": {Schema: s}}},
+		}
+		for _, code := range errs {
+			op.Responses[strconv.Itoa(code)] = &openapi.Response{
+				Description: http.StatusText(code),
+				Content:     map[string]*openapi.MediaType{"application/json": {Schema: errRef}},
+			}
+		}
+		if rest, ok := strings.CutPrefix(route, apiPrefix+"/"); ok {
+			d.Add(method, apiV1+"/"+rest, op)
+			d.Alias(method, apiV1+"/"+rest, route)
+			return
+		}
+		d.Add(method, route, op)
+	}
+	// exports adds the download formats of an exportable list to its 200 response.
+	exports := func(route string) {
+		resp := d.Operation(http.MethodGet, route).Responses["200"]
+		resp.Description += "; CSV, NDJSON or XLSX when negotiated with format= or Accept"
+		for _, f := range []string{export.FormatCSV, export.FormatNDJSON} {
+			resp.Content[export.ContentType(f)] = &openapi.MediaType{Schema: openapi.String()}
+		}
+		resp.Content[export.ContentType(export.FormatXLSX)] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string", Format: "binary"}}
+	}
+	query := func(name string, s *openapi.Schema, description string) *openapi.Parameter {
+		return &openapi.Parameter{Name: name, In: openapi.InQuery, Schema: s, Description: description}
+	}
+	list := func() *openapi.Schema { return &openapi.Schema{Type: "array", Items: openapi.String()} }
+	format := query("format", openapi.String().WithEnum(export.FormatJSON, export.FormatCSV, export.FormatNDJSON, "jsonl", export.FormatXLSX),
+		"Response format; overrides Accept")
+	sector := query("sector", openapi.String(), "Securities sector, case-insensitive")
+	industry := query("industry", openapi.String(), "Securities industry, case-insensitive")
+	csvBody := &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+		"text/csv": {Schema: openapi.String()},
+		"multipart/form-data": {Schema: &openapi.Schema{Type: "object", Required: []string{"file"},
+			Properties: map[string]*openapi.Schema{"file": {Type: "string", Format: "binary"}}}},
+	}}
+	period := []*openapi.Parameter{
+		query("from", openapi.Date(), "Start date; overrides period"),
+		query("to", openapi.Date(), "End date (default today)"),
+		query("period", openapi.String().WithEnum("1m", "3m", "6m", "ytd", "1y", "3y", "5y", "max"), "Named period ending at to (default 1y)"),
+	}
+	stockParams := func(extra ...*openapi.Parameter) []*openapi.Parameter {
+		return append([]*openapi.Parameter{
+			query("q", openapi.String(), "Ticker or company contains the text, or the company name is similar"),
+			query("brokerage", list(), "Any of these brokerages (comma-separated or repeated)"),
+			query("action", list(), "Any of these actions"),
+			query("rating_to", list(), "Any of these ratings"),
+			query("target_min", openapi.Number(), "Minimum target_to"),
+			query("target_max", openapi.Number(), "Maximum target_to"),
+			query("delta_min", openapi.Number(), "Minimum price_target_delta"),
+			query("delta_max", openapi.Number(), "Maximum price_target_delta"),
+			query("changed_from", openapi.String(), "Rating changed on or after (YYYY-MM-DD or RFC 3339)"),
+			query("changed_to", openapi.String(), "Rating changed before (YYYY-MM-DD or RFC 3339)"),
+			query("changed_within", openapi.String(), "Rating changed in the last 7d / 36h"),
+			query("watchlist", openapi.Boolean(), "On the watchlist"),
+			query("held", openapi.Boolean(), "Held in the portfolio"),
+			sector, industry,
+			query("min_upside", openapi.Number(), "Minimum target_to / cached price - 1"),
+			query("max_upside", openapi.Number(), "Maximum target_to / cached price - 1"),
+			query("sort", openapi.String(), "Stock column, enriched field or relevance; unknown values use the default"),
+			query("order", openapi.String(), "ASC or DESC"),
+			query("page", openapi.Integer(), "Page number (default 1)"),
+			query("limit", openapi.Integer(), "Page size, 1-100 (default 20)"),
+			query("cursor", openapi.String(), "next_cursor or prev_cursor of a previous page"),
+			query("enrich", openapi.Boolean(), "Add price, upside and valuation fields"),
+			format,
+		}, extra...)
+	}
+
+	add(http.MethodGet, "/healthz", "health", "health", "Health check", &openapi.Operation{}, http.StatusOK, healthOut{})
+	add(http.MethodGet, "/api/openapi.json", "openapi", "health", "This document", &openapi.Operation{}, http.StatusOK, &openapi.Schema{Type: "object"})
+
+	add(http.MethodGet, "/api/stocks", "listStocks", "stocks", "List, filter and sort stocks",
+		&openapi.Operation{Parameters: stockParams()}, http.StatusOK, stockPage{}, 400, 500)
+	add(http.MethodGet, "/api/stocks/search", "searchStocks", "stocks", "Search stocks (same as /api/stocks with a required q)",
+		&openapi.Operation{Parameters: stockParams(), Deprecated: true}, http.StatusOK, stockPage{}, 400, 500)
+	d.Operation(http.MethodGet, "/api/stocks/search").Parameters[0].Required = true
+	add(http.MethodGet, "/api/stocks/sort", "sortStocks", "stocks", "Sort stocks (same as /api/stocks sorted by field)",
+		&openapi.Operation{Parameters: stockParams(query("field", openapi.String(), "Sort field (default ticker)")), Deprecated: true},
+		http.StatusOK, stockPage{}, 400, 500)
+	for _, route := range []string{"/api/stocks", "/api/stocks/search", "/api/stocks/sort"} {
+		exports(route)
+	}
+	add(http.MethodGet, "/api/stocks/:ticker", "getStock", "stocks", "Stock details with valuation",
+		&openapi.Operation{}, http.StatusOK, stockDetail{}, 404)
+	add(http.MethodGet, "/api/quotes/:ticker", "getQuote", "stocks", "Current price of any ticker",
+		&openapi.Operation{}, http.StatusOK, quoteOut{}, 400, 404)
+	add(http.MethodGet, "/api/stream", "streamEvents", "stocks", "Live quote, rating and recommendation events",
+		&openapi.Operation{
+			Description: "Server-Sent Events. Each message has the event id, the event type (quote, rating_change, " +
+				"recommendation_update or resync) and the event as JSON data. Idle streams get a comment every 15s.",
+			Parameters: []*openapi.Parameter{
+				query("tickers", list(), "Only events about these tickers (comma-separated)"),
+				query("last_event_id", openapi.String(), "Resume after this event; the Last-Event-ID header takes precedence"),
+			},
+		}, http.StatusOK, openapi.String(), 400)
+	d.Operation(http.MethodGet, "/api/stream").Responses["200"].Content = map[string]*openapi.MediaType{
+		"text/event-stream": {Schema: openapi.String()},
+	}
+	add(http.MethodGet, "/api/ws", "serveWS", "stocks", "Live quote and rating events over a WebSocket",
+		&openapi.Operation{
+			Description: "Send {\"type\": \"subscribe\" or \"unsubscribe\", \"id\", \"tickers\": [...], \"watchlist\": bool, " +
+				"\"portfolio\": bool}; each is answered with {\"type\": \"subscribed\" or \"error\", \"id\", \"tickers\", " +
+				"\"watchlist\", \"portfolio\", \"error\"}. quote and rating_change events for the subscribed tickers are sent " +
+				"as on /stream.",
+		}, http.StatusSwitchingProtocols, openapi.String(), 400, 426)
+	d.Operation(http.MethodGet, "/api/ws").Responses["101"].Content = nil
+	add(http.MethodGet, "/api/search/suggest", "getSuggestions", "stocks", "Autocomplete symbols and names",
+		&openapi.Operation{Parameters: []*openapi.Parameter{
+			query("q", openapi.String(), "Text typed so far"),
+			query("limit", openapi.Integer().Min(1), "Maximum suggestions (default 8, capped at 25)"),
+		}}, http.StatusOK, items(search.Suggestion{}), 400, 500)
+
+	add(http.MethodGet, "/api/securities", "listSecurities", "securities", "Reference data",
+		&openapi.Operation{Parameters: []*openapi.Parameter{sector, industry,
+			query("exchange", openapi.String(), ""), query("country", openapi.String(), "")}},
+		http.StatusOK, items(securities.Security{}), 500)
+	add(http.MethodGet, "/api/securities/sectors", "getSectors", "securities", "Sectors with their industries",
+		&openapi.Operation{}, http.StatusOK, items(securities.SectorCount{}), 500)
+	add(http.MethodGet, "/api/securities/:symbol", "getSecurity", "securities", "Reference data for one symbol",
+		&openapi.Operation{}, http.StatusOK, securities.Security{}, 404, 500)
+
+	add(http.MethodGet, "/api/recommendations", "getRecommendations", "recommendations", "Top recommendations",
+		&openapi.Operation{Parameters: []*openapi.Parameter{sector, industry, format}}, http.StatusOK, items(rec.Recommendation{}), 400, 500)
+	exports("/api/recommendations")
+
+	add(http.MethodPost, "/api/admin/ingest", "runIngest", "admin", "Start an ingest run",
+		&openapi.Operation{}, http.StatusAccepted, object(map[string]*openapi.Schema{"status": openapi.String(), "run_id": openapi.String()}), 409, 500)
+	add(http.MethodGet, "/api/admin/ingest/runs", "listIngestRuns", "admin", "Ingest run history, newest first",
+		&openapi.Operation{Parameters: []*openapi.Parameter{query("limit", openapi.Integer(), "1-200, default 20")}},
+		http.StatusOK, items(ingest.Run{}), 400, 500)
+	add(http.MethodGet, "/api/admin/ingest/runs/:id", "getIngestRun", "admin", "One ingest run: counts, status and error",
+		&openapi.Operation{}, http.StatusOK, ingest.Run{}, 404, 500)
+	add(http.MethodPost, "/api/admin/fundamentals/refresh", "refreshFundamentals", "admin", "Ask the Fundamentals API to refresh symbols",
+		&openapi.Operation{
+			Parameters: []*openapi.Parameter{
+				query("symbols", openapi.String(), "Comma-separated symbols when no body is sent"),
+				query("use_final_metric", openapi.Boolean(), ""),
+			},
+			RequestBody: d.JSONBody(fundamentalsRefreshIn{}, false),
+		}, http.StatusAccepted, object(map[string]*openapi.Schema{"status": openapi.String(), "symbols": list()}), 400, 502, 503)
+	add(http.MethodGet, "/api/admin/corporate-actions", "listCorporateActions", "admin", "Corporate actions",
+		&openapi.Operation{Parameters: []*openapi.Parameter{query("status", openapi.String(), "pending or applied")}},
+		http.StatusOK, items(corpactions.Action{}), 500)
+	actionIn := d.RequestSchema(corporateActionIn{})
+	add(http.MethodPost, "/api/admin/corporate-actions", "addCorporateActions", "admin", "Record splits or ticker changes",
+		&openapi.Operation{RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: &openapi.Schema{OneOf: []*openapi.Schema{actionIn, {Type: "array", Items: actionIn}}}},
+		}}}, http.StatusCreated, object(map[string]*openapi.Schema{"added": openapi.Integer(), "received": openapi.Integer()}), 400, 500)
+	add(http.MethodPost, "/api/admin/corporate-actions/import", "importCorporateActions", "admin", "Import corporate actions from CSV",
+		&openapi.Operation{RequestBody: csvBody}, http.StatusCreated, object(map[string]*openapi.Schema{"added": openapi.Integer(), "received": openapi.Integer()}), 400, 500)
+	add(http.MethodPost, "/api/admin/corporate-actions/apply", "applyCorporateActions", "admin", "Apply due corporate actions",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"applied": {Type: "array", Items: d.Schema(corpactions.Action{})}}), 500)
+	add(http.MethodGet, "/api/admin/corporate-actions/:id/log", "getCorporateActionLog", "admin", "What applying an action changed",
+		&openapi.Operation{}, http.StatusOK, items(corpactions.LogEntry{}), 500)
+	add(http.MethodPost, "/api/admin/securities/import", "importSecurities", "admin", "Import reference data from CSV",
+		&openapi.Operation{RequestBody: csvBody}, http.StatusOK, object(map[string]*openapi.Schema{"parsed": openapi.Integer(), "saved": openapi.Integer()}), 400, 500)
+	add(http.MethodPost, "/api/admin/securities/sync", "syncSecurities", "admin", "Refresh stale reference data",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"refreshed": openapi.Integer()}), 500, 503)
+
+	add(http.MethodGet, "/api/watchlist", "getWatchlist", "watchlist", "Watched tickers",
+		&openapi.Operation{Parameters: []*openapi.Parameter{format}}, http.StatusOK, items(watchlistItem{}), 400, 500)
+	exports("/api/watchlist")
+	add(http.MethodPost, "/api/watchlist", "addToWatchlist", "watchlist", "Watch a ticker",
+		&openapi.Operation{RequestBody: d.JSONBody(watchlistIn{}, true)}, http.StatusAccepted, tickerStatus{}, 400, 500)
+	add(http.MethodDelete, "/api/watchlist/:ticker", "removeFromWatchlist", "watchlist", "Stop watching a ticker",
+		&openapi.Operation{}, http.StatusOK, tickerStatus{}, 400, 500)
+
+	add(http.MethodGet, "/api/alerts", "listAlerts", "alerts", "Alert rules",
+		&openapi.Operation{}, http.StatusOK, items(alerts.Rule{}), 500)
+	add(http.MethodPost, "/api/alerts", "createAlert", "alerts", "Create an alert rule",
+		&openapi.Operation{RequestBody: d.JSONBody(alertIn{}, true)}, http.StatusCreated, alerts.Rule{}, 400, 500)
+	add(http.MethodDelete, "/api/alerts/:id", "deleteAlert", "alerts", "Delete an alert rule",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"id": openapi.String(), "status": openapi.String()}), 404, 500)
+	add(http.MethodGet, "/api/alerts/events", "listAlertEvents", "alerts", "Triggered alerts, newest first",
+		&openapi.Operation{Parameters: []*openapi.Parameter{
+			query("since", openapi.String(), "YYYY-MM-DD or RFC 3339"),
+			query("alert_id", openapi.String(), "Only this rule's events"),
+			query("limit", openapi.Integer(), "1-200, default 50"),
+		}}, http.StatusOK, items(alerts.Event{}), 400, 500)
+
+	add(http.MethodGet, "/api/webhooks", "listWebhooks", "webhooks", "Webhook subscriptions",
+		&openapi.Operation{}, http.StatusOK, items(webhooks.Subscription{}), 500)
+	add(http.MethodPost, "/api/webhooks", "createWebhook", "webhooks", "Subscribe a URL to events",
+		&openapi.Operation{RequestBody: d.JSONBody(webhookIn{}, true)}, http.StatusCreated, webhooks.Subscription{}, 400, 500)
+	add(http.MethodDelete, "/api/webhooks/:id", "deleteWebhook", "webhooks", "Delete a subscription and its deliveries",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"id": openapi.String(), "status": openapi.String()}), 404, 500)
+	add(http.MethodGet, "/api/webhooks/:id/deliveries", "listWebhookDeliveries", "webhooks", "Delivery log, newest first",
+		&openapi.Operation{Parameters: []*openapi.Parameter{
+			query("status", openapi.String().WithEnum(webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead), ""),
+			query("limit", openapi.Integer(), "1-200, default 50"),
+		}}, http.StatusOK, items(webhooks.Delivery{}), 400, 404, 500)
+	add(http.MethodPost, "/api/webhooks/deliveries/:id/redeliver", "redeliverWebhook", "webhooks", "Queue a delivery again",
+		&openapi.Operation{}, http.StatusAccepted, object(map[string]*openapi.Schema{"id": openapi.String(), "status": openapi.String()}), 404, 500)
+
+	add(http.MethodGet, "/api/digest/preferences", "getDigestPreferences", "digest", "Email digest preferences",
+		&openapi.Operation{}, http.StatusOK, digest.Preferences{}, 500)
+	add(http.MethodPut, "/api/digest/preferences", "putDigestPreferences", "digest", "Set the digest email and subscription",
+		&openapi.Operation{RequestBody: d.JSONBody(digestPreferencesIn{}, true)}, http.StatusOK, digest.Preferences{}, 400, 500)
+	add(http.MethodGet, "/api/digest/preview", "previewDigest", "digest", "Today's digest, rendered but not sent",
+		&openapi.Operation{Parameters: []*openapi.Parameter{
+			query("format", openapi.String().WithEnum("json", "html", "text"), "json (default) has both renderings and the data"),
+		}}, http.StatusOK, digestPreview{}, 400, 500)
+	preview := d.Operation(http.MethodGet, "/api/digest/preview").Responses["200"]
+	preview.Content["text/html"] = &openapi.MediaType{Schema: openapi.String()}
+	preview.Content["text/plain"] = &openapi.MediaType{Schema: openapi.String()}
+	add(http.MethodPost, "/api/digest/send", "sendDigest", "digest", "Send today's digest now",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"status": openapi.String(), "to": openapi.String()}), 400, 502, 503)
+	unsubscribe := []*openapi.Parameter{query("token", openapi.String(), "From the link in the digest")}
+	add(http.MethodGet, "/api/digest/unsubscribe", "confirmUnsubscribeDigest", "digest", "Confirmation page for a digest's unsubscribe link",
+		&openapi.Operation{Parameters: unsubscribe}, http.StatusOK, openapi.String(), 500)
+	d.Operation(http.MethodGet, "/api/digest/unsubscribe").Responses["200"].Content = map[string]*openapi.MediaType{
+		"text/html": {Schema: openapi.String()},
+	}
+	add(http.MethodPost, "/api/digest/unsubscribe", "unsubscribeDigest", "digest", "Unsubscribe (one-click, or from the confirmation page)",
+		&openapi.Operation{Parameters: unsubscribe}, http.StatusOK, object(map[string]*openapi.Schema{"status": openapi.String()}), 404, 500)
+	d.Operation(http.MethodPost, "/api/digest/unsubscribe").Responses["200"].Content["text/html"] = &openapi.MediaType{Schema: openapi.String()}
+
+	add(http.MethodPost, "/api/portfolio/upload", "uploadPortfolio", "portfolio", "Extract positions from a screenshot for review",
+		&openapi.Operation{RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			"multipart/form-data": {Schema: &openapi.Schema{Type: "object", Required: []string{"image"},
+				Properties: map[string]*openapi.Schema{"image": {Type: "string", Format: "binary"}}}},
+		}}}, http.StatusCreated, portfolio.PendingImport{}, 400, 500, 503)
+	importFormats := append([]string{portfolio.FormatAuto}, portfolio.ImportFormats...)
+	add(http.MethodPost, "/api/portfolio/import", "importPortfolio", "portfolio", "Import a broker statement",
+		&openapi.Operation{
+			Parameters: []*openapi.Parameter{
+				query("format", openapi.String(), "Statement format: "+strings.Join(importFormats, ", ")+" (default auto)"),
+				query("mode", openapi.String().WithEnum(portfolio.ModeMerge, portfolio.ModeReplace), "Default merge"),
+				query("dry_run", openapi.Boolean(), "Parse without saving"),
+				query("skip_invalid", openapi.Boolean(), "Save the valid rows of a statement with errors"),
+				query("ticker_column", openapi.String(), ""), query("quantity_column", openapi.String(), ""),
+				query("price_column", openapi.String(), ""), query("cost_basis_column", openapi.String(), ""),
+				query("currency_column", openapi.String(), ""),
+			},
+			RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+				"text/csv":                 {Schema: openapi.String()},
+				"application/octet-stream": {Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				"multipart/form-data":      csvBody.Content["multipart/form-data"],
+			}},
+		}, http.StatusOK, statementImport{}, 400, 413, 422, 500)
+	add(http.MethodGet, "/api/portfolio/imports/:id", "getPortfolioImport", "portfolio", "A pending or committed import",
+		&openapi.Operation{}, http.StatusOK, portfolio.PendingImport{}, 404, 500)
+	add(http.MethodPost, "/api/portfolio/imports/:id/commit", "commitPortfolioImport", "portfolio", "Save a reviewed import",
+		&openapi.Operation{RequestBody: d.JSONBody(commitImportIn{}, false)}, http.StatusOK, portfolio.PendingImport{}, 400, 404, 409, 422, 500)
+	add(http.MethodGet, "/api/portfolio", "getPortfolio", "portfolio", "Positions with reference data",
+		&openapi.Operation{Parameters: []*openapi.Parameter{sector, industry, format}}, http.StatusOK, items(positionItem{}), 400, 500)
+	exports("/api/portfolio")
+	add(http.MethodGet, "/api/portfolio/transactions", "listTransactions", "portfolio", "Transaction ledger",
+		&openapi.Operation{}, http.StatusOK, items(portfolio.Transaction{}), 500)
+	txIn := d.RequestSchema(transactionIn{})
+	add(http.MethodPost, "/api/portfolio/transactions", "addTransactions", "portfolio", "Record transactions",
+		&openapi.Operation{RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: &openapi.Schema{OneOf: []*openapi.Schema{txIn, {Type: "array", Items: txIn}}}},
+		}}}, http.StatusCreated, items(portfolio.Transaction{}), 400, 500)
+	add(http.MethodGet, "/api/portfolio/performance", "getPerformance", "portfolio", "Returns, volatility, drawdown and Sharpe",
+		&openapi.Operation{Parameters: append(period[:3:3],
+			query("benchmark", openapi.String(), "Comparison symbol, e.g. SPY"),
+			query("risk_free", openapi.Number(), "Annual risk-free rate"),
+			query("base", openapi.String(), "Base currency (default BASE_CURRENCY)"),
+		)}, http.StatusOK, portfolio.PerformanceReport{}, 400, 422, 500)
+	add(http.MethodGet, "/api/portfolio/risk", "getPortfolioRisk", "portfolio", "Concentration, exposure, beta, correlations and VaR",
+		&openapi.Operation{Parameters: append(period[:3:3],
+			query("benchmark", openapi.String(), "Beta and correlation symbol"),
+			query("top", openapi.Integer().Min(1), "Holdings in the top-N weight (default 5)"),
+			query("confidence", openapi.Number(), "VaR confidence, between 0.5 and 1 (default 0.95)"),
+		)}, http.StatusOK, portfolio.RiskReport{}, 400, 422, 500)
+	add(http.MethodGet, "/api/portfolio/history", "getPortfolioHistory", "portfolio", "Daily portfolio value",
+		&openapi.Operation{Parameters: append(period[:3:3], query("positions", openapi.Boolean(), "Include holdings"))},
+		http.StatusOK, items(portfolio.Snapshot{}), 400, 500)
+	add(http.MethodPost, "/api/portfolio/history/backfill", "backfillPortfolioHistory", "portfolio", "Rebuild past snapshots from the ledger",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"snapshots": openapi.Integer()}), 500)
+	add(http.MethodGet, "/api/portfolio/targets", "getTargets", "portfolio", "Target allocation",
+		&openapi.Operation{}, http.StatusOK, portfolio.TargetAllocation{}, 500)
+	add(http.MethodPut, "/api/portfolio/targets", "putTargets", "portfolio", "Replace the target allocation",
+		&openapi.Operation{RequestBody: d.JSONBody(portfolio.TargetAllocation{}, true)}, http.StatusOK, portfolio.TargetAllocation{}, 400, 500)
+	add(http.MethodPost, "/api/portfolio/rebalance", "rebalancePortfolio", "portfolio", "Trades that restore the target allocation",
+		&openapi.Operation{
+			Parameters:  []*openapi.Parameter{query("base", openapi.String(), "Base currency (default BASE_CURRENCY)")},
+			RequestBody: d.JSONBody(rebalanceIn{}, false),
+		}, http.StatusOK, rebalance.Plan{}, 400, 422, 500)
+	add(http.MethodGet, "/api/portfolio/summary", "getPortfolioSummary", "portfolio", "Holdings and cash in one currency",
+		&openapi.Operation{Parameters: []*openapi.Parameter{query("base", openapi.String(), "Base currency (default BASE_CURRENCY)")}},
+		http.StatusOK, portfolio.Summary{}, 400, 500)
+	add(http.MethodGet, "/api/portfolio/cash", "getCashBalances", "portfolio", "Cash per currency",
+		&openapi.Operation{}, http.StatusOK, items(portfolio.CashBalance{}), 500)
+	add(http.MethodPut, "/api/portfolio/cash/:currency", "putCashBalance", "portfolio", "Set cash in a currency (0 removes it)",
+		&openapi.Operation{RequestBody: d.JSONBody(cashBalanceIn{}, true)}, http.StatusOK, portfolio.CashBalance{}, 400, 500)
+	add(http.MethodGet, "/api/portfolio/income", "getPortfolioIncome", "portfolio", "Dividend income and yield on cost",
+		&openapi.Operation{Parameters: []*openapi.Parameter{query("base", openapi.String(), "Base currency (default BASE_CURRENCY)")}},
+		http.StatusOK, portfolio.IncomeReport{}, 400, 500)
+	add(http.MethodPost, "/api/portfolio/dividends/sync", "syncDividends", "portfolio", "Record dividends on held positions",
+		&openapi.Operation{}, http.StatusOK, object(map[string]*openapi.Schema{"recorded": openapi.Integer()}), 500)
+	return d
+}
+
+// serveSpec answers with the OpenAPI document.
+func serveSpec(spec *openapi.Document) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+// validateRequests rejects requests whose parameters or JSON body do not match the route's
+// operation in spec with 400 and every problem found, and JSON bodies over
+// openapi.MaxBodyBytes with 413. Routes missing from spec pass through.
+func validateRequests(spec *openapi.Document) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := spec.Operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+		err := spec.ValidateRequest(op, c.Request, c.Param)
+		if errors.Is(err, openapi.ErrBodyTooLarge) {
+			writeError(c, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		var invalid *openapi.ValidationError
+		if errors.As(err, &invalid) {
+			writeErrorDetails(c, http.StatusBadRequest, invalid.Problems[0], invalid.Problems)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dateLayout = "2006-01-02"
+
+// transactionIn is the request shape for a ledger entry; trade_date is YYYY-MM-DD. For
+// type "dividend", quantity is the shares held and price the amount per share.
+type transactionIn struct {
+	Ticker    string  `json:"ticker" binding:"required"`
+	Type      string  `json:"type" binding:"required"`
+	Quantity  float64 `json:"quantity"`
+	Price     float64 `json:"price"`
+	Fees      float64 `json:"fees"`
+	Currency  string  `json:"currency"`
+	TradeDate string  `json:"trade_date" binding:"required"`
+	Note      *string `json:"note"`
+}
+
+// addTransactions records one transaction or an array of them.
+func (h *RouterDeps) addTransactions(c *gin.Context) {
+	raw, err := c.GetRawData()
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	var in []transactionIn
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(raw, &in)
+	} else {
+		var one transactionIn
+		err = json.Unmarshal(raw, &one)
+		in = append(in, one)
+	}
+	if err != nil || len(in) == 0 {
+		writeError(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	txs := make([]portfolio.Transaction, 0, len(in))
+	for i, t := range in {
+		d, err := time.Parse(dateLayout, t.TradeDate)
+		if err != nil {
+			writeErrorDetails(c, http.StatusBadRequest, "trade_date must be YYYY-MM-DD", gin.H{"index": i})
+			return
+		}
+		txs = append(txs, portfolio.Transaction{Ticker: t.Ticker, Type: t.Type, Quantity: t.Quantity, Price: t.Price, Fees: t.Fees, Currency: t.Currency, TradeDate: d, Note: t.Note})
+	}
+
+	saved, err := h.Portfolio.AddTransactions(c.Request.Context(), defaultUserID, txs)
+	var invalid *portfolio.InvalidTransactionError
+	if errors.As(err, &invalid) {
+		writeErrorDetails(c, http.StatusBadRequest, invalid.Error(), gin.H{"index": invalid.Index})
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("add transactions failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save transactions")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"items": saved})
+}
+
+func (h *RouterDeps) listTransactions(c *gin.Context) {
+	items, err := h.Portfolio.ListTransactions(c.Request.Context(), defaultUserID, time.Time{})
+	if err != nil {
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// getPerformance reports TWR, XIRR, volatility, drawdown and Sharpe for the transaction
+// ledger. The period is from/to (YYYY-MM-DD) or period=1m|3m|6m|ytd|1y|3y|5y|max
+// (default 1y). benchmark adds a comparison symbol (e.g. SPY); risk_free is an annual rate.
+// Values are in ?base= (defaults to BASE_CURRENCY).
+func (h *RouterDeps) getPerformance(c *gin.Context) {
+	from, to, ok := h.parsePeriod(c, "1y")
+	if !ok {
+		return
+	}
+	riskFree, _ := strconv.ParseFloat(c.DefaultQuery("risk_free", "0"), 64)
+
+	q := portfolio.PerformanceQuery{From: from, To: to, Benchmark: c.Query("benchmark"), Base: c.Query("base"), RiskFree: riskFree}
+	rep, err := h.Portfolio.Performance(c.Request.Context(), defaultUserID, q)
+	if errors.Is(err, portfolio.ErrInvalidCurrency) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, portfolio.ErrNoPriceHistory) {
+		h.refreshHistoryAsync(nil, from)
+		writeError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("performance failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to compute performance")
+		return
+	}
+	if len(rep.MissingPrices) > 0 {
+		h.refreshHistoryAsync(rep.MissingPrices, from)
+	}
+	c.JSON(http.StatusOK, rep)
+}
+
+// parsePeriod reads from/to (YYYY-MM-DD) or a named period ending today. On failure it
+// writes a 400 response and returns ok=false.
+func (h *RouterDeps) parsePeriod(c *gin.Context, defaultPeriod string) (from, to time.Time, ok bool) {
+	to = time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "to must be YYYY-MM-DD")
+			return from, to, false
+		}
+		to = t
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "from must be YYYY-MM-DD")
+			return from, to, false
+		}
+		from = t
+	} else if from, ok = periodStart(c.DefaultQuery("period", defaultPeriod), to); !ok {
+		writeError(c, http.StatusBadRequest, "period must be one of 1m, 3m, 6m, ytd, 1y, 3y, 5y, max")
+		return from, to, false
+	}
+	if !from.Before(to) {
+		writeError(c, http.StatusBadRequest, "from must be before to")
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// getPortfolioHistory returns the daily equity curve from portfolio_snapshots. Accepts the
+// same period parameters as performance (default 1y); positions=true includes holdings.
+func (h *RouterDeps) getPortfolioHistory(c *gin.Context) {
+	from, to, ok := h.parsePeriod(c, "1y")
+	if !ok {
+		return
+	}
+	withPositions := strings.ToLower(c.DefaultQuery("positions", "false")) == "true"
+	items, err := h.Portfolio.History(c.Request.Context(), defaultUserID, from, to, withPositions)
+	if err != nil {
+		h.Log.Warnf("portfolio history failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// backfillPortfolioHistory rebuilds past snapshots from the transaction ledger.
+func (h *RouterDeps) backfillPortfolioHistory(c *gin.Context) {
+	n, err := h.Portfolio.BackfillSnapshots(c.Request.Context(), defaultUserID, time.Now().UTC())
+	if err != nil {
+		h.Log.Warnf("snapshot backfill failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "backfill failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": n})
+}
+
+// periodStart maps a named period to its start date relative to to. "max" starts at the
+// zero date so the whole ledger is included.
+func periodStart(period string, to time.Time) (time.Time, bool) {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "1m":
+		return to.AddDate(0, -1, 0), true
+	case "3m":
+		return to.AddDate(0, -3, 0), true
+	case "6m":
+		return to.AddDate(0, -6, 0), true
+	case "ytd":
+		return time.Date(to.Year(), 1, 1, 0, 0, 0, 0, time.UTC), true
+	case "1y":
+		return to.AddDate(-1, 0, 0), true
+	case "3y":
+		return to.AddDate(-3, 0, 0), true
+	case "5y":
+		return to.AddDate(-5, 0, 0), true
+	case "max":
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// historyRefreshEvery is how long a symbol's history backfill is not asked for again, so
+// repeated report requests do not flood the Fundamentals API.
+var historyRefreshEvery = 15 * time.Minute
+
+// ledgerKey stands for "every ticker in the ledger" in historyRefresher.
+const ledgerKey = "*"
+
+// historyRefresher throttles history backfills per symbol and sends them with a timeout.
+type historyRefresher struct {
+	client *http.Client
+	mu     sync.Mutex
+	last   map[string]time.Time
+}
+
+func newHistoryRefresher() *historyRefresher {
+	return &historyRefresher{client: &http.Client{Timeout: 30 * time.Second}, last: map[string]time.Time{}}
+}
+
+// claim returns the keys not refreshed within historyRefreshEvery and marks them refreshed now.
+func (r *historyRefresher) claim(keys []string, now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []string
+	for _, k := range keys {
+		if last, ok := r.last[k]; ok && now.Sub(last) < historyRefreshEvery {
+			continue
+		}
+		r.last[k] = now
+		due = append(due, k)
+	}
+	return due
+}
+
+// refreshHistoryAsync asks the Fundamentals API to backfill daily closes for symbols, or for
+// every ticker in the transaction ledger when symbols is nil. Symbols asked for within
+// historyRefreshEvery are skipped.
+func (h *RouterDeps) refreshHistoryAsync(symbols []string, from time.Time) {
+	if strings.TrimSpace(h.FundamentalsAPI) == "" {
+		return
+	}
+	keys := symbols
+	if symbols == nil {
+		keys = []string{ledgerKey}
+	}
+	due := h.history.claim(keys, time.Now())
+	if len(due) == 0 {
+		return
+	}
+	if symbols != nil {
+		symbols = due
+	}
+	go h.refreshHistory(symbols, from)
+}
+
+// refreshHistory sends the backfill request of refreshHistoryAsync. History is asked for from
+// the first trade at the earliest, and not at all for an empty ledger.
+func (h *RouterDeps) refreshHistory(symbols []string, from time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.history.client.Timeout)
+	defer cancel()
+	txs, err := h.Portfolio.ListTransactions(ctx, defaultUserID, time.Time{})
+	if err != nil {
+		h.Log.Warnf("history refresh: reading the ledger failed: %v", err)
+		return
+	}
+	if len(txs) == 0 {
+		return
+	}
+	first := txs[0].TradeDate
+	tickers := map[string]bool{}
+	for _, t := range txs {
+		if t.TradeDate.Before(first) {
+			first = t.TradeDate
+		}
+		tickers[t.Ticker] = true
+	}
+	if from.Before(first) {
+		from = first
+	}
+	if symbols == nil {
+		for t := range tickers {
+			symbols = append(symbols, t)
+		}
+		sort.Strings(symbols)
+	}
+
+	body, _ := json.Marshal(gin.H{"symbols": symbols, "start": from.Format(dateLayout)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(h.FundamentalsAPI, "/")+"/api/update/history", bytes.NewReader(body))
+	if err != nil {
+		h.Log.Warnf("history refresh failed: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.history.client.Do(req)
+	if err != nil {
+		h.Log.Warnf("history refresh failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
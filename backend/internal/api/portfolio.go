@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"stockchallenge/backend/internal/export"
+	"stockchallenge/backend/internal/portfolio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hardcoded user ID for now
+const defaultUserID = "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c"
+
+// maxImportBytes bounds statement uploads; broker exports are typically a few hundred KB.
+const maxImportBytes = 10 << 20
+
+func (h *RouterDeps) uploadPortfolio(c *gin.Context) {
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "image file is required")
+		return
+	}
+	defer file.Close()
+
+	imageData, err := io.ReadAll(file)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, "failed to read image")
+		return
+	}
+
+	userID := defaultUserID
+
+	// Extraction only creates a pending import; the client reviews it and then commits.
+	imp, err := h.Portfolio.ExtractPortfolio(c.Request.Context(), userID, imageData)
+	if errors.Is(err, portfolio.ErrExtractorUnavailable) {
+		writeError(c, http.StatusServiceUnavailable, "screenshot upload is disabled; use /api/portfolio/import with a broker statement")
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("portfolio extraction failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to extract portfolio")
+		return
+	}
+
+	c.JSON(http.StatusCreated, imp)
+}
+
+// getPortfolioImport returns a pending (or committed) import for review.
+func (h *RouterDeps) getPortfolioImport(c *gin.Context) {
+	imp, err := h.Portfolio.GetImport(c.Request.Context(), defaultUserID, c.Param("id"))
+	if errors.Is(err, portfolio.ErrImportNotFound) {
+		writeError(c, http.StatusNotFound, "import not found")
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("get import failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, imp)
+}
+
+// commitImportIn is the optional request shape for commitPortfolioImport.
+type commitImportIn struct {
+	Rows []portfolio.ImportRow `json:"rows"`
+	Mode string                `json:"mode"`
+}
+
+// commitPortfolioImport writes a reviewed import to the portfolio.
+// Body (all optional): {"rows": [{"ticker","position","average_price","currency"}...], "mode": "merge"|"replace"}.
+// When rows are sent they replace the extracted rows entirely.
+func (h *RouterDeps) commitPortfolioImport(c *gin.Context) {
+	var body commitImportIn
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			writeError(c, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+
+	imp, err := h.Portfolio.CommitImport(c.Request.Context(), defaultUserID, c.Param("id"), body.Rows, strings.ToLower(strings.TrimSpace(body.Mode)))
+	var invalid *portfolio.InvalidRowsError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, imp)
+	case errors.Is(err, portfolio.ErrImportNotFound):
+		writeError(c, http.StatusNotFound, "import not found")
+	case errors.Is(err, portfolio.ErrImportNotPending):
+		writeError(c, http.StatusConflict, "import already committed")
+	case errors.Is(err, portfolio.ErrInvalidMode):
+		writeError(c, http.StatusBadRequest, err.Error())
+	case errors.As(err, &invalid):
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "rows failed validation", gin.H{"issues": invalid.Issues})
+	default:
+		h.Log.Warnf("commit import failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save portfolio")
+	}
+}
+
+// getPortfolio lists saved positions with their reference data; ?sector= and ?industry=
+// narrow the list. CSV, NDJSON and XLSX are streamed as downloads.
+func (h *RouterDeps) getPortfolio(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	userID := defaultUserID
+
+	where, args := securityFilter(c, []any{userID})
+	if where != "" {
+		where = " AND " + where
+	}
+	rows, err := h.DB.Query(c, `SELECT ticker, position, average_price, currency FROM portfolio WHERE user_id = $1`+where+` ORDER BY ticker`, args...)
+	if err != nil {
+		writeDBError(c, err, "query failed")
+		return
+	}
+	defer rows.Close()
+
+	scan := func(r rowScanner) (map[string]any, error) {
+		var ticker, currency string
+		var position, averagePrice float64
+		err := r.Scan(&ticker, &position, &averagePrice, &currency)
+		return gin.H{"ticker": ticker, "position": position, "average_price": averagePrice, "currency": currency}, err
+	}
+	if format != export.FormatJSON {
+		columns := append([]string{"ticker", "position", "average_price", "currency"}, securityColumns...)
+		h.exportRows(c, format, "portfolio", columns, rows, scan, h.attachSecurities)
+		return
+	}
+	items := make([]map[string]any, 0, 64)
+	for rows.Next() {
+		if item, err := scan(rows); err == nil {
+			items = append(items, item)
+		}
+	}
+	rows.Close()
+	h.attachSecurities(c.Request.Context(), items)
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// importPortfolio parses a broker statement (CSV, IBKR, Schwab, Fidelity, OFX/QFX) and
+// upserts the positions. The file is taken from the "file" multipart field or the raw body.
+// With dry_run=true nothing is saved and the parsed rows and issues are returned for review.
+// Statements with error-level issues are rejected unless skip_invalid=true, in which case
+// only the valid rows are saved. mode=replace drops holdings missing from the statement.
+func (h *RouterDeps) importPortfolio(c *gin.Context) {
+	data, err := readImportBody(c)
+	if errors.Is(err, errImportTooLarge) {
+		writeError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("statement exceeds %d MB", maxImportBytes>>20))
+		return
+	}
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		writeError(c, http.StatusBadRequest, "statement file is required")
+		return
+	}
+
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", portfolio.FormatAuto)))
+	if format == portfolio.FormatAuto {
+		format = portfolio.DetectFormat(data)
+	}
+	importer, err := portfolio.NewImporter(format, portfolio.ColumnMapping{
+		Ticker:    c.Query("ticker_column"),
+		Quantity:  c.Query("quantity_column"),
+		AvgPrice:  c.Query("price_column"),
+		CostBasis: c.Query("cost_basis_column"),
+		Currency:  c.Query("currency_column"),
+	})
+	if err != nil {
+		writeErrorDetails(c, http.StatusBadRequest, err.Error(), gin.H{"formats": portfolio.ImportFormats})
+		return
+	}
+
+	res, err := importer.Parse(bytes.NewReader(data))
+	if err != nil {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "failed to parse statement", gin.H{"detail": err.Error(), "format": format})
+		return
+	}
+
+	mode := strings.ToLower(c.DefaultQuery("mode", portfolio.ModeMerge))
+	if mode != portfolio.ModeMerge && mode != portfolio.ModeReplace {
+		writeError(c, http.StatusBadRequest, portfolio.ErrInvalidMode.Error())
+		return
+	}
+	dryRun := strings.ToLower(c.DefaultQuery("dry_run", "false")) == "true"
+	skipInvalid := strings.ToLower(c.DefaultQuery("skip_invalid", "false")) == "true"
+	resp := gin.H{"format": res.Format, "dry_run": dryRun, "rows": res.Rows, "issues": res.Issues, "saved": 0}
+	if dryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if (res.HasErrors() && !skipInvalid) || len(res.Rows) == 0 {
+		msg := "statement has validation errors"
+		if len(res.Rows) == 0 {
+			msg = "no valid positions in statement"
+		}
+		writeErrorDetails(c, http.StatusUnprocessableEntity, msg, gin.H{"format": res.Format, "rows": res.Rows, "issues": res.Issues})
+		return
+	}
+
+	if err := h.Portfolio.ImportPositions(c.Request.Context(), defaultUserID, res.Rows, mode); err != nil {
+		h.Log.Warnf("portfolio import failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save portfolio")
+		return
+	}
+	resp["saved"] = len(res.Rows)
+	c.JSON(http.StatusOK, resp)
+}
+
+// errImportTooLarge is returned by readImportBody for statements over maxImportBytes. They are
+// refused rather than cut short: a partial statement would import (or, in replace mode, drop)
+// the wrong holdings.
+var errImportTooLarge = errors.New("statement too large")
+
+// multipartOverhead leaves room for the form framing around an uploaded statement.
+const multipartOverhead = 64 << 10
+
+func readImportBody(c *gin.Context) ([]byte, error) {
+	body := io.Reader(c.Request.Body)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes+multipartOverhead)
+		file, _, err := c.Request.FormFile("file")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errImportTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportBytes {
+		return nil, errImportTooLarge
+	}
+	return data, nil
+}
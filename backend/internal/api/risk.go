@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"stockchallenge/backend/internal/portfolio"
+	"stockchallenge/backend/internal/portfolio/risk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getPortfolioRisk reports concentration, sector/industry exposure, beta, correlations and
+// one-day VaR for current positions. Returns are taken over the same period parameters as
+// performance (default 1y); top sets N for the top-N weight and confidence the VaR level.
+func (h *RouterDeps) getPortfolioRisk(c *gin.Context) {
+	from, to, ok := h.parsePeriod(c, "1y")
+	if !ok {
+		return
+	}
+	q := portfolio.RiskQuery{From: from, To: to, Benchmark: c.Query("benchmark")}
+	if v := c.Query("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(c, http.StatusBadRequest, "top must be a positive integer")
+			return
+		}
+		q.TopN = n
+	}
+	if v := c.Query("confidence"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, risk.ErrInvalidConfidence.Error())
+			return
+		}
+		q.Confidence = f
+	}
+
+	rep, err := h.Portfolio.Risk(c.Request.Context(), defaultUserID, q)
+	if errors.Is(err, risk.ErrInvalidConfidence) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, portfolio.ErrNoHoldings) {
+		writeError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("portfolio risk failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to compute risk")
+		return
+	}
+	if len(rep.MissingPrices) > 0 {
+		h.refreshHistoryAsync(rep.MissingPrices, from)
+	}
+	c.JSON(http.StatusOK, rep)
+}
@@ -3,18 +3,27 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"io"
+	"errors"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
+	"stockchallenge/backend/internal/alerts"
+	"stockchallenge/backend/internal/corpactions"
 	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/digest"
+	"stockchallenge/backend/internal/events"
+	"stockchallenge/backend/internal/export"
 	"stockchallenge/backend/internal/ingest"
+	"stockchallenge/backend/internal/openapi"
 	"stockchallenge/backend/internal/portfolio"
 	"stockchallenge/backend/internal/rec"
+	"stockchallenge/backend/internal/search"
+	"stockchallenge/backend/internal/securities"
+	"stockchallenge/backend/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
@@ -23,14 +32,61 @@ type RouterDeps struct {
 	Ingest          *ingest.Service
 	Recommender     *rec.Service
 	Portfolio       portfolio.PortfolioService
+	Actions         *corpactions.Service
+	Securities      *securities.Service
+	Search          *search.Service
+	Alerts          *alerts.Service
+	Webhooks        *webhooks.Service
+	Digest          *digest.Service
 	Log             *zap.SugaredLogger
 	FundamentalsAPI string
+	Events          *events.Bus
+	history         *historyRefresher
 }
 
-func NewRouter(db db.DBTX, ing *ingest.Service, recommender *rec.Service, portSvc portfolio.PortfolioService, log *zap.SugaredLogger, fundamentalsAPI string) http.Handler {
+// Option configures optional router dependencies.
+type Option func(*RouterDeps)
+
+// WithEvents makes /stream push the events published on bus. Without it the router has a bus
+// of its own that nothing publishes to.
+func WithEvents(bus *events.Bus) Option {
+	return func(d *RouterDeps) { d.Events = bus }
+}
+
+// WithDigest serves the digest endpoints from svc, which has the mailer and public URL. Without
+// it digests can be previewed but not sent.
+func WithDigest(svc *digest.Service) Option {
+	return func(d *RouterDeps) { d.Digest = svc }
+}
+
+// WithCorporateActions serves the corporate action endpoints from svc, which has the provider
+// the corporate actions cron uses.
+func WithCorporateActions(svc *corpactions.Service) Option {
+	return func(d *RouterDeps) { d.Actions = svc }
+}
+
+// WithSecurities serves the securities endpoints from svc. Without it the router's service has
+// no provider, so /admin/securities/sync refreshes nothing.
+func WithSecurities(svc *securities.Service) Option {
+	return func(d *RouterDeps) { d.Securities = svc }
+}
+
+// WithAlerts serves the alert endpoints from svc, the one the evaluator runs and that publishes
+// triggered alerts.
+func WithAlerts(svc *alerts.Service) Option {
+	return func(d *RouterDeps) { d.Alerts = svc }
+}
+
+// WithWebhooks serves the webhook endpoints from svc, the one the delivery worker runs.
+func WithWebhooks(svc *webhooks.Service) Option {
+	return func(d *RouterDeps) { d.Webhooks = svc }
+}
+
+func NewRouter(db db.DBTX, ing *ingest.Service, recommender *rec.Service, portSvc portfolio.PortfolioService, log *zap.SugaredLogger, fundamentalsAPI string, opts ...Option) http.Handler {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(requestID())
 	r.Use(corsMiddleware())
 
 	deps := &RouterDeps{
@@ -38,181 +94,113 @@ func NewRouter(db db.DBTX, ing *ingest.Service, recommender *rec.Service, portSv
 		Ingest:          ing,
 		Recommender:     recommender,
 		Portfolio:       portSvc,
+		Actions:         corpactions.NewService(db, log),
+		Securities:      securities.NewService(db, log),
+		Search:          search.NewService(db),
+		Alerts:          alerts.NewService(db, log, recommender),
+		Webhooks:        webhooks.NewService(db, log),
+		Digest:          digest.NewService(db, log, recommender, nil, ""),
 		Log:             log,
 		FundamentalsAPI: fundamentalsAPI,
+		history:         newHistoryRefresher(),
+	}
+	for _, opt := range opts {
+		opt(deps)
+	}
+	if deps.Events == nil {
+		deps.Events = events.NewBus(0)
 	}
 
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"ok": true, "time": time.Now().UTC()})
 	})
 
-	api := r.Group("/api")
-	{
-		api.GET("/stocks", deps.listStocks)
-		api.GET("/stocks/search", deps.searchStocks)
-		api.GET("/stocks/sort", deps.sortStocks)
-		api.GET("/stocks/:ticker", deps.getStock)
-		api.GET("/quotes/:ticker", deps.getQuote)
-		api.GET("/recommendations", deps.getRecommendations)
-		api.POST("/admin/ingest", deps.runIngest)
-		api.POST("/admin/fundamentals/refresh", deps.refreshFundamentals)
-		api.GET("/watchlist", deps.getWatchlist)
-		api.POST("/watchlist", deps.addToWatchlist)
-		api.DELETE("/watchlist/:ticker", deps.removeFromWatchlist)
-		api.POST("/portfolio/upload", deps.uploadPortfolio)
-		api.GET("/portfolio", deps.getPortfolio)
-	}
-
-	return r
-}
-
-func (h *RouterDeps) uploadPortfolio(c *gin.Context) {
-	file, _, err := c.Request.FormFile("image")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "image file is required"})
-		return
-	}
-	defer file.Close()
-
-	imageData, err := io.ReadAll(file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read image"})
-		return
-	}
-
-	// Hardcoded user ID for now
-	userID := "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c"
-
-	portfolioData, err := h.Portfolio.ExtractAndSavePortfolio(c.Request.Context(), userID, imageData)
-	if err != nil {
-		h.Log.Warnf("portfolio extraction failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to extract portfolio"})
-		return
-	}
-
-	c.JSON(http.StatusOK, portfolioData)
-}
-
-func (h *RouterDeps) getPortfolio(c *gin.Context) {
-	// Hardcoded user ID for now
-	userID := "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c"
+	// Unknown paths and methods get the same error envelope as every endpoint.
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(func(c *gin.Context) {
+		writeError(c, http.StatusNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+	})
+	r.NoMethod(func(c *gin.Context) {
+		writeError(c, http.StatusMethodNotAllowed, c.Request.Method+" is not allowed on "+c.Request.URL.Path)
+	})
 
-	rows, err := h.DB.Query(c, `SELECT ticker, position, average_price FROM portfolio WHERE user_id = $1 ORDER BY ticker`, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
-		return
-	}
-	defer rows.Close()
+	spec := newSpec()
+	deps.routes(r.Group(apiV1, validateRequests(spec)), spec)
+	deps.routes(r.Group(apiPrefix, deprecatedAlias(), validateRequests(spec)), spec)
 
-	items := make([]gin.H, 0, 64)
-	for rows.Next() {
-		var ticker string
-		var position, averagePrice float64
-		if err := rows.Scan(&ticker, &position, &averagePrice); err == nil {
-			items = append(items, gin.H{"ticker": ticker, "position": position, "average_price": averagePrice})
-		}
-	}
-	c.JSON(http.StatusOK, gin.H{"items": items})
+	return r
 }
 
-func (h *RouterDeps) listStocks(c *gin.Context) {
-	enrich := strings.ToLower(strings.TrimSpace(c.DefaultQuery("enrich", "false"))) == "true"
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
-	offset := (page - 1) * pageSize
-
-	q := `
-SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at
-FROM stocks
-ORDER BY updated_at DESC
-LIMIT $1 OFFSET $2
-`
-	rows, err := h.DB.Query(c, q, pageSize, offset)
-	if err != nil {
-		h.Log.Warnf("list query error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
-		return
-	}
-	defer rows.Close()
-
-	items := []map[string]any{}
-	for rows.Next() {
-		var (
-			id, ticker, company, brokerage, action, ratingFrom, ratingTo string
-			targetFrom, targetTo, priceDelta                             *float64
-			lastChange                                                   *time.Time
-			createdAt, updatedAt                                         time.Time
-		)
-		if err := rows.Scan(
-			&id, &ticker, &company, &brokerage, &action, &ratingFrom, &ratingTo,
-			&targetFrom, &targetTo, &lastChange, &priceDelta, &createdAt, &updatedAt,
-		); err != nil {
-			h.Log.Warnf("scan error: %v", err)
-			continue
-		}
-		m := gin.H{
-			"id":                    id,
-			"ticker":                ticker,
-			"company":               company,
-			"brokerage":             brokerage,
-			"action":                action,
-			"rating_from":           ratingFrom,
-			"rating_to":             ratingTo,
-			"target_from":           targetFrom,
-			"target_to":             targetTo,
-			"last_rating_change_at": lastChange,
-			"price_target_delta":    priceDelta,
-			"created_at":            createdAt,
-			"updated_at":            updatedAt,
-		}
-		if enrich && h.Recommender != nil {
-			// Use context with timeout for enrichment to avoid blocking list requests
-			enrichCtx, cancel := context.WithTimeout(c.Request.Context(), 500*time.Millisecond)
-			defer cancel()
-			
-			cp, up, eps, growth, iv, iv2 := h.Recommender.EnrichTicker(enrichCtx, ticker, targetTo)
-			if cp != nil {
-				m["current_price"] = *cp
-			}
-			if up != nil {
-				m["percent_upside"] = *up
-			}
-			if eps != nil {
-				m["eps"] = *eps
-			}
-			if growth != nil {
-				m["growth"] = *growth
-			}
-			if iv != nil {
-				m["intrinsic_value"] = *iv
-			}
-			if iv2 != nil {
-				m["intrinsic_value_2"] = *iv2
-			}
-		}
-		items = append(items, m)
-	}
-
-	// Count
-	var total int64
-	if err := h.DB.QueryRow(c, `
-SELECT count(*) FROM stocks
-`).Scan(&total); err != nil {
-		total = int64(len(items))
-	}
+// API path prefixes. Routes live under /api/v1; the unversioned /api paths are deprecated
+// aliases kept for existing clients.
+const (
+	apiPrefix = "/api"
+	apiV1     = "/api/v1"
+)
 
-	c.JSON(http.StatusOK, gin.H{
-		"items": items,
-		"page":  page,
-		"limit": pageSize,
-		"total": total,
-	})
+// routes registers every API endpoint on g.
+func (h *RouterDeps) routes(g *gin.RouterGroup, spec *openapi.Document) {
+	g.GET("/openapi.json", serveSpec(spec))
+	g.GET("/stocks", h.listStocks)
+	g.GET("/stocks/search", h.searchStocks)
+	g.GET("/stocks/sort", h.sortStocks)
+	g.GET("/stocks/:ticker", h.getStock)
+	g.GET("/quotes/:ticker", h.getQuote)
+	g.GET("/stream", h.streamEvents)
+	g.GET("/ws", h.serveWS)
+	g.GET("/search/suggest", h.getSuggestions)
+	g.GET("/securities", h.listSecurities)
+	g.GET("/securities/sectors", h.getSectors)
+	g.GET("/securities/:symbol", h.getSecurity)
+	g.GET("/recommendations", h.getRecommendations)
+	g.POST("/admin/ingest", h.runIngest)
+	g.GET("/admin/ingest/runs", h.listIngestRuns)
+	g.GET("/admin/ingest/runs/:id", h.getIngestRun)
+	g.POST("/admin/fundamentals/refresh", h.refreshFundamentals)
+	g.GET("/admin/corporate-actions", h.listCorporateActions)
+	g.POST("/admin/corporate-actions", h.addCorporateActions)
+	g.POST("/admin/corporate-actions/import", h.importCorporateActions)
+	g.POST("/admin/corporate-actions/apply", h.applyCorporateActions)
+	g.GET("/admin/corporate-actions/:id/log", h.getCorporateActionLog)
+	g.POST("/admin/securities/import", h.importSecurities)
+	g.POST("/admin/securities/sync", h.syncSecurities)
+	g.GET("/alerts", h.listAlerts)
+	g.POST("/alerts", h.createAlert)
+	g.GET("/alerts/events", h.listAlertEvents)
+	g.DELETE("/alerts/:id", h.deleteAlert)
+	g.GET("/webhooks", h.listWebhooks)
+	g.POST("/webhooks", h.createWebhook)
+	g.DELETE("/webhooks/:id", h.deleteWebhook)
+	g.GET("/webhooks/:id/deliveries", h.listWebhookDeliveries)
+	g.POST("/webhooks/deliveries/:id/redeliver", h.redeliverWebhook)
+	g.GET("/digest/preferences", h.getDigestPreferences)
+	g.PUT("/digest/preferences", h.putDigestPreferences)
+	g.GET("/digest/preview", h.previewDigest)
+	g.POST("/digest/send", h.sendDigest)
+	g.GET("/digest/unsubscribe", h.confirmUnsubscribeDigest)
+	g.POST("/digest/unsubscribe", h.unsubscribeDigest)
+	g.GET("/watchlist", h.getWatchlist)
+	g.POST("/watchlist", h.addToWatchlist)
+	g.DELETE("/watchlist/:ticker", h.removeFromWatchlist)
+	g.POST("/portfolio/upload", h.uploadPortfolio)
+	g.POST("/portfolio/import", h.importPortfolio)
+	g.GET("/portfolio/imports/:id", h.getPortfolioImport)
+	g.POST("/portfolio/imports/:id/commit", h.commitPortfolioImport)
+	g.GET("/portfolio", h.getPortfolio)
+	g.GET("/portfolio/transactions", h.listTransactions)
+	g.POST("/portfolio/transactions", h.addTransactions)
+	g.GET("/portfolio/performance", h.getPerformance)
+	g.GET("/portfolio/risk", h.getPortfolioRisk)
+	g.GET("/portfolio/history", h.getPortfolioHistory)
+	g.POST("/portfolio/history/backfill", h.backfillPortfolioHistory)
+	g.GET("/portfolio/targets", h.getTargets)
+	g.PUT("/portfolio/targets", h.putTargets)
+	g.POST("/portfolio/rebalance", h.rebalancePortfolio)
+	g.GET("/portfolio/summary", h.getPortfolioSummary)
+	g.GET("/portfolio/cash", h.getCashBalances)
+	g.PUT("/portfolio/cash/:currency", h.putCashBalance)
+	g.GET("/portfolio/income", h.getPortfolioIncome)
+	g.POST("/portfolio/dividends/sync", h.syncDividends)
 }
 
 func (h *RouterDeps) getStock(c *gin.Context) {
@@ -228,7 +216,10 @@ SELECT id, company, brokerage, action, rating_from, rating_to, target_from, targ
 FROM stocks WHERE ticker = $1
 `, ticker).Scan(&id, &company, &brokerage, &action, &ratingFrom, &ratingTo, &targetFrom, &targetTo, &lastChange, &priceDelta, &createdAt, &updatedAt)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		if !errors.Is(err, pgx.ErrNoRows) {
+			h.Log.Warnf("get stock failed: %v", err)
+		}
+		writeDBError(c, err, "query failed")
 		return
 	}
 	// Fire-and-forget refresh for this ticker (quotes always; fundamentals if stale)
@@ -271,7 +262,7 @@ FROM stocks WHERE ticker = $1
 func (h *RouterDeps) getQuote(c *gin.Context) {
 	ticker := c.Param("ticker")
 	if ticker == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ticker required"})
+		writeError(c, http.StatusBadRequest, "ticker required")
 		return
 	}
 	
@@ -288,7 +279,7 @@ func (h *RouterDeps) getQuote(c *gin.Context) {
 		}
 	}
 	
-	c.JSON(http.StatusNotFound, gin.H{"error": "price not available"})
+	writeError(c, http.StatusNotFound, "price not available")
 }
 
 // refreshTickerAsync triggers on-demand updates for the requested ticker using the Fundamentals API.
@@ -332,268 +323,47 @@ func (h *RouterDeps) refreshTickerAsync(ticker string) {
 	}(strings.ToUpper(strings.TrimSpace(ticker)))
 }
 
-func (h *RouterDeps) searchStocks(c *gin.Context) {
-	enrich := strings.ToLower(strings.TrimSpace(c.DefaultQuery("enrich", "false"))) == "true"
-	query := strings.TrimSpace(c.Query("q"))
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "search query 'q' is required"})
-		return
-	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
-	offset := (page - 1) * pageSize
-
-	q := `
-SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at
-FROM stocks
-WHERE ticker ILIKE '%' || $1 || '%' OR company ILIKE '%' || $1 || '%'
-ORDER BY updated_at DESC
-LIMIT $2 OFFSET $3
-`
-	rows, err := h.DB.Query(c, q, query, pageSize, offset)
-	if err != nil {
-		h.Log.Warnf("search query error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
-		return
-	}
-	defer rows.Close()
-
-	items := []map[string]any{}
-	for rows.Next() {
-		var (
-			id, ticker, company, brokerage, action, ratingFrom, ratingTo string
-			targetFrom, targetTo, priceDelta                             *float64
-			lastChange                                                   *time.Time
-			createdAt, updatedAt                                         time.Time
-		)
-		if err := rows.Scan(
-			&id, &ticker, &company, &brokerage, &action, &ratingFrom, &ratingTo,
-			&targetFrom, &targetTo, &lastChange, &priceDelta, &createdAt, &updatedAt,
-		); err != nil {
-			h.Log.Warnf("scan error: %v", err)
-			continue
-		}
-		m := gin.H{
-			"id":                    id,
-			"ticker":                ticker,
-			"company":               company,
-			"brokerage":             brokerage,
-			"action":                action,
-			"rating_from":           ratingFrom,
-			"rating_to":             ratingTo,
-			"target_from":           targetFrom,
-			"target_to":             targetTo,
-			"last_rating_change_at": lastChange,
-			"price_target_delta":    priceDelta,
-			"created_at":            createdAt,
-			"updated_at":            updatedAt,
-		}
-		if enrich && h.Recommender != nil {
-			// Use context with timeout for enrichment to avoid blocking list requests
-			enrichCtx, cancel := context.WithTimeout(c.Request.Context(), 500*time.Millisecond)
-			defer cancel()
-			
-			cp, up, eps, growth, iv, iv2 := h.Recommender.EnrichTicker(enrichCtx, ticker, targetTo)
-			if cp != nil {
-				m["current_price"] = *cp
-			}
-			if up != nil {
-				m["percent_upside"] = *up
-			}
-			if eps != nil {
-				m["eps"] = *eps
-			}
-			if growth != nil {
-				m["growth"] = *growth
-			}
-			if iv != nil {
-				m["intrinsic_value"] = *iv
-			}
-			if iv2 != nil {
-				m["intrinsic_value_2"] = *iv2
-			}
-		}
-		items = append(items, m)
-	}
-
-	// Count
-	var total int64
-	if err := h.DB.QueryRow(c, `
-SELECT count(*) FROM stocks
-WHERE ticker ILIKE '%' || $1 || '%' OR company ILIKE '%' || $1 || '%'
-`, query).Scan(&total); err != nil {
-		total = int64(len(items))
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"items": items,
-		"page":  page,
-		"limit": pageSize,
-		"total": total,
-	})
-}
-
-func (h *RouterDeps) sortStocks(c *gin.Context) {
-	enrich := strings.ToLower(strings.TrimSpace(c.DefaultQuery("enrich", "false"))) == "true"
-	sortField := c.DefaultQuery("field", "ticker")
-	order := strings.ToUpper(c.DefaultQuery("order", "ASC"))
-	if order != "ASC" && order != "DESC" {
-		order = "ASC"
-	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
-	offset := (page - 1) * pageSize
-
-	// Whitelist sort fields (include commonly used computed/temporal fields)
-	switch sortField {
-	case "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "updated_at", "price_target_delta":
-	default:
-		sortField = "ticker"
-	}
-
-	q := `
-SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at
-FROM stocks
-ORDER BY ` + sortField + ` ` + order + `
-LIMIT $1 OFFSET $2
-`
-	rows, err := h.DB.Query(c, q, pageSize, offset)
-	if err != nil {
-		h.Log.Warnf("sort query error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
-		return
-	}
-	defer rows.Close()
-
-	items := []map[string]any{}
-	for rows.Next() {
-		var (
-			id, ticker, company, brokerage, action, ratingFrom, ratingTo string
-			targetFrom, targetTo, priceDelta                             *float64
-			lastChange                                                   *time.Time
-			createdAt, updatedAt                                         time.Time
-		)
-		if err := rows.Scan(
-			&id, &ticker, &company, &brokerage, &action, &ratingFrom, &ratingTo,
-			&targetFrom, &targetTo, &lastChange, &priceDelta, &createdAt, &updatedAt,
-		); err != nil {
-			h.Log.Warnf("scan error: %v", err)
-			continue
-		}
-		m := gin.H{
-			"id":                    id,
-			"ticker":                ticker,
-			"company":               company,
-			"brokerage":             brokerage,
-			"action":                action,
-			"rating_from":           ratingFrom,
-			"rating_to":             ratingTo,
-			"target_from":           targetFrom,
-			"target_to":             targetTo,
-			"last_rating_change_at": lastChange,
-			"price_target_delta":    priceDelta,
-			"created_at":            createdAt,
-			"updated_at":            updatedAt,
-		}
-		if enrich && h.Recommender != nil {
-			// Use context with timeout for enrichment to avoid blocking list requests
-			enrichCtx, cancel := context.WithTimeout(c.Request.Context(), 500*time.Millisecond)
-			defer cancel()
-			
-			cp, up, eps, growth, iv, iv2 := h.Recommender.EnrichTicker(enrichCtx, ticker, targetTo)
-			if cp != nil {
-				m["current_price"] = *cp
-			}
-			if up != nil {
-				m["percent_upside"] = *up
-			}
-			if eps != nil {
-				m["eps"] = *eps
-			}
-			if growth != nil {
-				m["growth"] = *growth
-			}
-			if iv != nil {
-				m["intrinsic_value"] = *iv
-			}
-			if iv2 != nil {
-				m["intrinsic_value_2"] = *iv2
-			}
-		}
-		items = append(items, m)
-	}
-
-	// Count
-	var total int64
-	if err := h.DB.QueryRow(c, `
-SELECT count(*) FROM stocks
-`).Scan(&total); err != nil {
-		total = int64(len(items))
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"items": items,
-		"page":  page,
-		"limit": pageSize,
-		"total": total,
-		"sort":  sortField,
-		"order": order,
-	})
-}
-
 func (h *RouterDeps) getRecommendations(c *gin.Context) {
+    format, ok := exportFormat(c)
+    if !ok {
+        return
+    }
     // Bound recommendation latency to keep UI snappy even if upstreams are slow
     ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
     defer cancel()
-    top, err := h.Recommender.TopN(ctx, 5)
+    top, err := h.Recommender.TopNFiltered(ctx, 5, rec.Filter{Sector: c.Query("sector"), Industry: c.Query("industry")})
     if err != nil {
         h.Log.Warnf("recommendation error: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute"})
+        writeError(c, http.StatusInternalServerError, "failed to compute")
+        return
+    }
+    if format != export.FormatJSON {
+        h.exportRecommendations(c, format, top)
         return
     }
     c.JSON(http.StatusOK, gin.H{"items": top})
 }
 
-func (h *RouterDeps) runIngest(c *gin.Context) {
-	go func() {
-		if err := h.Ingest.RunOnce(c); err != nil {
-			h.Log.Warnf("manual ingest error: %v", err)
-		}
-	}()
-	c.JSON(http.StatusAccepted, gin.H{"status": "ingest started"})
+// fundamentalsRefreshIn is the request shape for refreshFundamentals.
+type fundamentalsRefreshIn struct {
+	Symbols        []string `json:"symbols"`
+	UseFinalMetric bool     `json:"use_final_metric"`
 }
 
 // refreshFundamentals proxies a refresh request to the external Fundamentals API service
 // configured via FUNDAMENTALS_API_BASE. Expects JSON body: {"symbols": ["NVDA","AAPL"], "use_final_metric": false}
 func (h *RouterDeps) refreshFundamentals(c *gin.Context) {
 	if strings.TrimSpace(h.FundamentalsAPI) == "" {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "fundamentals API not configured"})
+		writeError(c, http.StatusServiceUnavailable, "fundamentals API not configured")
 		return
 	}
 	// Accept both JSON and query param formats
-	var body struct {
-		Symbols        []string `json:"symbols"`
-		UseFinalMetric bool     `json:"use_final_metric"`
-	}
+	var body fundamentalsRefreshIn
 	if err := c.BindJSON(&body); err != nil {
 		// fall back to query param
 		syms := c.Query("symbols")
 		if syms == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "symbols required"})
+			writeError(c, http.StatusBadRequest, "symbols required")
 			return
 		}
 		parts := strings.Split(syms, ",")
@@ -608,12 +378,12 @@ func (h *RouterDeps) refreshFundamentals(c *gin.Context) {
 	resp, err := http.Post(strings.TrimRight(h.FundamentalsAPI, "/")+"/api/update/fundamentals", "application/json", strings.NewReader(string(reqBody)))
 	if err != nil {
 		h.Log.Warnf("fundamentals api error: %v", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream error"})
+		writeError(c, http.StatusBadGateway, "fundamentals API unreachable")
 		return
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream status", "status": resp.StatusCode})
+		writeErrorDetails(c, http.StatusBadGateway, "fundamentals API answered "+resp.Status, gin.H{"upstream_status": resp.StatusCode})
 		return
 	}
 	c.JSON(http.StatusAccepted, gin.H{"status": "refresh requested", "symbols": body.Symbols})
@@ -623,7 +393,8 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Deprecation, Link, Content-Disposition")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(204)
 			return
@@ -632,14 +403,28 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// getWatchlist returns all tickers from the watchlist table.
+// getWatchlist returns all tickers from the watchlist table, or streams them as a CSV,
+// NDJSON or XLSX download.
 func (h *RouterDeps) getWatchlist(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
 	rows, err := h.DB.Query(c, `SELECT ticker, notes, added_at FROM watchlist ORDER BY added_at DESC`)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		writeDBError(c, err, "query failed")
 		return
 	}
 	defer rows.Close()
+	if format != export.FormatJSON {
+		h.exportRows(c, format, "watchlist", []string{"ticker", "notes", "added_at"}, rows, func(r rowScanner) (map[string]any, error) {
+			var t, notes *string
+			var added time.Time
+			err := r.Scan(&t, &notes, &added)
+			return gin.H{"ticker": t, "notes": notes, "added_at": added}, err
+		}, nil)
+		return
+	}
 	items := make([]gin.H, 0, 64)
 	for rows.Next() {
 		var t, notes *string
@@ -651,19 +436,22 @@ func (h *RouterDeps) getWatchlist(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
 
+// watchlistIn is the request shape for addToWatchlist.
+type watchlistIn struct {
+	Ticker string  `json:"ticker" binding:"required"`
+	Notes  *string `json:"notes"`
+}
+
 // addToWatchlist upserts a single ticker.
 func (h *RouterDeps) addToWatchlist(c *gin.Context) {
-	var body struct {
-		Ticker string  `json:"ticker"`
-		Notes  *string `json:"notes"`
-	}
+	var body watchlistIn
 	if err := c.BindJSON(&body); err != nil || strings.TrimSpace(body.Ticker) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ticker required"})
+		writeError(c, http.StatusBadRequest, "ticker required")
 		return
 	}
 	t := strings.ToUpper(strings.TrimSpace(body.Ticker))
 	if _, err := h.DB.Exec(c, `UPSERT INTO watchlist (ticker, notes, added_at) VALUES ($1, $2, now())`, t, body.Notes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "upsert failed"})
+		writeDBError(c, err, "upsert failed")
 		return
 	}
 	c.JSON(http.StatusAccepted, gin.H{"ticker": t, "status": "ok"})
@@ -673,11 +461,11 @@ func (h *RouterDeps) addToWatchlist(c *gin.Context) {
 func (h *RouterDeps) removeFromWatchlist(c *gin.Context) {
 	t := strings.ToUpper(strings.TrimSpace(c.Param("ticker")))
 	if t == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ticker required"})
+		writeError(c, http.StatusBadRequest, "ticker required")
 		return
 	}
 	if _, err := h.DB.Exec(c, `DELETE FROM watchlist WHERE ticker = $1`, t); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		writeDBError(c, err, "delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ticker": t, "status": "deleted"})
@@ -1,20 +1,39 @@
 package api
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"stockchallenge/backend/internal/alerts"
+	"stockchallenge/backend/internal/events"
 	"stockchallenge/backend/internal/ingest"
+	"stockchallenge/backend/internal/openapi"
 	"stockchallenge/backend/internal/portfolio"
+	"stockchallenge/backend/internal/portfolio/rebalance"
+	"stockchallenge/backend/internal/portfolio/risk"
 	"stockchallenge/backend/internal/rec"
+	"stockchallenge/backend/internal/securities"
+	"stockchallenge/backend/internal/webhooks"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/coder/websocket"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -29,8 +48,107 @@ func (m *mockClock) Now() time.Time {
 // Mock portfolio service for testing
 type mockPortfolioService struct{}
 
-func (m *mockPortfolioService) ExtractAndSavePortfolio(ctx context.Context, userID string, imageData []byte) (*portfolio.PositionsOut, error) {
-	return &portfolio.PositionsOut{}, nil
+func (m *mockPortfolioService) ExtractPortfolio(ctx context.Context, userID string, imageData []byte) (*portfolio.PendingImport, error) {
+	return &portfolio.PendingImport{ID: "imp-1", Status: portfolio.ImportPending}, nil
+}
+
+func (m *mockPortfolioService) GetImport(ctx context.Context, userID, id string) (*portfolio.PendingImport, error) {
+	if id != "imp-1" {
+		return nil, portfolio.ErrImportNotFound
+	}
+	return &portfolio.PendingImport{ID: id, Status: portfolio.ImportPending}, nil
+}
+
+func (m *mockPortfolioService) CommitImport(ctx context.Context, userID, id string, edits []portfolio.ImportRow, mode string) (*portfolio.PendingImport, error) {
+	if id != "imp-1" {
+		return nil, portfolio.ErrImportNotFound
+	}
+	return &portfolio.PendingImport{ID: id, Status: portfolio.ImportCommitted, Mode: &mode}, nil
+}
+
+func (m *mockPortfolioService) ImportPositions(ctx context.Context, userID string, rows []portfolio.ImportRow, mode string) error {
+	return nil
+}
+
+func (m *mockPortfolioService) AddTransactions(ctx context.Context, userID string, txs []portfolio.Transaction) ([]portfolio.Transaction, error) {
+	return txs, nil
+}
+
+func (m *mockPortfolioService) ListTransactions(ctx context.Context, userID string, to time.Time) ([]portfolio.Transaction, error) {
+	return []portfolio.Transaction{}, nil
+}
+
+func (m *mockPortfolioService) Performance(ctx context.Context, userID string, q portfolio.PerformanceQuery) (*portfolio.PerformanceReport, error) {
+	if q.Benchmark == "NONE" {
+		return nil, portfolio.ErrNoPriceHistory
+	}
+	return &portfolio.PerformanceReport{From: q.From, To: q.To, MissingPrices: []string{}}, nil
+}
+
+func (m *mockPortfolioService) Risk(ctx context.Context, userID string, q portfolio.RiskQuery) (*portfolio.RiskReport, error) {
+	if q.Confidence != 0 && (q.Confidence <= 0.5 || q.Confidence >= 1) {
+		return nil, risk.ErrInvalidConfidence
+	}
+	if q.Benchmark == "NONE" {
+		return nil, portfolio.ErrNoHoldings
+	}
+	return &portfolio.RiskReport{From: q.From, To: q.To, Value: 1000, Concentration: risk.Concentration{TopN: q.TopN}, MissingPrices: []string{}}, nil
+}
+
+func (m *mockPortfolioService) History(ctx context.Context, userID string, from, to time.Time, withPositions bool) ([]portfolio.Snapshot, error) {
+	return []portfolio.Snapshot{{Date: from, TotalValue: 100, CostBasis: 90, Source: portfolio.SnapshotLive}}, nil
+}
+
+func (m *mockPortfolioService) BackfillSnapshots(ctx context.Context, userID string, today time.Time) (int, error) {
+	return 3, nil
+}
+
+func (m *mockPortfolioService) GetTargets(ctx context.Context, userID string) (*portfolio.TargetAllocation, error) {
+	return &portfolio.TargetAllocation{Tickers: map[string]float64{"AAPL": 1}}, nil
+}
+
+func (m *mockPortfolioService) SetTargets(ctx context.Context, userID string, t portfolio.TargetAllocation) (*portfolio.TargetAllocation, error) {
+	if err := rebalance.Validate(rebalance.Targets{Tickers: t.Tickers, Sectors: t.Sectors}); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (m *mockPortfolioService) Rebalance(ctx context.Context, userID string, opts portfolio.RebalanceOptions) (*rebalance.Plan, error) {
+	if opts.Targets != nil && len(opts.Targets.Sectors) > 0 {
+		return nil, portfolio.ErrSectorDataUnavailable
+	}
+	return &rebalance.Plan{TotalValue: 1000 + opts.CashContribution, Trades: []rebalance.Trade{}}, nil
+}
+
+func (m *mockPortfolioService) CashBalances(ctx context.Context, userID string) ([]portfolio.CashBalance, error) {
+	return []portfolio.CashBalance{{Currency: "EUR", Amount: 100}}, nil
+}
+
+func (m *mockPortfolioService) SetCashBalance(ctx context.Context, userID, currency string, amount float64) (*portfolio.CashBalance, error) {
+	if len(currency) != 3 {
+		return nil, portfolio.ErrInvalidCurrency
+	}
+	return &portfolio.CashBalance{Currency: currency, Amount: amount}, nil
+}
+
+func (m *mockPortfolioService) Summary(ctx context.Context, userID, base string) (*portfolio.Summary, error) {
+	if base == "" {
+		base = portfolio.DefaultCurrency
+	}
+	return &portfolio.Summary{BaseCurrency: base, InvestedValue: 1000, CashValue: 110, TotalValue: 1110, Rates: map[string]float64{"EUR": 1.1}}, nil
+}
+
+func (m *mockPortfolioService) Income(ctx context.Context, userID, base string, today time.Time) (*portfolio.IncomeReport, error) {
+	if base == "XX" {
+		return nil, portfolio.ErrInvalidCurrency
+	}
+	yoc := 0.04
+	return &portfolio.IncomeReport{BaseCurrency: portfolio.DefaultCurrency, TTMReceived: 120, ForwardAnnualIncome: 150, YieldOnCost: &yoc}, nil
+}
+
+func (m *mockPortfolioService) RecordDividends(ctx context.Context, userID string, today time.Time) (int, error) {
+	return 2, nil
 }
 
 func setupMockRouter(t *testing.T) (*gin.Engine, pgxmock.PgxPoolIface) {
@@ -78,7 +196,7 @@ func TestListStocks(t *testing.T) {
 	rows := pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}).
 		AddRow("1", "TEST", "Test Company", "Test Brokerage", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, time.Now(), time.Now())
 
-	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at FROM stocks ORDER BY updated_at DESC LIMIT`).
+	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at FROM stocks ORDER BY updated_at DESC, id DESC LIMIT`).
 		WithArgs(20, 0).
 		WillReturnRows(rows)
 	mock.ExpectQuery(`SELECT count`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
@@ -120,10 +238,13 @@ func TestSearchStocks(t *testing.T) {
 	p120 := 120.0
 	pd := 0.2
 	now := time.Now()
-	rows := pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}).
-		AddRow("1", "TEST", "Test Company", "Test Brokerage", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, time.Now(), time.Now())
+	rows := pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at", "relevance"}).
+		AddRow("1", "TEST", "Test Company", "Test Brokerage", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, time.Now(), time.Now(), "4.4")
 
-	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at FROM stocks WHERE ticker ILIKE`).
+	// Searches rank by relevance: exact ticker, prefixes, then fuzzy company-name matches.
+	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at, relevance::STRING `+
+		`FROM \( SELECT stocks\.\*, CASE WHEN upper\(stocks\.ticker\) = upper\(\$1\) THEN 4 .* similarity\(stocks\.company, \$1\) AS relevance FROM stocks \) AS stocks `+
+		`WHERE \(ticker ILIKE '%' \|\| \$1 \|\| '%' OR company ILIKE '%' \|\| \$1 \|\| '%' OR company % \$1\) ORDER BY relevance DESC, id DESC LIMIT`).
 		WithArgs("TEST", 20, 0).
 		WillReturnRows(rows)
 	mock.ExpectQuery(`SELECT count`).WithArgs("TEST").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
@@ -133,6 +254,8 @@ func TestSearchStocks(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"sort":"relevance"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestSortStocks(t *testing.T) {
@@ -146,7 +269,7 @@ func TestSortStocks(t *testing.T) {
 	rows := pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}).
 		AddRow("1", "TEST", "Test Company", "Test Brokerage", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, time.Now(), time.Now())
 
-	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at FROM stocks ORDER BY ticker ASC LIMIT`).
+	mock.ExpectQuery(`SELECT id, ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, last_rating_change_at, price_target_delta, created_at, updated_at FROM stocks ORDER BY ticker ASC, id ASC LIMIT`).
 		WithArgs(20, 0).
 		WillReturnRows(rows)
 	mock.ExpectQuery(`SELECT count`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
@@ -188,9 +311,1397 @@ func TestRunIngest(t *testing.T) {
 	router, mock := setupMockRouter(t)
 	defer mock.Close()
 
+	const runID = "6f1c1f0e-2b7a-4c55-9a55-0d7c3f0e9b11"
+	mock.ExpectQuery(`INSERT INTO ingest_runs`).WithArgs(ingest.TriggerManual, ingest.RunRunning).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "started_at"}).AddRow(runID, time.Now()))
+	// The test service has no API base, so the run fails and is recorded as failed.
+	mock.ExpectQuery(`UPDATE ingest_runs`).WithArgs(runID, ingest.RunFailed, 0, 0, 0, 0, 0, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"finished_at"}).AddRow(time.Now()))
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/admin/ingest", bytes.NewBuffer([]byte{}))
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"status":"ingest started","run_id":"`+runID+`"}`, w.Body.String())
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestIngestRuns(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	cols := []string{"id", "triggered_by", "status", "started_at", "finished_at", "pages", "items", "inserted", "updated", "unchanged", "error"}
+	started := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+	msg := "api error: status=502 body="
+
+	assert.Equal(t, http.StatusBadRequest, do("/api/admin/ingest/runs?limit=0").Code)
+	mock.ExpectQuery(`FROM ingest_runs ORDER BY started_at DESC`).WithArgs(5).
+		WillReturnRows(pgxmock.NewRows(cols).
+			AddRow("r2", ingest.TriggerCron, ingest.RunFailed, started, &finished, 2, 10, 0, 1, 9, &msg).
+			AddRow("r1", ingest.TriggerStartup, ingest.RunSucceeded, started.Add(-time.Hour), &finished, 3, 20, 20, 0, 0, (*string)(nil)))
+	w := do("/api/admin/ingest/runs?limit=5")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Items []ingest.Run `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Items, 2)
+	assert.Equal(t, msg, *list.Items[0].Error)
+	assert.Equal(t, 20, list.Items[1].Inserted)
+
+	const id = "6f1c1f0e-2b7a-4c55-9a55-0d7c3f0e9b11"
+	assert.Equal(t, http.StatusNotFound, do("/api/admin/ingest/runs/nope").Code)
+	mock.ExpectQuery(`FROM ingest_runs WHERE id`).WithArgs(id).
+		WillReturnRows(pgxmock.NewRows(cols).AddRow(id, ingest.TriggerManual, ingest.RunRunning, started, (*time.Time)(nil), 1, 100, 3, 2, 95, (*string)(nil)))
+	w = do("/api/admin/ingest/runs/" + id)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"running","started_at":"2026-03-02T08:00:00Z","finished_at":null`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportPortfolioDryRun(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	body := "Symbol,Quantity,Avg Price\nAAPL,10,150\nMSFT,abc,300\n"
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/portfolio/import?format=csv&dry_run=true", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Format string                  `json:"format"`
+		Rows   []portfolio.ImportRow   `json:"rows"`
+		Issues []portfolio.ImportIssue `json:"issues"`
+		Saved  int                     `json:"saved"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "csv", resp.Format)
+	assert.Len(t, resp.Rows, 1)
+	assert.Len(t, resp.Issues, 1)
+	assert.Equal(t, 0, resp.Saved)
+
+	// Without dry_run the same file is rejected because of the invalid row
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/import?format=csv", bytes.NewBufferString(body))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var rejected struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Details struct {
+				Rows   []portfolio.ImportRow   `json:"rows"`
+				Issues []portfolio.ImportIssue `json:"issues"`
+			} `json:"details"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rejected))
+	assert.Equal(t, CodeUnprocessable, rejected.Error.Code)
+	assert.Equal(t, "statement has validation errors", rejected.Error.Message)
+	assert.Len(t, rejected.Error.Details.Rows, 1)
+	assert.Len(t, rejected.Error.Details.Issues, 1)
+	assert.NotEmpty(t, rejected.Error.RequestID)
+}
+
+func TestImportPortfolioTooLarge(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	// A statement one byte over the limit is refused, not imported in part.
+	big := "Symbol,Quantity,Avg Price\n" + strings.Repeat("AAPL,1,150\n", maxImportBytes/11) + "MSFT,1,300\n"
+	require.Greater(t, len(big), maxImportBytes)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/portfolio/import?format=csv&mode=replace", strings.NewReader(big))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), CodeTooLarge)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "positions.csv")
+	fw.Write([]byte(big))
+	mw.Close()
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/import?format=csv", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPortfolioImportReviewFlow(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/portfolio/imports/missing", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/imports/imp-1/commit", bytes.NewBufferString(`{"mode":"replace"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var imp portfolio.PendingImport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &imp))
+	assert.Equal(t, portfolio.ImportCommitted, imp.Status)
+	assert.Equal(t, "replace", *imp.Mode)
+}
+
+func TestPortfolioPerformance(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/portfolio/performance?from=2024-01-01&to=2024-06-30&benchmark=SPY", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/performance?period=2w", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/performance?benchmark=NONE", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/transactions", bytes.NewBufferString(`{"ticker":"AAPL","type":"buy","quantity":1,"price":190,"trade_date":"01/02/2024"}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// ledgerPortfolio is mockPortfolioService with a fixed transaction ledger.
+type ledgerPortfolio struct {
+	mockPortfolioService
+	txs []portfolio.Transaction
+}
+
+func (l *ledgerPortfolio) ListTransactions(ctx context.Context, userID string, to time.Time) ([]portfolio.Transaction, error) {
+	return l.txs, nil
+}
+
+func TestRefreshHistory(t *testing.T) {
+	posted := make(chan map[string]any, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/update/history", r.URL.Path)
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		posted <- body
+	}))
+	defer srv.Close()
+	ledger := &ledgerPortfolio{txs: []portfolio.Transaction{
+		{Ticker: "MSFT", Type: portfolio.TxBuy, TradeDate: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Ticker: "AAPL", Type: portfolio.TxBuy, TradeDate: time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC)},
+	}}
+	h := &RouterDeps{Portfolio: ledger, Log: zap.NewNop().Sugar(), FundamentalsAPI: srv.URL, history: newHistoryRefresher()}
+	epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := func() map[string]any {
+		select {
+		case body := <-posted:
+			return body
+		case <-time.After(2 * time.Second):
+			t.Fatal("no history refresh sent")
+			return nil
+		}
+	}
+
+	// period=max starts at the first trade, and a symbol is asked for once per interval.
+	h.refreshHistoryAsync([]string{"AAPL"}, epoch)
+	assert.Equal(t, map[string]any{"symbols": []any{"AAPL"}, "start": "2020-06-15"}, next())
+	h.refreshHistoryAsync([]string{"AAPL", "MSFT"}, epoch)
+	assert.Equal(t, map[string]any{"symbols": []any{"MSFT"}, "start": "2020-06-15"}, next())
+	h.refreshHistoryAsync([]string{"AAPL", "MSFT"}, epoch)
+	// Without symbols the whole ledger is refreshed; a later period start is kept.
+	h.refreshHistoryAsync(nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, map[string]any{"symbols": []any{"AAPL", "MSFT"}, "start": "2024-01-01"}, next())
+
+	// An empty ledger has nothing to refresh.
+	ledger.txs = nil
+	h.refreshHistory(nil, epoch)
+	assert.Empty(t, posted)
+}
+
+func TestPortfolioRisk(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/portfolio/risk?period=6m&benchmark=SPY&top=3&confidence=0.99", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rep portfolio.RiskReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rep))
+	assert.Equal(t, 3, rep.Concentration.TopN)
+
+	for _, q := range []string{"top=0", "confidence=high", "confidence=1.5"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/portfolio/risk?"+q, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, q)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/risk?benchmark=NONE", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestPortfolioHistory(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/portfolio/history?period=3m", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []portfolio.Snapshot `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Items, 1)
+	assert.Equal(t, 100.0, resp.Items[0].TotalValue)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/history/backfill", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPortfolioRebalance(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/portfolio/targets", bytes.NewBufferString(`{"tickers":{"AAPL":0.7,"MSFT":0.5}}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/rebalance", bytes.NewBufferString(`{"cash_contribution":500,"min_trade":25}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var plan rebalance.Plan
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &plan))
+	assert.Equal(t, 1500.0, plan.TotalValue)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/portfolio/rebalance", bytes.NewBufferString(`{"targets":{"sectors":{"Technology":1}}}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestPortfolioCashAndSummary(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/portfolio/cash/EUR", bytes.NewBufferString(`{}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/portfolio/cash/EURO", bytes.NewBufferString(`{"amount":100}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/portfolio/cash/EUR", bytes.NewBufferString(`{"amount":100}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/summary?base=USD", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var sum portfolio.Summary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sum))
+	assert.Equal(t, "USD", sum.BaseCurrency)
+	assert.Equal(t, 1110.0, sum.TotalValue)
+}
+
+func TestPortfolioIncome(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/portfolio/dividends/sync", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"recorded":2}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/income", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rep portfolio.IncomeReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rep))
+	assert.Equal(t, 150.0, rep.ForwardAnnualIncome)
+	assert.Equal(t, 0.04, *rep.YieldOnCost)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio/income?base=XX", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCorporateActionsImport(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/admin/corporate-actions", bytes.NewBufferString(`{"symbol":"AAPL","type":"split","ex_date":"2020-08-31"}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectExec(`INSERT INTO corporate_actions`).
+		WithArgs("NVDA", "split", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), "csv").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/admin/corporate-actions/import", bytes.NewBufferString("symbol,type,ex_date,ratio\nNVDA,split,2024-06-10,10:1\n"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"added":1,"received":1}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListStocksSectorFilter(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM stocks WHERE ticker IN \(SELECT symbol FROM securities WHERE lower\(sector\) = lower\(\$1\)\) ORDER BY updated_at DESC, id DESC LIMIT \$2 OFFSET \$3`).
+		WithArgs("Energy", 20, 0).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}).
+			AddRow("1", "XOM", "Exxon Mobil", "UBS", "Buy", "Neutral", "Buy", (*float64)(nil), (*float64)(nil), (*time.Time)(nil), (*float64)(nil), now, now))
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).
+		WithArgs([]string{"XOM"}).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}).
+			AddRow("XOM", "Exxon Mobil Corporation", "NYSE", "Energy", "Oil & Gas Integrated", "US", "USD", (*float64)(nil), (*float64)(nil), "seed", now))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM stocks WHERE ticker IN`).
+		WithArgs("Energy").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(1)))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks?sector=Energy", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []map[string]any `json:"items"`
+		Total int64            `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Items, 1)
+	assert.Equal(t, "Oil & Gas Integrated", resp.Items[0]["industry"])
+	assert.Equal(t, "NYSE", resp.Items[0]["exchange"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSecuritiesImportAndSectors(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/admin/securities/import", bytes.NewBufferString("name,sector\nApple,Technology\n"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectExec(`UPSERT INTO securities`).
+		WithArgs("SAP", "SAP SE", "XETRA", "Technology", "Software - Application", "DE", "EUR", (*float64)(nil), (*float64)(nil), "csv").
+		WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/admin/securities/import", bytes.NewBufferString("symbol,name,exchange,sector,industry,country,currency\nSAP,SAP SE,XETRA,Technology,Software - Application,DE,EUR\n"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"parsed":1,"saved":1}`, w.Body.String())
+
+	mock.ExpectQuery(`GROUP BY sector, industry`).
+		WillReturnRows(pgxmock.NewRows([]string{"sector", "industry", "count"}).
+			AddRow("Energy", "Oil & Gas Integrated", 2).
+			AddRow("Technology", "Semiconductors", 3).
+			AddRow("Technology", "Software - Application", 1))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/securities/sectors", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[
+		{"sector":"Energy","count":2,"industries":["Oil & Gas Integrated"]},
+		{"sector":"Technology","count":4,"industries":["Semiconductors","Software - Application"]}
+	]}`, w.Body.String())
+
+	mock.ExpectQuery(`FROM securities WHERE symbol = \$1`).WithArgs("NOPE").
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/securities/nope", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListStocksFilters(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks?target_min=abc", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "target_min")
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM stocks WHERE lower\(brokerage\) = ANY\(\$1\) AND lower\(action\) = ANY\(\$2\) AND target_to >= \$3 `+
+		`AND last_rating_change_at >= \$4 AND EXISTS \(SELECT 1 FROM watchlist w WHERE w.ticker = stocks.ticker\) `+
+		`AND NOT EXISTS \(SELECT 1 FROM portfolio p WHERE p.ticker = stocks.ticker AND p.user_id = \$5 AND p.position > 0\) `+
+		`AND EXISTS \(SELECT 1 FROM quotes_cache qc .* stocks.target_to / qc.price - 1 >= \$6\) `+
+		`AND ticker IN \(SELECT symbol FROM securities WHERE lower\(sector\) = lower\(\$7\)\) ORDER BY target_to IS NULL, target_to ASC, id ASC LIMIT \$8 OFFSET \$9`).
+		WithArgs([]string{"ubs", "citigroup"}, []string{"upgraded by"}, 100.0, from, defaultUserID, 0.1, "Technology", 10, 10).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM stocks WHERE lower\(brokerage\)`).
+		WithArgs([]string{"ubs", "citigroup"}, []string{"upgraded by"}, 100.0, from, defaultUserID, 0.1, "Technology").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(11)))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/stocks?brokerage=UBS,Citigroup&action=upgraded%20by&target_min=100&changed_from=2024-01-01"+
+		"&watchlist=true&held=false&min_upside=0.1&sector=Technology&sort=target_to&order=asc&page=2&limit=10", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[],"page":2,"limit":10,"total":11,"sort":"target_to","order":"ASC","next_cursor":null,"prev_cursor":null}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSortStocksByEnrichedField(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	mock.ExpectQuery(`FROM \( SELECT stocks\.\*, CASE WHEN qc\.price > 0 AND stocks\.target_to > 0 THEN stocks\.target_to / qc\.price - 1 END AS percent_upside `+
+		`FROM stocks LEFT JOIN quotes_cache qc ON qc\.symbol = stocks\.ticker LEFT JOIN fundamentals f ON f\.ticker = stocks\.ticker \) AS stocks `+
+		`WHERE target_to >= \$1 ORDER BY percent_upside IS NULL, percent_upside DESC, id DESC LIMIT \$2 OFFSET \$3`).
+		WithArgs(50.0, 20, 20).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM stocks WHERE target_to >= \$1`).WithArgs(50.0).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(30)))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks/sort?field=percent_upside&order=DESC&target_min=50&page=2", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"sort":"percent_upside"`)
+
+	mock.ExpectQuery(`AS intrinsic_value_2 .* ORDER BY intrinsic_value_2 IS NULL, intrinsic_value_2 ASC, id ASC`).
+		WithArgs(20, 0).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM stocks`).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(30)))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/stocks?sort=intrinsic_value_2&order=asc", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListStocksEnrichBatches(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	now := time.Now()
+	target := 120.0
+	mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC, id DESC LIMIT`).
+		WithArgs(20, 0).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}).
+			AddRow("1", "AAA", "A Corp", "UBS", "Buy", "Neutral", "Buy", (*float64)(nil), &target, (*time.Time)(nil), (*float64)(nil), now, now).
+			AddRow("2", "BBB", "B Corp", "UBS", "Buy", "Neutral", "Buy", (*float64)(nil), &target, (*time.Time)(nil), (*float64)(nil), now, now))
+	// One fundamentals query for the whole page; the test recommender has no quote cache.
+	eps, growth := 5.0, 0.1
+	mock.ExpectQuery(`SELECT ticker, eps_avg, growth_estimate, updated_at FROM fundamentals WHERE ticker = ANY`).
+		WithArgs([]string{"AAA", "BBB"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "eps_avg", "growth_estimate", "updated_at"}).AddRow("BBB", &eps, &growth, now))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(2)))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks?enrich=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []map[string]any `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Items, 2)
+	assert.NotContains(t, resp.Items[0], "eps")
+	assert.InDelta(t, 142.5, resp.Items[1]["intrinsic_value"], 1e-9)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListStocksCursor(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	cols := []string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	row := func(rows *pgxmock.Rows, id, ticker string, updated time.Time) *pgxmock.Rows {
+		return rows.AddRow(id, ticker, ticker+" Inc", "UBS", "Buy", "Neutral", "Buy", (*float64)(nil), (*float64)(nil), (*time.Time)(nil), (*float64)(nil), updated, updated)
+	}
+	get := func(url string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		var body map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+	tickers := func(body map[string]any) []string {
+		var out []string
+		for _, it := range body["items"].([]any) {
+			out = append(out, it.(map[string]any)["ticker"].(string))
+		}
+		return out
+	}
+	const (
+		idA = "00000000-0000-0000-0000-00000000000a"
+		idB = "00000000-0000-0000-0000-00000000000b"
+		idC = "00000000-0000-0000-0000-00000000000c"
+		idD = "00000000-0000-0000-0000-00000000000d"
+	)
+
+	// The first page comes from page=1 and hands out a next cursor only.
+	mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC, id DESC LIMIT \$1 OFFSET \$2`).WithArgs(2, 0).
+		WillReturnRows(row(row(pgxmock.NewRows(cols), idD, "DDD", day), idC, "CCC", day))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(5)))
+	code, body := get("/api/stocks?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, body["prev_cursor"])
+	next, ok := body["next_cursor"].(string)
+	require.True(t, ok)
+
+	// Following it seeks past (updated_at, id) of CCC, with ties on updated_at broken by id.
+	mock.ExpectQuery(`FROM stocks WHERE \(updated_at < \$1::TIMESTAMPTZ OR \(updated_at = \$1::TIMESTAMPTZ AND id < \$2::UUID\)\) ORDER BY updated_at DESC, id DESC LIMIT \$3$`).
+		WithArgs(day.Format(time.RFC3339Nano), idC, 3).
+		WillReturnRows(row(row(row(pgxmock.NewRows(cols), idB, "BBB", day), idA, "AAA", day.Add(-time.Hour)), "00000000-0000-0000-0000-000000000009", "ZZZ", day.Add(-2*time.Hour)))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM stocks\s*$`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(5)))
+	code, body = get("/api/stocks?limit=2&cursor=" + next)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"BBB", "AAA"}, tickers(body))
+	assert.NotContains(t, body, "page")
+	assert.NotNil(t, body["next_cursor"])
+	prev, ok := body["prev_cursor"].(string)
+	require.True(t, ok)
+
+	// Going back walks the reversed order and flips the rows back.
+	mock.ExpectQuery(`FROM stocks WHERE \(updated_at > \$1::TIMESTAMPTZ OR \(updated_at = \$1::TIMESTAMPTZ AND id > \$2::UUID\)\) ORDER BY updated_at ASC, id ASC LIMIT \$3$`).
+		WithArgs(day.Format(time.RFC3339Nano), idB, 3).
+		WillReturnRows(row(row(pgxmock.NewRows(cols), idC, "CCC", day), idD, "DDD", day))
+	mock.ExpectQuery(`SELECT count`).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(5)))
+	code, body = get("/api/stocks?limit=2&cursor=" + prev)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"DDD", "CCC"}, tickers(body))
+	assert.Nil(t, body["prev_cursor"])
+	assert.NotNil(t, body["next_cursor"])
+
+	code, _ = get("/api/stocks?cursor=not-a-cursor")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body = get("/api/stocks?sort=ticker&cursor=" + next)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "cursor does not match sort and order", body["error"].(map[string]any)["message"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStockKeysetMissingValues(t *testing.T) {
+	v := "0.25"
+	for _, tc := range []struct {
+		value *string
+		prev  bool
+		want  string
+	}{
+		{nil, false, `percent_upside IS NULL AND id < $1::UUID`},
+		{nil, true, `(percent_upside IS NOT NULL OR id > $1::UUID)`},
+		{&v, false, `(percent_upside IS NULL OR (percent_upside < $1::DECIMAL OR (percent_upside = $1::DECIMAL AND id < $2::UUID)))`},
+		{&v, true, `percent_upside IS NOT NULL AND (percent_upside > $1::DECIMAL OR (percent_upside = $1::DECIMAL AND id > $2::UUID))`},
+	} {
+		q := stockQuery{Sort: "percent_upside", Order: "DESC", Cursor: &stockCursor{Sort: "percent_upside", Order: "DESC", Value: tc.value, ID: "id-1", Prev: tc.prev}}
+		got, args := q.keyset(nil)
+		assert.Equal(t, tc.want, got)
+		assert.Equal(t, "id-1", args[len(args)-1])
+	}
+}
+
+func TestSearchSuggest(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/search/suggest?q=app&limit=0", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Blank (or wildcard-only) input suggests nothing without touching the database.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/suggest?q=%25%25", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[]}`, w.Body.String())
+
+	mock.ExpectQuery(`FROM watchlist WHERE`).WithArgs("aple", 24).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "source", "score"}).
+			AddRow("AAPL", "Apple Inc.", "NASDAQ", "securities", 0.5).
+			AddRow("AAPL", "Apple Inc", "", "stocks", 0.45))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/search/suggest?q=+aple+", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[{"symbol":"AAPL","name":"Apple Inc.","exchange":"NASDAQ","sources":["stocks","securities"],"score":0.5}]}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportStocksCSV(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	// Exports skip paging and stream every match; lookups are made once per batch of rows.
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	target := 210.0
+	rows := pgxmock.NewRows([]string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"})
+	var tickers []string
+	for i := 0; i < exportBatch+2; i++ {
+		tk := fmt.Sprintf("T%04d", i)
+		tickers = append(tickers, tk)
+		rows.AddRow(fmt.Sprint(i), tk, "Co "+tk, "UBS", "upgraded by", "Hold", "Buy", (*float64)(nil), &target, (*time.Time)(nil), (*float64)(nil), now, now)
+	}
+	mock.ExpectQuery(`SELECT id, ticker, .* FROM stocks WHERE lower\(brokerage\) = ANY\(\$1\) ORDER BY updated_at DESC, id DESC\s*$`).
+		WithArgs([]string{"ubs"}).
+		WillReturnRows(rows)
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).
+		WithArgs(tickers[:exportBatch]).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}).
+			AddRow("T0000", "Zero Corp", "NYSE", "Energy", "Oil & Gas Integrated", "US", "USD", (*float64)(nil), (*float64)(nil), "seed", now))
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).
+		WithArgs(tickers[exportBatch:]).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks?brokerage=UBS&format=csv&page=3&limit=5", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename=stocks-\d{8}\.csv$`, w.Header().Get("Content-Disposition"))
+
+	recs, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, recs, exportBatch+3)
+	assert.Equal(t, append(append([]string{}, stockFields...), securityColumns...), recs[0])
+	assert.Equal(t, []string{"0", "T0000", "Co T0000", "UBS", "upgraded by", "Hold", "Buy", "", "210", "", "", "2024-05-01T00:00:00Z", "2024-05-01T00:00:00Z",
+		"Zero Corp", "NYSE", "Energy", "Oil & Gas Integrated", "US", ""}, recs[1])
+	assert.Equal(t, "T0501", recs[exportBatch+2][1])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportNegotiation(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	for _, path := range []string{"/api/stocks?format=pdf", "/api/watchlist?format=pdf", "/api/portfolio?format=pdf", "/api/recommendations?format=pdf"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+
+	// Accept picks the format when there is no ?format=.
+	added := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	notes := "earnings soon"
+	mock.ExpectQuery(`SELECT ticker, notes, added_at FROM watchlist`).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "notes", "added_at"}).
+			AddRow(strPtr("AAPL"), &notes, added).
+			AddRow(strPtr("MSFT"), (*string)(nil), added))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/watchlist", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"ticker":"AAPL","notes":"earnings soon","added_at":"2024-01-02T03:04:05Z"}
+{"ticker":"MSFT","notes":null,"added_at":"2024-01-02T03:04:05Z"}
+`, w.Body.String())
+
+	mock.ExpectQuery(`SELECT ticker, position, average_price, currency FROM portfolio WHERE user_id = \$1 ORDER BY ticker`).
+		WithArgs(defaultUserID).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "average_price", "currency"}).AddRow("AAPL", 10.0, 150.5, "USD"))
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).
+		WithArgs([]string{"AAPL"}).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/portfolio?format=xlsx", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", w.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(b)
+		}
+	}
+	assert.Contains(t, sheet, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">AAPL</t></is></c><c r="B2"><v>10</v></c><c r="C2"><v>150.5</v></c>`)
+
+	p120 := 120.0
+	mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC LIMIT`).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at"}).
+			AddRow("TEST", "Test Company", "Test Brokerage", "Neutral", "Buy", (*float64)(nil), &p120, (*float64)(nil), (*time.Time)(nil), added))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/recommendations", nil)
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	recs, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	assert.Equal(t, recommendationColumns, recs[0])
+	assert.Equal(t, "TEST", recs[1][0])
+	assert.Equal(t, "120", recs[1][6])
+}
+
+func strPtr(s string) *string { return &s }
+
+// TestOpenAPIContract fails when a route is missing from the OpenAPI document, or when a
+// handler answers with a status the document does not list or a JSON body that does not match
+// it, including fields the document does not declare.
+func TestOpenAPIContract(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+	spec := newSpec()
+
+	routed := map[string]bool{}
+	for _, r := range router.Routes() {
+		routed[r.Method+" "+r.Path] = true
+		assert.NotNil(t, spec.Operation(r.Method, r.Path), "%s %s is not documented", r.Method, r.Path)
+	}
+	pathParam := regexp.MustCompile(`\{(\w+)\}`)
+	for path, item := range spec.Paths {
+		for method := range *item {
+			route := strings.ToUpper(method) + " " + pathParam.ReplaceAllString(path, ":$1")
+			assert.True(t, routed[route], "%s is documented but not routed", route)
+		}
+	}
+
+	stockCols := []string{"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}
+	securityCols := []string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}
+	now := time.Now()
+	p100, p120, pd := 100.0, 120.0, 0.2
+
+	for _, tc := range []struct {
+		method, route, url, body string
+		expect                   func()
+	}{
+		{method: "GET", route: "/healthz", url: "/healthz"},
+		{method: "GET", route: "/api/openapi.json", url: "/api/openapi.json"},
+		{method: "GET", route: "/api/stocks", url: "/api/stocks?sector=Energy", expect: func() {
+			mock.ExpectQuery(`FROM stocks WHERE ticker IN`).WithArgs("Energy", 20, 0).
+				WillReturnRows(pgxmock.NewRows(stockCols).
+					AddRow("1", "XOM", "Exxon Mobil", "UBS", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, now, now).
+					AddRow("2", "CVX", "Chevron", "UBS", "Buy", "Neutral", "Buy", (*float64)(nil), (*float64)(nil), (*time.Time)(nil), (*float64)(nil), now, now))
+			mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).WithArgs([]string{"XOM", "CVX"}).
+				WillReturnRows(pgxmock.NewRows(securityCols).
+					AddRow("XOM", "Exxon Mobil Corporation", "NYSE", "Energy", "Oil & Gas Integrated", "US", "USD", &p120, (*float64)(nil), "seed", now))
+			mock.ExpectQuery(`SELECT count`).WithArgs("Energy").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(2)))
+		}},
+		{method: "GET", route: "/api/stocks/:ticker", url: "/api/stocks/TEST", expect: func() {
+			mock.ExpectQuery(`FROM stocks WHERE ticker`).WithArgs("TEST").
+				WillReturnRows(pgxmock.NewRows(append([]string{"id"}, stockCols[2:]...)).
+					AddRow("1", "Test Company", "Test Brokerage", "Buy", "Neutral", "Buy", &p100, &p120, &now, &pd, now, now))
+		}},
+		{method: "GET", route: "/api/stocks/:ticker", url: "/api/stocks/NONE", expect: func() {
+			mock.ExpectQuery(`FROM stocks WHERE ticker`).WithArgs("NONE").
+				WillReturnRows(pgxmock.NewRows(append([]string{"id"}, stockCols[2:]...)))
+		}},
+		{method: "GET", route: "/api/recommendations", url: "/api/recommendations", expect: func() {
+			mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC LIMIT`).
+				WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at"}).
+					AddRow("TEST", "Test Company", "Test Brokerage", "Neutral", "Buy", &p100, &p120, &pd, &now, now))
+		}},
+		{method: "GET", route: "/api/digest/preview", url: "/api/digest/preview", expect: func() {
+			mock.ExpectQuery(`FROM digest_preferences WHERE user_id`).WithArgs(defaultUserID).WillReturnError(pgx.ErrNoRows)
+			mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC LIMIT`).
+				WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at"}).
+					AddRow("TEST", "Test Company", "Test Brokerage", "Neutral", "Buy", &p100, &p120, &pd, &now, now))
+			mock.ExpectQuery(`JOIN watchlist`).WithArgs(pgxmock.AnyArg()).
+				WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_to", "last_rating_change_at"}).
+					AddRow("TEST", "Test Company", "Test Brokerage", "upgraded by", "Neutral", "Buy", &p120, &now))
+			mock.ExpectQuery(`FROM portfolio p`).WithArgs(defaultUserID, pgxmock.AnyArg()).
+				WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "price", "prev_close"}).AddRow("TEST", 2.0, &p120, &p100))
+		}},
+		{method: "GET", route: "/api/search/suggest", url: "/api/search/suggest?q=aple", expect: func() {
+			mock.ExpectQuery(`FROM watchlist WHERE`).WithArgs("aple", 24).
+				WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "source", "score"}).
+					AddRow("AAPL", "Apple Inc.", "NASDAQ", "securities", 0.5))
+		}},
+		{method: "GET", route: "/api/securities/sectors", url: "/api/securities/sectors", expect: func() {
+			mock.ExpectQuery(`GROUP BY sector, industry`).
+				WillReturnRows(pgxmock.NewRows([]string{"sector", "industry", "count"}).AddRow("Energy", "Oil & Gas Integrated", 2))
+		}},
+		{method: "GET", route: "/api/securities/:symbol", url: "/api/securities/XOM", expect: func() {
+			mock.ExpectQuery(`FROM securities WHERE symbol = \$1`).WithArgs("XOM").
+				WillReturnRows(pgxmock.NewRows(securityCols).
+					AddRow("XOM", "Exxon Mobil Corporation", "NYSE", "Energy", "Oil & Gas Integrated", "US", "USD", &p120, (*float64)(nil), "seed", now))
+		}},
+		{method: "POST", route: "/api/admin/securities/import", url: "/api/admin/securities/import",
+			body: "symbol,name\nSAP,SAP SE\n", expect: func() {
+				mock.ExpectExec(`UPSERT INTO securities`).WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+					WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+			}},
+		{method: "POST", route: "/api/portfolio/import", url: "/api/portfolio/import?format=csv&dry_run=true",
+			body: "Symbol,Quantity,Avg Price\nAAPL,10,150\nMSFT,abc,300\n"},
+		{method: "GET", route: "/api/portfolio/imports/:id", url: "/api/portfolio/imports/imp-1"},
+		{method: "GET", route: "/api/portfolio/imports/:id", url: "/api/portfolio/imports/missing"},
+		{method: "POST", route: "/api/portfolio/imports/:id/commit", url: "/api/portfolio/imports/imp-1/commit", body: `{"mode":"replace"}`},
+		{method: "GET", route: "/api/portfolio/transactions", url: "/api/portfolio/transactions"},
+		{method: "POST", route: "/api/portfolio/transactions", url: "/api/portfolio/transactions",
+			body: `[{"ticker":"AAPL","type":"buy","quantity":1,"price":190,"trade_date":"2024-01-02"}]`},
+		{method: "GET", route: "/api/portfolio/performance", url: "/api/portfolio/performance?period=6m&benchmark=SPY"},
+		{method: "GET", route: "/api/portfolio/performance", url: "/api/portfolio/performance?benchmark=NONE"},
+		{method: "GET", route: "/api/portfolio/risk", url: "/api/portfolio/risk?top=3"},
+		{method: "GET", route: "/api/portfolio/history", url: "/api/portfolio/history?period=3m"},
+		{method: "POST", route: "/api/portfolio/history/backfill", url: "/api/portfolio/history/backfill"},
+		{method: "GET", route: "/api/portfolio/targets", url: "/api/portfolio/targets"},
+		{method: "PUT", route: "/api/portfolio/targets", url: "/api/portfolio/targets", body: `{"tickers":{"AAPL":0.7,"MSFT":0.5}}`},
+		{method: "POST", route: "/api/portfolio/rebalance", url: "/api/portfolio/rebalance", body: `{"cash_contribution":500}`},
+		{method: "GET", route: "/api/portfolio/summary", url: "/api/portfolio/summary"},
+		{method: "GET", route: "/api/portfolio/cash", url: "/api/portfolio/cash"},
+		{method: "PUT", route: "/api/portfolio/cash/:currency", url: "/api/portfolio/cash/EUR", body: `{"amount":100}`},
+		{method: "GET", route: "/api/portfolio/income", url: "/api/portfolio/income"},
+		{method: "POST", route: "/api/portfolio/dividends/sync", url: "/api/portfolio/dividends/sync"},
+	} {
+		name := tc.method + " " + tc.url
+		if tc.expect != nil {
+			tc.expect()
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		router.ServeHTTP(w, req)
+
+		op := spec.Operation(tc.method, tc.route)
+		require.NotNil(t, op, name)
+		resp, ok := op.Responses[strconv.Itoa(w.Code)]
+		if !assert.True(t, ok, "%s: status %d is not documented (%s)", name, w.Code, w.Body.String()) {
+			continue
+		}
+		media, ok := resp.Content["application/json"]
+		require.True(t, ok, name)
+		var body any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), name)
+		assert.NoError(t, spec.Validate(media.Schema, body, openapi.ModeResponse), "%s: %s", name, w.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestValidation(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	for _, tc := range []struct {
+		method, url, body, want string
+	}{
+		{"GET", "/api/stocks?target_min=abc&limit=ten", "", `invalid target_min: \"abc\" (want number)`},
+		{"GET", "/api/stocks?format=pdf", "", "invalid format"},
+		{"GET", "/api/stocks/search", "", "q is required"},
+		{"GET", "/api/portfolio/history?from=01/02/2024", "", "want YYYY-MM-DD"},
+		{"GET", "/api/portfolio/import", "", ""},
+		{"POST", "/api/watchlist", `{"notes":"x"}`, "body.ticker: is required"},
+		{"POST", "/api/portfolio/transactions", `{"ticker":"AAPL","type":"buy","quantity":"1","trade_date":"2024-01-02"}`, "body.quantity: want number"},
+		{"POST", "/api/portfolio/rebalance", `{"cash_contribution":`, "body: invalid JSON"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		router.ServeHTTP(w, req)
+		if tc.want == "" {
+			// Methods a route does not have are left to the router, which answers 405.
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code, tc.url)
+			assert.Contains(t, w.Body.String(), `"code":"invalid_request"`, tc.url)
+			continue
+		}
+		assert.Equal(t, http.StatusBadRequest, w.Code, tc.url)
+		assert.Contains(t, w.Body.String(), tc.want, tc.url)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/stocks?target_min=abc&limit=ten", nil)
+	router.ServeHTTP(w, req)
+	var resp errorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+	assert.Len(t, resp.Error.Details, 2)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+	assert.Contains(t, doc.Paths["/api/v1/stocks/{ticker}"], "get")
+	assert.NotContains(t, doc.Paths, "/api/stocks/{ticker}")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIVersionsAndErrors(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	serve := func(url, requestID string) (*httptest.ResponseRecorder, errorResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		router.ServeHTTP(w, req)
+		var body errorResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+	stockCols := []string{"id", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "last_rating_change_at", "price_target_delta", "created_at", "updated_at"}
+
+	// A missing stock is 404, a failing database is 500, on both the versioned path and the alias.
+	mock.ExpectQuery(`FROM stocks WHERE ticker`).WithArgs("NONE").WillReturnRows(pgxmock.NewRows(stockCols))
+	w, body := serve("/api/v1/stocks/NONE", "trace-1")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, apiError{Code: CodeNotFound, Message: "not found", RequestID: "trace-1"}, body.Error)
+	assert.Equal(t, "trace-1", w.Header().Get("X-Request-ID"))
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	// Unknown paths get the envelope too, not gin's plain-text 404.
+	w, body = serve("/api/v1/nothing-here", "trace-2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, apiError{Code: CodeNotFound, Message: "no route for GET /api/v1/nothing-here", RequestID: "trace-2"}, body.Error)
+
+	mock.ExpectQuery(`FROM stocks WHERE ticker`).WithArgs("TEST").WillReturnError(fmt.Errorf("connection reset"))
+	w, body = serve("/api/stocks/TEST", "not a valid id!")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeDatabase, body.Error.Code)
+	assert.NotContains(t, w.Body.String(), "connection reset")
+	assert.Len(t, body.Error.RequestID, 16, "invalid ids are replaced")
+	assert.Equal(t, body.Error.RequestID, w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Equal(t, `</api/v1/stocks/TEST>; rel="successor-version"`, w.Header().Get("Link"))
+
+	w, body = serve("/api/v1/portfolio/performance?period=2w", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidRequest, body.Error.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Upstream failures are 502 with what the upstream answered.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+	logger, _ := zap.NewDevelopment()
+	router = NewRouter(mock, nil, nil, &mockPortfolioService{}, logger.Sugar(), upstream.URL).(*gin.Engine)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/admin/fundamentals/refresh", strings.NewReader(`{"symbols":["AAPL"]}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeUpstream, body.Error.Code)
+	assert.Equal(t, map[string]any{"upstream_status": float64(http.StatusTooManyRequests)}, body.Error.Details)
+}
+
+func TestStreamEvents(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	log := zap.NewNop().Sugar()
+	bus := events.NewBus(0)
+	router := NewRouter(mock, ingest.NewService("", "", mock, log), rec.NewService(mock), &mockPortfolioService{}, log, "", WithEvents(bus))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	open := func(url, lastID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+url, nil)
+		require.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() { cancel(); resp.Body.Close() }
+	}
+	// next reads the next message, skipping the retry hint and heartbeats.
+	next := func(r *bufio.Reader) (id, typ string, e events.Event) {
+		for {
+			fields := map[string]string{}
+			for {
+				line, err := r.ReadString('\n')
+				require.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					break
+				}
+				if k, v, ok := strings.Cut(line, ": "); ok {
+					fields[k] = v
+				}
+			}
+			if fields["data"] == "" {
+				continue
+			}
+			require.NoError(t, json.Unmarshal([]byte(fields["data"]), &e))
+			return fields["id"], fields["event"], e
+		}
+	}
+	waitSubscribed := func(n int) {
+		require.Eventually(t, func() bool { return bus.Subscribers() == n }, time.Second, 5*time.Millisecond)
+	}
+
+	r, stop := open("/api/v1/stream?tickers=aapl", "")
+	waitSubscribed(1)
+	bus.Publish(events.TypeQuote, "MSFT", events.Quote{Ticker: "MSFT", Price: 410})
+	first := bus.Publish(events.TypeQuote, "AAPL", events.Quote{Ticker: "AAPL", Price: 190.5})
+	bus.Publish(events.TypeRecommendationUpdate, "", events.RecommendationUpdate{Reason: events.ReasonRatings, Tickers: []string{"MSFT"}})
+	bus.Publish(events.TypeRecommendationUpdate, "", events.RecommendationUpdate{Reason: events.ReasonRatings, Tickers: []string{"MSFT", "AAPL"}})
+
+	id, typ, e := next(r)
+	assert.Equal(t, strconv.FormatUint(first.ID, 10), id)
+	assert.Equal(t, events.TypeQuote, typ)
+	assert.Equal(t, "AAPL", e.Ticker)
+	assert.Equal(t, map[string]any{"ticker": "AAPL", "price": 190.5, "as_of": "0001-01-01T00:00:00Z"}, e.Data)
+	_, typ, e = next(r)
+	assert.Equal(t, events.TypeRecommendationUpdate, typ)
+	assert.Equal(t, first.ID+2, e.ID)
+	stop()
+	waitSubscribed(0)
+
+	// A reconnecting client gets what it missed, filtered the same way.
+	missed := bus.Publish(events.TypeRatingChange, "AAPL", events.RatingChange{Ticker: "AAPL", RatingTo: "Buy"})
+	r, stop = open("/api/stream?tickers=AAPL", strconv.FormatUint(first.ID+2, 10))
+	defer stop()
+	id, typ, _ = next(r)
+	assert.Equal(t, strconv.FormatUint(missed.ID, 10), id)
+	assert.Equal(t, events.TypeRatingChange, typ)
+
+	resp, err := http.Get(srv.URL + "/api/v1/stream?last_event_id=x")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	log := zap.NewNop().Sugar()
+	bus := events.NewBus(0)
+	router := NewRouter(mock, ingest.NewService("", "", mock, log), rec.NewService(mock), &mockPortfolioService{}, log, "", WithEvents(bus))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	maxTickers, pingPeriod, pongWait := wsMaxTickers, wsPingPeriod, wsPongWait
+	defer func() { wsMaxTickers, wsPingPeriod, wsPongWait = maxTickers, pingPeriod, pongWait }()
+	wsMaxTickers = 3
+	waitClosed := func() {
+		require.Eventually(t, func() bool { return bus.Subscribers() == 0 }, 2*time.Second, 5*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/ws", nil)
+	require.NoError(t, err)
+	send := func(msg string) {
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+	}
+	read := func(v any) {
+		typ, msg, err := conn.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, websocket.MessageText, typ)
+		require.NoError(t, json.Unmarshal(msg, v), string(msg))
+	}
+	reply := func(msg string) wsReply {
+		send(msg)
+		var r wsReply
+		read(&r)
+		return r
+	}
+
+	r := reply(`{"type":"subscribe","id":"1","tickers":["aapl"]}`)
+	assert.Equal(t, wsReply{Type: "subscribed", ID: "1", Tickers: []string{"AAPL"}}, r)
+	mock.ExpectQuery(`SELECT ticker FROM watchlist`).WillReturnRows(pgxmock.NewRows([]string{"ticker"}).AddRow("MSFT"))
+	r = reply(`{"type":"subscribe","id":"2","watchlist":true}`)
+	assert.Equal(t, wsReply{Type: "subscribed", ID: "2", Tickers: []string{"AAPL", "MSFT"}, Watchlist: true}, r)
+
+	// Only quote and rating events about subscribed tickers are sent.
+	bus.Publish(events.TypeQuote, "NVDA", events.Quote{Ticker: "NVDA", Price: 120})
+	bus.Publish(events.TypeQuote, "MSFT", events.Quote{Ticker: "MSFT", Price: 410})
+	bus.Publish(events.TypeRecommendationUpdate, "AAPL", events.RecommendationUpdate{Reason: events.ReasonFundamentals})
+	bus.Publish(events.TypeRatingChange, "AAPL", events.RatingChange{Ticker: "AAPL", RatingTo: "Buy"})
+	var e events.Event
+	read(&e)
+	assert.Equal(t, events.TypeQuote, e.Type)
+	assert.Equal(t, "MSFT", e.Ticker)
+	read(&e)
+	assert.Equal(t, events.TypeRatingChange, e.Type)
+	assert.Equal(t, "AAPL", e.Ticker)
+
+	r = reply(`{"type":"unsubscribe","id":"3","tickers":["AAPL"]}`)
+	assert.Equal(t, []string{"MSFT"}, r.Tickers)
+
+	// A request that would go over the limit changes nothing.
+	r = reply(`{"type":"subscribe","id":"4","tickers":["A","B","C"]}`)
+	assert.Equal(t, "error", r.Type)
+	require.NotNil(t, r.Error)
+	assert.Equal(t, CodeLimitExceeded, r.Error.Code)
+	assert.Equal(t, []string{"MSFT"}, r.Tickers)
+
+	r = reply(`{"type":"watch"}`)
+	assert.Equal(t, CodeInvalidRequest, r.Error.Code)
+	r = reply(`not json`)
+	assert.Equal(t, CodeInvalidRequest, r.Error.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.NoError(t, conn.Close(websocket.StatusNormalClosure, ""))
+	waitClosed()
+
+	// A client that stops answering pings is dropped; one that keeps reading stays.
+	wsPingPeriod, wsPongWait = 10*time.Millisecond, 100*time.Millisecond
+	silent, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", nil)
+	require.NoError(t, err)
+	defer silent.CloseNow()
+	live, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", nil)
+	require.NoError(t, err)
+	go func() {
+		for {
+			if _, _, err := live.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+	require.Eventually(t, func() bool { return bus.Subscribers() == 2 }, time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(3 * wsPongWait)
+	assert.Equal(t, 1, bus.Subscribers())
+	live.CloseNow()
+	waitClosed()
+
+	resp, err := http.Get(srv.URL + "/api/v1/ws")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, CodeInvalidRequest, body.Error.Code)
+}
+
+func TestAlerts(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Invalid rules never reach the database.
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/alerts", `{}`).Code)
+	w := do("POST", "/api/alerts", `{"kind":"price_above","ticker":"AAPL"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "threshold")
+
+	now := time.Now()
+	ticker, level := "AAPL", 200.0
+	mock.ExpectQuery(`INSERT INTO alerts`).
+		WithArgs(defaultUserID, alerts.KindPriceAbove, &ticker, &level, (*string)(nil), int(alerts.DefaultCooldown.Seconds())).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "kind", "ticker", "threshold", "brokerage", "cooldown_seconds", "enabled", "created_at"}).
+			AddRow("a1", alerts.KindPriceAbove, &ticker, &level, (*string)(nil), 86400, true, now))
+	w = do("POST", "/api/alerts", `{"kind":"price_above","ticker":"aapl","threshold":200}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var rule alerts.Rule
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+	assert.Equal(t, "a1", rule.ID)
+
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/alerts/not-a-uuid", "").Code)
+
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/alerts/events?limit=500", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/alerts/events?since=yesterday", "").Code)
+	value := 201.5
+	mock.ExpectQuery(`FROM alert_events`).
+		WithArgs(defaultUserID, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), "", 10).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "alert_id", "kind", "ticker", "message", "value", "triggered_at"}).
+			AddRow("e1", "a1", alerts.KindPriceAbove, "AAPL", "AAPL crossed above 200.00", &value, now))
+	w = do("GET", "/api/alerts/events?since=2026-01-02&limit=10", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var feed struct{ Items []alerts.Event }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &feed))
+	if assert.Len(t, feed.Items, 1) {
+		assert.Equal(t, "AAPL", feed.Items[0].Ticker)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhooks(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/webhooks", `{}`).Code)
+	w := do("POST", "/api/webhooks", `{"url":"https://chat.example.com/hook","events":["quote"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown event type")
+
+	mock.ExpectQuery(`INSERT INTO webhook_subscriptions`).
+		WithArgs(defaultUserID, "https://chat.example.com/hook", "0123456789abcdef", []string{events.TypeAlert}).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "url", "event_types", "enabled", "created_at"}).
+			AddRow("s1", "https://chat.example.com/hook", []string{events.TypeAlert}, true, time.Now()))
+	w = do("POST", "/api/webhooks", `{"url":"https://chat.example.com/hook","secret":"0123456789abcdef","events":["alert"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var sub webhooks.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.Equal(t, "s1", sub.ID)
+	assert.Equal(t, "0123456789abcdef", sub.Secret, "returned on creation")
+
+	const id = "5f0c6f5e-8f51-4d3e-9a47-0d7c2b3b9a11"
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/webhooks/"+id+"/deliveries?status=failed", "").Code)
+	mock.ExpectQuery(`SELECT 1 FROM webhook_subscriptions`).WithArgs(id, defaultUserID).WillReturnError(pgx.ErrNoRows)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/webhooks/"+id+"/deliveries", "").Code)
+
+	mock.ExpectQuery(`SELECT 1 FROM webhook_subscriptions`).WithArgs(id, defaultUserID).
+		WillReturnRows(pgxmock.NewRows([]string{"one"}).AddRow(1))
+	code := 503
+	lastErr := "status=503 body=maintenance"
+	mock.ExpectQuery(`FROM webhook_deliveries`).WithArgs(id, webhooks.StatusDead, 50).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "subscription_id", "event_type", "payload", "status", "attempts", "next_attempt_at",
+			"last_status", "last_error", "created_at", "delivered_at"}).
+			AddRow("d1", id, events.TypeAlert, `{"type":"alert"}`, webhooks.StatusDead, 10, time.Now(), &code, &lastErr, time.Now(), (*time.Time)(nil)))
+	w = do("GET", "/api/webhooks/"+id+"/deliveries?status=dead", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var log struct{ Items []webhooks.Delivery }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &log))
+	if assert.Len(t, log.Items, 1) {
+		assert.JSONEq(t, `{"type":"alert"}`, string(log.Items[0].Payload))
+		assert.Nil(t, log.Items[0].NextAttemptAt, "not pending")
+	}
+
+	mock.ExpectExec(`UPDATE webhook_deliveries SET status = 'pending'`).WithArgs(pgxmock.AnyArg(), id, defaultUserID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	assert.Equal(t, http.StatusAccepted, do("POST", "/api/webhooks/deliveries/"+id+"/redeliver", "").Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDigest(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/digest/preferences", `{"email":"nobody"}`).Code)
+	mock.ExpectQuery(`INSERT INTO digest_preferences`).WithArgs(defaultUserID, "me@example.com", true, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"email", "subscribed", "last_sent_on"}).AddRow("me@example.com", true, (*time.Time)(nil)))
+	w := do("PUT", "/api/digest/preferences", `{"email":"me@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"email":"me@example.com","subscribed":true,"last_sent_on":null}`, w.Body.String())
+
+	// Without SMTP configured the digest can only be previewed.
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/digest/send", "").Code)
+
+	p100, p120 := 100.0, 120.0
+	now := time.Now()
+	mock.ExpectQuery(`FROM digest_preferences WHERE user_id`).WithArgs(defaultUserID).
+		WillReturnRows(pgxmock.NewRows([]string{"email", "subscribed", "unsubscribe_token", "last_sent_on"}).
+			AddRow("me@example.com", true, "tok", (*time.Time)(nil)))
+	mock.ExpectQuery(`FROM stocks ORDER BY updated_at DESC LIMIT`).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at"}).
+			AddRow("TEST", "Test Company", "Test Brokerage", "Neutral", "Buy", &p100, &p120, (*float64)(nil), &now, now))
+	mock.ExpectQuery(`JOIN watchlist`).WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_to", "last_rating_change_at"}))
+	mock.ExpectQuery(`FROM portfolio p`).WithArgs(defaultUserID, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "price", "prev_close"}))
+	w = do("GET", "/api/digest/preview?format=text", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "- TEST Test Company: Buy (Test Brokerage)")
+	assert.Contains(t, w.Body.String(), "/api/v1/digest/unsubscribe?token=tok")
+
+	mock.ExpectExec(`SET subscribed = false`).WithArgs("nope").WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/digest/unsubscribe?token=nope", "").Code)
+	// Following the link only shows a confirmation form; link scanners unsubscribe nobody.
+	w = do("GET", "/api/digest/unsubscribe?token=t%22ok", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<form method="post" action="?token=t%22ok">`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// One-click (RFC 8058) answers JSON; the confirmation form gets a page.
+	mock.ExpectExec(`SET subscribed = false`).WithArgs("tok").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	w = do("POST", "/api/digest/unsubscribe?token=tok", "List-Unsubscribe=One-Click")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"unsubscribed"}`, w.Body.String())
+	mock.ExpectExec(`SET subscribed = false`).WithArgs("tok").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	req, _ := http.NewRequest("POST", "/api/digest/unsubscribe?token=tok", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "no longer receive")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type fakeProfiles map[string]*securities.Security
+
+func (f fakeProfiles) Profile(ctx context.Context, symbol string) (*securities.Security, error) {
+	return f[symbol], nil
+}
+
+func TestSyncSecuritiesUsesConfiguredService(t *testing.T) {
+	router, mock := setupMockRouter(t)
+	defer mock.Close()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/admin/securities/sync", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "no provider")
+
+	log := zap.NewNop().Sugar()
+	secs := securities.NewService(mock, log)
+	secs.SetProvider(fakeProfiles{"XOM": {Name: "Exxon Mobil", Sector: "Energy"}})
+	router2 := NewRouter(mock, ingest.NewService("", "", mock, log), rec.NewService(mock), &mockPortfolioService{}, log, "", WithSecurities(secs))
+	mock.ExpectQuery(`LEFT JOIN securities s`).WithArgs(securities.SourceCSV, pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol"}).AddRow("XOM"))
+	mock.ExpectExec(`UPSERT INTO securities`).WithArgs("XOM", "Exxon Mobil", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), securities.SourceProvider).
+		WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/admin/securities/sync", nil)
+	router2.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"refreshed":1}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"stockchallenge/backend/internal/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSuggestions serves autocomplete for ?q= over tracked stocks, securities reference data
+// and the watchlist, best match first. ?limit= caps the list (default 8, max 25).
+func (h *RouterDeps) getSuggestions(c *gin.Context) {
+	limit := search.DefaultSuggestLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	items, err := h.Search.Suggest(c.Request.Context(), search.Normalize(c.Query("q")), limit)
+	if err != nil {
+		h.Log.Warnf("search suggest failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"stockchallenge/backend/internal/securities"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listSecurities returns reference data, filtered by ?sector=, industry=, exchange= and country=.
+func (h *RouterDeps) listSecurities(c *gin.Context) {
+	items, err := h.Securities.List(c.Request.Context(), securities.Filter{
+		Sector:   c.Query("sector"),
+		Industry: c.Query("industry"),
+		Exchange: c.Query("exchange"),
+		Country:  c.Query("country"),
+	})
+	if err != nil {
+		h.Log.Warnf("list securities failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// getSectors lists sectors with their industries, for building filters.
+func (h *RouterDeps) getSectors(c *gin.Context) {
+	items, err := h.Securities.SectorBreakdown(c.Request.Context())
+	if err != nil {
+		h.Log.Warnf("sector breakdown failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *RouterDeps) getSecurity(c *gin.Context) {
+	sec, err := h.Securities.Get(c.Request.Context(), c.Param("symbol"))
+	if errors.Is(err, securities.ErrNotFound) {
+		writeError(c, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("get security failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, sec)
+}
+
+// importSecurities upserts reference data from a CSV (raw body or "file" multipart field).
+// See securities.ParseCSV for the columns. Imported rows are not overwritten by the provider.
+func (h *RouterDeps) importSecurities(c *gin.Context) {
+	data, err := readImportBody(c)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		writeError(c, http.StatusBadRequest, "csv file is required")
+		return
+	}
+	secs, err := securities.ParseCSV(bytes.NewReader(data))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	n, err := h.Securities.Upsert(c.Request.Context(), secs)
+	if err != nil {
+		h.Log.Warnf("import securities failed: %v", err)
+		writeDBError(c, err, "failed to save securities")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"parsed": len(secs), "saved": n})
+}
+
+// syncSecurities refreshes missing or stale reference data from the provider.
+func (h *RouterDeps) syncSecurities(c *gin.Context) {
+	if h.Securities.Provider == nil {
+		writeError(c, http.StatusServiceUnavailable, "securities provider not configured")
+		return
+	}
+	n, err := h.Securities.Sync(c.Request.Context())
+	if err != nil {
+		h.Log.Warnf("securities sync failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "securities sync failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"refreshed": n})
+}
+
+// securityFilter turns ?sector= and ?industry= into a condition on the queried table's ticker
+// column. Placeholders continue from len(args) and the values are appended to args. It
+// returns "" when neither filter is set.
+func securityFilter(c *gin.Context, args []any) (string, []any) {
+	return securityCondition(c.Query("sector"), c.Query("industry"), args)
+}
+
+func securityCondition(sector, industry string, args []any) (string, []any) {
+	var conds []string
+	for _, f := range []struct{ col, value string }{{"sector", sector}, {"industry", industry}} {
+		if v := strings.TrimSpace(f.value); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("lower(%s) = lower($%d)", f.col, len(args)))
+		}
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return "ticker IN (SELECT symbol FROM securities WHERE " + strings.Join(conds, " AND ") + ")", args
+}
+
+// attachSecurities adds name, exchange, sector, industry, country and market cap to items
+// keyed by their "ticker". Lookup failures only cost the extra fields.
+func (h *RouterDeps) attachSecurities(ctx context.Context, items []map[string]any) {
+	if h.Securities == nil || len(items) == 0 {
+		return
+	}
+	tickers := make([]string, 0, len(items))
+	for _, it := range items {
+		if t, ok := it["ticker"].(string); ok {
+			tickers = append(tickers, t)
+		}
+	}
+	secs, err := h.Securities.Lookup(ctx, tickers)
+	if err != nil {
+		h.Log.Warnf("securities lookup failed: %v", err)
+		return
+	}
+	for _, it := range items {
+		t, _ := it["ticker"].(string)
+		sec, ok := secs[t]
+		if !ok {
+			continue
+		}
+		it["name"] = sec.Name
+		it["exchange"] = sec.Exchange
+		it["sector"] = sec.Sector
+		it["industry"] = sec.Industry
+		it["country"] = sec.Country
+		it["market_cap"] = sec.MarketCap
+	}
+}
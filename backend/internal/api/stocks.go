@@ -0,0 +1,584 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/export"
+	"stockchallenge/backend/internal/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stockSortFields are the columns the stock list can be ordered by.
+var stockSortFields = map[string]bool{
+	"ticker": true, "company": true, "brokerage": true, "action": true, "rating_from": true, "rating_to": true,
+	"target_from": true, "target_to": true, "updated_at": true, "price_target_delta": true, "last_rating_change_at": true,
+}
+
+// enrichedSortFields are the enriched values the stock list can be ordered by, computed over
+// the quotes_cache (qc) and fundamentals (f) joins the same way rec.Service.EnrichTicker does.
+// intrinsic_value_2 only rescales intrinsic_value by the bond yield, so it orders the same.
+var enrichedSortFields = map[string]string{
+	"current_price":     "CASE WHEN qc.price > 0 THEN qc.price END",
+	"percent_upside":    "CASE WHEN qc.price > 0 AND stocks.target_to > 0 THEN stocks.target_to / qc.price - 1 END",
+	"eps":               "f.eps_avg",
+	"growth":            "f.growth_estimate",
+	"intrinsic_value":   "f.eps_avg * (8.5 + 200 * f.growth_estimate)",
+	"intrinsic_value_2": "f.eps_avg * (8.5 + 200 * f.growth_estimate)",
+}
+
+// sortRelevance orders a search by how well each stock matches q (see search.Relevance).
+const sortRelevance = "relevance"
+
+func sortable(field string) bool {
+	_, enriched := enrichedSortFields[field]
+	return stockSortFields[field] || enriched || field == sortRelevance
+}
+
+// canSort reports whether q can be ordered by field; relevance needs a search term.
+func (q stockQuery) canSort(field string) bool {
+	return sortable(field) && (field != sortRelevance || q.Search != "")
+}
+
+// computed reports whether q's sort value is calculated per query rather than a stocks column.
+func (q stockQuery) computed() bool {
+	_, enriched := enrichedSortFields[q.Sort]
+	return enriched || q.Sort == sortRelevance
+}
+
+// stockQuery is the parsed stock list query string. Every filter is optional and the ones
+// that are set are combined with AND.
+type stockQuery struct {
+	Search     string
+	Brokerages []string
+	Actions    []string
+	RatingsTo  []string
+	// TargetMin/Max bound target_to, DeltaMin/Max bound price_target_delta.
+	TargetMin, TargetMax *float64
+	DeltaMin, DeltaMax   *float64
+	// ChangedFrom is inclusive and ChangedTo exclusive, both on last_rating_change_at.
+	ChangedFrom, ChangedTo *time.Time
+	Watchlist, Held        *bool
+	Sector, Industry       string
+	// MinUpside/MaxUpside bound target_to / cached price - 1, the same ratio the enriched
+	// percent_upside field reports. Stocks without a cached price never match.
+	MinUpside, MaxUpside *float64
+
+	Sort  string
+	Order string
+	// Cursor, when set, replaces Page: the page starts after (or ends before) that row.
+	Cursor *stockCursor
+	Page   int
+	Limit  int
+	Enrich bool
+	// Format is the negotiated response format; anything but JSON streams every match.
+	Format string
+}
+
+// parseStockQuery reads filters, sorting and paging from the query string. sort and order
+// fall back to the given defaults when missing or not allowed, except that a search is
+// ordered by relevance unless a sort is given. Malformed filter values are an error so a typo
+// does not silently widen the result.
+func parseStockQuery(c *gin.Context, sort, order string) (stockQuery, error) {
+	q := stockQuery{
+		Search:     search.Normalize(c.Query("q")),
+		Brokerages: queryList(c, "brokerage"),
+		Actions:    queryList(c, "action"),
+		RatingsTo:  queryList(c, "rating_to"),
+		Sector:     strings.TrimSpace(c.Query("sector")),
+		Industry:   strings.TrimSpace(c.Query("industry")),
+		Sort:       strings.ToLower(strings.TrimSpace(c.DefaultQuery("sort", sort))),
+		Order:      strings.ToUpper(strings.TrimSpace(c.DefaultQuery("order", order))),
+		Enrich:     strings.ToLower(strings.TrimSpace(c.DefaultQuery("enrich", "false"))) == "true",
+	}
+	if q.Search != "" && c.Query("sort") == "" {
+		q.Sort, q.Order = sortRelevance, "DESC"
+	}
+	if !q.canSort(q.Sort) {
+		q.Sort = sort
+	}
+	if q.Order != "ASC" && q.Order != "DESC" {
+		q.Order = order
+	}
+	q.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	q.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Limit < 1 || q.Limit > 100 {
+		q.Limit = 20
+	}
+
+	var err error
+	if q.Format, err = export.Negotiate(c.Query("format"), c.GetHeader("Accept")); err != nil {
+		return q, err
+	}
+	if token := strings.TrimSpace(c.Query("cursor")); token != "" {
+		if q.Cursor, err = decodeCursor(token); err != nil {
+			return q, err
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst **float64
+	}{
+		{"target_min", &q.TargetMin}, {"target_max", &q.TargetMax},
+		{"delta_min", &q.DeltaMin}, {"delta_max", &q.DeltaMax},
+		{"min_upside", &q.MinUpside}, {"max_upside", &q.MaxUpside},
+	} {
+		if *f.dst, err = queryFloat(c, f.key); err != nil {
+			return q, err
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst **bool
+	}{{"watchlist", &q.Watchlist}, {"held", &q.Held}} {
+		if *f.dst, err = queryBool(c, f.key); err != nil {
+			return q, err
+		}
+	}
+	if q.ChangedFrom, err = queryDate(c, "changed_from"); err != nil {
+		return q, err
+	}
+	if q.ChangedTo, err = queryDate(c, "changed_to"); err != nil {
+		return q, err
+	}
+	if v := strings.TrimSpace(c.Query("changed_within")); v != "" {
+		d, err := parseWindow(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid changed_within: %q", v)
+		}
+		from := time.Now().UTC().Add(-d)
+		q.ChangedFrom = &from
+	}
+	return q, nil
+}
+
+// where builds the WHERE condition (without the keyword) and its arguments, or "" when no
+// filter is set. The search term, when present, is always $1.
+func (q stockQuery) where() (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.Search != "" {
+		conds = append(conds, search.Match("ticker", "company", arg(q.Search)))
+	}
+	for _, f := range []struct {
+		col    string
+		values []string
+	}{{"brokerage", q.Brokerages}, {"action", q.Actions}, {"rating_to", q.RatingsTo}} {
+		if len(f.values) > 0 {
+			lower := make([]string, len(f.values))
+			for i, v := range f.values {
+				lower[i] = strings.ToLower(v)
+			}
+			conds = append(conds, "lower("+f.col+") = ANY("+arg(lower)+")")
+		}
+	}
+	for _, f := range []struct {
+		cond string
+		v    *float64
+	}{
+		{"target_to >= ", q.TargetMin}, {"target_to <= ", q.TargetMax},
+		{"price_target_delta >= ", q.DeltaMin}, {"price_target_delta <= ", q.DeltaMax},
+	} {
+		if f.v != nil {
+			conds = append(conds, f.cond+arg(*f.v))
+		}
+	}
+	if q.ChangedFrom != nil {
+		conds = append(conds, "last_rating_change_at >= "+arg(*q.ChangedFrom))
+	}
+	if q.ChangedTo != nil {
+		conds = append(conds, "last_rating_change_at < "+arg(*q.ChangedTo))
+	}
+	if q.Watchlist != nil {
+		conds = append(conds, notIf(!*q.Watchlist, "EXISTS (SELECT 1 FROM watchlist w WHERE w.ticker = stocks.ticker)"))
+	}
+	if q.Held != nil {
+		conds = append(conds, notIf(!*q.Held, "EXISTS (SELECT 1 FROM portfolio p WHERE p.ticker = stocks.ticker AND p.user_id = "+arg(defaultUserID)+" AND p.position > 0)"))
+	}
+	if q.MinUpside != nil || q.MaxUpside != nil {
+		upside := "EXISTS (SELECT 1 FROM quotes_cache qc WHERE qc.symbol = stocks.ticker AND qc.price > 0 AND stocks.target_to > 0"
+		if q.MinUpside != nil {
+			upside += " AND stocks.target_to / qc.price - 1 >= " + arg(*q.MinUpside)
+		}
+		if q.MaxUpside != nil {
+			upside += " AND stocks.target_to / qc.price - 1 <= " + arg(*q.MaxUpside)
+		}
+		conds = append(conds, upside+")")
+	}
+	if filter, a := securityCondition(q.Sector, q.Industry, args); filter != "" {
+		conds, args = append(conds, filter), a
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// source returns the FROM source for q and, for computed sorts, the extra select column
+// carrying the sort value as text. Computed sorts read from a subquery over stocks aliased
+// back to stocks so filters apply unchanged; enriched ones join the cached quote and
+// fundamentals.
+func (q stockQuery) source() (from, sortCol string) {
+	if q.Sort == sortRelevance {
+		// where puts the search term in $1.
+		return `(
+  SELECT stocks.*, ` + search.Relevance("stocks.ticker", "stocks.company", "$1") + ` AS relevance
+  FROM stocks
+) AS stocks`, ", relevance::STRING"
+	}
+	expr, ok := enrichedSortFields[q.Sort]
+	if !ok {
+		return "stocks", ""
+	}
+	from = `(
+  SELECT stocks.*, ` + expr + ` AS ` + q.Sort + `
+  FROM stocks
+  LEFT JOIN quotes_cache qc ON qc.symbol = stocks.ticker
+  LEFT JOIN fundamentals f ON f.ticker = stocks.ticker
+) AS stocks`
+	return from, ", " + q.Sort + "::STRING"
+}
+
+// orderBy returns the ORDER BY terms for q, reversed when walking back from a cursor. Ties
+// are broken by id so pages never overlap, and missing values sort last either way.
+func (q stockQuery) orderBy(reverse bool) string {
+	dir := q.Order
+	if reverse {
+		dir = map[string]string{"ASC": "DESC", "DESC": "ASC"}[dir]
+	}
+	order := q.Sort + " " + dir + ", id " + dir
+	if q.nullable() {
+		nulls := q.Sort + " IS NULL"
+		if reverse {
+			nulls += " DESC"
+		}
+		order = nulls + ", " + order
+	}
+	return order
+}
+
+func notIf(neg bool, cond string) string {
+	if neg {
+		return "NOT " + cond
+	}
+	return cond
+}
+
+// queryStocks serves one page of stocks for q with the total match count and cursors for
+// the pages before and after it, or every matching stock when q asks for an export format.
+func (h *RouterDeps) queryStocks(c *gin.Context, q stockQuery) {
+	if q.Format != export.FormatJSON {
+		h.exportStocks(c, q)
+		return
+	}
+	filter, args := q.where()
+	cond, listArgs := filter, append([]any{}, args...)
+	reverse := false
+	if q.Cursor != nil {
+		if q.Cursor.Sort != q.Sort || q.Cursor.Order != q.Order {
+			writeError(c, http.StatusBadRequest, "cursor does not match sort and order")
+			return
+		}
+		var keyset string
+		keyset, listArgs = q.keyset(listArgs)
+		cond = keyset
+		if filter != "" {
+			cond = filter + " AND " + keyset
+		}
+		reverse = q.Cursor.Prev
+	}
+	where := ""
+	if cond != "" {
+		where = "WHERE " + cond + "\n"
+	}
+	from, sortCol := q.source()
+	page := `LIMIT $` + strconv.Itoa(len(listArgs)+1) + ` OFFSET $` + strconv.Itoa(len(listArgs)+2)
+	if q.Cursor != nil {
+		// One extra row tells whether there is another page in the direction of travel.
+		page = `LIMIT $` + strconv.Itoa(len(listArgs)+1)
+		listArgs = append(listArgs, q.Limit+1)
+	} else {
+		listArgs = append(listArgs, q.Limit, (q.Page-1)*q.Limit)
+	}
+	sql := `
+SELECT ` + stockColumns + sortCol + `
+FROM ` + from + `
+` + where + `ORDER BY ` + q.orderBy(reverse) + `
+` + page + `
+`
+	rows, err := h.DB.Query(c, sql, listArgs...)
+	if err != nil {
+		h.Log.Warnf("stock list query error: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	var sortValues []*string
+	for rows.Next() {
+		m, sortValue, err := scanStock(rows, sortCol != "")
+		if err != nil {
+			h.Log.Warnf("scan error: %v", err)
+			continue
+		}
+		items = append(items, m)
+		sortValues = append(sortValues, sortValue)
+	}
+	rows.Close()
+
+	more := len(items) > q.Limit
+	if more {
+		items, sortValues = items[:q.Limit], sortValues[:q.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			sortValues[i], sortValues[j] = sortValues[j], sortValues[i]
+		}
+	}
+
+	if q.Enrich && h.Recommender != nil {
+		h.enrichItems(c.Request.Context(), items)
+	}
+	h.attachSecurities(c.Request.Context(), items)
+
+	countWhere := ""
+	if filter != "" {
+		countWhere = "WHERE " + filter + "\n"
+	}
+	var total int64
+	if err := h.DB.QueryRow(c, `
+SELECT count(*) FROM stocks
+`+countWhere, args...).Scan(&total); err != nil {
+		total = int64(len(items))
+	}
+
+	var next, prev *string
+	if n := len(items); n > 0 {
+		hasNext, hasPrev := more, true
+		switch {
+		case q.Cursor == nil:
+			hasNext, hasPrev = int64((q.Page-1)*q.Limit+n) < total, q.Page > 1
+		case reverse:
+			hasNext, hasPrev = true, more
+		}
+		if hasNext {
+			next = q.cursorAt(items[n-1], sortValues[n-1], false)
+		}
+		if hasPrev {
+			prev = q.cursorAt(items[0], sortValues[0], true)
+		}
+	}
+
+	resp := gin.H{
+		"items":       items,
+		"limit":       q.Limit,
+		"total":       total,
+		"sort":        q.Sort,
+		"order":       q.Order,
+		"next_cursor": next,
+		"prev_cursor": prev,
+	}
+	if q.Cursor == nil {
+		resp["page"] = q.Page
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// stockFields are the columns every stock item has, in stockColumns order.
+var stockFields = []string{
+	"id", "ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to",
+	"last_rating_change_at", "price_target_delta", "created_at", "updated_at",
+}
+
+var stockColumns = strings.Join(stockFields, ", ")
+
+// scanStock reads a row of stockColumns, followed by the text sort value when withSort is set.
+func scanStock(r rowScanner, withSort bool) (map[string]any, *string, error) {
+	var (
+		id, ticker, company, brokerage, action, ratingFrom, ratingTo string
+		targetFrom, targetTo, priceDelta                             *float64
+		lastChange                                                   *time.Time
+		createdAt, updatedAt                                         time.Time
+		sortValue                                                    *string
+	)
+	dest := []any{
+		&id, &ticker, &company, &brokerage, &action, &ratingFrom, &ratingTo,
+		&targetFrom, &targetTo, &lastChange, &priceDelta, &createdAt, &updatedAt,
+	}
+	if withSort {
+		dest = append(dest, &sortValue)
+	}
+	if err := r.Scan(dest...); err != nil {
+		return nil, nil, err
+	}
+	return gin.H{
+		"id":                    id,
+		"ticker":                ticker,
+		"company":               company,
+		"brokerage":             brokerage,
+		"action":                action,
+		"rating_from":           ratingFrom,
+		"rating_to":             ratingTo,
+		"target_from":           targetFrom,
+		"target_to":             targetTo,
+		"last_rating_change_at": lastChange,
+		"price_target_delta":    priceDelta,
+		"created_at":            createdAt,
+		"updated_at":            updatedAt,
+	}, sortValue, nil
+}
+
+// enrichItems adds price, upside and valuation fields to items keyed by "ticker" and with
+// "target_to", using cached data only. A failed lookup only costs the extra fields.
+func (h *RouterDeps) enrichItems(ctx context.Context, items []map[string]any) {
+	tickers := make([]string, 0, len(items))
+	for _, it := range items {
+		if t, ok := it["ticker"].(string); ok {
+			tickers = append(tickers, t)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	enriched, err := h.Recommender.EnrichBatch(ctx, tickers)
+	if err != nil {
+		h.Log.Warnf("enrich failed: %v", err)
+		return
+	}
+	for _, it := range items {
+		t, _ := it["ticker"].(string)
+		e, ok := enriched[t]
+		if !ok {
+			continue
+		}
+		targetTo, _ := it["target_to"].(*float64)
+		for k, v := range map[string]*float64{
+			"current_price":     e.Price,
+			"percent_upside":    e.Upside(targetTo),
+			"eps":               e.EPS,
+			"growth":            e.Growth,
+			"intrinsic_value":   e.Intrinsic,
+			"intrinsic_value_2": e.Intrinsic2,
+		} {
+			if v != nil {
+				it[k] = *v
+			}
+		}
+	}
+}
+
+// listStocks is the stock list with every filter; newest ratings first by default.
+func (h *RouterDeps) listStocks(c *gin.Context) {
+	q, err := parseStockQuery(c, "updated_at", "DESC")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.queryStocks(c, q)
+}
+
+// searchStocks is kept for existing clients: /api/stocks with a required q.
+func (h *RouterDeps) searchStocks(c *gin.Context) {
+	q, err := parseStockQuery(c, "updated_at", "DESC")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.Search == "" {
+		writeError(c, http.StatusBadRequest, "search query 'q' is required")
+		return
+	}
+	h.queryStocks(c, q)
+}
+
+// sortStocks is kept for existing clients: /api/stocks with the sort column in ?field=
+// (default ticker ASC).
+func (h *RouterDeps) sortStocks(c *gin.Context) {
+	q, err := parseStockQuery(c, "ticker", "ASC")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f := strings.ToLower(strings.TrimSpace(c.Query("field"))); q.canSort(f) {
+		q.Sort = f
+	}
+	h.queryStocks(c, q)
+}
+
+// queryList collects a repeatable, comma-separated parameter (?action=up&action=init or
+// ?action=up,init).
+func queryList(c *gin.Context, key string) []string {
+	var out []string
+	for _, raw := range c.QueryArray(key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+func queryFloat(c *gin.Context, key string) (*float64, error) {
+	v := strings.TrimSpace(c.Query(key))
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	return &f, nil
+}
+
+func queryBool(c *gin.Context, key string) (*bool, error) {
+	v := strings.TrimSpace(c.Query(key))
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	return &b, nil
+}
+
+// queryDate accepts YYYY-MM-DD or RFC 3339.
+func queryDate(c *gin.Context, key string) (*time.Time, error) {
+	v := strings.TrimSpace(c.Query(key))
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %q (want YYYY-MM-DD)", key, v)
+		}
+	}
+	return &t, nil
+}
+
+// parseWindow reads a look-back window as a number of days ("7d" or "7") or a Go duration
+// ("36h").
+func parseWindow(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(strings.TrimSuffix(v, "d")); err == nil && n > 0 {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, so proxies and load balancers
+// do not close it and clients notice a dead connection.
+var streamHeartbeat = 15 * time.Second
+
+// streamRetry is the reconnect delay suggested to EventSource clients, in milliseconds.
+const streamRetry = 3000
+
+// tickerSet is the tickers a client asked for; empty means every ticker.
+type tickerSet map[string]struct{}
+
+// newTickerSet normalizes tickers to upper case, skipping blank ones.
+func newTickerSet(tickers []string) tickerSet {
+	set := tickerSet{}
+	for _, t := range tickers {
+		if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+			set[t] = struct{}{}
+		}
+	}
+	return set
+}
+
+// parseTickers reads a comma-separated ticker list.
+func parseTickers(s string) tickerSet {
+	return newTickerSet(strings.Split(s, ","))
+}
+
+// match reports whether e concerns the set. Events about no single ticker match when they
+// list one of the set's tickers, or list none.
+func (s tickerSet) match(e events.Event) bool {
+	if len(s) == 0 {
+		return true
+	}
+	if e.Ticker != "" {
+		_, ok := s[e.Ticker]
+		return ok
+	}
+	if u, ok := e.Data.(events.RecommendationUpdate); ok && len(u.Tickers) > 0 {
+		for _, t := range u.Tickers {
+			if _, ok := s[t]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// streamEvents pushes quote, rating_change and recommendation_update events as Server-Sent
+// Events, optionally only for ?tickers=. A client reconnecting with Last-Event-ID (or
+// ?last_event_id=) first receives the events it missed, or a resync event when they are no
+// longer kept. A client too slow to keep up is disconnected and resumes the same way.
+func (h *RouterDeps) streamEvents(c *gin.Context) {
+	var lastID uint64
+	last := c.GetHeader("Last-Event-ID")
+	if last == "" {
+		last = c.Query("last_event_id")
+	}
+	if last != "" {
+		id, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+		lastID = id
+	}
+	tickers := parseTickers(c.Query("tickers"))
+
+	sub, backlog := h.Events.SubscribeFrom(lastID, 0)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop nginx from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry); err != nil {
+		return
+	}
+	for _, e := range backlog {
+		if !tickers.match(e) {
+			continue
+		}
+		if err := writeEvent(c.Writer, e); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					h.Log.Infof("event stream closed: client fell behind")
+				}
+				return
+			}
+			if !tickers.match(e) {
+				continue
+			}
+			if err := writeEvent(c.Writer, e); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeEvent writes e as one SSE message whose data is the event as JSON.
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
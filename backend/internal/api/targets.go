@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"stockchallenge/backend/internal/portfolio"
+	"stockchallenge/backend/internal/portfolio/rebalance"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *RouterDeps) getTargets(c *gin.Context) {
+	t, err := h.Portfolio.GetTargets(c.Request.Context(), defaultUserID)
+	if err != nil {
+		h.Log.Warnf("get targets failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// putTargets replaces the target allocation.
+// Body: {"tickers": {"AAPL": 0.3, ...}, "sectors": {"Technology": 0.5, ...}} with weights in 0..1.
+func (h *RouterDeps) putTargets(c *gin.Context) {
+	var body portfolio.TargetAllocation
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	t, err := h.Portfolio.SetTargets(c.Request.Context(), defaultUserID, body)
+	if isTargetError(err) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("set targets failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to save targets")
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// rebalanceIn is the optional request shape for rebalancePortfolio.
+type rebalanceIn struct {
+	CashContribution float64                     `json:"cash_contribution"`
+	MinTrade         float64                     `json:"min_trade"`
+	WholeShares      *bool                       `json:"whole_shares"`
+	AllowSells       *bool                       `json:"allow_sells"`
+	Targets          *portfolio.TargetAllocation `json:"targets"`
+}
+
+// rebalancePortfolio suggests trades that bring positions back to target at cached prices,
+// in ?base= (defaults to BASE_CURRENCY). Body (all optional): {"cash_contribution": 1000,
+// "min_trade": 50, "whole_shares": true, "allow_sells": true, "targets": {...}}. targets
+// overrides the stored allocation.
+func (h *RouterDeps) rebalancePortfolio(c *gin.Context) {
+	var body rebalanceIn
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			writeError(c, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	if body.CashContribution < 0 || body.MinTrade < 0 {
+		writeError(c, http.StatusBadRequest, "cash_contribution and min_trade cannot be negative")
+		return
+	}
+	opts := portfolio.RebalanceOptions{
+		Base:             c.Query("base"),
+		Targets:          body.Targets,
+		CashContribution: body.CashContribution,
+		MinTrade:         body.MinTrade,
+		WholeShares:      body.WholeShares == nil || *body.WholeShares,
+		AllowSells:       body.AllowSells == nil || *body.AllowSells,
+	}
+
+	plan, err := h.Portfolio.Rebalance(c.Request.Context(), defaultUserID, opts)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, plan)
+	case isTargetError(err), errors.Is(err, rebalance.ErrNoTargets), errors.Is(err, portfolio.ErrInvalidCurrency):
+		writeError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, rebalance.ErrEmptyPortfolio), errors.Is(err, portfolio.ErrSectorDataUnavailable):
+		writeError(c, http.StatusUnprocessableEntity, err.Error())
+	default:
+		h.Log.Warnf("rebalance failed: %v", err)
+		writeError(c, http.StatusInternalServerError, "failed to compute rebalance")
+	}
+}
+
+func isTargetError(err error) bool {
+	return errors.Is(err, rebalance.ErrTargetsExceedOne) || errors.Is(err, rebalance.ErrNegativeTarget)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"stockchallenge/backend/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookIn is the request shape for createWebhook. An empty secret is generated and
+// returned once; empty events subscribes to every supported type.
+type webhookIn struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func (h *RouterDeps) listWebhooks(c *gin.Context) {
+	items, err := h.Webhooks.List(c.Request.Context(), defaultUserID)
+	if err != nil {
+		h.Log.Warnf("list webhooks failed: %v", err)
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *RouterDeps) createWebhook(c *gin.Context) {
+	var body webhookIn
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, http.StatusBadRequest, "url is required")
+		return
+	}
+	sub, err := h.Webhooks.Create(c.Request.Context(), defaultUserID, webhooks.Subscription{
+		URL: body.URL, Secret: body.Secret, EventTypes: body.Events,
+	})
+	var invalid *webhooks.InvalidSubscriptionError
+	if errors.As(err, &invalid) {
+		writeError(c, http.StatusBadRequest, invalid.Reason)
+		return
+	}
+	if err != nil {
+		h.Log.Warnf("create webhook failed: %v", err)
+		writeDBError(c, err, "failed to save webhook")
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (h *RouterDeps) deleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.Webhooks.Delete(c.Request.Context(), defaultUserID, id); err != nil {
+		writeDBError(c, err, "failed to delete webhook")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": "deleted"})
+}
+
+// listWebhookDeliveries is a subscription's delivery log, newest first: status= narrows it to
+// pending, delivered or dead deliveries, limit= caps it (default 50, at most 200).
+func (h *RouterDeps) listWebhookDeliveries(c *gin.Context) {
+	q := webhooks.DeliveryQuery{Status: c.Query("status"), Limit: 50}
+	switch q.Status {
+	case "", webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead:
+	default:
+		writeError(c, http.StatusBadRequest, "status must be pending, delivered or dead")
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			writeError(c, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		q.Limit = n
+	}
+	items, err := h.Webhooks.Deliveries(c.Request.Context(), defaultUserID, c.Param("id"), q)
+	if err != nil {
+		writeDBError(c, err, "query failed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// redeliverWebhook queues a delivery again, typically a dead-lettered one.
+func (h *RouterDeps) redeliverWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.Webhooks.Redeliver(c.Request.Context(), defaultUserID, id); err != nil {
+		writeDBError(c, err, "failed to queue delivery")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": webhooks.StatusPending})
+}
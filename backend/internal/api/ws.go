@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"stockchallenge/backend/internal/events"
+
+	"github.com/coder/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of /ws connections; variables so tests can shorten them.
+var (
+	// wsPingPeriod is how often the server pings. A client that does not answer a ping with
+	// a pong within wsPongWait is disconnected.
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 60 * time.Second
+	// wsWriteWait bounds each write, so a stalled client cannot hold its connection open.
+	wsWriteWait = 10 * time.Second
+	// wsMaxTickers caps the tickers one connection receives events for, watchlist and
+	// portfolio tickers included.
+	wsMaxTickers = 200
+	// wsSendBuffer is how many events can wait for a client. One that falls further behind is
+	// disconnected with StatusTryAgainLater.
+	wsSendBuffer = 64
+)
+
+// wsReadLimit is the largest message a client may send.
+const wsReadLimit = 4096
+
+// wsRequest is a client message. Type is "subscribe" or "unsubscribe"; the tickers, the
+// watchlist and the portfolio it names are added to or removed from the subscription.
+type wsRequest struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id,omitempty"`
+	Tickers   []string `json:"tickers,omitempty"`
+	Watchlist bool     `json:"watchlist,omitempty"`
+	Portfolio bool     `json:"portfolio,omitempty"`
+}
+
+// wsReply answers a wsRequest, echoing its ID, with the subscription as it is afterwards.
+// Type is "subscribed", or "error" when the request was refused and changed nothing.
+type wsReply struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id,omitempty"`
+	Tickers   []string  `json:"tickers"`
+	Watchlist bool      `json:"watchlist"`
+	Portfolio bool      `json:"portfolio"`
+	Error     *apiError `json:"error,omitempty"`
+}
+
+// wsSession is what one connection is subscribed to. The watchlist and portfolio sets are
+// their tickers when subscribed, and nil otherwise.
+type wsSession struct {
+	mu                   sync.Mutex
+	tickers              tickerSet
+	watchlist, portfolio tickerSet
+}
+
+// all returns every ticker the session receives events for; mu must be held.
+func (s *wsSession) all() tickerSet {
+	all := tickerSet{}
+	for _, set := range []tickerSet{s.tickers, s.watchlist, s.portfolio} {
+		for t := range set {
+			all[t] = struct{}{}
+		}
+	}
+	return all
+}
+
+// match reports whether e is a quote or rating event about a subscribed ticker.
+func (s *wsSession) match(e events.Event) bool {
+	if e.Type != events.TypeQuote && e.Type != events.TypeRatingChange {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, set := range []tickerSet{s.tickers, s.watchlist, s.portfolio} {
+		if _, ok := set[e.Ticker]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// update applies req, given the current watchlist and portfolio tickers when it subscribes
+// to them, unless the result would exceed wsMaxTickers.
+func (s *wsSession) update(req wsRequest, watchlist, portfolio tickerSet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := wsSession{tickers: tickerSet{}, watchlist: s.watchlist, portfolio: s.portfolio}
+	for t := range s.tickers {
+		next.tickers[t] = struct{}{}
+	}
+	for t := range newTickerSet(req.Tickers) {
+		if req.Type == "subscribe" {
+			next.tickers[t] = struct{}{}
+		} else {
+			delete(next.tickers, t)
+		}
+	}
+	if req.Type == "subscribe" {
+		if req.Watchlist {
+			next.watchlist = watchlist
+		}
+		if req.Portfolio {
+			next.portfolio = portfolio
+		}
+	} else {
+		if req.Watchlist {
+			next.watchlist = nil
+		}
+		if req.Portfolio {
+			next.portfolio = nil
+		}
+	}
+	if n := len(next.all()); n > wsMaxTickers {
+		return fmt.Errorf("subscription limit exceeded: %d tickers, at most %d", n, wsMaxTickers)
+	}
+	s.tickers, s.watchlist, s.portfolio = next.tickers, next.watchlist, next.portfolio
+	return nil
+}
+
+func (s *wsSession) reply(id string, err *apiError) wsReply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := wsReply{Type: "subscribed", ID: id, Tickers: []string{}, Watchlist: s.watchlist != nil, Portfolio: s.portfolio != nil}
+	for t := range s.all() {
+		r.Tickers = append(r.Tickers, t)
+	}
+	sort.Strings(r.Tickers)
+	if err != nil {
+		r.Type, r.Error = "error", err
+	}
+	return r
+}
+
+// serveWS upgrades to a WebSocket on which the client subscribes to tickers, the watchlist
+// or the portfolio and receives their quote and rating_change events, as sent on /stream.
+// Watchlist and portfolio tickers are looked up when subscribing; subscribing again picks
+// up later changes.
+func (h *RouterDeps) serveWS(c *gin.Context) {
+	if !wsIsUpgrade(c.Request) {
+		writeError(c, http.StatusBadRequest, "not a WebSocket handshake")
+		return
+	}
+	// Like the CORS headers, the socket is open to pages from any origin.
+	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		// Accept has already answered the client.
+		h.Log.Warnf("websocket upgrade failed: %v", err)
+		return
+	}
+	conn.SetReadLimit(wsReadLimit)
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	sub := h.Events.Subscribe(wsSendBuffer)
+	defer sub.Close()
+	session := &wsSession{tickers: tickerSet{}}
+	replies := make(chan wsReply)
+	readDone := make(chan struct{})
+	pingDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		h.wsRead(ctx, conn, session, replies)
+	}()
+	go func() {
+		defer close(pingDone)
+		wsPing(ctx, conn)
+	}()
+	defer func() {
+		cancel()
+		conn.CloseNow()
+		<-readDone
+		<-pingDone
+	}()
+
+	for {
+		var err error
+		select {
+		case <-readDone:
+			// The client closed or broke the protocol.
+			return
+		case <-pingDone:
+			// The client stopped answering pings.
+			return
+		case r := <-replies:
+			err = wsWriteJSON(ctx, conn, r)
+		case e, ok := <-sub.C:
+			if !ok {
+				_ = conn.Close(websocket.StatusTryAgainLater, "client too slow")
+				return
+			}
+			if session.match(e) {
+				err = wsWriteJSON(ctx, conn, e)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wsRead handles client messages until the connection fails or ctx ends, passing each reply
+// to the writing goroutine. Reading also answers the client's pings and takes its pongs.
+func (h *RouterDeps) wsRead(ctx context.Context, conn *websocket.Conn, session *wsSession, replies chan<- wsReply) {
+	for {
+		typ, msg, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		select {
+		case replies <- h.wsHandle(ctx, session, typ, msg):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wsPing pings every wsPingPeriod until a pong does not come back within wsPongWait or ctx ends.
+func wsPing(ctx context.Context, conn *websocket.Conn) {
+	t := time.NewTicker(wsPingPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			pingCtx, cancel := context.WithTimeout(ctx, wsPongWait)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h *RouterDeps) wsHandle(ctx context.Context, session *wsSession, typ websocket.MessageType, msg []byte) wsReply {
+	var req wsRequest
+	if typ != websocket.MessageText || json.Unmarshal(msg, &req) != nil {
+		return session.reply("", &apiError{Code: CodeInvalidRequest, Message: "messages must be JSON objects"})
+	}
+	if req.Type != "subscribe" && req.Type != "unsubscribe" {
+		return session.reply(req.ID, &apiError{Code: CodeInvalidRequest, Message: `type must be "subscribe" or "unsubscribe"`})
+	}
+	var watchlist, portfolio tickerSet
+	var err error
+	if req.Type == "subscribe" && req.Watchlist {
+		if watchlist, err = h.queryTickers(ctx, `SELECT ticker FROM watchlist`); err != nil {
+			h.Log.Warnf("websocket watchlist lookup: %v", err)
+			return session.reply(req.ID, &apiError{Code: CodeDatabase, Message: "watchlist lookup failed"})
+		}
+	}
+	if req.Type == "subscribe" && req.Portfolio {
+		if portfolio, err = h.queryTickers(ctx, `SELECT ticker FROM portfolio WHERE user_id = $1`, defaultUserID); err != nil {
+			h.Log.Warnf("websocket portfolio lookup: %v", err)
+			return session.reply(req.ID, &apiError{Code: CodeDatabase, Message: "portfolio lookup failed"})
+		}
+	}
+	if err := session.update(req, watchlist, portfolio); err != nil {
+		return session.reply(req.ID, &apiError{Code: CodeLimitExceeded, Message: err.Error(), Details: gin.H{"max_tickers": wsMaxTickers}})
+	}
+	return session.reply(req.ID, nil)
+}
+
+// queryTickers returns the tickers a single-column query selects.
+func (h *RouterDeps) queryTickers(ctx context.Context, query string, args ...any) (tickerSet, error) {
+	rows, err := h.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	set := tickerSet{}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		set[t] = struct{}{}
+	}
+	return set, rows.Err()
+}
+
+// wsWriteJSON sends v as a text message, giving up after wsWriteWait so a stalled client
+// cannot hold its connection open.
+func wsWriteJSON(ctx context.Context, conn *websocket.Conn, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wsWriteWait)
+	defer cancel()
+	return conn.Write(ctx, websocket.MessageText, b)
+}
+
+// wsIsUpgrade reports whether r asks to switch to the WebSocket protocol. Other requests get
+// the API's JSON error rather than the library's plain-text one.
+func wsIsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,6 +30,44 @@ type Config struct {
 	FundamentalsUpdateInterval time.Duration
 	GeminiAPIKey               string
 	GeminiModelID              string
+	// Screenshot extractor: gemini, openai, fixture or none. Empty picks gemini when
+	// GEMINI_API_KEY is set and none otherwise.
+	PortfolioExtractor   string
+	OpenAIBaseURL        string
+	OpenAIAPIKey         string
+	OpenAIModel          string
+	ExtractorFixturesDir string
+	// How often the portfolio snapshot job records each user's value (default daily)
+	SnapshotInterval time.Duration
+	// Currency portfolio totals are reported in unless ?base= is given
+	BaseCurrency string
+	// FX rate provider (Frankfurter) and how long fetched rates are reused
+	FXAPIBase string
+	FXTTL     time.Duration
+	// How often dividends are synced and recorded for held positions (default daily)
+	DividendSyncInterval time.Duration
+	// How often splits/ticker changes are synced and pending ones applied (default daily)
+	CorporateActionsInterval time.Duration
+	// Optional CSV of securities reference data loaded on startup (see securities.ParseCSV)
+	SecuritiesSeedFile string
+	// How often missing or stale sector/industry data is refreshed from the provider (default daily)
+	SecuritiesSyncInterval time.Duration
+	// How often quotes_cache and fundamentals are polled for changes to push to /api/stream
+	EventsPollInterval time.Duration
+	// How often due webhook deliveries (retries) are attempted
+	WebhookRetryInterval time.Duration
+	// Networks (CIDRs) webhooks may reach although they are private, loopback or link-local
+	WebhookAllowedNetworks []string
+	// SMTP server for the email digest; digests are not sent when SMTPHost is empty
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// Hour of the day (UTC) from which each subscriber's daily digest is sent
+	DigestHour int
+	// Base URL the backend is reachable at from an email client, for unsubscribe links
+	PublicURL string
 }
 
 func getenv(key, def string) string {
@@ -38,6 +77,17 @@ func getenv(key, def string) string {
 	return def
 }
 
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 func Load() (*Config, error) {
 	portStr := getenv("BACKEND_PORT", "8080")
 	port, err := strconv.Atoi(portStr)
@@ -101,9 +151,70 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid FUNDAMENTALS_UPDATE_INTERVAL: %w", err)
 	}
 
+	snapshotStr := getenv("SNAPSHOT_INTERVAL", "24h")
+	snapshotEvery, err := time.ParseDuration(snapshotStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNAPSHOT_INTERVAL: %w", err)
+	}
+
+	fxTTLStr := getenv("FX_TTL", "12h")
+	fxTTL, err := time.ParseDuration(fxTTLStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FX_TTL: %w", err)
+	}
+
+	dividendStr := getenv("DIVIDEND_SYNC_INTERVAL", "24h")
+	dividendEvery, err := time.ParseDuration(dividendStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DIVIDEND_SYNC_INTERVAL: %w", err)
+	}
+
+	actionsStr := getenv("CORPORATE_ACTIONS_INTERVAL", "24h")
+	actionsEvery, err := time.ParseDuration(actionsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CORPORATE_ACTIONS_INTERVAL: %w", err)
+	}
+
+	securitiesStr := getenv("SECURITIES_SYNC_INTERVAL", "24h")
+	securitiesEvery, err := time.ParseDuration(securitiesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECURITIES_SYNC_INTERVAL: %w", err)
+	}
+
+	eventsPollStr := getenv("EVENTS_POLL_INTERVAL", "10s")
+	eventsPollEvery, err := time.ParseDuration(eventsPollStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EVENTS_POLL_INTERVAL: %w", err)
+	}
+
+	webhookRetryStr := getenv("WEBHOOK_RETRY_INTERVAL", "30s")
+	webhookRetryEvery, err := time.ParseDuration(webhookRetryStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_RETRY_INTERVAL: %w", err)
+	}
+
+	smtpPortStr := getenv("SMTP_PORT", "587")
+	smtpPort, err := strconv.Atoi(smtpPortStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+	}
+	digestHourStr := getenv("DIGEST_HOUR", "7")
+	digestHour, err := strconv.Atoi(digestHourStr)
+	if err != nil || digestHour < 0 || digestHour > 23 {
+		return nil, fmt.Errorf("invalid DIGEST_HOUR: must be 0-23")
+	}
+
 	geminiAPIKey := getenv("GEMINI_API_KEY", "")
 	geminiModelID := getenv("GEMINI_MODEL_ID", "gemini-2.5-flash-lite")
 
+	extractor := getenv("PORTFOLIO_EXTRACTOR", "")
+	if extractor == "" {
+		extractor = "none"
+		if geminiAPIKey != "" {
+			extractor = "gemini"
+		}
+	}
+
 	return &Config{
 		BackendPort:                port,
 		DBURL:                      dbURL,
@@ -122,5 +233,28 @@ func Load() (*Config, error) {
 		FundamentalsUpdateInterval: fundUpdEvery,
 		GeminiAPIKey:               geminiAPIKey,
 		GeminiModelID:              geminiModelID,
+		PortfolioExtractor:         extractor,
+		OpenAIBaseURL:              getenv("OPENAI_BASE_URL", ""),
+		OpenAIAPIKey:               getenv("OPENAI_API_KEY", ""),
+		OpenAIModel:                getenv("OPENAI_MODEL", ""),
+		ExtractorFixturesDir:       getenv("EXTRACTOR_FIXTURES_DIR", ""),
+		SnapshotInterval:           snapshotEvery,
+		BaseCurrency:               strings.ToUpper(getenv("BASE_CURRENCY", "USD")),
+		FXAPIBase:                  getenv("FX_API_BASE", ""),
+		FXTTL:                      fxTTL,
+		DividendSyncInterval:       dividendEvery,
+		CorporateActionsInterval:   actionsEvery,
+		SecuritiesSeedFile:         getenv("SECURITIES_SEED_FILE", ""),
+		SecuritiesSyncInterval:     securitiesEvery,
+		EventsPollInterval:         eventsPollEvery,
+		WebhookRetryInterval:       webhookRetryEvery,
+		WebhookAllowedNetworks:     splitList(getenv("WEBHOOK_ALLOWED_NETWORKS", "")),
+		SMTPHost:                   getenv("SMTP_HOST", ""),
+		SMTPPort:                   smtpPort,
+		SMTPUsername:               getenv("SMTP_USERNAME", ""),
+		SMTPPassword:               getenv("SMTP_PASSWORD", ""),
+		SMTPFrom:                   getenv("SMTP_FROM", "Stock Digest <digest@localhost>"),
+		DigestHour:                 digestHour,
+		PublicURL:                  strings.TrimRight(getenv("PUBLIC_URL", fmt.Sprintf("http://localhost:%d", port)), "/"),
 	}, nil
 }
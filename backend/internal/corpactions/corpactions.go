@@ -0,0 +1,557 @@
+// Package corpactions records stock splits and ticker changes and applies them to stored
+// positions, ledger entries, analyst targets and cached prices so they stay consistent.
+package corpactions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Action types.
+const (
+	TypeSplit        = "split"
+	TypeSymbolChange = "symbol_change"
+)
+
+// Action statuses.
+const (
+	StatusPending = "pending"
+	StatusApplied = "applied"
+)
+
+// Action sources.
+const (
+	SourceManual   = "manual"
+	SourceCSV      = "csv"
+	SourceProvider = "provider"
+)
+
+var tickerPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-/]{0,11}$`)
+
+// Action is a split or a ticker change effective on ExDate. For splits, RatioTo new shares
+// replace every RatioFrom old shares (a 4-for-1 split is 1:4, a 1-for-10 reverse split 10:1).
+type Action struct {
+	ID        string     `json:"id"`
+	Symbol    string     `json:"symbol"`
+	Type      string     `json:"type"`
+	ExDate    time.Time  `json:"ex_date"`
+	RatioFrom *float64   `json:"ratio_from,omitempty"`
+	RatioTo   *float64   `json:"ratio_to,omitempty"`
+	NewSymbol *string    `json:"new_symbol,omitempty"`
+	Source    string     `json:"source"`
+	Status    string     `json:"status"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Factor is how many shares one old share becomes; prices are divided by it.
+func (a Action) Factor() float64 {
+	if a.RatioFrom == nil || a.RatioTo == nil || *a.RatioFrom == 0 {
+		return 1
+	}
+	return *a.RatioTo / *a.RatioFrom
+}
+
+// InvalidActionError reports the first problem found in a batch of actions.
+type InvalidActionError struct {
+	Index  int
+	Reason string
+}
+
+func (e *InvalidActionError) Error() string {
+	return fmt.Sprintf("action %d: %s", e.Index, e.Reason)
+}
+
+func normalizeTicker(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+func (a *Action) normalize(i int) error {
+	a.Symbol = normalizeTicker(a.Symbol)
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	if !tickerPattern.MatchString(a.Symbol) {
+		return &InvalidActionError{i, fmt.Sprintf("invalid symbol %q", a.Symbol)}
+	}
+	if a.ExDate.IsZero() {
+		return &InvalidActionError{i, "ex_date is required"}
+	}
+	a.ExDate = time.Date(a.ExDate.Year(), a.ExDate.Month(), a.ExDate.Day(), 0, 0, 0, 0, time.UTC)
+	switch a.Type {
+	case TypeSplit:
+		if a.RatioFrom == nil || a.RatioTo == nil || *a.RatioFrom <= 0 || *a.RatioTo <= 0 {
+			return &InvalidActionError{i, "split needs positive ratio_from and ratio_to"}
+		}
+		if *a.RatioFrom == *a.RatioTo {
+			return &InvalidActionError{i, "split ratio must not be 1:1"}
+		}
+		a.NewSymbol = nil
+	case TypeSymbolChange:
+		if a.NewSymbol == nil {
+			return &InvalidActionError{i, "symbol_change needs new_symbol"}
+		}
+		ns := normalizeTicker(*a.NewSymbol)
+		if !tickerPattern.MatchString(ns) || ns == a.Symbol {
+			return &InvalidActionError{i, fmt.Sprintf("invalid new_symbol %q", *a.NewSymbol)}
+		}
+		a.NewSymbol = &ns
+		a.RatioFrom, a.RatioTo = nil, nil
+	default:
+		return &InvalidActionError{i, "type must be split or symbol_change"}
+	}
+	if a.Source == "" {
+		a.Source = SourceManual
+	}
+	return nil
+}
+
+// Provider lists corporate actions from a market data vendor.
+// Implemented by internal/marketdata providers.
+type Provider interface {
+	Splits(ctx context.Context, symbol string, from time.Time) ([]Action, error)
+	SymbolChanges(ctx context.Context, from time.Time) ([]Action, error)
+}
+
+// Service stores corporate actions and applies them.
+type Service struct {
+	DB       db.DBTX
+	Log      *zap.SugaredLogger
+	Provider Provider
+}
+
+func NewService(db db.DBTX, log *zap.SugaredLogger) *Service {
+	return &Service{DB: db, Log: log}
+}
+
+// SetProvider enables Sync.
+func (s *Service) SetProvider(p Provider) {
+	s.Provider = p
+}
+
+// Add validates and stores actions as pending. Actions already known (same symbol, type
+// and ex-date) are ignored. It returns the number of new actions.
+func (s *Service) Add(ctx context.Context, actions []Action) (int, error) {
+	for i := range actions {
+		if err := actions[i].normalize(i); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, a := range actions {
+		tag, err := s.DB.Exec(ctx, `
+INSERT INTO corporate_actions (symbol, type, ex_date, ratio_from, ratio_to, new_symbol, source)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (symbol, type, ex_date) DO NOTHING
+`, a.Symbol, a.Type, a.ExDate, a.RatioFrom, a.RatioTo, a.NewSymbol, a.Source)
+		if err != nil {
+			return n, err
+		}
+		n += int(tag.RowsAffected())
+	}
+	return n, nil
+}
+
+const actionCols = `id::STRING, symbol, type, ex_date, ratio_from, ratio_to, new_symbol, source, status, applied_at, created_at`
+
+func scanActions(rows pgx.Rows) ([]Action, error) {
+	defer rows.Close()
+	out := []Action{}
+	for rows.Next() {
+		var a Action
+		if err := rows.Scan(&a.ID, &a.Symbol, &a.Type, &a.ExDate, &a.RatioFrom, &a.RatioTo, &a.NewSymbol, &a.Source, &a.Status, &a.AppliedAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// List returns actions, newest ex-date first, optionally filtered by status.
+func (s *Service) List(ctx context.Context, status string) ([]Action, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT `+actionCols+`
+FROM corporate_actions
+WHERE $1 = '' OR status = $1
+ORDER BY ex_date DESC, symbol
+`, status)
+	if err != nil {
+		return nil, err
+	}
+	return scanActions(rows)
+}
+
+// LogEntry is one audit row written when an action was applied.
+type LogEntry struct {
+	Table        string          `json:"table"`
+	RowsAffected int             `json:"rows_affected"`
+	Detail       json.RawMessage `json:"detail,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// AuditLog returns what applying action id changed.
+func (s *Service) AuditLog(ctx context.Context, id string) ([]LogEntry, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT table_name, rows_affected, detail, created_at
+FROM corporate_action_log
+WHERE action_id = $1
+ORDER BY created_at, table_name
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []LogEntry{}
+	for rows.Next() {
+		var (
+			e      LogEntry
+			detail []byte
+		)
+		if err := rows.Scan(&e.Table, &e.RowsAffected, &detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(detail) > 0 {
+			e.Detail = detail
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Sync pulls splits since from for every symbol held, traded or watched, plus ticker
+// changes affecting them, and stores them as pending. Without a provider it is a no-op.
+func (s *Service) Sync(ctx context.Context, from time.Time) (int, error) {
+	if s.Provider == nil {
+		return 0, nil
+	}
+	rows, err := s.DB.Query(ctx, `
+SELECT ticker FROM portfolio
+UNION SELECT ticker FROM portfolio_transactions
+UNION SELECT ticker FROM watchlist
+`)
+	if err != nil {
+		return 0, err
+	}
+	tracked := map[string]bool{}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err == nil {
+			tracked[t] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var found []Action
+	for sym := range tracked {
+		splits, err := s.Provider.Splits(ctx, sym, from)
+		if err != nil {
+			if s.Log != nil {
+				s.Log.Warnf("splits %s: %v", sym, err)
+			}
+			continue
+		}
+		found = append(found, splits...)
+	}
+	changes, err := s.Provider.SymbolChanges(ctx, from)
+	if err != nil {
+		if s.Log != nil {
+			s.Log.Warnf("symbol changes: %v", err)
+		}
+	}
+	for _, c := range changes {
+		if tracked[normalizeTicker(c.Symbol)] {
+			found = append(found, c)
+		}
+	}
+
+	valid := found[:0]
+	for i, a := range found {
+		a.Source = SourceProvider
+		if err := a.normalize(i); err != nil {
+			if s.Log != nil {
+				s.Log.Warnf("skipping provider action %s: %v", a.Symbol, err)
+			}
+			continue
+		}
+		valid = append(valid, a)
+	}
+	return s.Add(ctx, valid)
+}
+
+// ApplyPending applies every pending action with an ex-date up to today, oldest first,
+// and returns the ones applied. It stops at the first failure.
+func (s *Service) ApplyPending(ctx context.Context, today time.Time) ([]Action, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT `+actionCols+`
+FROM corporate_actions
+WHERE status = $1 AND ex_date <= $2
+ORDER BY ex_date, created_at
+`, StatusPending, today)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := scanActions(rows)
+	if err != nil {
+		return nil, err
+	}
+	applied := []Action{}
+	for _, a := range pending {
+		if err := s.Apply(ctx, a); err != nil {
+			return applied, fmt.Errorf("apply %s %s: %w", a.Type, a.Symbol, err)
+		}
+		a.Status = StatusApplied
+		applied = append(applied, a)
+	}
+	return applied, nil
+}
+
+// step is one statement run when applying an action. collision, when set, explains in the
+// audit log that the rows it touched clashed with rows already under the new symbol.
+type step struct {
+	table     string
+	sql       string
+	collision string
+}
+
+// splitStep scales the rows of table matching where, skipping rows already recorded in
+// applied_corporate_actions for this action and recording the ones it scales; key is the
+// row's identity within the table. Its statement takes ($1 symbol, $2 factor, $3 ex-date,
+// $4 action id).
+func splitStep(table, set, where, key string) step {
+	return step{table: table, sql: `
+WITH adjusted AS (
+    UPDATE ` + table + ` SET ` + set + `
+    WHERE ` + where + ` AND NOT EXISTS (
+        SELECT 1 FROM applied_corporate_actions l
+        WHERE l.action_id = $4::UUID AND l.table_name = '` + table + `' AND l.row_key = ` + key + `)
+    RETURNING ` + key + ` AS row_key
+)
+INSERT INTO applied_corporate_actions (action_id, table_name, row_key)
+SELECT $4::UUID, '` + table + `', row_key FROM adjusted
+`}
+}
+
+// splitSteps scale positions opened before the ex-date and every dated row from before it,
+// however late it was written; the ledger keeps a row from being scaled twice. Quotes and
+// fundamentals are snapshots dated by when they were fetched. daily_prices is left alone: the
+// Fundamentals API writes it split-adjusted already.
+var splitSteps = []step{
+	splitStep("portfolio", `position = position * $2::DECIMAL, average_price = average_price / $2::DECIMAL, updated_at = now()`,
+		`ticker = $1 AND created_at < $3`, `portfolio.id::STRING`),
+	splitStep("portfolio_transactions", `quantity = quantity * $2::DECIMAL, price = price / $2::DECIMAL`,
+		`ticker = $1 AND trade_date < $3`, `portfolio_transactions.id::STRING`),
+	splitStep("stocks", `target_from = target_from / $2::DECIMAL, target_to = target_to / $2::DECIMAL`,
+		`ticker = $1 AND COALESCE(last_rating_change_at, created_at) < $3`, `stocks.ticker`),
+	splitStep("quotes_cache", `price = price / $2::DECIMAL`,
+		`symbol = $1 AND as_of < $3`, `quotes_cache.symbol`),
+	splitStep("dividends", `amount = amount / $2::DECIMAL`,
+		`symbol = $1 AND ex_date < $3`, `dividends.ex_date::STRING`),
+	splitStep("eps_points", `eps_reported = eps_reported / $2::DECIMAL, eps_basic = eps_basic / $2::DECIMAL, eps_diluted = eps_diluted / $2::DECIMAL`,
+		`ticker = $1 AND period_date < $3`, `eps_points.id::STRING`),
+	splitStep("fundamentals", `eps_avg = eps_avg / $2::DECIMAL`,
+		`ticker = $1 AND updated_at < $3`, `fundamentals.ticker`),
+}
+
+// renameSteps take ($1 old symbol, $2 new symbol). Where the new symbol already has a row,
+// a position in the same currency and a ticker target are merged into it, and cached or
+// reference data under the old symbol is dropped in favour of the new symbol's. Both are
+// recorded in the audit log as collisions.
+var renameSteps = []step{
+	{table: "portfolio", collision: "merged into the position under the new symbol", sql: `
+UPDATE portfolio SET position = portfolio.position + o.position,
+    average_price = COALESCE((portfolio.position * portfolio.average_price + o.position * o.average_price) / NULLIF(portfolio.position + o.position, 0), portfolio.average_price),
+    updated_at = now()
+FROM portfolio o
+WHERE portfolio.ticker = $2 AND o.ticker = $1 AND o.user_id = portfolio.user_id AND o.currency = portfolio.currency`},
+	{table: "portfolio", collision: "removed after merging into the new symbol", sql: `DELETE FROM portfolio WHERE ticker = $1 AND EXISTS (SELECT 1 FROM portfolio p WHERE p.user_id = portfolio.user_id AND p.ticker = $2 AND p.currency = portfolio.currency)`},
+	{table: "portfolio", sql: `UPDATE portfolio SET ticker = $2, updated_at = now() WHERE ticker = $1 AND NOT EXISTS (SELECT 1 FROM portfolio p WHERE p.user_id = portfolio.user_id AND p.ticker = $2)`},
+	{table: "portfolio_transactions", sql: `UPDATE portfolio_transactions SET ticker = $2 WHERE ticker = $1`},
+	{table: "portfolio_targets", collision: "weight added to the target for the new symbol", sql: `
+UPDATE portfolio_targets SET weight = portfolio_targets.weight + o.weight, updated_at = now()
+FROM portfolio_targets o
+WHERE portfolio_targets.kind = 'ticker' AND portfolio_targets.key = $2 AND o.kind = 'ticker' AND o.key = $1 AND o.user_id = portfolio_targets.user_id`},
+	{table: "portfolio_targets", collision: "removed after merging into the new symbol", sql: `DELETE FROM portfolio_targets WHERE kind = 'ticker' AND key = $1 AND EXISTS (SELECT 1 FROM portfolio_targets t WHERE t.user_id = portfolio_targets.user_id AND t.kind = 'ticker' AND t.key = $2)`},
+	{table: "portfolio_targets", sql: `UPDATE portfolio_targets SET key = $2 WHERE kind = 'ticker' AND key = $1`},
+	{table: "watchlist", sql: `UPDATE watchlist SET ticker = $2 WHERE ticker = $1 AND NOT EXISTS (SELECT 1 FROM watchlist w WHERE w.ticker = $2)`},
+	{table: "watchlist", collision: "already watched under the new symbol", sql: `DELETE FROM watchlist WHERE ticker = $1`},
+	{table: "stocks", sql: `UPDATE stocks SET ticker = $2, updated_at = now() WHERE ticker = $1 AND NOT EXISTS (SELECT 1 FROM stocks s WHERE s.ticker = $2)`},
+	{table: "stocks", collision: "superseded by the row under the new symbol", sql: `DELETE FROM stocks WHERE ticker = $1`},
+	{table: "daily_prices", sql: `UPDATE daily_prices SET symbol = $2 WHERE symbol = $1 AND NOT EXISTS (SELECT 1 FROM daily_prices p WHERE p.symbol = $2 AND p.date = daily_prices.date)`},
+	{table: "daily_prices", collision: "superseded by the row under the new symbol", sql: `DELETE FROM daily_prices WHERE symbol = $1`},
+	{table: "dividends", sql: `UPDATE dividends SET symbol = $2 WHERE symbol = $1 AND NOT EXISTS (SELECT 1 FROM dividends d WHERE d.symbol = $2 AND d.ex_date = dividends.ex_date)`},
+	{table: "dividends", collision: "superseded by the row under the new symbol", sql: `DELETE FROM dividends WHERE symbol = $1`},
+	{table: "eps_points", sql: `UPDATE eps_points SET ticker = $2 WHERE ticker = $1 AND NOT EXISTS (SELECT 1 FROM eps_points e WHERE e.ticker = $2 AND e.period_date = eps_points.period_date AND e.is_estimate = eps_points.is_estimate)`},
+	{table: "eps_points", collision: "superseded by the row under the new symbol", sql: `DELETE FROM eps_points WHERE ticker = $1`},
+	{table: "fundamentals", sql: `UPDATE fundamentals SET ticker = $2 WHERE ticker = $1 AND NOT EXISTS (SELECT 1 FROM fundamentals f WHERE f.ticker = $2)`},
+	{table: "fundamentals", collision: "superseded by the row under the new symbol", sql: `DELETE FROM fundamentals WHERE ticker = $1`},
+	{table: "quotes_cache", sql: `UPDATE quotes_cache SET symbol = $2 WHERE symbol = $1 AND NOT EXISTS (SELECT 1 FROM quotes_cache q WHERE q.symbol = $2)`},
+	{table: "quotes_cache", collision: "superseded by the row under the new symbol", sql: `DELETE FROM quotes_cache WHERE symbol = $1`},
+	{table: "securities", sql: `UPDATE securities SET symbol = $2, updated_at = now() WHERE symbol = $1 AND NOT EXISTS (SELECT 1 FROM securities s WHERE s.symbol = $2)`},
+	{table: "securities", collision: "superseded by the row under the new symbol", sql: `DELETE FROM securities WHERE symbol = $1`},
+}
+
+// positionBefore is the audit detail kept for portfolio rows.
+type positionBefore struct {
+	UserID   string  `json:"user_id"`
+	Ticker   string  `json:"ticker"`
+	Position float64 `json:"position"`
+	AvgPrice float64 `json:"average_price"`
+}
+
+// Apply runs one action in a database transaction, records what changed in
+// corporate_action_log and marks the action applied. Applying a split again only scales
+// rows it has not scaled before.
+func (s *Service) Apply(ctx context.Context, a Action) error {
+	var (
+		steps   []step
+		args    []any
+		symbols = []string{a.Symbol}
+	)
+	switch a.Type {
+	case TypeSplit:
+		steps, args = splitSteps, []any{a.Symbol, a.Factor(), a.ExDate, a.ID}
+	case TypeSymbolChange:
+		if a.NewSymbol == nil {
+			return errors.New("symbol change without new symbol")
+		}
+		steps, args = renameSteps, []any{a.Symbol, *a.NewSymbol}
+		symbols = append(symbols, *a.NewSymbol)
+	default:
+		return fmt.Errorf("unknown action type %q", a.Type)
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := positionsBefore(ctx, tx, symbols)
+	if err != nil {
+		return err
+	}
+	for _, st := range steps {
+		tag, err := tx.Exec(ctx, st.sql, args...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", st.table, err)
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		detail := map[string]any{}
+		if st.table == "portfolio" {
+			detail["before"] = before
+		}
+		if st.collision != "" {
+			detail["collision"] = st.collision
+		}
+		if err := logStep(ctx, tx, a.ID, st.table, tag.RowsAffected(), detail); err != nil {
+			return err
+		}
+	}
+	if a.Type == TypeSymbolChange {
+		// A position that could not be merged (the new symbol is held in another currency)
+		// stays under the old symbol; flag it rather than leave it silently orphaned.
+		var left int64
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM portfolio WHERE ticker = $1`, a.Symbol).Scan(&left); err != nil {
+			return err
+		}
+		if left > 0 {
+			detail := map[string]any{"before": before, "collision": "left under the old symbol: held in another currency under the new symbol"}
+			if err := logStep(ctx, tx, a.ID, "portfolio", left, detail); err != nil {
+				return err
+			}
+			if s.Log != nil {
+				s.Log.Warnf("%s -> %s: %d positions left under the old symbol (currency differs)", a.Symbol, *a.NewSymbol, left)
+			}
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE corporate_actions SET status = $2, applied_at = now() WHERE id = $1`, a.ID, StatusApplied); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if s.Log != nil {
+		s.Log.Infof("applied %s for %s (ex %s)", a.Type, a.Symbol, a.ExDate.Format("2006-01-02"))
+	}
+	return nil
+}
+
+func logStep(ctx context.Context, tx pgx.Tx, actionID, table string, affected int64, detail map[string]any) error {
+	var raw []byte
+	if len(detail) > 0 {
+		raw, _ = json.Marshal(detail)
+	}
+	_, err := tx.Exec(ctx, `
+INSERT INTO corporate_action_log (action_id, table_name, rows_affected, detail) VALUES ($1, $2, $3, $4)
+`, actionID, table, affected, raw)
+	return err
+}
+
+func positionsBefore(ctx context.Context, tx pgx.Tx, symbols []string) ([]positionBefore, error) {
+	rows, err := tx.Query(ctx, `SELECT user_id::STRING, ticker, position, average_price FROM portfolio WHERE ticker = ANY($1)`, symbols)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []positionBefore{}
+	for rows.Next() {
+		var p positionBefore
+		if err := rows.Scan(&p.UserID, &p.Ticker, &p.Position, &p.AvgPrice); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// syncLookback is how far back each scheduled run asks the provider for actions.
+const syncLookback = 90 * 24 * time.Hour
+
+// StartCron syncs actions from the provider and applies pending ones on startup and then
+// every interval until stop is closed.
+func StartCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		now := time.Now().UTC()
+		if n, err := svc.Sync(ctx, now.Add(-syncLookback)); err != nil {
+			log.Warnf("corporate actions sync error: %v", err)
+		} else if n > 0 {
+			log.Infof("corporate actions: %d new from provider", n)
+		}
+		applied, err := svc.ApplyPending(ctx, now)
+		if err != nil {
+			log.Warnf("corporate actions apply error: %v", err)
+		}
+		if len(applied) > 0 {
+			log.Infof("corporate actions: applied %d", len(applied))
+		}
+	}
+	run()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			run()
+		case <-stop:
+			log.Infof("corporate actions cron stopped")
+			return
+		}
+	}
+}
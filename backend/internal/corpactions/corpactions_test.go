@@ -0,0 +1,124 @@
+package corpactions
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCSV(t *testing.T) {
+	in := "Symbol,Type,Ex_Date,Ratio,New_Symbol\n" +
+		"nvda,split,2024-06-10,10-for-1,\n" +
+		"GE,reverse_split,2021-08-02,1:8,\n" +
+		"FB,ticker_change,2022-06-09,,meta\n"
+	actions, err := ParseCSV(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, actions, 3)
+
+	assert.Equal(t, "NVDA", actions[0].Symbol)
+	assert.Equal(t, TypeSplit, actions[0].Type)
+	assert.Equal(t, 10.0, actions[0].Factor())
+	assert.Equal(t, 0.125, actions[1].Factor())
+	assert.Equal(t, TypeSymbolChange, actions[2].Type)
+	assert.Equal(t, "META", *actions[2].NewSymbol)
+	assert.Equal(t, SourceCSV, actions[2].Source)
+
+	_, err = ParseCSV(strings.NewReader("symbol,type,ex_date,ratio\nAAPL,split,2020-08-31,four\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = ParseCSV(strings.NewReader("symbol,type,ex_date,new_symbol\nAAPL,rename,2020-08-31,AAPL\n"))
+	assert.ErrorContains(t, err, "new_symbol")
+	_, err = ParseCSV(strings.NewReader("symbol,ex_date\nAAPL,2020-08-31\n"))
+	assert.ErrorContains(t, err, "type")
+}
+
+func TestApplySplit(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil)
+
+	from, to := 1.0, 4.0
+	ex := time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC)
+	a := Action{ID: "act-1", Symbol: "AAPL", Type: TypeSplit, ExDate: ex, RatioFrom: &from, RatioTo: &to}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT user_id::STRING, ticker, position, average_price FROM portfolio`).WithArgs([]string{"AAPL"}).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "ticker", "position", "average_price"}).AddRow("user-1", "AAPL", 10.0, 400.0))
+	for _, st := range splitSteps {
+		affected := int64(0)
+		if st.table == "portfolio" || st.table == "stocks" {
+			affected = 1
+		}
+		mock.ExpectExec(regexp.QuoteMeta(st.sql)).WithArgs("AAPL", 4.0, ex, "act-1").WillReturnResult(pgxmock.NewResult("INSERT", affected))
+		if affected > 0 {
+			mock.ExpectExec(`INSERT INTO corporate_action_log`).WithArgs("act-1", st.table, affected, pgxmock.AnyArg()).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		}
+	}
+	mock.ExpectExec(`UPDATE corporate_actions SET status`).WithArgs("act-1", StatusApplied).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	require.NoError(t, svc.Apply(context.Background(), a))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSplitStepsUseLedger(t *testing.T) {
+	for _, st := range splitSteps {
+		assert.Contains(t, st.sql, "applied_corporate_actions", st.table)
+		assert.NotEqual(t, "daily_prices", st.table, "daily closes are stored split-adjusted")
+	}
+	assert.Equal(t, "portfolio", splitSteps[0].table)
+	assert.Contains(t, splitSteps[0].sql, "created_at < $3", "positions opened on or after the ex-date are post-split")
+	assert.NotContains(t, splitSteps[0].sql, "updated_at <", "positions are scaled whenever they were edited")
+}
+
+func TestApplySymbolChangeMergesCollisions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil)
+
+	newSym := "META"
+	a := Action{ID: "act-2", Symbol: "FB", Type: TypeSymbolChange, ExDate: time.Date(2022, 6, 9, 0, 0, 0, 0, time.UTC), NewSymbol: &newSym}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM portfolio WHERE ticker = ANY`).WithArgs([]string{"FB", "META"}).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "ticker", "position", "average_price"}).
+			AddRow("user-1", "FB", 10.0, 200.0).AddRow("user-1", "META", 5.0, 300.0))
+	for i, st := range renameSteps {
+		affected := int64(0)
+		if i < 2 { // the position merge and the removal of the merged row
+			affected = 1
+		}
+		mock.ExpectExec(regexp.QuoteMeta(st.sql)).WithArgs("FB", "META").WillReturnResult(pgxmock.NewResult("UPDATE", affected))
+		if affected > 0 {
+			mock.ExpectExec(`INSERT INTO corporate_action_log`).WithArgs("act-2", "portfolio", affected,
+				pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		}
+	}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM portfolio WHERE ticker`).WithArgs("FB").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(0)))
+	mock.ExpectExec(`UPDATE corporate_actions SET status`).WithArgs("act-2", StatusApplied).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	require.NoError(t, svc.Apply(context.Background(), a))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "merged into the position under the new symbol", renameSteps[0].collision)
+	assert.Contains(t, renameSteps[0].sql, "o.currency = portfolio.currency")
+}
+
+func TestAddRejectsInvalid(t *testing.T) {
+	svc := NewService(nil, nil)
+	_, err := svc.Add(context.Background(), []Action{{Symbol: "AAPL", Type: TypeSplit, ExDate: time.Now()}})
+	var inv *InvalidActionError
+	require.ErrorAs(t, err, &inv)
+	assert.Equal(t, 0, inv.Index)
+}
@@ -0,0 +1,109 @@
+package corpactions
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseCSV reads actions from a CSV with a header row. Columns (any order, case-insensitive):
+// symbol, type, ex_date (YYYY-MM-DD), ratio and new_symbol. ratio is "new:old" or
+// "new-for-old", so "4:1" is a 4-for-1 split and "1:10" a 1-for-10 reverse split. type
+// accepts split, reverse_split, symbol_change, ticker_change and rename.
+func ParseCSV(r io.Reader) ([]Action, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, req := range []string{"symbol", "type", "ex_date"} {
+		if _, ok := col[req]; !ok {
+			return nil, fmt.Errorf("missing %q column", req)
+		}
+	}
+	get := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var out []Action
+	line := 1
+	for {
+		rec, err := cr.Read()
+		line++
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if strings.Join(rec, "") == "" {
+			continue
+		}
+		a := Action{Symbol: get(rec, "symbol"), Source: SourceCSV}
+		ex, err := time.Parse("2006-01-02", get(rec, "ex_date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: ex_date must be YYYY-MM-DD", line)
+		}
+		a.ExDate = ex
+		switch t := strings.ToLower(get(rec, "type")); t {
+		case TypeSplit, "reverse_split":
+			a.Type = TypeSplit
+			to, from, err := parseRatio(get(rec, "ratio"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			a.RatioFrom, a.RatioTo = &from, &to
+		case TypeSymbolChange, "ticker_change", "rename":
+			a.Type = TypeSymbolChange
+			ns := get(rec, "new_symbol")
+			a.NewSymbol = &ns
+		default:
+			a.Type = t
+		}
+		if err := a.normalize(len(out)); err != nil {
+			var inv *InvalidActionError
+			if errors.As(err, &inv) {
+				return nil, fmt.Errorf("line %d: %s", line, inv.Reason)
+			}
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// parseRatio reads "4:1", "4/1" or "4-for-1" as (new, old).
+func parseRatio(s string) (float64, float64, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	var parts []string
+	for _, sep := range []string{"-for-", "for", ":", "/"} {
+		if strings.Contains(s, sep) {
+			parts = strings.SplitN(s, sep, 2)
+			break
+		}
+	}
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("ratio %q must look like 4:1", s)
+	}
+	to, err1 := strconv.ParseFloat(parts[0], 64)
+	from, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || to <= 0 || from <= 0 {
+		return 0, 0, fmt.Errorf("ratio %q must look like 4:1", s)
+	}
+	return to, from, nil
+}
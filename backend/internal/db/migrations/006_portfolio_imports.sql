@@ -0,0 +1,16 @@
+-- Pending portfolio imports awaiting user review before they touch the portfolio table
+
+CREATE TABLE IF NOT EXISTS portfolio_imports (
+    id           UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id      UUID         NOT NULL,
+    source       STRING       NOT NULL,
+    status       STRING       NOT NULL DEFAULT 'pending',
+    raw_output   STRING       NULL,
+    rows         JSONB        NOT NULL DEFAULT '[]',
+    issues       JSONB        NOT NULL DEFAULT '[]',
+    mode         STRING       NULL,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    committed_at TIMESTAMPTZ  NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_portfolio_imports_user_created ON portfolio_imports (user_id, created_at DESC);
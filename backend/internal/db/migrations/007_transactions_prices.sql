@@ -0,0 +1,27 @@
+-- Transaction ledger and daily closes used for performance reporting
+
+CREATE TABLE IF NOT EXISTS portfolio_transactions (
+    id          UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id     UUID         NOT NULL,
+    ticker      STRING       NOT NULL,
+    type        STRING       NOT NULL,
+    quantity    DECIMAL      NOT NULL,
+    price       DECIMAL      NOT NULL,
+    fees        DECIMAL      NOT NULL DEFAULT 0,
+    trade_date  DATE         NOT NULL,
+    note        STRING       NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_portfolio_transactions_user_date ON portfolio_transactions (user_id, trade_date);
+
+-- Written by the Fundamentals API (/api/update/history). close is split-adjusted;
+-- adj_close also reinvests dividends and is preferred for benchmarks.
+CREATE TABLE IF NOT EXISTS daily_prices (
+    symbol      STRING       NOT NULL,
+    date        DATE         NOT NULL,
+    close       DECIMAL      NOT NULL,
+    adj_close   DECIMAL      NULL,
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (symbol, date)
+);
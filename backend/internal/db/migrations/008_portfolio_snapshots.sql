@@ -0,0 +1,13 @@
+-- Daily portfolio values for the equity curve. source is 'live' for the scheduled job
+-- (positions table at cached quotes) or 'backfill' when rebuilt from the transaction ledger.
+
+CREATE TABLE IF NOT EXISTS portfolio_snapshots (
+    user_id      UUID         NOT NULL,
+    date         DATE         NOT NULL,
+    total_value  DECIMAL      NOT NULL,
+    cost_basis   DECIMAL      NOT NULL,
+    positions    JSONB        NOT NULL DEFAULT '[]',
+    source       STRING       NOT NULL DEFAULT 'live',
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, date)
+);
@@ -0,0 +1,10 @@
+-- Target allocation weights per user. kind is 'ticker' or 'sector'; weight is 0..1.
+
+CREATE TABLE IF NOT EXISTS portfolio_targets (
+    user_id     UUID         NOT NULL,
+    kind        STRING       NOT NULL,
+    key         STRING       NOT NULL,
+    weight      DECIMAL      NOT NULL,
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, kind, key)
+);
@@ -0,0 +1,22 @@
+-- Currencies for positions and trades, per-currency cash and cached FX rates
+
+ALTER TABLE portfolio ADD COLUMN IF NOT EXISTS currency STRING NOT NULL DEFAULT 'USD';
+ALTER TABLE portfolio_transactions ADD COLUMN IF NOT EXISTS currency STRING NOT NULL DEFAULT 'USD';
+
+CREATE TABLE IF NOT EXISTS cash_balances (
+    user_id     UUID         NOT NULL,
+    currency    STRING       NOT NULL,
+    amount      DECIMAL      NOT NULL,
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, currency)
+);
+
+-- Latest rate per pair: 1 unit of base = rate units of quote
+CREATE TABLE IF NOT EXISTS fx_rates (
+    base        STRING       NOT NULL,
+    quote       STRING       NOT NULL,
+    rate        DECIMAL      NOT NULL,
+    as_of       TIMESTAMPTZ  NOT NULL,
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (base, quote)
+);
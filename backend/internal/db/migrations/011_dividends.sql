@@ -0,0 +1,14 @@
+-- Dividend events per symbol. Received dividends are recorded in portfolio_transactions
+-- with type 'dividend' (quantity = shares held on the ex-date, price = amount per share).
+
+CREATE TABLE IF NOT EXISTS dividends (
+    symbol      STRING       NOT NULL,
+    ex_date     DATE         NOT NULL,
+    pay_date    DATE         NULL,
+    amount      DECIMAL      NOT NULL,
+    currency    STRING       NOT NULL DEFAULT 'USD',
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (symbol, ex_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_portfolio_transactions_user_type ON portfolio_transactions (user_id, type, ticker, trade_date);
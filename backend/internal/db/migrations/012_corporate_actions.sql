@@ -0,0 +1,32 @@
+-- Splits and ticker changes, applied once by the corporate actions job.
+-- Splits: ratio_to new shares for every ratio_from old shares (4-for-1 = 1:4).
+-- Symbol changes: symbol is renamed to new_symbol from ex_date.
+
+CREATE TABLE IF NOT EXISTS corporate_actions (
+    id          UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    symbol      STRING       NOT NULL,
+    type        STRING       NOT NULL,
+    ex_date     DATE         NOT NULL,
+    ratio_from  DECIMAL      NULL,
+    ratio_to    DECIMAL      NULL,
+    new_symbol  STRING       NULL,
+    source      STRING       NOT NULL DEFAULT 'manual',
+    status      STRING       NOT NULL DEFAULT 'pending',
+    applied_at  TIMESTAMPTZ  NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    UNIQUE (symbol, type, ex_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_corporate_actions_status ON corporate_actions (status, ex_date);
+
+-- Audit trail: one row per table touched when an action is applied
+CREATE TABLE IF NOT EXISTS corporate_action_log (
+    id             UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    action_id      UUID         NOT NULL,
+    table_name     STRING       NOT NULL,
+    rows_affected  INT          NOT NULL,
+    detail         JSONB        NULL,
+    created_at     TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_corporate_action_log_action ON corporate_action_log (action_id, created_at);
@@ -0,0 +1,19 @@
+-- Reference data per listed symbol: who it is, where it trades and how it is classified.
+-- Seeded from CSV and refreshed from the market data provider.
+
+CREATE TABLE IF NOT EXISTS securities (
+    symbol              STRING       PRIMARY KEY,
+    name                STRING       NOT NULL DEFAULT '',
+    exchange            STRING       NOT NULL DEFAULT '',
+    sector              STRING       NOT NULL DEFAULT '',
+    industry            STRING       NOT NULL DEFAULT '',
+    country             STRING       NOT NULL DEFAULT '',
+    currency            STRING       NOT NULL DEFAULT 'USD',
+    market_cap          DECIMAL      NULL,
+    shares_outstanding  DECIMAL      NULL,
+    source              STRING       NOT NULL DEFAULT 'seed',
+    updated_at          TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_securities_sector ON securities (sector, industry);
+CREATE INDEX IF NOT EXISTS idx_securities_updated ON securities (updated_at);
@@ -0,0 +1,4 @@
+-- Trigram indexes for fuzzy company-name search (name % query) and substring matches.
+
+CREATE INDEX IF NOT EXISTS idx_stocks_company_trgm ON stocks USING GIN (company gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_securities_name_trgm ON securities USING GIN (name gin_trgm_ops);
@@ -0,0 +1,4 @@
+-- The event cache watcher polls for rows written since its last look.
+
+CREATE INDEX IF NOT EXISTS idx_quotes_cache_updated ON quotes_cache (updated_at);
+CREATE INDEX IF NOT EXISTS idx_fundamentals_updated ON fundamentals (updated_at);
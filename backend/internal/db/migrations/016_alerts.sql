@@ -0,0 +1,46 @@
+-- User-defined alert rules, evaluated by the alerts worker as ratings and prices change.
+-- threshold: price level (price_above/price_below), upside fraction (upside_above) or
+-- margin below intrinsic value (below_intrinsic). ticker NULL applies the rule to every ticker.
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id                UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id           UUID         NOT NULL,
+    kind              STRING       NOT NULL,
+    ticker            STRING       NULL,
+    threshold         DECIMAL      NULL,
+    brokerage         STRING       NULL,
+    cooldown_seconds  INT          NOT NULL DEFAULT 86400,
+    enabled           BOOL         NOT NULL DEFAULT true,
+    created_at        TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_user ON alerts (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_alerts_kind ON alerts (kind, ticker) WHERE enabled;
+
+-- Last evaluation of a rule for a ticker: whether its condition held (so only crossings
+-- trigger) and when it last triggered (for the cool-down).
+CREATE TABLE IF NOT EXISTS alert_state (
+    alert_id      UUID         NOT NULL,
+    ticker        STRING       NOT NULL,
+    met           BOOL         NOT NULL DEFAULT false,
+    triggered_at  TIMESTAMPTZ  NULL,
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (alert_id, ticker)
+);
+
+-- Triggered alerts. dedup_key identifies what triggered the rule, so the same rating change
+-- or crossing seen twice is recorded once.
+CREATE TABLE IF NOT EXISTS alert_events (
+    id            UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    alert_id      UUID         NOT NULL,
+    user_id       UUID         NOT NULL,
+    kind          STRING       NOT NULL,
+    ticker        STRING       NOT NULL,
+    message       STRING       NOT NULL,
+    value         DECIMAL      NULL,
+    dedup_key     STRING       NOT NULL,
+    triggered_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    UNIQUE (alert_id, dedup_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_events_user ON alert_events (user_id, triggered_at DESC);
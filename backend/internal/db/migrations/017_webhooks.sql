@@ -0,0 +1,33 @@
+-- Outbound webhooks. A subscription receives the event types it lists (all supported types
+-- when event_types is empty), signed with its secret.
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id           UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id      UUID         NOT NULL,
+    url          STRING       NOT NULL,
+    secret       STRING       NOT NULL,
+    event_types  STRING[]     NOT NULL DEFAULT ARRAY[]::STRING[],
+    enabled      BOOL         NOT NULL DEFAULT true,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_user ON webhook_subscriptions (user_id, created_at);
+
+-- Delivery queue and log. status is pending (due at next_attempt_at), delivered, or dead once
+-- max attempts failed. The payload is stored as sent so retries are signed identically.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    subscription_id  UUID         NOT NULL,
+    event_type       STRING       NOT NULL,
+    payload          STRING       NOT NULL,
+    status           STRING       NOT NULL DEFAULT 'pending',
+    attempts         INT          NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    last_status      INT          NULL,
+    last_error       STRING       NULL,
+    created_at       TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    delivered_at     TIMESTAMPTZ  NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at DESC);
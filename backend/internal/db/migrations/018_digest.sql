@@ -0,0 +1,11 @@
+-- Email digest preferences. A user gets the daily digest while subscribed; the token signs the
+-- unsubscribe link in each email. last_sent_on keeps the job to one digest per day.
+
+CREATE TABLE IF NOT EXISTS digest_preferences (
+    user_id            UUID         PRIMARY KEY,
+    email              STRING       NOT NULL,
+    subscribed         BOOL         NOT NULL DEFAULT true,
+    unsubscribe_token  STRING       NOT NULL UNIQUE,
+    last_sent_on       DATE         NULL,
+    updated_at         TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
@@ -0,0 +1,19 @@
+-- One row per ingest run: what started it, how far it got and how it ended. status is
+-- running, succeeded or failed (error says why). Counts cover the pages processed before a
+-- failure too. unchanged items had the same rating and target as already stored.
+
+CREATE TABLE IF NOT EXISTS ingest_runs (
+    id            UUID         DEFAULT gen_random_uuid() PRIMARY KEY,
+    triggered_by  STRING       NOT NULL,
+    status        STRING       NOT NULL DEFAULT 'running',
+    started_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    finished_at   TIMESTAMPTZ  NULL,
+    pages         INT          NOT NULL DEFAULT 0,
+    items         INT          NOT NULL DEFAULT 0,
+    inserted      INT          NOT NULL DEFAULT 0,
+    updated       INT          NOT NULL DEFAULT 0,
+    unchanged     INT          NOT NULL DEFAULT 0,
+    error         STRING       NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ingest_runs_started ON ingest_runs (started_at DESC);
@@ -0,0 +1,11 @@
+-- Which rows a split has already been applied to, so applying it again (or after rows were
+-- written late) never scales a row twice. row_key identifies the row within table_name: its
+-- id, or the date or symbol that keys it.
+
+CREATE TABLE IF NOT EXISTS applied_corporate_actions (
+    action_id   UUID         NOT NULL,
+    table_name  STRING       NOT NULL,
+    row_key     STRING       NOT NULL,
+    applied_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (action_id, table_name, row_key)
+);
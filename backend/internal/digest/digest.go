@@ -0,0 +1,424 @@
+// Package digest builds the daily email digest (top recommendations, watchlist rating changes
+// and portfolio movers), renders it from HTML and text templates and sends it to subscribed
+// users over SMTP.
+package digest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/rec"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/digest.html templates/digest.txt
+var templates embed.FS
+
+var funcs = map[string]any{"money": money, "pct": pct}
+
+var (
+	htmlTmpl = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).ParseFS(templates, "templates/digest.html"))
+	textTmpl = texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).ParseFS(templates, "templates/digest.txt"))
+)
+
+// money formats a price or amount, "n/a" when missing.
+func money(v any) string {
+	switch f := v.(type) {
+	case float64:
+		return fmt.Sprintf("%.2f", f)
+	case *float64:
+		if f != nil {
+			return fmt.Sprintf("%.2f", *f)
+		}
+	}
+	return "n/a"
+}
+
+// pct formats a fraction as a signed percentage, "n/a" when missing.
+func pct(v any) string {
+	switch f := v.(type) {
+	case float64:
+		return fmt.Sprintf("%+.1f%%", f*100)
+	case *float64:
+		if f != nil {
+			return fmt.Sprintf("%+.1f%%", *f*100)
+		}
+	}
+	return "n/a"
+}
+
+// ErrInvalidEmail is returned by SetPreferences for an address that does not parse.
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// ErrNoEmail is returned by Send for a user without digest preferences.
+var ErrNoEmail = errors.New("no email address for this user; set the digest preferences first")
+
+// ErrNotConfigured is returned by Send when no mailer is wired (SMTP_HOST is not set).
+var ErrNotConfigured = errors.New("email is not configured")
+
+// Preferences is a user's digest subscription.
+type Preferences struct {
+	Email      string  `json:"email"`
+	Subscribed bool    `json:"subscribed"`
+	LastSentOn *string `json:"last_sent_on"` // YYYY-MM-DD
+}
+
+// RatingChange is a rating change on a watchlist ticker.
+type RatingChange struct {
+	Ticker     string     `json:"ticker"`
+	Company    string     `json:"company"`
+	Brokerage  string     `json:"brokerage"`
+	Action     string     `json:"action"`
+	RatingFrom string     `json:"rating_from"`
+	RatingTo   string     `json:"rating_to"`
+	TargetTo   *float64   `json:"target_to"`
+	ChangedAt  *time.Time `json:"changed_at"`
+}
+
+// Mover is a held position's move since the previous close.
+type Mover struct {
+	Ticker      string  `json:"ticker"`
+	Position    float64 `json:"position"`
+	Price       float64 `json:"price"`
+	PrevClose   float64 `json:"prev_close"`
+	Change      float64 `json:"change"`       // fraction, 0.05 = +5%
+	ValueChange float64 `json:"value_change"` // position * price change
+}
+
+// Digest is what one user's email shows.
+type Digest struct {
+	To              string               `json:"to"`
+	Subject         string               `json:"subject"`
+	Date            string               `json:"date"`
+	Recommendations []rec.Recommendation `json:"recommendations"`
+	RatingChanges   []RatingChange       `json:"rating_changes"`
+	Movers          []Mover              `json:"movers"`
+	UnsubscribeURL  string               `json:"unsubscribe_url,omitempty"`
+}
+
+// Empty reports whether there is nothing worth sending.
+func (d *Digest) Empty() bool {
+	return len(d.Recommendations) == 0 && len(d.RatingChanges) == 0 && len(d.Movers) == 0
+}
+
+// Render returns the digest as HTML and plain text.
+func (d *Digest) Render() (html, text string, err error) {
+	var h, t bytes.Buffer
+	if err := htmlTmpl.Execute(&h, d); err != nil {
+		return "", "", err
+	}
+	if err := textTmpl.Execute(&t, d); err != nil {
+		return "", "", err
+	}
+	return h.String(), t.String(), nil
+}
+
+// Recommender returns the current top recommendations; implemented by rec.Service.
+type Recommender interface {
+	TopN(ctx context.Context, n int) ([]rec.Recommendation, error)
+}
+
+// Service builds and sends digests. Mailer is nil when email is not configured; digests can
+// still be previewed.
+type Service struct {
+	DB     db.DBTX
+	Log    *zap.SugaredLogger
+	Recs   Recommender
+	Mailer Mailer
+	// PublicURL is the backend's base URL as seen from a mail client, for unsubscribe links.
+	PublicURL string
+	// Hour (UTC) from which SendDue sends the day's digests.
+	Hour int
+	// How many recommendations and movers a digest lists.
+	TopN   int
+	Movers int
+	now    func() time.Time
+}
+
+func NewService(db db.DBTX, log *zap.SugaredLogger, recs Recommender, mailer Mailer, publicURL string) *Service {
+	return &Service{DB: db, Log: log, Recs: recs, Mailer: mailer, PublicURL: strings.TrimRight(publicURL, "/"),
+		Hour: 7, TopN: 5, Movers: 5, now: time.Now}
+}
+
+type prefsRow struct {
+	Preferences
+	token string
+}
+
+func (s *Service) prefs(ctx context.Context, userID string) (*prefsRow, error) {
+	var p prefsRow
+	var last *time.Time
+	err := s.DB.QueryRow(ctx, `SELECT email, subscribed, unsubscribe_token, last_sent_on FROM digest_preferences WHERE user_id = $1`, userID).
+		Scan(&p.Email, &p.Subscribed, &p.token, &last)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		d := last.Format("2006-01-02")
+		p.LastSentOn = &d
+	}
+	return &p, nil
+}
+
+// Preferences returns userID's preferences; a user who never set them is not subscribed.
+func (s *Service) Preferences(ctx context.Context, userID string) (*Preferences, error) {
+	p, err := s.prefs(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &Preferences{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p.Preferences, nil
+}
+
+// SetPreferences stores userID's email address and whether they want the digest.
+func (s *Service) SetPreferences(ctx context.Context, userID, email string, subscribed bool) (*Preferences, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	var p Preferences
+	var last *time.Time
+	err = s.DB.QueryRow(ctx, `
+INSERT INTO digest_preferences (user_id, email, subscribed, unsubscribe_token, updated_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, subscribed = EXCLUDED.subscribed, updated_at = now()
+RETURNING email, subscribed, last_sent_on
+`, userID, addr.Address, subscribed, hex.EncodeToString(b)).Scan(&p.Email, &p.Subscribed, &last)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		d := last.Format("2006-01-02")
+		p.LastSentOn = &d
+	}
+	return &p, nil
+}
+
+// Unsubscribe turns the digest off for the user an unsubscribe link was sent to. It returns
+// pgx.ErrNoRows for an unknown token.
+func (s *Service) Unsubscribe(ctx context.Context, token string) error {
+	if token == "" {
+		return pgx.ErrNoRows
+	}
+	tag, err := s.DB.Exec(ctx, `UPDATE digest_preferences SET subscribed = false, updated_at = now() WHERE unsubscribe_token = $1`, token)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// Build assembles userID's digest for today: the top recommendations, rating changes on
+// watchlist tickers in the last 24 hours and the positions that moved most since the previous
+// close.
+func (s *Service) Build(ctx context.Context, userID string) (*Digest, error) {
+	now := s.now().UTC()
+	d := &Digest{Date: now.Format("2006-01-02")}
+	d.Subject = "Your stock digest for " + d.Date
+	p, err := s.prefs(ctx, userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if p != nil {
+		d.To = p.Email
+		d.UnsubscribeURL = s.PublicURL + "/api/v1/digest/unsubscribe?token=" + url.QueryEscape(p.token)
+	}
+	if d.Recommendations, err = s.Recs.TopN(ctx, s.TopN); err != nil {
+		return nil, err
+	}
+	if d.RatingChanges, err = s.ratingChanges(ctx, now.Add(-24*time.Hour)); err != nil {
+		return nil, err
+	}
+	if d.Movers, err = s.movers(ctx, userID, now); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *Service) ratingChanges(ctx context.Context, since time.Time) ([]RatingChange, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT s.ticker, s.company, s.brokerage, s.action, s.rating_from, s.rating_to, s.target_to, s.last_rating_change_at
+FROM stocks s
+JOIN watchlist w ON w.ticker = s.ticker
+WHERE s.last_rating_change_at >= $1
+ORDER BY s.last_rating_change_at DESC, s.ticker
+LIMIT 20
+`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []RatingChange{}
+	for rows.Next() {
+		var c RatingChange
+		if err := rows.Scan(&c.Ticker, &c.Company, &c.Brokerage, &c.Action, &c.RatingFrom, &c.RatingTo, &c.TargetTo, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// movers compares cached quotes of userID's positions with the last close before today and
+// returns the largest moves first.
+func (s *Service) movers(ctx context.Context, userID string, now time.Time) ([]Mover, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rows, err := s.DB.Query(ctx, `
+SELECT p.ticker, p.position, q.price,
+       (SELECT d.close FROM daily_prices d WHERE d.symbol = p.ticker AND d.date < $2 ORDER BY d.date DESC LIMIT 1)
+FROM portfolio p
+LEFT JOIN quotes_cache q ON q.symbol = p.ticker
+WHERE p.user_id = $1 AND p.position <> 0
+`, userID, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Mover{}
+	for rows.Next() {
+		var m Mover
+		var price, prev *float64
+		if err := rows.Scan(&m.Ticker, &m.Position, &price, &prev); err != nil {
+			return nil, err
+		}
+		if price == nil || prev == nil || *prev <= 0 || *price == *prev {
+			continue
+		}
+		m.Price, m.PrevClose = *price, *prev
+		m.Change = m.Price/m.PrevClose - 1
+		m.ValueChange = m.Position * (m.Price - m.PrevClose)
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return math.Abs(out[i].Change) > math.Abs(out[j].Change) })
+	if len(out) > s.Movers {
+		out = out[:s.Movers]
+	}
+	return out, nil
+}
+
+// Send builds, renders and mails userID's digest now, whether or not they are subscribed.
+func (s *Service) Send(ctx context.Context, userID string) (*Digest, error) {
+	if s.Mailer == nil {
+		return nil, ErrNotConfigured
+	}
+	d, err := s.Build(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return d, s.deliver(ctx, d)
+}
+
+func (s *Service) deliver(ctx context.Context, d *Digest) error {
+	if d.To == "" {
+		return ErrNoEmail
+	}
+	html, text, err := d.Render()
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, Message{To: d.To, Subject: d.Subject, HTML: html, Text: text, UnsubscribeURL: d.UnsubscribeURL})
+}
+
+// SendDue sends today's digest to every subscriber who has not had it yet, once the clock has
+// passed Hour. Digests with nothing in them are skipped but count as sent. It returns the
+// number of emails sent; a failure for one user does not stop the others.
+func (s *Service) SendDue(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	if s.Mailer == nil || now.Hour() < s.Hour {
+		return 0, nil
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rows, err := s.DB.Query(ctx, `
+SELECT user_id::STRING FROM digest_preferences
+WHERE subscribed AND (last_sent_on IS NULL OR last_sent_on < $1)
+ORDER BY user_id
+`, today)
+	if err != nil {
+		return 0, err
+	}
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for _, u := range users {
+		d, err := s.Build(ctx, u)
+		if err == nil && !d.Empty() {
+			if err = s.deliver(ctx, d); err == nil {
+				sent++
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("digest for %s: %w", u, err))
+			continue
+		}
+		if _, err := s.DB.Exec(ctx, `UPDATE digest_preferences SET last_sent_on = $2 WHERE user_id = $1`, u, today); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+// StartCron checks for due digests every interval until stop is closed.
+func StartCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if n, err := svc.SendDue(ctx); err != nil {
+			log.Warnf("digest error: %v", err)
+		} else if n > 0 {
+			log.Infof("digest: sent %d", n)
+		}
+	}
+	run()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			run()
+		case <-stop:
+			log.Infof("digest cron stopped")
+			return
+		}
+	}
+}
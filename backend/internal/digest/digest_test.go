@@ -0,0 +1,251 @@
+package digest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/rec"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeRecs []rec.Recommendation
+
+func (f fakeRecs) TopN(ctx context.Context, n int) ([]rec.Recommendation, error) { return f, nil }
+
+type fakeMailer struct{ sent []Message }
+
+func (m *fakeMailer) Send(ctx context.Context, msg Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func ptr(v float64) *float64 { return &v }
+
+const user = "a4f68b5c-5a4f-4698-852d-732b8e4b2e3c"
+
+var (
+	prefCols   = []string{"email", "subscribed", "unsubscribe_token", "last_sent_on"}
+	changeCols = []string{"ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_to", "last_rating_change_at"}
+	moverCols  = []string{"ticker", "position", "price", "prev_close"}
+)
+
+func newTestService(t *testing.T, mailer Mailer) (*Service, pgxmock.PgxPoolIface, time.Time) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	t.Cleanup(mock.Close)
+	recs := fakeRecs{{Ticker: "NVDA", Company: "NVIDIA", Brokerage: "UBS", RatingTo: "Buy", CurrentPrice: ptr(100), TargetTo: ptr(125), PercentUpside: ptr(0.25)}}
+	svc := NewService(mock, zap.NewNop().Sugar(), recs, mailer, "http://stocks.local/")
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	return svc, mock, now
+}
+
+// expectBuild queues the queries Build runs for user.
+func expectBuild(mock pgxmock.PgxPoolIface, now time.Time) {
+	changed := now.Add(-time.Hour)
+	mock.ExpectQuery(`FROM digest_preferences WHERE user_id`).WithArgs(user).
+		WillReturnRows(pgxmock.NewRows(prefCols).AddRow("me@example.com", true, "tok123", (*time.Time)(nil)))
+	mock.ExpectQuery(`JOIN watchlist`).WithArgs(now.Add(-24 * time.Hour)).
+		WillReturnRows(pgxmock.NewRows(changeCols).
+			AddRow("T", "AT&T", "Citi", "upgraded by", "Neutral", "Buy", ptr(30), &changed))
+	mock.ExpectQuery(`FROM portfolio p`).WithArgs(user, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(pgxmock.NewRows(moverCols).
+			AddRow("AAPL", 10.0, ptr(198), ptr(200)).
+			AddRow("MSFT", 5.0, ptr(440), ptr(400)).
+			AddRow("NOQ", 5.0, (*float64)(nil), ptr(10)))
+}
+
+func TestBuildAndRender(t *testing.T) {
+	svc, mock, now := newTestService(t, nil)
+	expectBuild(mock, now)
+
+	d, err := svc.Build(context.Background(), user)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "me@example.com", d.To)
+	assert.Equal(t, "2026-03-02", d.Date)
+	assert.Equal(t, "http://stocks.local/api/v1/digest/unsubscribe?token=tok123", d.UnsubscribeURL)
+	require.Len(t, d.Movers, 2, "positions without a price are left out")
+	assert.Equal(t, "MSFT", d.Movers[0].Ticker, "largest move first")
+	assert.InDelta(t, 0.1, d.Movers[0].Change, 1e-9)
+	assert.InDelta(t, 200, d.Movers[0].ValueChange, 1e-9)
+	assert.InDelta(t, -20, d.Movers[1].ValueChange, 1e-9)
+
+	html, text, err := d.Render()
+	require.NoError(t, err)
+	assert.Contains(t, html, "AT&amp;T", "escaped")
+	assert.Contains(t, html, `href="http://stocks.local/api/v1/digest/unsubscribe?token=tok123"`)
+	assert.Contains(t, text, "- NVDA NVIDIA: Buy (UBS), price 100.00, target 125.00, upside +25.0%")
+	assert.Contains(t, text, "- T AT&T: Citi upgraded by, Neutral -> Buy, target 30.00")
+	assert.Contains(t, text, "- MSFT: 440.00 (+10.0%), value change 200.00")
+	assert.Contains(t, text, "- AAPL: 198.00 (-1.0%), value change -20.00")
+
+	empty := &Digest{Date: "2026-03-02"}
+	assert.True(t, empty.Empty())
+	_, text, err = empty.Render()
+	require.NoError(t, err)
+	assert.Contains(t, text, "No rating changes on your watchlist.")
+	assert.NotContains(t, text, "Unsubscribe")
+}
+
+func TestSendDue(t *testing.T) {
+	mailer := &fakeMailer{}
+	svc, mock, now := newTestService(t, mailer)
+
+	svc.Hour = 9
+	n, err := svc.SendDue(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n, "before the send hour")
+
+	svc.Hour = 7
+	today := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT user_id::STRING FROM digest_preferences`).WithArgs(today).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow(user))
+	expectBuild(mock, now)
+	mock.ExpectExec(`UPDATE digest_preferences SET last_sent_on`).WithArgs(user, today).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	n, err = svc.SendDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "me@example.com", mailer.sent[0].To)
+	assert.Equal(t, "Your stock digest for 2026-03-02", mailer.sent[0].Subject)
+	assert.Contains(t, mailer.sent[0].HTML, "<table")
+
+	// Preview-only services refuse to send.
+	svc.Mailer = nil
+	_, err = svc.Send(context.Background(), user)
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
+
+func TestPreferences(t *testing.T) {
+	svc, mock, _ := newTestService(t, nil)
+	ctx := context.Background()
+
+	mock.ExpectQuery(`FROM digest_preferences WHERE user_id`).WithArgs(user).WillReturnError(pgx.ErrNoRows)
+	p, err := svc.Preferences(ctx, user)
+	require.NoError(t, err)
+	assert.False(t, p.Subscribed)
+
+	_, err = svc.SetPreferences(ctx, user, "not an address", true)
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+
+	sent := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`INSERT INTO digest_preferences`).WithArgs(user, "me@example.com", false, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"email", "subscribed", "last_sent_on"}).AddRow("me@example.com", false, &sent))
+	p, err = svc.SetPreferences(ctx, user, " Me <me@example.com> ", false)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-03-01", *p.LastSentOn)
+
+	mock.ExpectExec(`SET subscribed = false`).WithArgs("nope").WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	assert.ErrorIs(t, svc.Unsubscribe(ctx, "nope"), pgx.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// smtpServer accepts one message on a loopback port, speaking just enough SMTP, and returns
+// the envelope and data it received.
+func smtpServer(t *testing.T) (port int, received <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	out := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ESMTP test")
+		var got []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+			case cmd == "EHLO" || cmd == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL") || strings.HasPrefix(cmd, "RCPT"):
+				got = append(got, line)
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				got = append(got, data.String())
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				out <- got
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, out
+}
+
+func TestSMTPMailer(t *testing.T) {
+	port, received := smtpServer(t)
+	m := &SMTPMailer{Host: "127.0.0.1", Port: port, From: "Stock Digest <digest@stocks.local>"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := m.Send(ctx, Message{To: "me@example.com", Subject: "Your stock digest for 2026-03-02",
+		Text: "plain body", HTML: "<p>html body</p>", UnsubscribeURL: "http://stocks.local/u?token=t"})
+	require.NoError(t, err)
+
+	var got []string
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	require.Len(t, got, 3)
+	assert.Equal(t, "MAIL FROM:<digest@stocks.local>", strings.SplitN(got[0], " BODY", 2)[0])
+	assert.Equal(t, "RCPT TO:<me@example.com>", got[1])
+
+	msg, err := mail.ReadMessage(strings.NewReader(got[2]))
+	require.NoError(t, err)
+	assert.Equal(t, "Your stock digest for 2026-03-02", msg.Header.Get("Subject"))
+	assert.Equal(t, "<http://stocks.local/u?token=t>", msg.Header.Get("List-Unsubscribe"))
+	typ, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", typ)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		b, _ := io.ReadAll(p) // NextPart decodes quoted-printable
+		parts = append(parts, p.Header.Get("Content-Type")+": "+string(b))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8: plain body", "text/html; charset=utf-8: <p>html body</p>"}, parts)
+}
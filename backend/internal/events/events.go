@@ -0,0 +1,212 @@
+// Package events is an in-process publish/subscribe bus for market data changes. Producers
+// (ingest, the cache watcher) publish events; push endpoints subscribe and forward them. A
+// bounded history lets a reconnecting client resume from the last event it saw.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// TypeQuote is a new cached price: data is a Quote.
+	TypeQuote = "quote"
+	// TypeRatingChange is an analyst rating that was added or changed: data is a RatingChange.
+	TypeRatingChange = "rating_change"
+	// TypeRecommendationUpdate means recommendations may have changed and should be refetched:
+	// data is a RecommendationUpdate. Ticker is empty when many tickers changed at once.
+	TypeRecommendationUpdate = "recommendation_update"
+	// TypeResync tells a resuming subscriber that events were missed (the history no longer
+	// reaches back far enough), so it should refetch whatever it displays.
+	TypeResync = "resync"
+	// TypeAlert is an alert rule that triggered: data is the alerts.Event.
+	TypeAlert = "alert"
+	// TypeIngestComplete is a finished ingest run: data is an IngestComplete.
+	TypeIngestComplete = "ingest_complete"
+)
+
+// Event is one change. IDs increase monotonically, also across restarts of the process, so
+// they can be used as resume points.
+type Event struct {
+	ID     uint64    `json:"id"`
+	Type   string    `json:"type"`
+	Ticker string    `json:"ticker,omitempty"`
+	Time   time.Time `json:"time"`
+	Data   any       `json:"data,omitempty"`
+}
+
+type Quote struct {
+	Ticker string    `json:"ticker"`
+	Price  float64   `json:"price"`
+	AsOf   time.Time `json:"as_of"`
+}
+
+type RatingChange struct {
+	Ticker     string     `json:"ticker"`
+	Company    string     `json:"company"`
+	Brokerage  string     `json:"brokerage"`
+	Action     string     `json:"action"`
+	RatingFrom string     `json:"rating_from"`
+	RatingTo   string     `json:"rating_to"`
+	TargetFrom *float64   `json:"target_from"`
+	TargetTo   *float64   `json:"target_to"`
+	ChangedAt  *time.Time `json:"changed_at"`
+	// New is set when the ticker had no stored rating before: new coverage.
+	New bool `json:"new"`
+}
+
+// Reasons for a RecommendationUpdate.
+const (
+	ReasonRatings      = "ratings"
+	ReasonFundamentals = "fundamentals"
+)
+
+type RecommendationUpdate struct {
+	Reason  string   `json:"reason"`
+	Tickers []string `json:"tickers,omitempty"`
+}
+
+type IngestComplete struct {
+	RunID   string `json:"run_id,omitempty"` // ingest_runs id, for recorded runs
+	Items   int    `json:"items"`            // items fetched
+	Changed int    `json:"changed"`          // ratings added or changed
+}
+
+// Publisher is what producers need from a Bus.
+type Publisher interface {
+	Publish(typ, ticker string, data any) Event
+}
+
+// Defaults for NewBus.
+const (
+	DefaultHistory = 1024
+	DefaultBuffer  = 256
+)
+
+// Bus fans events out to subscribers. Publish never blocks: a subscriber whose buffer is full
+// is closed with Lagged set and has to resubscribe (resuming from its last event).
+type Bus struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event // ring of the last len(history) events
+	size    int     // events in history
+	start   int     // index of the oldest event in history
+	subs    map[*Subscription]struct{}
+	now     func() time.Time
+}
+
+// NewBus keeps the last history events for resuming (DefaultHistory if history <= 0).
+func NewBus(history int) *Bus {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	return &Bus{
+		// Starting from the clock keeps IDs from an earlier process below this one's, so a
+		// client resuming after a restart is told to resync instead of skipping events.
+		nextID:  uint64(time.Now().UnixMilli()) * 1000,
+		history: make([]Event, history),
+		subs:    map[*Subscription]struct{}{},
+		now:     time.Now,
+	}
+}
+
+// Publish records an event and delivers it to every subscriber.
+func (b *Bus) Publish(typ, ticker string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e := Event{ID: b.nextID, Type: typ, Ticker: ticker, Time: b.now().UTC(), Data: data}
+	i := (b.start + b.size) % len(b.history)
+	b.history[i] = e
+	if b.size < len(b.history) {
+		b.size++
+	} else {
+		b.start = (b.start + 1) % len(b.history)
+	}
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			s.lagged = true
+			b.drop(s)
+		}
+	}
+	return e
+}
+
+// Subscription receives events on C until it is closed, by Close or because it lagged.
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	bus    *Bus
+	lagged bool
+}
+
+// Subscribe starts receiving events published from now on, with room for buffer undelivered
+// events (DefaultBuffer if buffer <= 0).
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	s, _ := b.SubscribeFrom(0, buffer)
+	return s
+}
+
+// SubscribeFrom subscribes and returns the events after lastID that are still in the history.
+// When events after lastID are no longer in the history the backlog starts with a TypeResync
+// event. lastID 0 means no backlog.
+func (b *Bus) SubscribeFrom(lastID uint64, buffer int) (*Subscription, []Event) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, bus: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	if lastID == 0 || lastID >= b.nextID {
+		if lastID > b.nextID {
+			// An ID this process never issued.
+			return s, []Event{{ID: b.nextID, Type: TypeResync, Time: b.now().UTC()}}
+		}
+		return s, nil
+	}
+	var backlog []Event
+	if b.size == 0 || b.history[b.start].ID > lastID+1 {
+		backlog = append(backlog, Event{ID: lastID, Type: TypeResync, Time: b.now().UTC()})
+	}
+	for i := 0; i < b.size; i++ {
+		if e := b.history[(b.start+i)%len(b.history)]; e.ID > lastID {
+			backlog = append(backlog, e)
+		}
+	}
+	return s, backlog
+}
+
+// Lagged reports whether the subscription was closed because it fell behind.
+func (s *Subscription) Lagged() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.lagged
+}
+
+// Close stops the subscription and closes C. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+func (b *Bus) drop(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Subscribers returns how many subscriptions are open.
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
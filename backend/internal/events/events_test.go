@@ -0,0 +1,79 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBus(4)
+	sub := b.Subscribe(8)
+	e1 := b.Publish(TypeQuote, "AAPL", Quote{Ticker: "AAPL", Price: 10})
+	e2 := b.Publish(TypeRatingChange, "MSFT", nil)
+	assert.Equal(t, e1.ID+1, e2.ID)
+
+	got := <-sub.C
+	assert.Equal(t, e1, got)
+	assert.Equal(t, "MSFT", (<-sub.C).Ticker)
+
+	sub.Close()
+	sub.Close()
+	_, open := <-sub.C
+	assert.False(t, open)
+	assert.Equal(t, 0, b.Subscribers())
+}
+
+func TestSubscribeFromResumes(t *testing.T) {
+	b := NewBus(3)
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		ids = append(ids, b.Publish(TypeQuote, "AAPL", nil).ID)
+	}
+
+	// ids[1] is the newest event the client saw: ids[2:] are all still kept.
+	s, backlog := b.SubscribeFrom(ids[1], 0)
+	defer s.Close()
+	require.Len(t, backlog, 3)
+	assert.Equal(t, ids[2], backlog[0].ID)
+
+	// Events after ids[0] have been dropped from the history.
+	s2, backlog := b.SubscribeFrom(ids[0], 0)
+	defer s2.Close()
+	require.Len(t, backlog, 4)
+	assert.Equal(t, TypeResync, backlog[0].Type)
+	assert.Equal(t, ids[2], backlog[1].ID)
+
+	// Up to date, or an ID from a later process.
+	s3, backlog := b.SubscribeFrom(ids[4], 0)
+	defer s3.Close()
+	assert.Empty(t, backlog)
+	s4, backlog := b.SubscribeFrom(ids[4]+100, 0)
+	defer s4.Close()
+	require.Len(t, backlog, 1)
+	assert.Equal(t, TypeResync, backlog[0].Type)
+
+	// A restart starts with an empty history.
+	s5, backlog := NewBus(3).SubscribeFrom(ids[4], 0)
+	defer s5.Close()
+	require.Len(t, backlog, 1)
+	assert.Equal(t, TypeResync, backlog[0].Type)
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBus(0)
+	slow := b.Subscribe(1)
+	fast := b.Subscribe(4)
+	b.Publish(TypeQuote, "AAPL", nil)
+	b.Publish(TypeQuote, "AAPL", nil)
+
+	<-slow.C
+	_, open := <-slow.C
+	assert.False(t, open)
+	assert.True(t, slow.Lagged())
+	assert.False(t, fast.Lagged())
+	assert.Len(t, fast.C, 2)
+	assert.Equal(t, 1, b.Subscribers())
+	slow.Close()
+}
@@ -0,0 +1,205 @@
+// Package export writes tabular API results as CSV, NDJSON or XLSX. Writers emit each row as
+// it is given, so a handler can stream a whole table straight from the database cursor to the
+// client without holding it in memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats a response can be negotiated to. FormatJSON is the regular API response.
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+// ErrUnsupportedFormat is returned for a ?format= value that is not one of the formats.
+var ErrUnsupportedFormat = errors.New("unsupported format; use json, csv, ndjson or xlsx")
+
+var contentTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatCSV:    "text/csv",
+	FormatNDJSON: "application/x-ndjson",
+	FormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// acceptTypes maps Accept media types to formats; anything not listed is ignored.
+var acceptTypes = map[string]string{
+	"application/json":       FormatJSON,
+	"text/csv":               FormatCSV,
+	"application/x-ndjson":   FormatNDJSON,
+	"application/jsonl":      FormatNDJSON,
+	contentTypes[FormatXLSX]: FormatXLSX,
+}
+
+// Negotiate picks the response format from an explicit ?format= value, which wins, or else
+// the Accept header. The most preferred supported media type in Accept is used; JSON is the
+// default when neither names a supported format.
+func Negotiate(format, accept string) (string, error) {
+	if f := strings.ToLower(strings.TrimSpace(format)); f != "" {
+		if f == "jsonl" {
+			f = FormatNDJSON
+		}
+		if _, ok := contentTypes[f]; !ok {
+			return "", ErrUnsupportedFormat
+		}
+		return f, nil
+	}
+	best, bestQ := FormatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := acceptTypes[mt]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, nil
+}
+
+// ContentType is the media type served for format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Filename names a download of the given base name, e.g. "stocks-20240102.csv".
+func Filename(base, format string, now time.Time) string {
+	return base + "-" + now.UTC().Format("20060102") + "." + format
+}
+
+// Writer writes rows whose values line up with the columns it was created with. Values may
+// be nil, strings, numbers, bools, times, pointers to those, or string slices.
+type Writer interface {
+	Write(row []any) error
+	// Close flushes buffered output and completes the file; the Writer is unusable afterwards.
+	Close() error
+}
+
+// NewWriter starts a file of the given format on w. CSV and XLSX begin with a header row of
+// columns; NDJSON writes one object per row keyed by them. name titles the XLSX sheet.
+func NewWriter(w io.Writer, format, name string, columns []string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		return cw, cw.w.Write(columns)
+	case FormatNDJSON:
+		keys := make([][]byte, len(columns))
+		for i, c := range columns {
+			keys[i], _ = json.Marshal(c)
+		}
+		return &ndjsonWriter{w: bufio.NewWriter(w), keys: keys}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, name, columns)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(row []any) error {
+	rec := make([]string, len(row))
+	for i, v := range row {
+		s, numeric := text(v)
+		// A leading =, +, - or @ makes spreadsheets evaluate the cell as a formula.
+		if !numeric && s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+			s = "'" + s
+		}
+		rec[i] = s
+	}
+	return c.w.Write(rec)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func (n *ndjsonWriter) Write(row []any) error {
+	n.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", n.keys[i], err)
+		}
+		n.w.Write(n.keys[i])
+		n.w.WriteByte(':')
+		n.w.Write(b)
+	}
+	// bufio keeps the first write error and returns it from every later write.
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+// text renders v for a text cell and reports whether it is a number.
+func text(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, false
+	case *string:
+		if v == nil {
+			return "", false
+		}
+		return *v, false
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case *float64:
+		if v == nil {
+			return "", false
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), false
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), false
+	case *time.Time:
+		if v == nil {
+			return "", false
+		}
+		return v.UTC().Format(time.RFC3339), false
+	case []string:
+		return strings.Join(v, "; "), false
+	}
+	return fmt.Sprint(v), false
+}
@@ -0,0 +1,156 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		format, accept, want string
+	}{
+		{"", "", FormatJSON},
+		{"", "*/*", FormatJSON},
+		{"", "text/csv", FormatCSV},
+		{"", "application/x-ndjson", FormatNDJSON},
+		{"", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", FormatXLSX},
+		{"", "text/csv;q=0.5, application/x-ndjson", FormatNDJSON},
+		{"", "application/json;q=0.2, text/csv;q=0.9", FormatCSV},
+		{"", "text/html, text/csv", FormatCSV},
+		{"XLSX", "text/csv", FormatXLSX},
+		{"jsonl", "", FormatNDJSON},
+		{"json", "text/csv", FormatJSON},
+	} {
+		got, err := Negotiate(tc.format, tc.accept)
+		require.NoError(t, err, "%q %q", tc.format, tc.accept)
+		assert.Equal(t, tc.want, got, "%q %q", tc.format, tc.accept)
+	}
+	_, err := Negotiate("pdf", "")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+var (
+	testColumns = []string{"ticker", "company", "target_to", "updated_at", "reasons"}
+	testUpdated = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+)
+
+func testRows() [][]any {
+	target := 120.5
+	return [][]any{
+		{"AAPL", "Apple, Inc.", &target, testUpdated, []string{"upgrade", "high upside"}},
+		{"BRK", "=HYPERLINK(\"x\")", (*float64)(nil), &testUpdated, nil},
+	}
+}
+
+func writeAll(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, "stocks", testColumns)
+	require.NoError(t, err)
+	for _, row := range testRows() {
+		require.NoError(t, w.Write(row))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	recs, err := csv.NewReader(bytes.NewReader(writeAll(t, FormatCSV))).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		testColumns,
+		{"AAPL", "Apple, Inc.", "120.5", "2024-03-01T12:30:00Z", "upgrade; high upside"},
+		{"BRK", `'=HYPERLINK("x")`, "", "2024-03-01T12:30:00Z", ""},
+	}, recs)
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	sc := bufio.NewScanner(bytes.NewReader(writeAll(t, FormatNDJSON)))
+	var lines []map[string]any
+	for sc.Scan() {
+		var m map[string]any
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &m), sc.Text())
+		lines = append(lines, m)
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, map[string]any{
+		"ticker": "AAPL", "company": "Apple, Inc.", "target_to": 120.5,
+		"updated_at": "2024-03-01T12:30:00Z", "reasons": []any{"upgrade", "high upside"},
+	}, lines[0])
+	assert.Nil(t, lines[1]["target_to"])
+	assert.Equal(t, `=HYPERLINK("x")`, lines[1]["company"])
+}
+
+func TestXLSXWriter(t *testing.T) {
+	data := writeAll(t, FormatXLSX)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		parts[f.Name] = string(b)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		require.Contains(t, parts, name)
+		assert.NoError(t, xml.Unmarshal([]byte(parts[name]), new(any)), name)
+	}
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="stocks"`)
+
+	var sheet struct {
+		Rows []struct {
+			R     string `xml:"r,attr"`
+			Cells []struct {
+				R string `xml:"r,attr"`
+				T string `xml:"t,attr"`
+				V string `xml:"v"`
+				S string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet))
+	require.Len(t, sheet.Rows, 3)
+	assert.Equal(t, "ticker", sheet.Rows[0].Cells[0].S)
+
+	row := sheet.Rows[1]
+	assert.Equal(t, "2", row.R)
+	assert.Equal(t, "Apple, Inc.", row.Cells[1].S)
+	assert.Equal(t, "C2", row.Cells[2].R)
+	assert.Equal(t, "", row.Cells[2].T)
+	assert.Equal(t, "120.5", row.Cells[2].V)
+
+	// Empty cells are left out; the formula stays text in a spreadsheet.
+	row = sheet.Rows[2]
+	require.Len(t, row.Cells, 3)
+	assert.Equal(t, "inlineStr", row.Cells[1].T)
+	assert.Equal(t, `=HYPERLINK("x")`, row.Cells[1].S)
+	assert.Equal(t, "D3", row.Cells[2].R)
+}
+
+func TestColumnAndSheetNames(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, columnName(i), "%d", i)
+	}
+	assert.Equal(t, "a_b_c", sheetName("a/b?c"))
+	assert.Equal(t, strings.Repeat("x", 31), sheetName(strings.Repeat("x", 40)))
+	assert.Equal(t, "Sheet1", sheetName(""))
+}
+
+func TestNewWriterUnsupported(t *testing.T) {
+	_, err := NewWriter(io.Discard, FormatJSON, "x", testColumns)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
@@ -0,0 +1,124 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The fixed parts of a single-sheet workbook. Cells use inline strings, so no shared string
+// table has to be built (and held) before the sheet is written.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbookStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`
+	xlsxWorkbookEnd = `" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxSheetStart  = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxWriter streams a workbook: the zip entries before the sheet are written up front and
+// the sheet's rows are compressed as they arrive, so only the deflate window is buffered.
+type xlsxWriter struct {
+	zw  *zip.Writer
+	w   *bufio.Writer
+	row int
+}
+
+func newXLSXWriter(w io.Writer, name string, columns []string) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", xlsxWorkbookStart + escapeXML(sheetName(name)) + xlsxWorkbookEnd},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxWriter{zw: zw, w: bufio.NewWriter(f)}
+	x.w.WriteString(xlsxSheetStart)
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return x, x.Write(header)
+}
+
+func (x *xlsxWriter) Write(row []any) error {
+	x.row++
+	r := strconv.Itoa(x.row)
+	x.w.WriteString(`<row r="` + r + `">`)
+	for i, v := range row {
+		s, numeric := text(v)
+		if s == "" {
+			continue
+		}
+		ref := columnName(i) + r
+		if numeric {
+			x.w.WriteString(`<c r="` + ref + `"><v>` + s + `</v></c>`)
+		} else {
+			x.w.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + escapeXML(s) + `</t></is></c>`)
+		}
+	}
+	_, err := x.w.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	x.w.WriteString(xlsxSheetEnd)
+	if err := x.w.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// columnName is the spreadsheet column letter for a zero-based index: A..Z, AA, AB, ...
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// sheetName makes name a valid sheet title: at most 31 characters and none of []:*?/\.
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if r := []rune(name); len(r) > 31 {
+		name = string(r[:31])
+	}
+	if name == "" {
+		return "Sheet1"
+	}
+	return name
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
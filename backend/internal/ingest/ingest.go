@@ -3,15 +3,18 @@ package ingest
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/events"
 
 	"go.uber.org/zap"
 )
@@ -22,6 +25,10 @@ type Service struct {
 	db      db.DBTX
 	log     *zap.SugaredLogger
 	client  *http.Client
+	events  events.Publisher
+
+	runMu   sync.Mutex
+	current *Run // the run in progress, if any
 }
 
 func NewService(apiBase, token string, db db.DBTX, log *zap.SugaredLogger) *Service {
@@ -36,6 +43,10 @@ func NewService(apiBase, token string, db db.DBTX, log *zap.SugaredLogger) *Serv
 	}
 }
 
+// SetEvents publishes a rating_change event for every stock an ingest run adds or changes, a
+// recommendation_update after runs that changed any and an ingest_complete after every run.
+func (s *Service) SetEvents(p events.Publisher) { s.events = p }
+
 type apiResponse struct {
 	Items    []apiItem `json:"items"`
 	NextPage string    `json:"next_page"`
@@ -54,34 +65,51 @@ type apiItem struct {
 	// Some fields might not exist; we focus on the above from sample.
 }
 
+// RunOnce ingests every page without recording a run in ingest_runs; see Run.
 func (s *Service) RunOnce(ctx context.Context) error {
+	return s.ingest(ctx, &Run{})
+}
+
+// ingest fetches and upserts every page, counting pages and items into r as it goes.
+func (s *Service) ingest(ctx context.Context, r *Run) error {
 	if s.apiBase == "" || s.token == "" {
 		return fmt.Errorf("missing API base or token")
 	}
 
 	next := ""
-	total := 0
+	var changed []string
 	for {
 		items, np, err := s.fetchPage(ctx, next)
 		if err != nil {
 			return err
 		}
+		r.Pages++
 		if len(items) == 0 && np == "" {
 			break
 		}
-		if err := s.upsertItems(ctx, items); err != nil {
+		tickers, err := s.upsertAndPublish(ctx, items, r)
+		if err != nil {
 			return err
 		}
-		total += len(items)
+		changed = append(changed, tickers...)
+		r.Items += len(items)
 		if np == "" {
 			break
 		}
 		next = np
 	}
-	s.log.Infof("ingest completed: %d items", total)
+	s.log.Infof("ingest completed: %d items (%d inserted, %d updated, %d unchanged)", r.Items, r.Inserted, r.Updated, r.Unchanged)
+	if s.events != nil && len(changed) > 0 {
+		s.events.Publish(events.TypeRecommendationUpdate, "", events.RecommendationUpdate{Reason: events.ReasonRatings, Tickers: changed})
+	}
+	if s.events != nil {
+		s.events.Publish(events.TypeIngestComplete, "", events.IngestComplete{RunID: r.ID, Items: r.Items, Changed: len(changed)})
+	}
 	return nil
 }
 
+// StartCron records a run every interval until stop is closed, skipping a tick while an
+// earlier run (or a manual one) is still going.
 func StartCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
 	t := time.NewTicker(every)
 	defer t.Stop()
@@ -89,7 +117,11 @@ func StartCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <
 		select {
 		case <-t.C:
 			ctx, cancel := context.WithTimeout(context.Background(), 2*every)
-			_ = svc.RunOnce(ctx) // errors already logged by RunOnce
+			if r, err := svc.Run(ctx, TriggerCron); errors.Is(err, ErrRunInProgress) {
+				log.Infof("ingest cron skipped: run %s still in progress", r.ID)
+			} else if err != nil && r == nil {
+				log.Warnf("ingest cron could not start a run: %v", err)
+			} // failed runs are logged and recorded by Execute
 			cancel()
 		case <-stop:
 			log.Infof("ingest cron stopped")
@@ -226,6 +258,84 @@ ON CONFLICT (ticker) DO UPDATE SET
 	return batch.Send(ctx, s.db)
 }
 
+// upsertAndPublish upserts items, adds them to r's inserted/updated/unchanged counts and, when
+// events are wired, publishes the ratings that were new or changed. It returns the changed
+// tickers.
+func (s *Service) upsertAndPublish(ctx context.Context, items []apiItem, r *Run) ([]string, error) {
+	changes := s.ratingChanges(ctx, items)
+	if err := s.upsertItems(ctx, items); err != nil {
+		return nil, err
+	}
+	r.Unchanged += len(items) - len(changes)
+	tickers := make([]string, 0, len(changes))
+	for _, c := range changes {
+		if c.New {
+			r.Inserted++
+		} else {
+			r.Updated++
+		}
+		if s.events != nil {
+			s.events.Publish(events.TypeRatingChange, c.Ticker, c)
+		}
+		tickers = append(tickers, c.Ticker)
+	}
+	return tickers, nil
+}
+
+// ratingChanges compares items with the stored ratings. Re-ingesting the same page changes
+// nothing, so it publishes nothing. If the lookup fails every item counts as changed (updated).
+func (s *Service) ratingChanges(ctx context.Context, items []apiItem) []events.RatingChange {
+	type stored struct {
+		ratingTo  string
+		targetTo  *float64
+		changedAt *time.Time
+	}
+	tickers := make([]string, len(items))
+	for i, it := range items {
+		tickers[i] = it.Ticker
+	}
+	prev := map[string]stored{}
+	rows, err := s.db.Query(ctx, `SELECT ticker, rating_to, target_to, last_rating_change_at FROM stocks WHERE ticker = ANY($1)`, tickers)
+	known := err == nil
+	if err != nil {
+		s.log.Warnf("ingest: previous ratings lookup failed: %v", err)
+	} else {
+		for rows.Next() {
+			var t string
+			var st stored
+			if err := rows.Scan(&t, &st.ratingTo, &st.targetTo, &st.changedAt); err == nil {
+				prev[t] = st
+			}
+		}
+		rows.Close()
+	}
+
+	var out []events.RatingChange
+	for _, it := range items {
+		c := events.RatingChange{
+			Ticker: it.Ticker, Company: it.Company, Brokerage: it.Brokerage, Action: it.Action,
+			RatingFrom: it.RatingFrom, RatingTo: it.RatingTo,
+			TargetFrom: parseDollars(it.TargetFrom), TargetTo: parseDollars(it.TargetTo), ChangedAt: parseTime(it.Time),
+		}
+		p, ok := prev[it.Ticker]
+		c.New = known && !ok
+		if ok && p.ratingTo == c.RatingTo && sameFloat(p.targetTo, c.TargetTo) && sameTime(p.changedAt, c.ChangedAt) {
+			continue
+		}
+		prev[it.Ticker] = stored{ratingTo: c.RatingTo, targetTo: c.TargetTo, changedAt: c.ChangedAt}
+		out = append(out, c)
+	}
+	return out
+}
+
+func sameFloat(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameTime(a, b *time.Time) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+}
+
 // Minimal batch wrapper to avoid importing pgx Batch everywhere
 type pgxBatch struct {
 	stmts []stmt
@@ -9,6 +9,8 @@ import (
 	"testing"
 	"time"
 
+	"stockchallenge/backend/internal/events"
+
 	"github.com/pashagolub/pgxmock/v2"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -321,6 +323,8 @@ func TestRunOnce(t *testing.T) {
 	service := NewService(server.URL, "test-token", mock, log)
 
 	// Set up the mock to expect the transaction and queries
+	mock.ExpectQuery(`FROM stocks WHERE ticker = ANY`).WithArgs([]string{"TEST"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}))
 	mock.ExpectBegin()
 
 	// Parse the time string from the test data
@@ -559,6 +563,8 @@ func TestRunOnceWithMultiplePages(t *testing.T) {
 	service := NewService(server.URL, "test-token", mock, log)
 
 	// Set up the mock to expect the transactions and queries
+	mock.ExpectQuery(`FROM stocks WHERE ticker = ANY`).WithArgs([]string{"TEST1"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}))
 	mock.ExpectBegin()
 
 	// Parse the time strings from the test data
@@ -576,6 +582,8 @@ func TestRunOnceWithMultiplePages(t *testing.T) {
 	).WillReturnResult(pgxmock.NewResult("INSERT", 1))
 	mock.ExpectCommit()
 
+	mock.ExpectQuery(`FROM stocks WHERE ticker = ANY`).WithArgs([]string{"TEST2"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}))
 	mock.ExpectBegin()
 
 	lastRatingChangeStr2 := "2023-01-02T12:00:00Z"
@@ -640,6 +648,8 @@ func TestRunOnceWithDatabaseError(t *testing.T) {
 	service := NewService(server.URL, "test-token", mock, log)
 
 	// Set up the mock to expect the transaction and return an error
+	mock.ExpectQuery(`FROM stocks WHERE ticker = ANY`).WithArgs([]string{"TEST"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}))
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("database error"))
 
 	// Call RunOnce - should return an error
@@ -651,3 +661,54 @@ func TestRunOnceWithDatabaseError(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 func ptrf(f float64) *float64 { return &f }
+
+func TestUpsertPublishesRatingChanges(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create mock pool: %v", err)
+	}
+	defer mock.Close()
+	logger, _ := zap.NewDevelopment()
+	service := NewService("http://example.com", "test-token", mock, logger.Sugar())
+	bus := events.NewBus(10)
+	service.SetEvents(bus)
+	sub := bus.Subscribe(10)
+	defer sub.Close()
+
+	changedAt := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	items := []apiItem{
+		// Same rating and target as stored: not a change.
+		{Ticker: "SAME", RatingTo: "Buy", TargetTo: ptr("$120.00"), Time: ptr("2023-01-01T12:00:00Z")},
+		{Ticker: "UP", RatingFrom: "Neutral", RatingTo: "Buy", TargetTo: ptr("$50.00"), Time: ptr("2023-01-01T12:00:00Z")},
+		{Ticker: "NEW", RatingTo: "Hold"},
+	}
+	mock.ExpectQuery(`SELECT ticker, rating_to, target_to, last_rating_change_at FROM stocks WHERE ticker = ANY`).
+		WithArgs([]string{"SAME", "UP", "NEW"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}).
+			AddRow("SAME", "Buy", ptrf(120), &changedAt).
+			AddRow("UP", "Neutral", ptrf(50), &changedAt))
+	mock.ExpectBegin()
+	for range items {
+		mock.ExpectExec(`INSERT INTO stocks`).WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(),
+			pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	}
+	mock.ExpectCommit()
+
+	run := &Run{}
+	tickers, err := service.upsertAndPublish(context.Background(), items, run)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UP", "NEW"}, tickers)
+	assert.Equal(t, Run{Inserted: 1, Updated: 1, Unchanged: 1}, *run)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	e := <-sub.C
+	assert.Equal(t, events.TypeRatingChange, e.Type)
+	assert.Equal(t, "UP", e.Ticker)
+	assert.Equal(t, "Neutral", e.Data.(events.RatingChange).RatingFrom)
+	assert.False(t, e.Data.(events.RatingChange).New)
+	e = <-sub.C
+	assert.Equal(t, "NEW", e.Ticker)
+	assert.True(t, e.Data.(events.RatingChange).New, "no stored rating before")
+	assert.Len(t, sub.C, 0)
+}
@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// What started a run.
+const (
+	TriggerManual  = "manual"
+	TriggerCron    = "cron"
+	TriggerStartup = "startup"
+)
+
+// Run statuses.
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// ErrRunInProgress is returned by StartRun while another run of this service is going.
+var ErrRunInProgress = errors.New("an ingest run is already in progress")
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Run is one recorded ingest run. The counts cover the pages processed so far, so a failed run
+// shows how far it got. Inserted, Updated and Unchanged split Items by how each compared with
+// the stored rating.
+type Run struct {
+	ID         string     `json:"id"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Pages      int        `json:"pages"`
+	Items      int        `json:"items"`
+	Inserted   int        `json:"inserted"`
+	Updated    int        `json:"updated"`
+	Unchanged  int        `json:"unchanged"`
+	Error      *string    `json:"error"`
+}
+
+// StartRun records a new run in ingest_runs without ingesting anything; Execute does that.
+// Only one run per service goes at a time: while one does, StartRun returns it together with
+// ErrRunInProgress.
+func (s *Service) StartRun(ctx context.Context, trigger string) (*Run, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.current != nil {
+		return s.current, ErrRunInProgress
+	}
+	r := &Run{Trigger: trigger, Status: RunRunning}
+	err := s.db.QueryRow(ctx, `
+INSERT INTO ingest_runs (triggered_by, status) VALUES ($1, $2)
+RETURNING id::STRING, started_at
+`, trigger, RunRunning).Scan(&r.ID, &r.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.current = r
+	return r, nil
+}
+
+// Execute ingests every page for a run returned by StartRun and records how it ended. The
+// result is recorded even when ctx is cancelled. It returns the ingest error.
+func (s *Service) Execute(ctx context.Context, r *Run) error {
+	defer func() {
+		s.runMu.Lock()
+		if s.current == r {
+			s.current = nil
+		}
+		s.runMu.Unlock()
+	}()
+
+	runErr := s.ingest(ctx, r)
+	r.Status = RunSucceeded
+	if runErr != nil {
+		r.Status = RunFailed
+		msg := runErr.Error()
+		r.Error = &msg
+		s.log.Warnf("ingest run %s (%s) failed: %v", r.ID, r.Trigger, runErr)
+	}
+
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	var finished time.Time
+	err := s.db.QueryRow(saveCtx, `
+UPDATE ingest_runs
+SET status = $2, finished_at = now(), pages = $3, items = $4, inserted = $5, updated = $6,
+    unchanged = $7, error = $8
+WHERE id = $1
+RETURNING finished_at
+`, r.ID, r.Status, r.Pages, r.Items, r.Inserted, r.Updated, r.Unchanged, r.Error).Scan(&finished)
+	if err != nil {
+		s.log.Warnf("recording ingest run %s: %v", r.ID, err)
+	} else {
+		r.FinishedAt = &finished
+	}
+	return runErr
+}
+
+// Run starts a recorded run and waits for it. When the run could not be started the returned
+// Run is nil, except with ErrRunInProgress, where it is the run in progress.
+func (s *Service) Run(ctx context.Context, trigger string) (*Run, error) {
+	r, err := s.StartRun(ctx, trigger)
+	if err != nil {
+		return r, err
+	}
+	return r, s.Execute(ctx, r)
+}
+
+// interruptedError is recorded for runs MarkInterrupted closes.
+const interruptedError = "interrupted: the backend stopped before the run finished"
+
+// MarkInterrupted records runs still marked running as failed. Call it on startup, before any
+// run is started: a run only stays running past a restart when the process died during it,
+// and would otherwise show as running forever. It returns how many runs were closed.
+func (s *Service) MarkInterrupted(ctx context.Context) (int, error) {
+	tag, err := s.db.Exec(ctx, `
+UPDATE ingest_runs SET status = $1, finished_at = now(), error = $2
+WHERE status = $3
+`, RunFailed, interruptedError, RunRunning)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+const runCols = `id::STRING, triggered_by, status, started_at, finished_at, pages, items, inserted, updated, unchanged, error`
+
+// Runs returns the most recent runs, newest first.
+func (s *Service) Runs(ctx context.Context, limit int) ([]Run, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+runCols+` FROM ingest_runs ORDER BY started_at DESC, id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Run{}
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
+
+// GetRun returns one run, or pgx.ErrNoRows when there is no such run.
+func (s *Service) GetRun(ctx context.Context, id string) (*Run, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, pgx.ErrNoRows
+	}
+	return scanRun(s.db.QueryRow(ctx, `SELECT `+runCols+` FROM ingest_runs WHERE id = $1`, id))
+}
+
+func scanRun(row pgx.Row) (*Run, error) {
+	var r Run
+	if err := row.Scan(&r.ID, &r.Trigger, &r.Status, &r.StartedAt, &r.FinishedAt, &r.Pages, &r.Items,
+		&r.Inserted, &r.Updated, &r.Unchanged, &r.Error); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,122 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const runID = "6f1c1f0e-2b7a-4c55-9a55-0d7c3f0e9b11"
+
+func TestRunRecordsCounts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(apiResponse{Items: []apiItem{
+			{Ticker: "SAME", RatingTo: "Buy", Time: ptr("2023-01-01T12:00:00Z")},
+			{Ticker: "NEW", RatingTo: "Hold"},
+		}})
+	}))
+	defer server.Close()
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	service := NewService(server.URL, "test-token", mock, zap.NewNop().Sugar())
+
+	started := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	changedAt := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`INSERT INTO ingest_runs`).WithArgs(TriggerManual, RunRunning).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "started_at"}).AddRow(runID, started))
+	mock.ExpectQuery(`FROM stocks WHERE ticker = ANY`).WithArgs([]string{"SAME", "NEW"}).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "rating_to", "target_to", "last_rating_change_at"}).
+			AddRow("SAME", "Buy", (*float64)(nil), &changedAt))
+	mock.ExpectBegin()
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec(`INSERT INTO stocks`).WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(),
+			pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	}
+	mock.ExpectCommit()
+	finished := started.Add(time.Minute)
+	mock.ExpectQuery(`UPDATE ingest_runs`).WithArgs(runID, RunSucceeded, 1, 2, 1, 0, 1, (*string)(nil)).
+		WillReturnRows(pgxmock.NewRows([]string{"finished_at"}).AddRow(finished))
+
+	r, err := service.StartRun(context.Background(), TriggerManual)
+	require.NoError(t, err)
+	busy, err := service.StartRun(context.Background(), TriggerCron)
+	assert.ErrorIs(t, err, ErrRunInProgress)
+	assert.Same(t, r, busy)
+
+	require.NoError(t, service.Execute(context.Background(), r))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, Run{ID: runID, Trigger: TriggerManual, Status: RunSucceeded, StartedAt: started, FinishedAt: &finished,
+		Pages: 1, Items: 2, Inserted: 1, Unchanged: 1}, *r)
+	assert.Nil(t, service.current, "the next run can start")
+}
+
+func TestRunRecordsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	service := NewService(server.URL, "test-token", mock, zap.NewNop().Sugar())
+
+	mock.ExpectQuery(`INSERT INTO ingest_runs`).WithArgs(TriggerCron, RunRunning).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "started_at"}).AddRow(runID, time.Now()))
+	mock.ExpectQuery(`UPDATE ingest_runs`).WithArgs(runID, RunFailed, 0, 0, 0, 0, 0, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"finished_at"}).AddRow(time.Now()))
+
+	r, err := service.Run(context.Background(), TriggerCron)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, RunFailed, r.Status)
+	require.NotNil(t, r.Error)
+	assert.Contains(t, *r.Error, "502")
+}
+
+func TestGetRun(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	service := NewService("http://example.com", "test-token", mock, zap.NewNop().Sugar())
+
+	_, err = service.GetRun(context.Background(), "not-a-uuid")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	msg := "upstream status 502"
+	mock.ExpectQuery(`FROM ingest_runs WHERE id`).WithArgs(runID).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "triggered_by", "status", "started_at", "finished_at", "pages", "items",
+			"inserted", "updated", "unchanged", "error"}).
+			AddRow(runID, TriggerCron, RunFailed, time.Now(), (*time.Time)(nil), 3, 20, 1, 2, 17, &msg))
+	r, err := service.GetRun(context.Background(), runID)
+	require.NoError(t, err)
+	assert.Equal(t, 17, r.Unchanged)
+	assert.Equal(t, &msg, r.Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkInterrupted(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	service := NewService("", "", mock, zap.NewNop().Sugar())
+
+	mock.ExpectExec(`UPDATE ingest_runs SET status = \$1, finished_at = now\(\), error = \$2\s+WHERE status = \$3`).
+		WithArgs(RunFailed, interruptedError, RunRunning).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+
+	n, err := service.MarkInterrupted(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
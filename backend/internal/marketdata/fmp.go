@@ -0,0 +1,176 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"stockchallenge/backend/internal/corpactions"
+	"stockchallenge/backend/internal/portfolio"
+	"stockchallenge/backend/internal/securities"
+)
+
+// FMPClient reads reference data from Financial Modeling Prep. It needs FMP_API_KEY.
+type FMPClient struct {
+	base   string
+	apiKey string
+	http   *http.Client
+}
+
+func NewFMPClient(apiKey string) *FMPClient {
+	return &FMPClient{
+		base:   "https://financialmodelingprep.com/api",
+		apiKey: apiKey,
+		http: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (c *FMPClient) getJSON(ctx context.Context, path string, out any) error {
+	if c.apiKey == "" {
+		return errors.New("fmp: no api key")
+	}
+	u := fmt.Sprintf("%s%s?apikey=%s", c.base, path, url.QueryEscape(c.apiKey))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("fmp: http %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("fmp: %w", err)
+	}
+	return nil
+}
+
+// GetDividends returns the cash dividends of symbol with an ex-date on or after from.
+// FMP does not report a currency; callers assume the listing currency.
+func (c *FMPClient) GetDividends(ctx context.Context, symbol string, from time.Time) ([]portfolio.Dividend, error) {
+	var body struct {
+		Historical []struct {
+			Date        string  `json:"date"`
+			PaymentDate string  `json:"paymentDate"`
+			Dividend    float64 `json:"dividend"`
+			AdjDividend float64 `json:"adjDividend"`
+		} `json:"historical"`
+	}
+	if err := c.getJSON(ctx, "/v3/historical-price-full/stock_dividend/"+url.PathEscape(symbol), &body); err != nil {
+		return nil, err
+	}
+
+	out := make([]portfolio.Dividend, 0, len(body.Historical))
+	for _, h := range body.Historical {
+		ex, err := time.Parse("2006-01-02", h.Date)
+		if err != nil || ex.Before(from) {
+			continue
+		}
+		d := portfolio.Dividend{Symbol: symbol, ExDate: ex, Amount: h.Dividend}
+		if d.Amount == 0 {
+			d.Amount = h.AdjDividend
+		}
+		if pay, err := time.Parse("2006-01-02", h.PaymentDate); err == nil {
+			d.PayDate = &pay
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Splits returns the stock splits of symbol with an ex-date on or after from.
+func (c *FMPClient) Splits(ctx context.Context, symbol string, from time.Time) ([]corpactions.Action, error) {
+	var body struct {
+		Historical []struct {
+			Date        string  `json:"date"`
+			Numerator   float64 `json:"numerator"`
+			Denominator float64 `json:"denominator"`
+		} `json:"historical"`
+	}
+	if err := c.getJSON(ctx, "/v3/historical-price-full/stock_split/"+url.PathEscape(symbol), &body); err != nil {
+		return nil, err
+	}
+
+	out := make([]corpactions.Action, 0, len(body.Historical))
+	for _, h := range body.Historical {
+		ex, err := time.Parse("2006-01-02", h.Date)
+		if err != nil || ex.Before(from) || h.Numerator <= 0 || h.Denominator <= 0 {
+			continue
+		}
+		to, old := h.Numerator, h.Denominator
+		out = append(out, corpactions.Action{Symbol: symbol, Type: corpactions.TypeSplit, ExDate: ex, RatioFrom: &old, RatioTo: &to})
+	}
+	return out, nil
+}
+
+// SymbolChanges returns ticker changes effective on or after from, across all symbols.
+func (c *FMPClient) SymbolChanges(ctx context.Context, from time.Time) ([]corpactions.Action, error) {
+	var body []struct {
+		Date      string `json:"date"`
+		OldSymbol string `json:"oldSymbol"`
+		NewSymbol string `json:"newSymbol"`
+	}
+	if err := c.getJSON(ctx, "/v4/symbol_change", &body); err != nil {
+		return nil, err
+	}
+
+	var out []corpactions.Action
+	for _, h := range body {
+		ex, err := time.Parse("2006-01-02", h.Date)
+		if err != nil || ex.Before(from) || h.OldSymbol == "" || h.NewSymbol == "" {
+			continue
+		}
+		ns := h.NewSymbol
+		out = append(out, corpactions.Action{Symbol: h.OldSymbol, Type: corpactions.TypeSymbolChange, ExDate: ex, NewSymbol: &ns})
+	}
+	return out, nil
+}
+
+// Profile returns company reference data for symbol, or nil when FMP has no profile.
+// Shares outstanding are derived from market cap and price.
+func (c *FMPClient) Profile(ctx context.Context, symbol string) (*securities.Security, error) {
+	var body []struct {
+		CompanyName string  `json:"companyName"`
+		Exchange    string  `json:"exchangeShortName"`
+		Sector      string  `json:"sector"`
+		Industry    string  `json:"industry"`
+		Country     string  `json:"country"`
+		Currency    string  `json:"currency"`
+		MktCap      float64 `json:"mktCap"`
+		Price       float64 `json:"price"`
+	}
+	if err := c.getJSON(ctx, "/v3/profile/"+url.PathEscape(symbol), &body); err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+	p := body[0]
+	sec := &securities.Security{
+		Symbol:   symbol,
+		Name:     p.CompanyName,
+		Exchange: p.Exchange,
+		Sector:   p.Sector,
+		Industry: p.Industry,
+		Country:  p.Country,
+		Currency: p.Currency,
+	}
+	if p.MktCap > 0 {
+		mc := p.MktCap
+		sec.MarketCap = &mc
+		if p.Price > 0 {
+			shares := p.MktCap / p.Price
+			sec.SharesOutstanding = &shares
+		}
+	}
+	return sec, nil
+}
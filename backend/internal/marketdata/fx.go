@@ -0,0 +1,67 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FrankfurterClient fetches daily reference FX rates (published by the ECB) from the
+// Frankfurter API. No key is required.
+type FrankfurterClient struct {
+	base string
+	http *http.Client
+}
+
+// NewFrankfurterClient returns a client for baseURL, defaulting to the public instance.
+func NewFrankfurterClient(baseURL string) *FrankfurterClient {
+	if baseURL == "" {
+		baseURL = "https://api.frankfurter.app"
+	}
+	return &FrankfurterClient{
+		base: strings.TrimRight(baseURL, "/"),
+		http: &http.Client{
+			Timeout: 8 * time.Second,
+		},
+	}
+}
+
+// GetFXRate returns how many units of quote one unit of base buys, and the rate date.
+func (c *FrankfurterClient) GetFXRate(ctx context.Context, base, quote string) (float64, time.Time, error) {
+	u := fmt.Sprintf("%s/latest?from=%s&to=%s", c.base, url.QueryEscape(base), url.QueryEscape(quote))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, time.Time{}, fmt.Errorf("frankfurter: http %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Date  string             `json:"date"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, time.Time{}, fmt.Errorf("frankfurter: %w", err)
+	}
+	rate, ok := out.Rates[quote]
+	if !ok || rate <= 0 {
+		return 0, time.Time{}, fmt.Errorf("frankfurter: no rate for %s/%s", base, quote)
+	}
+	asOf, err := time.Parse("2006-01-02", out.Date)
+	if err != nil {
+		asOf = time.Now().UTC()
+	}
+	return rate, asOf, nil
+}
@@ -0,0 +1,311 @@
+// Package openapi builds an OpenAPI 3 document from Go types and validates requests and
+// decoded JSON against it. Schemas are reflected from the structs handlers bind and return,
+// using their json tags, so the document follows the code instead of being kept by hand.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version documents are written in.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document. Operations are added with Add.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	// ops indexes operations by method and route as registered with the router.
+	ops   map[string]*Operation
+	types map[reflect.Type]string
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower-case HTTP methods to operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Deprecated  bool                 `json:"deprecated,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter locations.
+const (
+	InQuery = "query"
+	InPath  = "path"
+)
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is the subset of JSON Schema the API uses.
+type Schema struct {
+	Ref         string   `json:"$ref,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Nullable    bool     `json:"nullable,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+	Items       *Schema  `json:"items,omitempty"`
+	// Properties and Required describe objects; AdditionalProperties types map values.
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// Scalar schemas for parameters.
+func String() *Schema  { return &Schema{Type: "string"} }
+func Integer() *Schema { return &Schema{Type: "integer"} }
+func Number() *Schema  { return &Schema{Type: "number"} }
+func Boolean() *Schema { return &Schema{Type: "boolean"} }
+func Date() *Schema    { return &Schema{Type: "string", Format: "date"} }
+
+// Enum restricts s to values and returns it.
+func (s *Schema) WithEnum(values ...string) *Schema {
+	for _, v := range values {
+		s.Enum = append(s.Enum, v)
+	}
+	return s
+}
+
+// Min sets an inclusive minimum and returns s.
+func (s *Schema) Min(v float64) *Schema {
+	s.Minimum = &v
+	return s
+}
+
+// Max sets an inclusive maximum and returns s.
+func (s *Schema) Max(v float64) *Schema {
+	s.Maximum = &v
+	return s
+}
+
+// New returns an empty document.
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Version: version, Description: description},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+		ops:        map[string]*Operation{},
+		types:      map[reflect.Type]string{},
+	}
+}
+
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+// Add registers op under a router path such as /api/stocks/:ticker. Path parameters that op
+// does not declare are added as required strings.
+func (d *Document) Add(method, route string, op *Operation) {
+	for _, m := range routeParam.FindAllStringSubmatch(route, -1) {
+		if op.param(m[1], InPath) == nil {
+			op.Parameters = append(op.Parameters, &Parameter{Name: m[1], In: InPath, Required: true, Schema: String()})
+		}
+	}
+	path := routeParam.ReplaceAllString(route, "{$1}")
+	item := d.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = op
+	d.ops[strings.ToUpper(method)+" "+route] = op
+}
+
+// Alias makes the operation at method and route answer for alias as well, for validation.
+// Aliases are not listed in Paths.
+func (d *Document) Alias(method, route, alias string) {
+	if op := d.Operation(method, route); op != nil {
+		d.ops[strings.ToUpper(method)+" "+alias] = op
+	}
+}
+
+// Operation returns the operation registered for method and router path, or nil.
+func (d *Document) Operation(method, route string) *Operation {
+	return d.ops[strings.ToUpper(method)+" "+route]
+}
+
+func (op *Operation) param(name, in string) *Parameter {
+	for _, p := range op.Parameters {
+		if p.Name == name && p.In == in {
+			return p
+		}
+	}
+	return nil
+}
+
+// JSON is a response with a JSON body shaped like v (see Schema).
+func (d *Document) JSON(v any, description string) *Response {
+	return &Response{Description: description, Content: map[string]*MediaType{"application/json": {Schema: d.Schema(v)}}}
+}
+
+// JSONBody is a request body shaped like v (see RequestSchema).
+func (d *Document) JSONBody(v any, required bool) *RequestBody {
+	return &RequestBody{Required: required, Content: map[string]*MediaType{"application/json": {Schema: d.RequestSchema(v)}}}
+}
+
+// Schema describes values of v's type as handlers write them. Named structs become
+// components referenced by $ref. Fields are required unless tagged omitempty, and pointers
+// are nullable.
+func (d *Document) Schema(v any) *Schema {
+	return d.schema(reflect.TypeOf(v), false)
+}
+
+// RequestSchema describes v's type as a request body: structs are inlined and only fields
+// tagged binding:"required" (gin's own validation tag) are required.
+func (d *Document) RequestSchema(v any) *Schema {
+	return d.schema(reflect.TypeOf(v), true)
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+func (d *Document) schema(t reflect.Type, request bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := d.schema(t.Elem(), request)
+		if s.Ref != "" {
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Integer()
+	case reflect.Float32, reflect.Float64:
+		return Number()
+	case reflect.String:
+		return String()
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		// encoding/json writes nil slices and maps as null.
+		return &Schema{Type: "array", Items: d.schema(t.Elem(), request), Nullable: true}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem(), request), Nullable: true}
+	case reflect.Struct:
+		if request || t.Name() == "" {
+			return d.object(t, request)
+		}
+		return &Schema{Ref: "#/components/schemas/" + d.component(t)}
+	}
+	return &Schema{}
+}
+
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// component registers a named struct and returns its component name, qualified by package
+// when two packages use the same type name.
+func (d *Document) component(t reflect.Type) string {
+	if name, ok := d.types[t]; ok {
+		return name
+	}
+	name := title(t.Name())
+	for other := range d.types {
+		if d.types[other] == name {
+			name = title(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+			break
+		}
+	}
+	d.types[t] = name
+	// Registered before the properties so recursive types terminate.
+	d.Components.Schemas[name] = &Schema{}
+	*d.Components.Schemas[name] = *d.object(t, false)
+	return name
+}
+
+func (d *Document) object(t reflect.Type, request bool) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	d.fields(s, t, request)
+	return s
+}
+
+func (d *Document) fields(s *Schema, t reflect.Type, request bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			d.fields(s, f.Type, request)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.schema(f.Type, request)
+		required := !strings.Contains(","+opts+",", ",omitempty,")
+		if request {
+			required = strings.Contains(f.Tag.Get("binding"), "required")
+		}
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type audit struct {
+	By string `json:"by"`
+}
+
+type item struct {
+	ID      string            `json:"id"`
+	Price   *float64          `json:"price"`
+	Note    string            `json:"note,omitempty"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]int    `json:"meta,omitempty"`
+	When    time.Time         `json:"when"`
+	Raw     json.RawMessage   `json:"raw,omitempty"`
+	Audit   *audit            `json:"audit"`
+	Ignored string            `json:"-"`
+	Extra   map[string]*audit `json:"extra,omitempty"`
+	audit
+}
+
+type itemIn struct {
+	ID    string   `json:"id" binding:"required"`
+	Price *float64 `json:"price"`
+	Audit audit    `json:"audit"`
+}
+
+func TestSchemaReflection(t *testing.T) {
+	d := New("Test", "1", "")
+	s := d.Schema([]item{})
+	require.Equal(t, "array", s.Type)
+	assert.Equal(t, "#/components/schemas/Item", s.Items.Ref)
+
+	c := d.Components.Schemas["Item"]
+	require.NotNil(t, c)
+	assert.ElementsMatch(t, []string{"id", "price", "tags", "when", "audit", "by"}, c.Required)
+	assert.NotContains(t, c.Properties, "Ignored")
+	assert.True(t, c.Properties["price"].Nullable)
+	assert.Equal(t, "number", c.Properties["price"].Type)
+	assert.Equal(t, "date-time", c.Properties["when"].Format)
+	assert.Equal(t, &Schema{}, c.Properties["raw"])
+	assert.Equal(t, "integer", c.Properties["meta"].AdditionalProperties.Type)
+	// A pointer to a component is a nullable wrapper, as $ref siblings are ignored.
+	assert.True(t, c.Properties["audit"].Nullable)
+	assert.Equal(t, "#/components/schemas/Audit", c.Properties["audit"].AllOf[0].Ref)
+	assert.Contains(t, c.Properties, "by", "embedded fields are flattened")
+
+	in := d.RequestSchema(itemIn{})
+	assert.Equal(t, "object", in.Type)
+	assert.Equal(t, []string{"id"}, in.Required)
+	assert.Equal(t, "object", in.Properties["audit"].Type, "request bodies are inlined")
+}
+
+func TestAddRoutes(t *testing.T) {
+	d := New("Test", "1", "")
+	d.Add(http.MethodGet, "/api/items/:id/log", &Operation{})
+	op := d.Operation("get", "/api/items/:id/log")
+	require.NotNil(t, op)
+	require.Len(t, op.Parameters, 1)
+	assert.Equal(t, Parameter{Name: "id", In: InPath, Required: true, Schema: String()}, *op.Parameters[0])
+	assert.Contains(t, *d.Paths["/api/items/{id}/log"], "get")
+	assert.Nil(t, d.Operation(http.MethodPost, "/api/items/:id/log"))
+}
+
+func TestValidateRequest(t *testing.T) {
+	d := New("Test", "1", "")
+	op := &Operation{
+		Parameters: []*Parameter{
+			{Name: "q", In: InQuery, Required: true, Schema: String()},
+			{Name: "limit", In: InQuery, Schema: Integer().Min(1)},
+			{Name: "from", In: InQuery, Schema: Date()},
+			{Name: "period", In: InQuery, Schema: String().WithEnum("1m", "ytd")},
+			{Name: "tags", In: InQuery, Schema: &Schema{Type: "array", Items: Boolean()}},
+		},
+		RequestBody: d.JSONBody(itemIn{}, true),
+	}
+	d.Add(http.MethodPost, "/items", op)
+
+	validate := func(url, body, contentType string) error {
+		r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return d.ValidateRequest(op, r, func(string) string { return "" })
+	}
+	problems := func(err error) []string {
+		var v *ValidationError
+		require.ErrorAs(t, err, &v)
+		return v.Problems
+	}
+
+	assert.NoError(t, validate("/items?q=a&limit=5&from=2024-01-31&period=YTD&tags=true", `{"id":"x","price":null}`, ""))
+	assert.Equal(t, []string{
+		"q is required",
+		`invalid limit: "0" (minimum 1)`,
+		`invalid from: "01/31/2024" (want YYYY-MM-DD)`,
+		`invalid period: "2w" (want one of 1m, ytd)`,
+		`invalid tags: "maybe" (want true or false)`,
+		"body.id: is required",
+		"body.price: want number",
+	}, problems(validate("/items?limit=0&from=01/31/2024&period=2w&tags=true&tags=maybe", `{"price":"1"}`, "application/json")))
+	assert.Equal(t, []string{"request body is required"}, problems(validate("/items?q=a", "", "")))
+	assert.Equal(t, []string{"body: invalid JSON"}, problems(validate("/items?q=a", `{"id":`, "")))
+	// Other media types are left to the handler.
+	assert.NoError(t, validate("/items?q=a", "id\nx\n", "text/csv"))
+	// Oversized bodies are refused before anything else is looked at.
+	big := `{"id":"` + strings.Repeat("x", MaxBodyBytes) + `"}`
+	assert.ErrorIs(t, validate("/items?limit=0", big, ""), ErrBodyTooLarge)
+
+	// The handler still reads the body.
+	r := httptest.NewRequest(http.MethodPost, "/items?q=a", strings.NewReader(`{"id":"x"}`))
+	require.NoError(t, d.ValidateRequest(op, r, nil))
+	var in itemIn
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+	assert.Equal(t, "x", in.ID)
+}
+
+func TestValidateResponse(t *testing.T) {
+	d := New("Test", "1", "")
+	s := d.Schema(item{})
+	decode := func(body string) any {
+		var v any
+		require.NoError(t, json.Unmarshal([]byte(body), &v))
+		return v
+	}
+
+	ok := decode(`{"id":"1","price":null,"tags":null,"when":"2024-03-01T12:30:00Z","audit":{"by":"me"},"by":"you","extra":{"a":null}}`)
+	assert.NoError(t, d.Validate(s, ok, ModeResponse))
+
+	bad := decode(`{"id":1,"price":"x","tags":[1],"when":"yesterday","audit":{},"by":"you","surprise":true}`)
+	err := d.Validate(s, bad, ModeResponse)
+	var v *ValidationError
+	require.ErrorAs(t, err, &v)
+	assert.Equal(t, []string{
+		"$.audit.by: is required",
+		"$.id: want string",
+		"$.price: want number",
+		"$.surprise: is not in the schema",
+		"$.tags[0]: want string",
+		"$.when: want RFC 3339 date-time",
+	}, v.Problems)
+
+	// Requests may carry fields a handler ignores.
+	assert.Len(t, d.check(s, bad, "$", ModeRequest), 5)
+
+	one := &Schema{OneOf: []*Schema{d.RequestSchema(itemIn{}), {Type: "array", Items: d.RequestSchema(itemIn{})}}}
+	assert.NoError(t, d.Validate(one, decode(`[{"id":"a"}]`), ModeRequest))
+	assert.NoError(t, d.Validate(one, decode(`{"id":"a"}`), ModeRequest))
+	assert.Error(t, d.Validate(one, decode(`"a"`), ModeRequest))
+}
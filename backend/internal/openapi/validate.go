@@ -0,0 +1,303 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found, each prefixed with where it was found.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// MaxBodyBytes bounds the JSON bodies ValidateRequest reads.
+const MaxBodyBytes = 1 << 20
+
+// ErrBodyTooLarge is returned by ValidateRequest for bodies over MaxBodyBytes.
+var ErrBodyTooLarge = fmt.Errorf("request body exceeds %d bytes", MaxBodyBytes)
+
+// Mode sets how strictly objects are checked.
+type Mode int
+
+const (
+	// ModeRequest allows properties a schema does not declare, as json.Unmarshal ignores them.
+	ModeRequest Mode = iota
+	// ModeResponse rejects undeclared properties, so a handler cannot send fields the document
+	// does not mention.
+	ModeResponse
+)
+
+// ValidateRequest checks r's path and query parameters and, when op takes a JSON body and r
+// sends one, the body. The body is read and put back for the handler; bodies over
+// MaxBodyBytes fail with ErrBodyTooLarge before anything else is checked. param returns a
+// path parameter's value.
+func (d *Document) ValidateRequest(op *Operation, r *http.Request, param func(string) string) error {
+	var bodyProblems []string
+	if op.RequestBody != nil {
+		var err error
+		if bodyProblems, err = d.validateBody(op.RequestBody, r); err != nil {
+			return err
+		}
+	}
+	var problems []string
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case InPath:
+			values = []string{param(p.Name)}
+		case InQuery:
+			values = query[p.Name]
+		}
+		if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+			if p.Required {
+				problems = append(problems, p.Name+" is required")
+			}
+			continue
+		}
+		s := d.resolve(p.Schema)
+		if s.Type == "array" {
+			s = d.resolve(s.Items)
+		}
+		for _, v := range values {
+			if msg := checkParam(s, v); msg != "" {
+				problems = append(problems, fmt.Sprintf("invalid %s: %q (%s)", p.Name, v, msg))
+			}
+		}
+	}
+	problems = append(problems, bodyProblems...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (d *Document) validateBody(rb *RequestBody, r *http.Request) ([]string, error) {
+	mt, ok := rb.Content["application/json"]
+	if !ok || r.Body == nil {
+		return nil, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if t, _, _ := mime.ParseMediaType(ct); t != "application/json" {
+			// Other media types are parsed by the handler.
+			return nil, nil
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, ErrBodyTooLarge
+	}
+	if err != nil {
+		return []string{"body: " + err.Error()}, nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return []string{"request body is required"}, nil
+		}
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []string{"body: invalid JSON"}, nil
+	}
+	return d.check(mt.Schema, v, "body", ModeRequest), nil
+}
+
+// checkParam returns why v does not fit the scalar schema s, or "".
+func checkParam(s *Schema, v string) string {
+	var n float64
+	switch s.Type {
+	case "integer":
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "want integer"
+		}
+		n = float64(i)
+	case "number":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "want number"
+		}
+		n = f
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return "want true or false"
+		}
+		return ""
+	case "string":
+		if s.Format == "date" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return "want YYYY-MM-DD"
+			}
+		}
+		if len(s.Enum) > 0 && !inEnum(s.Enum, strings.ToLower(v)) {
+			return "want one of " + enumList(s.Enum)
+		}
+		return ""
+	default:
+		return ""
+	}
+	return checkRange(s, n)
+}
+
+// Validate checks a value decoded by encoding/json (into any) against s.
+func (d *Document) Validate(s *Schema, v any, mode Mode) error {
+	if problems := d.check(s, v, "$", mode); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if s == nil {
+		return &Schema{}
+	}
+	return s
+}
+
+func (d *Document) check(s *Schema, v any, at string, mode Mode) []string {
+	if s == nil {
+		return nil
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" && s.Ref == "" && len(s.AllOf) == 0 && len(s.OneOf) == 0 {
+			return nil
+		}
+		return []string{at + ": must not be null"}
+	}
+	if s.Ref != "" {
+		return d.check(d.resolve(s), v, at, mode)
+	}
+	var problems []string
+	for _, sub := range s.AllOf {
+		problems = append(problems, d.check(sub, v, at, mode)...)
+	}
+	if len(s.OneOf) > 0 {
+		var first []string
+		matched := false
+		for _, sub := range s.OneOf {
+			p := d.check(sub, v, at, mode)
+			if len(p) == 0 {
+				matched = true
+				break
+			}
+			if first == nil {
+				first = p
+			}
+		}
+		if !matched {
+			problems = append(problems, first...)
+		}
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return append(problems, at+": want object")
+		}
+		for _, name := range s.Required {
+			if _, ok := m[name]; !ok {
+				problems = append(problems, at+"."+name+": is required")
+			}
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := s.Properties[k]; ok {
+				problems = append(problems, d.check(ps, m[k], at+"."+k, mode)...)
+			} else if s.AdditionalProperties != nil {
+				problems = append(problems, d.check(s.AdditionalProperties, m[k], at+"."+k, mode)...)
+			} else if mode == ModeResponse && len(s.Properties) > 0 {
+				problems = append(problems, at+"."+k+": is not in the schema")
+			}
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			return append(problems, at+": want array")
+		}
+		for i, item := range a {
+			problems = append(problems, d.check(s.Items, item, fmt.Sprintf("%s[%d]", at, i), mode)...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return append(problems, at+": want string")
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				problems = append(problems, at+": want RFC 3339 date-time")
+			}
+		}
+		if len(s.Enum) > 0 && !inEnum(s.Enum, str) {
+			problems = append(problems, at+": want one of "+enumList(s.Enum))
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return append(problems, at+": want "+s.Type)
+		}
+		if s.Type == "integer" && n != math.Trunc(n) {
+			return append(problems, at+": want integer")
+		}
+		if msg := checkRange(s, n); msg != "" {
+			problems = append(problems, at+": "+msg)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			problems = append(problems, at+": want boolean")
+		}
+	}
+	return problems
+}
+
+func checkRange(s *Schema, n float64) string {
+	if s.Minimum != nil && n < *s.Minimum {
+		return "minimum " + strconv.FormatFloat(*s.Minimum, 'f', -1, 64)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return "maximum " + strconv.FormatFloat(*s.Maximum, 'f', -1, 64)
+	}
+	return ""
+}
+
+func inEnum(enum []any, v string) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []any) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,301 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultCurrency is assumed for positions and trades that do not state one.
+const DefaultCurrency = "USD"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+var (
+	ErrInvalidCurrency = errors.New("currency must be a 3-letter ISO code")
+	ErrFXUnavailable   = errors.New("fx rate unavailable")
+)
+
+// FXRateProvider returns how many units of quote one unit of base buys.
+// Implemented by internal/marketdata providers.
+type FXRateProvider interface {
+	GetFXRate(ctx context.Context, base, quote string) (float64, time.Time, error)
+}
+
+// SetFXProvider wires an FX provider; rates are cached in fx_rates for ttl.
+func (s *Service) SetFXProvider(p FXRateProvider, ttl time.Duration) {
+	s.FX = p
+	s.fxTTL = ttl
+}
+
+// SetBaseCurrency sets the currency totals are reported in when the caller does not pick one.
+func (s *Service) SetBaseCurrency(code string) {
+	if c, err := normalizeCurrency(code); err == nil && c != "" {
+		s.baseCurrency = c
+	}
+}
+
+// resolveBase validates a requested base currency, falling back to the service default.
+func (s *Service) resolveBase(code string) (string, error) {
+	c, err := normalizeCurrency(code)
+	if err != nil || c != "" {
+		return c, err
+	}
+	if s.baseCurrency == "" {
+		return DefaultCurrency, nil
+	}
+	return s.baseCurrency, nil
+}
+
+// normalizeCurrency upper-cases code and checks it looks like an ISO 4217 code. Empty
+// input stays empty so callers can apply their own default.
+func normalizeCurrency(code string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(code))
+	if c == "" {
+		return "", nil
+	}
+	if !currencyPattern.MatchString(c) {
+		return "", ErrInvalidCurrency
+	}
+	return c, nil
+}
+
+func currencyOrDefault(code string) string {
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+// Rate converts 1 unit of from into to. Rates fetched within the TTL are used as-is; otherwise the
+// provider is asked and the cache updated. When the provider fails a stale cached rate
+// (direct or inverse) is better than nothing.
+func (s *Service) Rate(ctx context.Context, from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	cached, fetched, err := s.cachedRate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	if cached > 0 && time.Since(fetched) < s.fxTTL {
+		return cached, nil
+	}
+	if s.FX != nil {
+		rate, asOf, err := s.FX.GetFXRate(ctx, from, to)
+		if err == nil {
+			if _, err := s.DB.Exec(ctx, `
+UPSERT INTO fx_rates (base, quote, rate, as_of, updated_at) VALUES ($1, $2, $3, $4, now())
+`, from, to, rate, asOf); err != nil && s.Log != nil {
+				s.Log.Warnf("cache fx rate %s/%s: %v", from, to, err)
+			}
+			return rate, nil
+		}
+		if s.Log != nil {
+			s.Log.Warnf("fx provider %s/%s: %v", from, to, err)
+		}
+	}
+	if cached > 0 {
+		return cached, nil
+	}
+	if inv, _, err := s.cachedRate(ctx, to, from); err == nil && inv > 0 {
+		return 1 / inv, nil
+	}
+	return 0, fmt.Errorf("%w: %s/%s", ErrFXUnavailable, from, to)
+}
+
+// converter returns a lookup of currency -> base rates that memoizes into rates and records
+// a warning for each currency without one (ok is false for those).
+func (s *Service) converter(ctx context.Context, base string, rates map[string]float64, warnings *[]string) func(cur string) (float64, bool) {
+	return func(cur string) (float64, bool) {
+		if r, ok := rates[cur]; ok {
+			return r, r > 0
+		}
+		r, err := s.Rate(ctx, cur, base)
+		if err != nil {
+			*warnings = append(*warnings, fmt.Sprintf("no %s/%s rate; %s amounts are excluded from totals", cur, base, cur))
+			r = 0
+		}
+		rates[cur] = r
+		return r, r > 0
+	}
+}
+
+// cachedRate returns the stored rate and when it was fetched; rate is 0 when none is cached.
+func (s *Service) cachedRate(ctx context.Context, base, quote string) (float64, time.Time, error) {
+	var (
+		rate    float64
+		fetched time.Time
+	)
+	err := s.DB.QueryRow(ctx, `SELECT rate, updated_at FROM fx_rates WHERE base = $1 AND quote = $2`, base, quote).Scan(&rate, &fetched)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
+	return rate, fetched, err
+}
+
+// CashBalance is uninvested cash in one currency.
+type CashBalance struct {
+	Currency  string    `json:"currency"`
+	Amount    float64   `json:"amount"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CashBalances returns the user's cash per currency.
+func (s *Service) CashBalances(ctx context.Context, userID string) ([]CashBalance, error) {
+	rows, err := s.DB.Query(ctx, `SELECT currency, amount, updated_at FROM cash_balances WHERE user_id = $1 ORDER BY currency`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []CashBalance{}
+	for rows.Next() {
+		var c CashBalance
+		if err := rows.Scan(&c.Currency, &c.Amount, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SetCashBalance sets the user's cash in currency; a zero amount removes the balance.
+func (s *Service) SetCashBalance(ctx context.Context, userID, currency string, amount float64) (*CashBalance, error) {
+	c, err := normalizeCurrency(currency)
+	if err != nil || c == "" {
+		return nil, ErrInvalidCurrency
+	}
+	if amount == 0 {
+		_, err := s.DB.Exec(ctx, `DELETE FROM cash_balances WHERE user_id = $1 AND currency = $2`, userID, c)
+		return &CashBalance{Currency: c, UpdatedAt: time.Now().UTC()}, err
+	}
+	out := &CashBalance{Currency: c, Amount: amount}
+	err = s.DB.QueryRow(ctx, `
+UPSERT INTO cash_balances (user_id, currency, amount, updated_at) VALUES ($1, $2, $3, now())
+RETURNING updated_at
+`, userID, c, amount).Scan(&out.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HoldingValue is one position marked to market in its own and the base currency.
+type HoldingValue struct {
+	Ticker          string  `json:"ticker"`
+	Currency        string  `json:"currency"`
+	Position        float64 `json:"position"`
+	AvgPrice        float64 `json:"average_price"`
+	Sector          string  `json:"sector,omitempty"`
+	Industry        string  `json:"industry,omitempty"`
+	Price           float64 `json:"price"`
+	PriceMissing    bool    `json:"price_missing,omitempty"`
+	MarketValue     float64 `json:"market_value"`
+	CostBasis       float64 `json:"cost_basis"`
+	MarketValueBase float64 `json:"market_value_base"`
+	CostBasisBase   float64 `json:"cost_basis_base"`
+}
+
+// CashValue is a cash balance converted to the base currency.
+type CashValue struct {
+	Currency   string  `json:"currency"`
+	Amount     float64 `json:"amount"`
+	AmountBase float64 `json:"amount_base"`
+}
+
+// Summary is the portfolio valued in one base currency. Rates holds the conversion used
+// for each currency (units of base per unit of currency).
+type Summary struct {
+	BaseCurrency  string             `json:"base_currency"`
+	Holdings      []HoldingValue     `json:"holdings"`
+	Cash          []CashValue        `json:"cash"`
+	Rates         map[string]float64 `json:"rates"`
+	InvestedValue float64            `json:"invested_value"`
+	CashValue     float64            `json:"cash_value"`
+	TotalValue    float64            `json:"total_value"`
+	CostBasis     float64            `json:"cost_basis"`
+	UnrealizedPL  float64            `json:"unrealized_pl"`
+	Warnings      []string           `json:"warnings"`
+}
+
+// Summary values positions at cached quotes (falling back to average price) plus cash,
+// converting everything into base (the service default when empty). Currencies without an
+// FX rate are left out of the totals and reported in Warnings. Holdings are classified by
+// sector and industry when a SectorSource is wired.
+func (s *Service) Summary(ctx context.Context, userID, base string) (*Summary, error) {
+	base, err := s.resolveBase(base)
+	if err != nil {
+		return nil, err
+	}
+	out := &Summary{BaseCurrency: base, Holdings: []HoldingValue{}, Cash: []CashValue{}, Rates: map[string]float64{}, Warnings: []string{}}
+
+	rows, err := s.DB.Query(ctx, `
+SELECT p.ticker, p.currency, p.position, p.average_price, q.price
+FROM portfolio p
+LEFT JOIN quotes_cache q ON q.symbol = p.ticker
+WHERE p.user_id = $1
+ORDER BY p.ticker
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			h     HoldingValue
+			price *float64
+		)
+		if err := rows.Scan(&h.Ticker, &h.Currency, &h.Position, &h.AvgPrice, &price); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		h.Price, h.PriceMissing = h.AvgPrice, price == nil
+		if price != nil {
+			h.Price = *price
+		}
+		h.MarketValue = h.Position * h.Price
+		h.CostBasis = h.Position * h.AvgPrice
+		out.Holdings = append(out.Holdings, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.classify(ctx, out.Holdings); err != nil && s.Log != nil {
+		s.Log.Warnf("classify holdings: %v", err)
+	}
+
+	cash, err := s.CashBalances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := s.converter(ctx, base, out.Rates, &out.Warnings)
+	for i := range out.Holdings {
+		h := &out.Holdings[i]
+		if r, ok := rate(h.Currency); ok {
+			h.MarketValueBase = h.MarketValue * r
+			h.CostBasisBase = h.CostBasis * r
+			out.InvestedValue += h.MarketValueBase
+			out.CostBasis += h.CostBasisBase
+		}
+	}
+	for _, c := range cash {
+		cv := CashValue{Currency: c.Currency, Amount: c.Amount}
+		if r, ok := rate(c.Currency); ok {
+			cv.AmountBase = c.Amount * r
+			out.CashValue += cv.AmountBase
+		}
+		out.Cash = append(out.Cash, cv)
+	}
+	out.TotalValue = out.InvestedValue + out.CashValue
+	out.UnrealizedPL = out.InvestedValue - out.CostBasis
+	sort.Strings(out.Warnings)
+	return out, nil
+}
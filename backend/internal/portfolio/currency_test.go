@@ -0,0 +1,113 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubFX struct {
+	rate  float64
+	err   error
+	calls int
+}
+
+func (f *stubFX) GetFXRate(ctx context.Context, base, quote string) (float64, time.Time, error) {
+	f.calls++
+	return f.rate, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), f.err
+}
+
+func TestRateCaching(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	fx := &stubFX{rate: 1.1}
+	svc := NewService(mock, nil, nil)
+	svc.SetFXProvider(fx, time.Hour)
+
+	// Fresh cache hit: provider is not called.
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.05, time.Now()))
+	r, err := svc.Rate(context.Background(), "EUR", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, 1.05, r)
+	assert.Equal(t, 0, fx.calls)
+
+	// Stale cache: fetch and store.
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.05, time.Now().Add(-2*time.Hour)))
+	mock.ExpectExec(`UPSERT INTO fx_rates`).WithArgs("EUR", "USD", 1.1, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	r, err = svc.Rate(context.Background(), "EUR", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, 1.1, r)
+
+	// Provider down and no direct pair: fall back to the inverse.
+	fx.err = errors.New("down")
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("USD", "GBP").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("GBP", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.25, time.Now().Add(-48*time.Hour)))
+	r, err = svc.Rate(context.Background(), "USD", "GBP")
+	require.NoError(t, err)
+	assert.Equal(t, 0.8, r)
+
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("USD", "JPY").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("JPY", "USD").WillReturnError(pgx.ErrNoRows)
+	_, err = svc.Rate(context.Background(), "USD", "JPY")
+	assert.ErrorIs(t, err, ErrFXUnavailable)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSummaryConvertsToBase(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil, nil)
+	svc.SetBaseCurrency("usd")
+
+	price := 12.0
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "currency", "position", "average_price", "price"}).
+			AddRow("AAPL", "USD", 10.0, 100.0, (*float64)(nil)).
+			AddRow("SAP", "EUR", 10.0, 10.0, &price).
+			AddRow("TM", "JPY", 1.0, 1000.0, (*float64)(nil)))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}).
+			AddRow("EUR", 100.0, time.Now()))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.5, time.Now()))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("JPY", "USD").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("USD", "JPY").WillReturnError(pgx.ErrNoRows)
+
+	sum, err := svc.Summary(context.Background(), "user-1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "USD", sum.BaseCurrency)
+	assert.True(t, sum.Holdings[0].PriceMissing)
+	assert.InDelta(t, 1000+180, sum.InvestedValue, 1e-9)
+	assert.InDelta(t, 1000+150, sum.CostBasis, 1e-9)
+	assert.InDelta(t, 150, sum.CashValue, 1e-9)
+	assert.InDelta(t, 1330, sum.TotalValue, 1e-9)
+	assert.Len(t, sum.Warnings, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = svc.Summary(context.Background(), "user-1", "euro")
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+}
+
+func TestImportCurrencyValidation(t *testing.T) {
+	in := "Symbol,Quantity,Avg Price,Currency\nSAP,5,120,eur\nSAP,5,130,USD\nBMW,1,90,EURO\n"
+	res, err := NewCSVImporter(ColumnMapping{}).Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, "EUR", res.Rows[0].Currency)
+	require.Len(t, res.Issues, 2)
+	assert.Equal(t, "currency", res.Issues[0].Field)
+	assert.Equal(t, "currency", res.Issues[1].Field)
+}
@@ -0,0 +1,529 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// incomeWindow is the trailing period used for received income and the forward rate.
+const incomeWindow = 365 * 24 * time.Hour
+
+// Dividend is one declared cash dividend per share.
+type Dividend struct {
+	Symbol   string     `json:"symbol"`
+	ExDate   time.Time  `json:"ex_date"`
+	PayDate  *time.Time `json:"pay_date,omitempty"`
+	Amount   float64    `json:"amount"`
+	Currency string     `json:"currency"`
+}
+
+// paidOn is the day the cash arrives; providers without a pay date fall back to the ex-date.
+func (d Dividend) paidOn() time.Time {
+	if d.PayDate != nil {
+		return *d.PayDate
+	}
+	return d.ExDate
+}
+
+// DividendProvider returns the dividends of symbol with an ex-date on or after from.
+// Implemented by internal/marketdata providers.
+type DividendProvider interface {
+	GetDividends(ctx context.Context, symbol string, from time.Time) ([]Dividend, error)
+}
+
+// SetDividendProvider enables dividend syncing into the dividends table.
+func (s *Service) SetDividendProvider(p DividendProvider) {
+	s.Dividends = p
+}
+
+// SyncDividends fetches dividends since from for symbols and upserts them. Symbols the
+// provider fails on are logged and skipped. Without a provider it is a no-op.
+func (s *Service) SyncDividends(ctx context.Context, symbols []string, from time.Time) (int, error) {
+	if s.Dividends == nil {
+		return 0, nil
+	}
+	n := 0
+	for _, sym := range symbols {
+		divs, err := s.Dividends.GetDividends(ctx, sym, from)
+		if err != nil {
+			if s.Log != nil {
+				s.Log.Warnf("dividends %s: %v", sym, err)
+			}
+			continue
+		}
+		for _, d := range divs {
+			if d.Amount <= 0 {
+				continue
+			}
+			_, err := s.DB.Exec(ctx, `
+UPSERT INTO dividends (symbol, ex_date, pay_date, amount, currency, updated_at) VALUES ($1, $2, $3, $4, $5, now())
+`, sym, truncateDay(d.ExDate), d.PayDate, d.Amount, currencyOrDefault(d.Currency))
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Service) loadDividends(ctx context.Context, symbols []string, from time.Time) (map[string][]Dividend, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT symbol, ex_date, pay_date, amount, currency
+FROM dividends
+WHERE symbol = ANY($1) AND ex_date >= $2
+ORDER BY symbol, ex_date
+`, symbols, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string][]Dividend{}
+	for rows.Next() {
+		var d Dividend
+		if err := rows.Scan(&d.Symbol, &d.ExDate, &d.PayDate, &d.Amount, &d.Currency); err != nil {
+			return nil, err
+		}
+		out[d.Symbol] = append(out[d.Symbol], d)
+	}
+	return out, rows.Err()
+}
+
+// incomeHolding is a current position with what the income report needs.
+type incomeHolding struct {
+	Ticker    string
+	Currency  string
+	Position  float64
+	AvgPrice  float64
+	Price     *float64
+	CreatedAt time.Time
+}
+
+func (s *Service) incomeHoldings(ctx context.Context, userID string) ([]incomeHolding, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT p.ticker, p.currency, p.position, p.average_price, q.price, p.created_at
+FROM portfolio p
+LEFT JOIN quotes_cache q ON q.symbol = p.ticker
+WHERE p.user_id = $1
+ORDER BY p.ticker
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []incomeHolding
+	for rows.Next() {
+		var h incomeHolding
+		if err := rows.Scan(&h.Ticker, &h.Currency, &h.Position, &h.AvgPrice, &h.Price, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// sharesBefore returns the shares of ticker held at the end of the day before day according
+// to the ledger, and whether the ledger has any trades in ticker at all.
+func sharesBefore(txs []Transaction, ticker string, day time.Time) (float64, bool) {
+	held, traded := 0.0, false
+	for _, t := range txs {
+		if t.Ticker != ticker || t.Type == TxDividend {
+			continue
+		}
+		traded = true
+		if t.TradeDate.Before(day) {
+			held += t.signedQuantity()
+		}
+	}
+	return held, traded
+}
+
+// dividendScope is what recording a user's dividends looks at: current holdings, the ledger
+// up to today, the symbols in either and the earliest date dividends are needed from.
+type dividendScope struct {
+	holdings []incomeHolding
+	txs      []Transaction
+	symbols  []string
+	from     time.Time
+}
+
+func (s *Service) dividendScope(ctx context.Context, userID string, today time.Time) (*dividendScope, error) {
+	holdings, err := s.incomeHoldings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := s.ListTransactions(ctx, userID, today)
+	if err != nil {
+		return nil, err
+	}
+
+	from := today.Add(-incomeWindow)
+	symbols := map[string]bool{}
+	for _, h := range holdings {
+		symbols[h.Ticker] = true
+	}
+	for _, t := range txs {
+		symbols[t.Ticker] = true
+		if t.TradeDate.Before(from) {
+			from = t.TradeDate
+		}
+	}
+	return &dividendScope{holdings: holdings, txs: txs, symbols: keys(symbols), from: from}, nil
+}
+
+// RecordDividends refreshes dividends from the provider (when set) and adds a dividend
+// transaction for every paid dividend (pay date up to today) on a stock the user held
+// before its ex-date. Holdings come from the ledger when it has
+// trades in the ticker, otherwise from the positions table for ex-dates after the position
+// was created. Dividends already in the ledger for that ticker and pay date are skipped.
+func (s *Service) RecordDividends(ctx context.Context, userID string, today time.Time) (int, error) {
+	today = truncateDay(today)
+	scope, err := s.dividendScope(ctx, userID, today)
+	if err != nil {
+		return 0, err
+	}
+	if len(scope.symbols) == 0 {
+		return 0, nil
+	}
+	if _, err := s.SyncDividends(ctx, scope.symbols, scope.from); err != nil {
+		return 0, err
+	}
+	return s.recordDividends(ctx, userID, today, scope)
+}
+
+// recordDividends adds the dividend transactions of RecordDividends from the dividends table
+// as it is, without asking the provider.
+func (s *Service) recordDividends(ctx context.Context, userID string, today time.Time, scope *dividendScope) (int, error) {
+	if len(scope.symbols) == 0 {
+		return 0, nil
+	}
+	txs, holdings := scope.txs, scope.holdings
+	divs, err := s.loadDividends(ctx, scope.symbols, scope.from)
+	if err != nil {
+		return 0, err
+	}
+
+	var record []Transaction
+	for sym, list := range divs {
+		for _, d := range list {
+			if d.paidOn().After(today) {
+				continue
+			}
+			shares, traded := sharesBefore(txs, sym, d.ExDate)
+			if !traded {
+				for _, h := range holdings {
+					if h.Ticker == sym && truncateDay(h.CreatedAt).Before(d.ExDate) {
+						shares = h.Position
+					}
+				}
+			}
+			if shares <= 0 {
+				continue
+			}
+			note := fmt.Sprintf("dividend ex %s", d.ExDate.Format("2006-01-02"))
+			record = append(record, Transaction{
+				Ticker:    sym,
+				Type:      TxDividend,
+				Quantity:  shares,
+				Price:     d.Amount,
+				Currency:  currencyOrDefault(d.Currency),
+				TradeDate: d.paidOn(),
+				Note:      &note,
+			})
+		}
+	}
+
+	n := 0
+	for _, t := range record {
+		tag, err := s.DB.Exec(ctx, `
+INSERT INTO portfolio_transactions (user_id, ticker, type, quantity, price, fees, currency, trade_date, note)
+SELECT $1, $2, $3, $4, $5, 0, $6, $7, $8
+WHERE NOT EXISTS (
+	SELECT 1 FROM portfolio_transactions
+	WHERE user_id = $1 AND ticker = $2 AND type = $3 AND trade_date = $7
+)
+`, userID, t.Ticker, t.Type, t.Quantity, t.Price, t.Currency, t.TradeDate, t.Note)
+		if err != nil {
+			return n, err
+		}
+		n += int(tag.RowsAffected())
+	}
+	return n, nil
+}
+
+// forwardRate annualizes the latest dividend by the number of payments in the year to
+// today. It returns the annual amount per share and the payment count.
+func forwardRate(divs []Dividend, today time.Time) (float64, int) {
+	from := today.Add(-incomeWindow)
+	var (
+		n      int
+		latest Dividend
+	)
+	for _, d := range divs {
+		if d.ExDate.After(from) && !d.ExDate.After(today) {
+			n++
+			if d.ExDate.After(latest.ExDate) {
+				latest = d
+			}
+		}
+	}
+	return latest.Amount * float64(n), n
+}
+
+// HoldingIncome is the dividend income of one holding, in the holding's currency.
+type HoldingIncome struct {
+	Ticker   string  `json:"ticker"`
+	Currency string  `json:"currency"`
+	Position float64 `json:"position"`
+	// TTMReceived is the dividend cash recorded in the ledger over the last 12 months.
+	TTMReceived float64 `json:"ttm_received"`
+	// AnnualPerShare is the latest dividend times the payments made in the last 12 months.
+	AnnualPerShare  float64    `json:"annual_dividend_per_share"`
+	PaymentsPerYear int        `json:"payments_per_year"`
+	ForwardIncome   float64    `json:"forward_annual_income"`
+	YieldOnCost     *float64   `json:"yield_on_cost"`
+	CurrentYield    *float64   `json:"current_yield"`
+	LastExDate      *time.Time `json:"last_ex_date,omitempty"`
+}
+
+// IncomeReport sums dividend income in the base currency.
+type IncomeReport struct {
+	BaseCurrency        string             `json:"base_currency"`
+	AsOf                time.Time          `json:"as_of"`
+	TTMReceived         float64            `json:"ttm_received"`
+	ForwardAnnualIncome float64            `json:"forward_annual_income"`
+	CostBasis           float64            `json:"cost_basis"`
+	YieldOnCost         *float64           `json:"yield_on_cost"`
+	Holdings            []HoldingIncome    `json:"holdings"`
+	Rates               map[string]float64 `json:"rates"`
+	Warnings            []string           `json:"warnings"`
+}
+
+// Income reports dividends received over the trailing 12 months (from the ledger), the
+// forward annual income of current positions and yield on cost, converted into base.
+// Tickers that paid in the window but are no longer held are listed with position 0.
+func (s *Service) Income(ctx context.Context, userID, base string, today time.Time) (*IncomeReport, error) {
+	base, err := s.resolveBase(base)
+	if err != nil {
+		return nil, err
+	}
+	today = truncateDay(today)
+	holdings, err := s.incomeHoldings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	received, err := s.dividendsReceived(ctx, userID, today.Add(-incomeWindow), today)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(holdings))
+	for _, h := range holdings {
+		symbols = append(symbols, h.Ticker)
+	}
+	divs, err := s.loadDividends(ctx, symbols, today.Add(-incomeWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &IncomeReport{BaseCurrency: base, AsOf: today, Holdings: []HoldingIncome{}, Rates: map[string]float64{}, Warnings: []string{}}
+	rate := s.converter(ctx, base, out.Rates, &out.Warnings)
+	seen := map[string]bool{}
+	for _, h := range holdings {
+		seen[h.Ticker] = true
+		hi := HoldingIncome{Ticker: h.Ticker, Currency: h.Currency, Position: h.Position, TTMReceived: sumIn(received[h.Ticker], h.Currency, rate)}
+		hi.AnnualPerShare, hi.PaymentsPerYear = forwardRate(divs[h.Ticker], today)
+		hi.ForwardIncome = hi.AnnualPerShare * h.Position
+		if list := divs[h.Ticker]; len(list) > 0 {
+			last := list[len(list)-1].ExDate
+			hi.LastExDate = &last
+		}
+		if h.AvgPrice > 0 {
+			y := hi.AnnualPerShare / h.AvgPrice
+			hi.YieldOnCost = &y
+		}
+		if h.Price != nil && *h.Price > 0 {
+			y := hi.AnnualPerShare / *h.Price
+			hi.CurrentYield = &y
+		}
+		if r, ok := rate(h.Currency); ok {
+			out.ForwardAnnualIncome += hi.ForwardIncome * r
+			out.CostBasis += h.Position * h.AvgPrice * r
+		}
+		out.Holdings = append(out.Holdings, hi)
+	}
+	for _, tk := range sortedKeys(received) {
+		streams := received[tk]
+		for _, rc := range streams {
+			if r, ok := rate(rc.currency); ok {
+				out.TTMReceived += rc.amount * r
+			}
+		}
+		if !seen[tk] {
+			// A ticker no longer held is reported in the currency it paid the most in.
+			cur, most := streams[0].currency, 0.0
+			for _, rc := range streams {
+				if v := sumIn([]receivedIncome{rc}, base, rate); v > most {
+					cur, most = rc.currency, v
+				}
+			}
+			out.Holdings = append(out.Holdings, HoldingIncome{Ticker: tk, Currency: cur, TTMReceived: sumIn(streams, cur, rate)})
+		}
+	}
+	if out.CostBasis > 0 {
+		y := out.ForwardAnnualIncome / out.CostBasis
+		out.YieldOnCost = &y
+	}
+	sort.Strings(out.Warnings)
+	return out, nil
+}
+
+type receivedIncome struct {
+	amount   float64
+	currency string
+}
+
+// dividendsReceived sums net dividend cash paid in (from, to] per ticker and currency. A
+// ticker paid in several currencies gets one stream per currency, ordered by currency.
+func (s *Service) dividendsReceived(ctx context.Context, userID string, from, to time.Time) (map[string][]receivedIncome, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT ticker, currency, SUM(quantity * price - fees)
+FROM portfolio_transactions
+WHERE user_id = $1 AND type = $2 AND trade_date > $3 AND trade_date <= $4
+GROUP BY ticker, currency
+ORDER BY ticker, currency
+`, userID, TxDividend, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string][]receivedIncome{}
+	for rows.Next() {
+		var (
+			tk, cur string
+			amount  float64
+		)
+		if err := rows.Scan(&tk, &cur, &amount); err != nil {
+			return nil, err
+		}
+		out[tk] = append(out[tk], receivedIncome{amount: amount, currency: cur})
+	}
+	return out, rows.Err()
+}
+
+// sumIn adds up dividend streams in currency cur, converting through rate (which gives the
+// base-currency value of one unit). Streams that cannot be converted are left out; rate
+// records the warning.
+func sumIn(streams []receivedIncome, cur string, rate func(string) (float64, bool)) float64 {
+	var total float64
+	for _, rc := range streams {
+		if rc.currency == cur {
+			total += rc.amount
+			continue
+		}
+		from, ok := rate(rc.currency)
+		if !ok {
+			continue
+		}
+		to, ok := rate(cur)
+		if !ok || to == 0 {
+			continue
+		}
+		total += rc.amount * from / to
+	}
+	return total
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RecordAllDividends records dividends for every user with positions or ledger entries.
+// Each symbol is synced from the provider once, from the earliest date any user needs, and
+// every user's dividends are then recorded from the dividends table.
+func (s *Service) RecordAllDividends(ctx context.Context, today time.Time) (int, error) {
+	today = truncateDay(today)
+	rows, err := s.DB.Query(ctx, `
+SELECT user_id::STRING FROM portfolio
+UNION
+SELECT user_id::STRING FROM portfolio_transactions
+`)
+	if err != nil {
+		return 0, err
+	}
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err == nil {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+
+	scopes := make([]*dividendScope, len(users))
+	since := map[string]time.Time{}
+	for i, u := range users {
+		if scopes[i], err = s.dividendScope(ctx, u, today); err != nil {
+			return 0, err
+		}
+		for _, sym := range scopes[i].symbols {
+			if from, ok := since[sym]; !ok || scopes[i].from.Before(from) {
+				since[sym] = scopes[i].from
+			}
+		}
+	}
+	for _, sym := range sortedKeys(since) {
+		if _, err := s.SyncDividends(ctx, []string{sym}, since[sym]); err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	for i, u := range users {
+		n, err := s.recordDividends(ctx, u, today, scopes[i])
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// StartDividendCron syncs and records dividends on startup and then every interval until stop is closed.
+func StartDividendCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		n, err := svc.RecordAllDividends(ctx, time.Now().UTC())
+		if err != nil {
+			log.Warnf("dividend sync error: %v", err)
+			return
+		}
+		log.Infof("dividend sync recorded %d payments", n)
+	}
+	run()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			run()
+		case <-stop:
+			log.Infof("dividend cron stopped")
+			return
+		}
+	}
+}
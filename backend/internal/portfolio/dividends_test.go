@@ -0,0 +1,190 @@
+package portfolio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	holdingCols  = []string{"ticker", "currency", "position", "average_price", "price", "created_at"}
+	dividendCols = []string{"symbol", "ex_date", "pay_date", "amount", "currency"}
+)
+
+func datePtr(s string) *time.Time {
+	t := d(s)
+	return &t
+}
+
+func TestRecordDividends(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	mock.MatchExpectationsInOrder(false)
+	svc := &Service{DB: mock}
+	today := d("2024-06-30")
+
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows(holdingCols).
+			AddRow("MSFT", "USD", 10.0, 300.0, (*float64)(nil), d("2024-01-01")))
+	mock.ExpectQuery(`FROM portfolio_transactions`).WithArgs("user-1", today).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "type", "quantity", "price", "fees", "currency", "trade_date", "note", "created_at"}).
+			AddRow("t1", "KO", TxBuy, 100.0, 60.0, 0.0, "USD", d("2024-03-01"), (*string)(nil), time.Now()).
+			AddRow("t2", "KO", TxSell, 50.0, 62.0, 0.0, "USD", d("2024-05-01"), (*string)(nil), time.Now()))
+	mock.ExpectQuery(`FROM dividends`).WithArgs(pgxmock.AnyArg(), d("2023-07-01")).
+		WillReturnRows(pgxmock.NewRows(dividendCols).
+			AddRow("KO", d("2024-02-14"), datePtr("2024-04-01"), 0.485, "USD"). // before the first buy
+			AddRow("KO", d("2024-05-31"), datePtr("2024-06-14"), 0.485, "USD").
+			AddRow("KO", d("2024-06-13"), datePtr("2024-07-01"), 0.485, "USD").  // not paid yet
+			AddRow("MSFT", d("2023-11-15"), datePtr("2023-12-14"), 0.75, "USD"). // before the position existed
+			AddRow("MSFT", d("2024-05-15"), datePtr("2024-06-13"), 0.75, "USD"))
+	mock.ExpectExec(`INSERT INTO portfolio_transactions`).
+		WithArgs("user-1", "KO", TxDividend, 50.0, 0.485, "USD", d("2024-06-14"), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`INSERT INTO portfolio_transactions`).
+		WithArgs("user-1", "MSFT", TxDividend, 10.0, 0.75, "USD", d("2024-06-13"), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0)) // already recorded
+
+	n, err := svc.RecordDividends(context.Background(), "user-1", today)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// countingDividends returns one KO dividend and counts the calls per symbol.
+type countingDividends map[string]int
+
+func (c countingDividends) GetDividends(ctx context.Context, symbol string, from time.Time) ([]Dividend, error) {
+	c[symbol]++
+	return []Dividend{{Symbol: symbol, ExDate: d("2024-05-31"), PayDate: datePtr("2024-06-14"), Amount: 0.485, Currency: "USD"}}, nil
+}
+
+func TestRecordAllDividendsSyncsEachSymbolOnce(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	provider := countingDividends{}
+	svc := &Service{DB: mock}
+	svc.SetDividendProvider(provider)
+	today := d("2024-06-30")
+	txCols := []string{"id", "ticker", "type", "quantity", "price", "fees", "currency", "trade_date", "note", "created_at"}
+
+	mock.ExpectQuery(`SELECT user_id::STRING FROM portfolio`).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow("user-1").AddRow("user-2"))
+	// Both users hold KO, so it is synced once; each user reads it from the table.
+	for _, u := range []string{"user-1", "user-2"} {
+		mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs(u).
+			WillReturnRows(pgxmock.NewRows(holdingCols).AddRow("KO", "USD", 10.0, 60.0, (*float64)(nil), d("2024-01-01")))
+		rows := pgxmock.NewRows(txCols)
+		if u == "user-2" {
+			rows.AddRow("t1", "KO", TxBuy, 20.0, 50.0, 0.0, "USD", d("2022-01-03"), (*string)(nil), time.Now())
+		}
+		mock.ExpectQuery(`FROM portfolio_transactions`).WithArgs(u, today).WillReturnRows(rows)
+	}
+	mock.ExpectExec(`UPSERT INTO dividends`).
+		WithArgs("KO", d("2024-05-31"), datePtr("2024-06-14"), 0.485, "USD").
+		WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	for _, c := range []struct {
+		user   string
+		from   time.Time
+		shares float64
+	}{{"user-1", d("2023-07-01"), 10}, {"user-2", d("2022-01-03"), 20}} {
+		mock.ExpectQuery(`FROM dividends`).WithArgs([]string{"KO"}, c.from).
+			WillReturnRows(pgxmock.NewRows(dividendCols).AddRow("KO", d("2024-05-31"), datePtr("2024-06-14"), 0.485, "USD"))
+		mock.ExpectExec(`INSERT INTO portfolio_transactions`).
+			WithArgs(c.user, "KO", TxDividend, c.shares, 0.485, "USD", d("2024-06-14"), pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	}
+
+	n, err := svc.RecordAllDividends(context.Background(), today)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, countingDividends{"KO": 1}, provider)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIncome(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+	today := d("2024-06-30")
+	price := 60.0
+
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows(holdingCols).
+			AddRow("KO", "USD", 50.0, 50.0, &price, d("2023-01-01")))
+	mock.ExpectQuery(`SUM\(quantity \* price - fees\)`).WithArgs("user-1", TxDividend, d("2023-07-01"), today).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "currency", "sum"}).
+			AddRow("KO", "USD", 24.25).
+			AddRow("PEP", "USD", 10.0))
+	mock.ExpectQuery(`FROM dividends`).WithArgs([]string{"KO"}, d("2023-07-01")).
+		WillReturnRows(pgxmock.NewRows(dividendCols).
+			AddRow("KO", d("2023-09-14"), datePtr("2023-10-01"), 0.46, "USD").
+			AddRow("KO", d("2023-11-30"), datePtr("2023-12-15"), 0.46, "USD").
+			AddRow("KO", d("2024-03-14"), datePtr("2024-04-01"), 0.485, "USD").
+			AddRow("KO", d("2024-05-31"), datePtr("2024-06-14"), 0.485, "USD"))
+
+	rep, err := svc.Income(context.Background(), "user-1", "", today)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCurrency, rep.BaseCurrency)
+	assert.InDelta(t, 34.25, rep.TTMReceived, 1e-9)
+	assert.InDelta(t, 97, rep.ForwardAnnualIncome, 1e-9)
+	require.Len(t, rep.Holdings, 2)
+	ko := rep.Holdings[0]
+	assert.Equal(t, 4, ko.PaymentsPerYear)
+	assert.InDelta(t, 1.94, ko.AnnualPerShare, 1e-9)
+	assert.InDelta(t, 0.0388, *ko.YieldOnCost, 1e-9)
+	assert.InDelta(t, 1.94/60, *ko.CurrentYield, 1e-9)
+	assert.Equal(t, "PEP", rep.Holdings[1].Ticker)
+	assert.Zero(t, rep.Holdings[1].Position)
+	assert.InDelta(t, 97.0/2500, *rep.YieldOnCost, 1e-9)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIncomeConvertsEveryDividendCurrency(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+	today := d("2024-06-30")
+
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows(holdingCols).
+			AddRow("SHEL", "USD", 10.0, 60.0, (*float64)(nil), d("2023-01-01")))
+	mock.ExpectQuery(`SUM\(quantity \* price - fees\)`).WithArgs("user-1", TxDividend, d("2023-07-01"), today).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "currency", "sum"}).
+			AddRow("BP", "GBP", 8.0).
+			AddRow("BP", "USD", 2.0).
+			AddRow("SHEL", "GBP", 20.0).
+			AddRow("SHEL", "USD", 10.0))
+	mock.ExpectQuery(`FROM dividends`).WithArgs([]string{"SHEL"}, d("2023-07-01")).
+		WillReturnRows(pgxmock.NewRows(dividendCols))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("GBP", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.25, time.Now()))
+
+	rep, err := svc.Income(context.Background(), "user-1", "", today)
+	require.NoError(t, err)
+	// Both streams of both tickers count: 8*1.25 + 2 + 20*1.25 + 10.
+	assert.InDelta(t, 47, rep.TTMReceived, 1e-9)
+	require.Len(t, rep.Holdings, 2)
+	assert.Equal(t, "SHEL", rep.Holdings[0].Ticker)
+	assert.InDelta(t, 35, rep.Holdings[0].TTMReceived, 1e-9)
+	bp := rep.Holdings[1]
+	assert.Equal(t, "GBP", bp.Currency)
+	assert.InDelta(t, 9.6, bp.TTMReceived, 1e-9)
+	assert.Empty(t, rep.Warnings)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDividendTransactionIsACashFlow(t *testing.T) {
+	tx := Transaction{Ticker: "ko", Type: "Dividend", Quantity: 50, Price: 0.5, Fees: 3.75, TradeDate: d("2024-06-14")}
+	require.NoError(t, tx.normalize(0))
+	assert.Equal(t, "USD", tx.Currency)
+	assert.Zero(t, tx.signedQuantity())
+	assert.InDelta(t, -21.25, tx.cashAmount(), 1e-9)
+}
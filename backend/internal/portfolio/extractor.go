@@ -0,0 +1,57 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Extractor turns a portfolio screenshot into the raw JSON text described by PositionsOut.
+// Implementations only talk to the vision backend; validation and storage stay in Service.
+type Extractor interface {
+	Extract(ctx context.Context, image []byte) (string, error)
+}
+
+// ErrExtractorUnavailable is returned when no extractor is configured.
+var ErrExtractorUnavailable = errors.New("portfolio extraction is not configured")
+
+// Extractor kinds accepted by PORTFOLIO_EXTRACTOR.
+const (
+	ExtractorGemini  = "gemini"
+	ExtractorOpenAI  = "openai"
+	ExtractorFixture = "fixture"
+	ExtractorNone    = "none"
+)
+
+const instruction = `
+You are a precise data-extraction engine.
+
+Goal: Return ONLY a JSON object with three arrays: "INSTRUMENTS", "POSITION", "AVG PRICE".
+Use the table under the "Positions" header. Columns to read: INSTRUMENT (ticker only), POSITION, AVG PRICE.
+If the table shows the currency of each row, also return a fourth array "CURRENCY" with 3-letter ISO codes (e.g., "USD", "EUR"); otherwise omit it.
+Ignore all other columns.
+
+Normalization:
+- INSTRUMENTS: extract the ticker only; drop venue/exchange suffixes (e.g., "NASDAQ.NMS", "NYSE", "ARCA").
+- POSITION and AVG PRICE: numbers only; drop currency letters/symbols; use "." as decimal; no thousands separators.
+- If a row lacks POSITION or AVG PRICE, omit that row.
+- Keep original visual order from top to bottom.
+
+Return just the JSON.
+`
+
+const userPrompt = "Extract the three arrays from this screenshot."
+
+// stripCodeFence removes a ```json ... ``` wrapper that chat models like to add even when
+// asked for bare JSON.
+func stripCodeFence(s string) string {
+	t := strings.TrimSpace(s)
+	if !strings.HasPrefix(t, "```") {
+		return s
+	}
+	t = strings.TrimPrefix(t, "```")
+	if i := strings.IndexByte(t, '\n'); i >= 0 {
+		t = t[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(t), "```"))
+}
@@ -0,0 +1,84 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractPortfolioWithFixtures(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	fx, err := NewFixtureExtractor("testdata/extractor")
+	require.NoError(t, err)
+	svc := NewService(mock, nil, fx)
+
+	mock.ExpectQuery(`INSERT INTO portfolio_imports`).
+		WithArgs("user-1", SourceScreenshot, ImportPending, pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "created_at"}).AddRow("imp-1", time.Now()))
+
+	imp, err := svc.ExtractPortfolio(context.Background(), "user-1", []byte("any screenshot"))
+	require.NoError(t, err)
+	require.Len(t, imp.Rows, 3)
+	assert.Equal(t, "AAPL", imp.Rows[0].Ticker)
+	assert.Equal(t, 150.25, imp.Rows[0].AvgPrice)
+	assert.Empty(t, imp.Issues)
+
+	// A fixture keyed by the image hash wins over default.json; its code fence is stripped.
+	mock.ExpectQuery(`INSERT INTO portfolio_imports`).
+		WithArgs("user-1", SourceScreenshot, ImportPending, pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "created_at"}).AddRow("imp-2", time.Now()))
+
+	imp, err = svc.ExtractPortfolio(context.Background(), "user-1", []byte("broken-screenshot"))
+	require.NoError(t, err)
+	require.Len(t, imp.Rows, 2)
+	assert.Equal(t, 0.0, imp.Rows[1].Confidence)
+	assert.NotEmpty(t, imp.Issues)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExtractPortfolioWithoutExtractor(t *testing.T) {
+	svc := NewService(nil, nil, nil)
+	_, err := svc.ExtractPortfolio(context.Background(), "user-1", []byte("img"))
+	assert.ErrorIs(t, err, ErrExtractorUnavailable)
+}
+
+func TestOpenAIExtractor(t *testing.T) {
+	var got chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"{\"INSTRUMENTS\":[\"KO\"],\"POSITION\":[1],\"AVG PRICE\":[60]}"}}]}`))
+	}))
+	defer srv.Close()
+
+	ex, err := NewOpenAIExtractor(srv.URL+"/v1/", "secret", "llava")
+	require.NoError(t, err)
+	text, err := ex.Extract(context.Background(), []byte("\x89PNG\r\n\x1a\n"))
+	require.NoError(t, err)
+	assert.Contains(t, text, `"KO"`)
+	assert.Equal(t, "llava", got.Model)
+	require.Len(t, got.Messages, 2)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusBadGateway)
+	})
+	_, err = ex.Extract(context.Background(), []byte("img"))
+	assert.ErrorContains(t, err, "status 502")
+}
+
+func TestStripCodeFence(t *testing.T) {
+	assert.Equal(t, `{"a":1}`, stripCodeFence("```json\n{\"a\":1}\n```"))
+	assert.Equal(t, `{"a":1}`, stripCodeFence(`{"a":1}`))
+}
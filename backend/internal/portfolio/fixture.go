@@ -0,0 +1,43 @@
+package portfolio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FixtureExtractor is an offline stand-in for a vision model. It answers with the contents
+// of <dir>/<sha256 of image>.json, falling back to <dir>/default.json, so tests and local
+// development can exercise the upload flow without network access or API keys.
+type FixtureExtractor struct {
+	Dir string
+}
+
+func NewFixtureExtractor(dir string) (*FixtureExtractor, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("fixture extractor: %w", err)
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("fixture extractor: %s is not a directory", dir)
+	}
+	return &FixtureExtractor{Dir: dir}, nil
+}
+
+func (f *FixtureExtractor) Extract(ctx context.Context, image []byte) (string, error) {
+	sum := sha256.Sum256(image)
+	for _, name := range []string{hex.EncodeToString(sum[:]) + ".json", "default.json"} {
+		b, err := os.ReadFile(filepath.Join(f.Dir, name))
+		if err == nil {
+			return string(b), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("fixture extractor: no fixture for image %x", sum[:8])
+}
@@ -0,0 +1,102 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// GeminiExtractor reads screenshots with a Gemini model constrained to the PositionsOut schema.
+type GeminiExtractor struct {
+	Model *genai.GenerativeModel
+}
+
+func NewGeminiExtractor(ctx context.Context, apiKey, modelID string) (*GeminiExtractor, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("gemini: GEMINI_API_KEY is empty")
+	}
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("genai.NewClient: %w", err)
+	}
+
+	model := client.GenerativeModel(modelID)
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(instruction)},
+	}
+	model.GenerationConfig = genai.GenerationConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"INSTRUMENTS": {
+					Type:  genai.TypeArray,
+					Items: &genai.Schema{Type: genai.TypeString},
+				},
+				"POSITION": {
+					Type:  genai.TypeArray,
+					Items: &genai.Schema{Type: genai.TypeNumber},
+				},
+				"AVG PRICE": {
+					Type:  genai.TypeArray,
+					Items: &genai.Schema{Type: genai.TypeNumber},
+				},
+				"CURRENCY": {
+					Type:  genai.TypeArray,
+					Items: &genai.Schema{Type: genai.TypeString},
+				},
+			},
+			Required: []string{"POSITION", "AVG PRICE"},
+		},
+	}
+	return &GeminiExtractor{Model: model}, nil
+}
+
+func (g *GeminiExtractor) Extract(ctx context.Context, image []byte) (string, error) {
+	format := extractImageFormat(http.DetectContentType(image))
+	resp, err := g.Model.GenerateContent(
+		ctx,
+		genai.ImageData(format, image),
+		genai.Text(userPrompt),
+	)
+	if err != nil {
+		return "", fmt.Errorf("GenerateContent: %w", err)
+	}
+	return extractText(resp), nil
+}
+
+// extractImageFormat converts MIME type to format string expected by Gemini AI
+func extractImageFormat(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	case "image/webp":
+		return "webp"
+	case "image/gif":
+		return "gif"
+	default:
+		// Default to jpeg for unknown types
+		return "jpeg"
+	}
+}
+
+func extractText(resp *genai.GenerateContentResponse) string {
+	var b strings.Builder
+	for _, c := range resp.Candidates {
+		if c.Content == nil {
+			continue
+		}
+		for _, p := range c.Content.Parts {
+			if t, ok := p.(genai.Text); ok {
+				b.WriteString(string(t))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,324 @@
+package portfolio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ColumnMapping names the header columns that hold each field in a generic CSV.
+// Empty fields fall back to common aliases ("Symbol", "Quantity", "Avg Price", ...).
+// CostBasis is a total cost column used when no per-share price column is present.
+// Currency is optional; rows without one are treated as USD.
+type ColumnMapping struct {
+	Ticker    string `json:"ticker"`
+	Quantity  string `json:"quantity"`
+	AvgPrice  string `json:"average_price"`
+	CostBasis string `json:"cost_basis"`
+	Currency  string `json:"currency"`
+}
+
+var (
+	tickerAliases    = []string{"symbol", "ticker", "instrument", "securitysymbol"}
+	quantityAliases  = []string{"quantity", "qty", "qtyquantity", "position", "shares", "units"}
+	avgPriceAliases  = []string{"averageprice", "avgprice", "averagecost", "avgcost", "averagecostbasis", "costprice", "costbasisprice", "costpershare", "pricepaid"}
+	costBasisAliases = []string{"costbasis", "costbasistotal", "totalcost", "costbasismoney"}
+	currencyAliases  = []string{"currency", "currencyprimary", "ccy"}
+)
+
+// csvImporter reads a single header + rows table. The broker-specific exports are
+// presets over the same engine with their own skip rules.
+type csvImporter struct {
+	format  string
+	mapping ColumnMapping
+	// skip reports rows that are not positions (cash sweeps, totals, disclaimers).
+	skip func(ticker string) bool
+}
+
+// NewCSVImporter returns a generic CSV importer using the given column mapping.
+func NewCSVImporter(mapping ColumnMapping) Importer {
+	return &csvImporter{format: FormatCSV, mapping: mapping}
+}
+
+// NewSchwabImporter parses Schwab "Positions" CSV exports. Schwab reports total cost basis,
+// so the average price is derived as cost basis / quantity.
+func NewSchwabImporter() Importer {
+	return &csvImporter{
+		format: FormatSchwab,
+		skip: func(t string) bool {
+			t = strings.ToLower(t)
+			return strings.HasPrefix(t, "cash") || strings.HasPrefix(t, "account total")
+		},
+	}
+}
+
+// NewFidelityImporter parses Fidelity "Portfolio Positions" CSV exports.
+// Money-market sweep symbols (suffixed "**") and pending activity rows are skipped.
+func NewFidelityImporter() Importer {
+	return &csvImporter{
+		format: FormatFidelity,
+		skip: func(t string) bool {
+			return strings.HasSuffix(t, "**") || strings.EqualFold(t, "Pending Activity")
+		},
+	}
+}
+
+func (i *csvImporter) Format() string { return i.format }
+
+func (i *csvImporter) Parse(r io.Reader) (*ImportResult, error) {
+	records, lines, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportResult{Format: i.format}
+	cols, hdr := -1, columns{}
+	for n, rec := range records {
+		if c, ok := matchHeader(rec, i.mapping); ok {
+			cols, hdr = n, c
+			break
+		}
+	}
+	if cols < 0 {
+		return nil, errors.New("no header row with ticker and quantity columns found")
+	}
+	rows := make([]ImportRow, 0, len(records)-cols)
+	for n := cols + 1; n < len(records); n++ {
+		rec, line := records[n], lines[n]
+		if isBlank(rec) {
+			continue
+		}
+		row, skip, issue := hdr.row(rec, line)
+		if skip || (i.skip != nil && i.skip(strings.TrimSpace(field(rec, hdr.ticker)))) {
+			continue
+		}
+		if issue != nil {
+			res.Issues = append(res.Issues, *issue)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return finalize(res, rows), nil
+}
+
+// columns holds resolved column indexes for one table; -1 marks an absent column.
+type columns struct {
+	ticker, quantity, avgPrice, costBasis, currency int
+}
+
+// matchHeader resolves the column indexes for rec if it looks like a header row.
+func matchHeader(rec []string, m ColumnMapping) (columns, bool) {
+	c := columns{
+		ticker:    findColumn(rec, m.Ticker, tickerAliases),
+		quantity:  findColumn(rec, m.Quantity, quantityAliases),
+		avgPrice:  findColumn(rec, m.AvgPrice, avgPriceAliases),
+		costBasis: findColumn(rec, m.CostBasis, costBasisAliases),
+		currency:  findColumn(rec, m.Currency, currencyAliases),
+	}
+	if c.ticker < 0 || c.quantity < 0 || (c.avgPrice < 0 && c.costBasis < 0) {
+		return c, false
+	}
+	return c, true
+}
+
+// row converts one data record. Rows too short to reach the ticker column (footers,
+// disclaimers) are skipped; rows with unreadable numbers produce an issue.
+func (c columns) row(rec []string, line int) (ImportRow, bool, *ImportIssue) {
+	if len(rec) <= c.ticker || len(rec) <= c.quantity {
+		return ImportRow{}, true, nil
+	}
+	ticker := strings.TrimSpace(rec[c.ticker])
+	if ticker == "" {
+		return ImportRow{}, true, nil
+	}
+	qty, ok := parseAmount(field(rec, c.quantity))
+	if !ok {
+		return ImportRow{}, false, &ImportIssue{Line: line, Field: "position", Severity: SeverityError, Message: fmt.Sprintf("%s: unreadable quantity %q", ticker, field(rec, c.quantity))}
+	}
+	row := ImportRow{Line: line, Ticker: ticker, Position: qty, Currency: strings.ToUpper(strings.TrimSpace(field(rec, c.currency)))}
+	if p, ok := parseAmount(field(rec, c.avgPrice)); ok {
+		row.AvgPrice = p
+	} else if cost, ok := parseAmount(field(rec, c.costBasis)); ok && qty != 0 {
+		row.AvgPrice = cost / qty
+	}
+	if row.AvgPrice < 0 {
+		row.AvgPrice = -row.AvgPrice
+	}
+	return row, false, nil
+}
+
+func findColumn(rec []string, explicit string, aliases []string) int {
+	if explicit != "" {
+		aliases = []string{normalizeHeader(explicit)}
+	}
+	for _, a := range aliases {
+		for i, h := range rec {
+			if normalizeHeader(h) == a {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// normalizeHeader lower-cases a header and strips everything but letters and digits,
+// so "Avg. Price", "AVG PRICE" and "AvgPrice" compare equal.
+func normalizeHeader(h string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(h) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func field(rec []string, i int) string {
+	if i < 0 || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
+
+func isBlank(rec []string) bool {
+	for _, f := range rec {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readCSV reads every record, tolerating ragged rows and stray quotes, and returns
+// the 1-based source line of each record for issue reporting.
+func readCSV(r io.Reader) ([][]string, []int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	var (
+		records [][]string
+		lines   []int
+	)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rec) > 0 {
+			rec[0] = strings.TrimPrefix(rec[0], "\ufeff")
+		}
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+	return records, lines, nil
+}
+
+// ibkrImporter handles both Interactive Brokers exports: the sectioned Activity
+// Statement ("Open Positions,Header,..." / "Open Positions,Data,...") and flat Flex
+// Query CSVs, which are parsed as a generic table.
+type ibkrImporter struct{}
+
+// NewIBKRImporter parses Interactive Brokers activity statements and Flex Query CSVs.
+func NewIBKRImporter() Importer { return ibkrImporter{} }
+
+func (ibkrImporter) Format() string { return FormatIBKR }
+
+func (ibkrImporter) Parse(r io.Reader) (*ImportResult, error) {
+	records, lines, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	sectioned := false
+	for _, rec := range records {
+		if len(rec) > 1 && rec[1] == "Header" {
+			sectioned = true
+			break
+		}
+	}
+	if !sectioned {
+		return flexTable(records, lines)
+	}
+
+	res := &ImportResult{Format: FormatIBKR}
+	var (
+		rows []ImportRow
+		hdr  columns
+		disc = -1 // DataDiscriminator column
+		kind = -1 // Asset Category column
+		ok   bool
+	)
+	for n, rec := range records {
+		if len(rec) < 3 || rec[0] != "Open Positions" {
+			continue
+		}
+		body := rec[2:]
+		switch rec[1] {
+		case "Header":
+			hdr, ok = matchHeader(body, ColumnMapping{})
+			disc = findColumn(body, "DataDiscriminator", nil)
+			kind = findColumn(body, "Asset Category", nil)
+		case "Data":
+			if !ok {
+				continue
+			}
+			// Positions are repeated per tax lot; only the summary line carries the total.
+			if d := field(body, disc); d != "" && !strings.EqualFold(d, "Summary") {
+				continue
+			}
+			if k := field(body, kind); k != "" && !strings.EqualFold(k, "Stocks") {
+				continue
+			}
+			row, skip, issue := hdr.row(body, lines[n])
+			if skip {
+				continue
+			}
+			if issue != nil {
+				res.Issues = append(res.Issues, *issue)
+				continue
+			}
+			rows = append(rows, row)
+		}
+	}
+	if !ok {
+		return nil, errors.New("no Open Positions section found")
+	}
+	return finalize(res, rows), nil
+}
+
+// flexTable parses Flex Query CSVs, which may repeat the header once per account.
+func flexTable(records [][]string, lines []int) (*ImportResult, error) {
+	res := &ImportResult{Format: FormatIBKR}
+	var (
+		rows []ImportRow
+		hdr  columns
+		ok   bool
+	)
+	for n, rec := range records {
+		if c, isHdr := matchHeader(rec, ColumnMapping{}); isHdr {
+			hdr, ok = c, true
+			continue
+		}
+		if !ok || isBlank(rec) {
+			continue
+		}
+		row, skip, issue := hdr.row(rec, lines[n])
+		if skip {
+			continue
+		}
+		if issue != nil {
+			res.Issues = append(res.Issues, *issue)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if !ok {
+		return nil, errors.New("no header row with Symbol and Position columns found")
+	}
+	return finalize(res, rows), nil
+}
@@ -0,0 +1,187 @@
+package portfolio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ofxImporter parses OFX/QFX investment statements, both the SGML (1.x) flavour with
+// unclosed leaf elements and the XML (2.x) flavour.
+//
+// Positions come from INVPOSLIST, tickers from SECLIST. OFX does not carry cost basis
+// for positions, so the average price is derived from BUY* transactions in INVTRANLIST
+// when present; otherwise the statement's market price is used and a warning is raised.
+type ofxImporter struct{}
+
+// NewOFXImporter parses OFX and Quicken QFX investment statements.
+func NewOFXImporter() Importer { return ofxImporter{} }
+
+func (ofxImporter) Format() string { return FormatOFX }
+
+func (ofxImporter) Parse(r io.Reader) (*ImportResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseOFX(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	tickers := make(map[string]string)
+	for _, sec := range root.findAll("SECINFO") {
+		if id, t := sec.path("SECID", "UNIQUEID"), sec.value("TICKER"); id != "" && t != "" {
+			tickers[id] = t
+		}
+	}
+
+	type cost struct{ units, total float64 }
+	costs := make(map[string]*cost)
+	for _, buy := range root.findAll("INVBUY") {
+		id := buy.path("SECID", "UNIQUEID")
+		units, ok1 := parseAmount(buy.value("UNITS"))
+		price, ok2 := parseAmount(buy.value("UNITPRICE"))
+		if id == "" || !ok1 || units <= 0 {
+			continue
+		}
+		total, ok := parseAmount(buy.value("TOTAL"))
+		if !ok {
+			if !ok2 {
+				continue
+			}
+			total = units * price
+		}
+		c := costs[id]
+		if c == nil {
+			c = &cost{}
+			costs[id] = c
+		}
+		c.units += units
+		if total < 0 {
+			total = -total
+		}
+		c.total += total
+	}
+
+	res := &ImportResult{Format: FormatOFX}
+	positions := root.findAll("INVPOS")
+	if len(positions) == 0 && root.find("INVPOSLIST") == nil {
+		return nil, errors.New("no INVPOSLIST found in statement")
+	}
+	// Amounts are in the statement's CURDEF unless a position carries its own CURRENCY aggregate.
+	curdef := strings.ToUpper(root.value("CURDEF"))
+	rows := make([]ImportRow, 0, len(positions))
+	for n, pos := range positions {
+		line := n + 1 // OFX has no meaningful lines; report the position's ordinal
+		id := pos.path("SECID", "UNIQUEID")
+		ticker := tickers[id]
+		if ticker == "" {
+			res.Issues = append(res.Issues, ImportIssue{Line: line, Field: "ticker", Severity: SeverityError, Message: fmt.Sprintf("no ticker in SECLIST for security %q", id)})
+			continue
+		}
+		units, ok := parseAmount(pos.value("UNITS"))
+		if !ok {
+			res.Issues = append(res.Issues, ImportIssue{Line: line, Field: "position", Severity: SeverityError, Message: fmt.Sprintf("%s: unreadable UNITS", ticker)})
+			continue
+		}
+		row := ImportRow{Line: line, Ticker: ticker, Position: units, Currency: curdef}
+		if cur := pos.path("CURRENCY", "CURSYM"); cur != "" {
+			row.Currency = strings.ToUpper(cur)
+		}
+		if c := costs[id]; c != nil && c.units > 0 {
+			row.AvgPrice = c.total / c.units
+		} else if p, ok := parseAmount(pos.value("UNITPRICE")); ok {
+			row.AvgPrice = p
+			res.Issues = append(res.Issues, ImportIssue{Line: line, Field: "average_price", Severity: SeverityWarning, Message: fmt.Sprintf("%s: no purchase history in statement; using market price as average price", ticker)})
+		}
+		rows = append(rows, row)
+	}
+	return finalize(res, rows), nil
+}
+
+// ofxNode is an element of the parsed OFX document. Leaf elements carry text;
+// aggregates carry children.
+type ofxNode struct {
+	name     string
+	text     string
+	children []*ofxNode
+}
+
+var ofxTag = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+// parseOFX builds an element tree from SGML or XML OFX. In SGML, leaf elements are
+// not closed, so any open tag followed by text is treated as a leaf; closing tags for
+// leaves (XML) are ignored, and closing an aggregate pops back to its opener.
+func parseOFX(s string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("not an OFX document")
+	}
+	root := &ofxNode{name: "#root"}
+	stack := []*ofxNode{root}
+	for _, m := range ofxTag.FindAllStringSubmatch(s[start:], -1) {
+		closing, name, text := m[1] == "/", strings.ToUpper(m[2]), strings.TrimSpace(m[3])
+		top := stack[len(stack)-1]
+		if closing {
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+		n := &ofxNode{name: name, text: text}
+		top.children = append(top.children, n)
+		if text == "" {
+			stack = append(stack, n)
+		}
+	}
+	return root, nil
+}
+
+func (n *ofxNode) find(name string) *ofxNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+		if f := c.find(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func (n *ofxNode) findAll(name string) []*ofxNode {
+	var out []*ofxNode
+	for _, c := range n.children {
+		if c.name == name {
+			out = append(out, c)
+			continue
+		}
+		out = append(out, c.findAll(name)...)
+	}
+	return out
+}
+
+// value returns the text of the first descendant leaf with the given name.
+func (n *ofxNode) value(name string) string {
+	if f := n.find(name); f != nil {
+		return f.text
+	}
+	return ""
+}
+
+// path returns the text of a leaf reached through the given chain of descendants.
+func (n *ofxNode) path(names ...string) string {
+	cur := n
+	for _, name := range names {
+		if cur = cur.find(name); cur == nil {
+			return ""
+		}
+	}
+	return cur.text
+}
@@ -0,0 +1,235 @@
+package portfolio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportRow is a single position parsed from a broker statement.
+type ImportRow struct {
+	Line     int     `json:"line"`
+	Ticker   string  `json:"ticker"`
+	Position float64 `json:"position"`
+	AvgPrice float64 `json:"average_price"`
+	// Currency is the ISO code prices are quoted in; empty means DefaultCurrency.
+	Currency string `json:"currency,omitempty"`
+}
+
+// ImportIssue describes a row or field that failed to parse or validate.
+// Rows with an error-level issue are left out of ImportResult.Rows.
+type ImportIssue struct {
+	Line     int    `json:"line"`
+	Field    string `json:"field,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ImportResult is the outcome of parsing a statement: valid rows plus any issues found.
+type ImportResult struct {
+	Format string        `json:"format"`
+	Rows   []ImportRow   `json:"rows"`
+	Issues []ImportIssue `json:"issues"`
+}
+
+// HasErrors reports whether any issue is error-level.
+func (r *ImportResult) HasErrors() bool {
+	for _, is := range r.Issues {
+		if is.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Importer parses a broker statement export into portfolio positions.
+type Importer interface {
+	Format() string
+	Parse(r io.Reader) (*ImportResult, error)
+}
+
+// Supported import formats.
+const (
+	FormatCSV      = "csv"
+	FormatIBKR     = "ibkr"
+	FormatSchwab   = "schwab"
+	FormatFidelity = "fidelity"
+	FormatOFX      = "ofx"
+	FormatQFX      = "qfx"
+	FormatAuto     = "auto"
+)
+
+// ImportFormats lists the formats accepted by NewImporter (besides "auto").
+var ImportFormats = []string{FormatCSV, FormatIBKR, FormatSchwab, FormatFidelity, FormatOFX, FormatQFX}
+
+// NewImporter returns the importer for format. The column mapping only applies to the generic CSV format.
+func NewImporter(format string, mapping ColumnMapping) (Importer, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case FormatCSV:
+		return NewCSVImporter(mapping), nil
+	case FormatIBKR:
+		return NewIBKRImporter(), nil
+	case FormatSchwab:
+		return NewSchwabImporter(), nil
+	case FormatFidelity:
+		return NewFidelityImporter(), nil
+	case FormatOFX, FormatQFX:
+		return NewOFXImporter(), nil
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// DetectFormat guesses the statement format from the first bytes of the file.
+// Falls back to generic CSV when nothing more specific matches.
+func DetectFormat(data []byte) string {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	upper := bytes.ToUpper(head)
+	switch {
+	case bytes.Contains(upper, []byte("OFXHEADER")) || bytes.Contains(upper, []byte("<OFX>")):
+		return FormatOFX
+	case bytes.HasPrefix(bytes.TrimLeft(head, "\ufeff\" "), []byte("Statement,")),
+		bytes.Contains(head, []byte("Open Positions,Header")),
+		bytes.Contains(upper, []byte("CLIENTACCOUNTID")):
+		return FormatIBKR
+	case bytes.Contains(upper, []byte("POSITIONS FOR ")) && bytes.Contains(upper, []byte("COST BASIS")):
+		return FormatSchwab
+	case bytes.Contains(upper, []byte("ACCOUNT NUMBER")) && bytes.Contains(upper, []byte("AVERAGE COST BASIS")):
+		return FormatFidelity
+	default:
+		return FormatCSV
+	}
+}
+
+var tickerPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-/]{0,11}$`)
+
+// normalizeTicker upper-cases a symbol and drops venue suffixes such as "AAPL NASDAQ".
+func normalizeTicker(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if i := strings.IndexAny(s, " \t"); i > 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// parseAmount parses broker-formatted numbers such as "$1,234.50", "(12.00)" or "1 200".
+// Returns ok=false for blanks and placeholders like "--" and "N/A".
+func parseAmount(s string) (float64, bool) {
+	v := strings.TrimSpace(s)
+	switch strings.ToUpper(v) {
+	case "", "--", "-", "N/A", "NA":
+		return 0, false
+	}
+	neg := false
+	if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+		neg = true
+		v = strings.TrimSuffix(strings.TrimPrefix(v, "("), ")")
+	}
+	v = strings.NewReplacer("$", "", ",", "", " ", "", "+", "").Replace(v)
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	if neg {
+		f = -f
+	}
+	return f, true
+}
+
+// finalize validates parsed rows, merges duplicate tickers (e.g. the same symbol held
+// in several accounts) into one row with a quantity-weighted average price, and sorts
+// the issues by line.
+func finalize(res *ImportResult, rows []ImportRow) *ImportResult {
+	merged := make(map[string]int, len(rows))
+	for _, r := range rows {
+		r.Ticker = normalizeTicker(r.Ticker)
+		if !tickerPattern.MatchString(r.Ticker) {
+			res.Issues = append(res.Issues, ImportIssue{Line: r.Line, Field: "ticker", Severity: SeverityError, Message: fmt.Sprintf("invalid ticker %q", r.Ticker)})
+			continue
+		}
+		if r.Position == 0 {
+			res.Issues = append(res.Issues, ImportIssue{Line: r.Line, Field: "position", Severity: SeverityError, Message: "position must be non-zero"})
+			continue
+		}
+		if r.AvgPrice <= 0 {
+			res.Issues = append(res.Issues, ImportIssue{Line: r.Line, Field: "average_price", Severity: SeverityError, Message: "average price must be positive"})
+			continue
+		}
+		cur, err := normalizeCurrency(r.Currency)
+		if err != nil {
+			res.Issues = append(res.Issues, ImportIssue{Line: r.Line, Field: "currency", Severity: SeverityError, Message: fmt.Sprintf("invalid currency %q", r.Currency)})
+			continue
+		}
+		r.Currency = cur
+		var issue *ImportIssue
+		if res.Rows, issue = mergeRow(res.Rows, merged, r); issue != nil {
+			res.Issues = append(res.Issues, *issue)
+		}
+	}
+	var mergeIssues []ImportIssue
+	res.Rows, mergeIssues = validateMerged(res.Rows)
+	res.Issues = append(res.Issues, mergeIssues...)
+	if res.Rows == nil {
+		res.Rows = []ImportRow{}
+	}
+	if res.Issues == nil {
+		res.Issues = []ImportIssue{}
+	}
+	sort.SliceStable(res.Issues, func(i, j int) bool { return res.Issues[i].Line < res.Issues[j].Line })
+	return res
+}
+
+// mergeRow appends r to rows, or folds it into the earlier row for the same ticker with a
+// quantity-weighted average price; index maps tickers to their place in rows. The same
+// ticker in two currencies cannot be merged and is returned as an issue instead.
+func mergeRow(rows []ImportRow, index map[string]int, r ImportRow) ([]ImportRow, *ImportIssue) {
+	i, ok := index[r.Ticker]
+	if !ok {
+		index[r.Ticker] = len(rows)
+		return append(rows, r), nil
+	}
+	prev := &rows[i]
+	if currencyOrDefault(prev.Currency) != currencyOrDefault(r.Currency) {
+		return rows, &ImportIssue{Line: r.Line, Field: "currency", Severity: SeverityError, Message: fmt.Sprintf("%s is listed in both %s and %s", r.Ticker, currencyOrDefault(prev.Currency), currencyOrDefault(r.Currency))}
+	}
+	qty := prev.Position + r.Position
+	if qty != 0 {
+		prev.AvgPrice = (prev.Position*prev.AvgPrice + r.Position*r.AvgPrice) / qty
+	}
+	prev.Position = qty
+	return rows, nil
+}
+
+// validateMerged checks rows again once duplicates were merged into them: opposite
+// quantities can cancel out or leave a non-positive average price. It returns the rows that
+// are still valid and an error issue for each one that is not. Rows that were not merged
+// passed validateRow already, so every issue comes from a merge.
+func validateMerged(rows []ImportRow) ([]ImportRow, []ImportIssue) {
+	valid := rows[:0]
+	var issues []ImportIssue
+	for _, r := range rows {
+		found := validateRow(r)
+		for i := range found {
+			found[i].Message += fmt.Sprintf(" once the rows for %s are merged", r.Ticker)
+		}
+		if len(found) > 0 {
+			issues = append(issues, found...)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid, issues
+}
@@ -0,0 +1,209 @@
+package portfolio
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenericCSVImporterAliasesAndMerge(t *testing.T) {
+	in := `Symbol,Qty,Avg. Price
+aapl,10,150.00
+MSFT,"1,000",$300.50
+AAPL,10,170
+TSLA NASDAQ.NMS,5,200
+B@D,5,1
+NVDA,--,100
+`
+	res, err := NewCSVImporter(ColumnMapping{}).Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 3)
+	assert.Equal(t, "AAPL", res.Rows[0].Ticker)
+	assert.Equal(t, 20.0, res.Rows[0].Position)
+	assert.InDelta(t, 160.0, res.Rows[0].AvgPrice, 1e-9)
+	assert.Equal(t, 1000.0, res.Rows[1].Position)
+	assert.Equal(t, 300.5, res.Rows[1].AvgPrice)
+	assert.Equal(t, "TSLA", res.Rows[2].Ticker)
+	require.Len(t, res.Issues, 2)
+	assert.Equal(t, 6, res.Issues[0].Line)
+	assert.Equal(t, "ticker", res.Issues[0].Field)
+	assert.Equal(t, 7, res.Issues[1].Line)
+	assert.Equal(t, "position", res.Issues[1].Field)
+	assert.True(t, res.HasErrors())
+}
+
+func TestMergedRowsAreRevalidated(t *testing.T) {
+	in := `Symbol,Qty,Avg. Price
+AAPL,10,100
+AAPL,-10,120
+MSFT,10,100
+MSFT,-5,200
+KO,5,60
+`
+	res, err := NewCSVImporter(ColumnMapping{}).Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1, "rows that merge into an invalid position are not kept")
+	assert.Equal(t, "KO", res.Rows[0].Ticker)
+	require.Len(t, res.Issues, 2)
+	assert.Equal(t, "position", res.Issues[0].Field)
+	assert.Equal(t, SeverityError, res.Issues[0].Severity)
+	assert.Contains(t, res.Issues[0].Message, "once the rows for AAPL are merged")
+	assert.Equal(t, "average_price", res.Issues[1].Field)
+	assert.True(t, res.HasErrors())
+}
+
+func TestGenericCSVImporterExplicitMapping(t *testing.T) {
+	in := "Code;Held;Paid\nKO;4;60\n"
+	_, err := NewCSVImporter(ColumnMapping{}).Parse(strings.NewReader(in))
+	assert.Error(t, err)
+
+	in = "Code,Held,Paid\nKO,4,240\n"
+	res, err := NewCSVImporter(ColumnMapping{Ticker: "code", Quantity: "held", CostBasis: "paid"}).Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, 60.0, res.Rows[0].AvgPrice)
+}
+
+func TestIBKRActivityStatement(t *testing.T) {
+	in := `Statement,Header,Field Name,Field Value
+Statement,Data,Title,Activity Statement
+Open Positions,Header,DataDiscriminator,Asset Category,Currency,Symbol,Quantity,Mult,Cost Price,Cost Basis,Close Price,Value,Unrealized P/L,Code
+Open Positions,Data,Summary,Stocks,USD,AAPL,10,1,150.25,1502.5,190,1900,397.5,
+Open Positions,Data,Lot,Stocks,USD,AAPL,10,1,150.25,1502.5,190,1900,397.5,
+Open Positions,Data,Summary,Options,USD,AAPL 240119C00200000,1,100,2.5,250,1,100,-150,
+Open Positions,Total,,Stocks,USD,,,,,1502.5,,1900,397.5,
+`
+	assert.Equal(t, FormatIBKR, DetectFormat([]byte(in)))
+	res, err := NewIBKRImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, ImportRow{Line: 4, Ticker: "AAPL", Position: 10, AvgPrice: 150.25, Currency: "USD"}, res.Rows[0])
+	assert.Empty(t, res.Issues)
+}
+
+func TestIBKRFlexQuery(t *testing.T) {
+	in := `"ClientAccountID","CurrencyPrimary","Symbol","Position","MarkPrice","CostBasisPrice"
+"U1","USD","VTI","12","250","200.5"
+"ClientAccountID","CurrencyPrimary","Symbol","Position","MarkPrice","CostBasisPrice"
+"U2","USD","VTI","8","250","210.5"
+`
+	res, err := NewIBKRImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, 20.0, res.Rows[0].Position)
+	assert.InDelta(t, 204.5, res.Rows[0].AvgPrice, 1e-9)
+}
+
+func TestSchwabPositions(t *testing.T) {
+	in := `"Positions for account Individual ...123 as of 09:30 PM ET, 2024/01/05","","",""
+
+"Symbol","Description","Qty (Quantity)","Price","Cost Basis","Security Type"
+"SCHD","SCHWAB US DIVIDEND EQUITY ETF","100","$75.00","$7,012.00","ETFs & Closed End Funds"
+"Cash & Cash Investments","--","--","--","--","Cash and Money Market"
+"Account Total","--","--","--","$7,012.00","--"
+`
+	assert.Equal(t, FormatSchwab, DetectFormat([]byte(in)))
+	res, err := NewSchwabImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, "SCHD", res.Rows[0].Ticker)
+	assert.InDelta(t, 70.12, res.Rows[0].AvgPrice, 1e-9)
+	assert.Empty(t, res.Issues)
+}
+
+func TestFidelityPositions(t *testing.T) {
+	in := `Account Number,Account Name,Symbol,Description,Quantity,Last Price,Current Value,Cost Basis Total,Average Cost Basis,Type
+X123,Individual,SPAXX**,HELD IN MONEY MARKET,,,$1200.00,,,Cash
+X123,Individual,FXAIX,FIDELITY 500 INDEX FUND,5.5,$180.00,$990.00,$880.00,$160.00,Cash
+X123,Individual,Pending Activity,,,,$-20.00,,,
+
+"The data and information in this spreadsheet is provided to you solely for your use."
+`
+	assert.Equal(t, FormatFidelity, DetectFormat([]byte(in)))
+	res, err := NewFidelityImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, ImportRow{Line: 3, Ticker: "FXAIX", Position: 5.5, AvgPrice: 160}, res.Rows[0])
+	assert.Empty(t, res.Issues)
+}
+
+func TestOFXSGMLStatement(t *testing.T) {
+	in := `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS>
+<INVTRANLIST>
+<BUYSTOCK><INVBUY><INVTRAN><FITID>1<DTTRADE>20240102</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>10<UNITPRICE>100<TOTAL>-1000</INVBUY><BUYTYPE>BUY</BUYSTOCK>
+<BUYSTOCK><INVBUY><INVTRAN><FITID>2<DTTRADE>20240202</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>10<UNITPRICE>120<TOTAL>-1200</INVBUY><BUYTYPE>BUY</BUYSTOCK>
+</INVTRANLIST>
+<INVPOSLIST>
+<POSSTOCK><INVPOS><SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<HELDINACCT>CASH<POSTYPE>LONG<UNITS>20<UNITPRICE>190<MKTVAL>3800<DTPRICEASOF>20240301</INVPOS></POSSTOCK>
+<POSSTOCK><INVPOS><SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<HELDINACCT>CASH<POSTYPE>LONG<UNITS>5<UNITPRICE>400<MKTVAL>2000<DTPRICEASOF>20240301</INVPOS></POSSTOCK>
+<POSSTOCK><INVPOS><SECID><UNIQUEID>999999999<UNIQUEIDTYPE>CUSIP</SECID>
+<HELDINACCT>CASH<POSTYPE>LONG<UNITS>1<UNITPRICE>1<MKTVAL>1<DTPRICEASOF>20240301</INVPOS></POSSTOCK>
+</INVPOSLIST>
+</INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1><SECLIST>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID><SECNAME>Apple Inc<TICKER>AAPL</SECINFO></STOCKINFO>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID><SECNAME>Microsoft<TICKER>MSFT</SECINFO></STOCKINFO>
+</SECLIST></SECLISTMSGSRSV1>
+</OFX>
+`
+	assert.Equal(t, FormatOFX, DetectFormat([]byte(in)))
+	res, err := NewOFXImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 2)
+	assert.Equal(t, "AAPL", res.Rows[0].Ticker)
+	assert.Equal(t, 20.0, res.Rows[0].Position)
+	assert.InDelta(t, 110.0, res.Rows[0].AvgPrice, 1e-9)
+	assert.Equal(t, "MSFT", res.Rows[1].Ticker)
+	assert.Equal(t, 400.0, res.Rows[1].AvgPrice)
+	require.Len(t, res.Issues, 2)
+	assert.Equal(t, SeverityWarning, res.Issues[0].Severity)
+	assert.Equal(t, SeverityError, res.Issues[1].Severity)
+}
+
+func TestOFXXMLStatement(t *testing.T) {
+	in := `<?xml version="1.0"?><?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS><INVPOSLIST>
+<POSMF><INVPOS><SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+<UNITS>3.5</UNITS><UNITPRICE>250.00</UNITPRICE></INVPOS></POSMF>
+</INVPOSLIST></INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1><SECLIST><MFINFO><SECINFO><SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+<SECNAME>Vanguard Total Stock Market</SECNAME><TICKER>VTI</TICKER></SECINFO></MFINFO></SECLIST></SECLISTMSGSRSV1></OFX>`
+	res, err := NewOFXImporter().Parse(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, ImportRow{Line: 1, Ticker: "VTI", Position: 3.5, AvgPrice: 250}, res.Rows[0])
+}
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"$1,234.50", 1234.5, true},
+		{"(12.00)", -12, true},
+		{"+3", 3, true},
+		{"--", 0, false},
+		{"N/A", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseAmount(c.in)
+		assert.Equal(t, c.ok, ok, c.in)
+		assert.Equal(t, c.want, got, c.in)
+	}
+}
@@ -0,0 +1,305 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Import sources, statuses and commit modes.
+const (
+	SourceScreenshot = "screenshot"
+
+	ImportPending   = "pending"
+	ImportCommitted = "committed"
+
+	// ModeMerge upserts the imported tickers and keeps other holdings untouched.
+	ModeMerge = "merge"
+	// ModeReplace removes holdings that are not part of the import.
+	ModeReplace = "replace"
+)
+
+var (
+	ErrImportNotFound   = errors.New("import not found")
+	ErrImportNotPending = errors.New("import already committed")
+	ErrInvalidMode      = errors.New("mode must be merge or replace")
+
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// InvalidRowsError is returned by CommitImport when the rows to commit fail validation.
+type InvalidRowsError struct {
+	Issues []ImportIssue
+}
+
+func (e *InvalidRowsError) Error() string {
+	return fmt.Sprintf("%d rows failed validation", len(e.Issues))
+}
+
+// PendingRow is an extracted row awaiting review. Confidence is a 0..1 heuristic derived
+// from validation (the model does not report its own certainty); rows below 1 deserve a look.
+type PendingRow struct {
+	ImportRow
+	Confidence float64 `json:"confidence"`
+}
+
+// PendingImport is an extraction result stored for review before it is committed.
+type PendingImport struct {
+	ID          string        `json:"id"`
+	Source      string        `json:"source"`
+	Status      string        `json:"status"`
+	RawOutput   string        `json:"raw_output"`
+	Rows        []PendingRow  `json:"rows"`
+	Issues      []ImportIssue `json:"issues"`
+	Mode        *string       `json:"mode,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	CommittedAt *time.Time    `json:"committed_at,omitempty"`
+}
+
+// reviewRows aligns the model's parallel arrays into rows without ever indexing past the
+// shortest one. Every row is kept so the user can fix it; problems become issues and
+// lower the row's confidence.
+func reviewRows(out *PositionsOut) ([]PendingRow, []ImportIssue) {
+	n := max(len(out.Instruments), len(out.Position), len(out.AvgPrice))
+	misaligned := len(out.Instruments) != n || len(out.Position) != n || len(out.AvgPrice) != n
+
+	rows := make([]PendingRow, 0, n)
+	issues := []ImportIssue{}
+	if misaligned {
+		issues = append(issues, ImportIssue{Severity: SeverityWarning, Message: fmt.Sprintf(
+			"model returned %d instruments, %d positions and %d prices; rows may be misaligned",
+			len(out.Instruments), len(out.Position), len(out.AvgPrice))})
+	}
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		line := i + 1
+		row := PendingRow{ImportRow: ImportRow{Line: line}, Confidence: 1}
+		if misaligned {
+			row.Confidence *= 0.6
+		}
+		if i < len(out.Instruments) {
+			row.Ticker = normalizeTicker(out.Instruments[i])
+		}
+		if i < len(out.Position) {
+			row.Position = out.Position[i]
+		}
+		if i < len(out.AvgPrice) {
+			row.AvgPrice = out.AvgPrice[i]
+		}
+		// CURRENCY is optional, so a short or missing array does not count as misaligned.
+		if i < len(out.Currency) {
+			row.Currency = strings.ToUpper(strings.TrimSpace(out.Currency[i]))
+		}
+		for _, is := range validateRow(row.ImportRow) {
+			issues = append(issues, is)
+			row.Confidence = 0
+		}
+		if row.Confidence > 0 && seen[row.Ticker] {
+			issues = append(issues, ImportIssue{Line: line, Field: "ticker", Severity: SeverityWarning, Message: fmt.Sprintf("%s appears more than once; the rows are merged on commit", row.Ticker)})
+			row.Confidence *= 0.7
+		}
+		seen[row.Ticker] = true
+		rows = append(rows, row)
+	}
+	return rows, issues
+}
+
+// validateRow applies the same checks as statement imports to a single row.
+func validateRow(r ImportRow) []ImportIssue {
+	var out []ImportIssue
+	if !tickerPattern.MatchString(r.Ticker) {
+		out = append(out, ImportIssue{Line: r.Line, Field: "ticker", Severity: SeverityError, Message: fmt.Sprintf("invalid ticker %q", r.Ticker)})
+	}
+	if r.Position == 0 {
+		out = append(out, ImportIssue{Line: r.Line, Field: "position", Severity: SeverityError, Message: "position must be non-zero"})
+	}
+	if r.AvgPrice <= 0 {
+		out = append(out, ImportIssue{Line: r.Line, Field: "average_price", Severity: SeverityError, Message: "average price must be positive"})
+	}
+	if _, err := normalizeCurrency(r.Currency); err != nil {
+		out = append(out, ImportIssue{Line: r.Line, Field: "currency", Severity: SeverityError, Message: fmt.Sprintf("invalid currency %q", r.Currency)})
+	}
+	return out
+}
+
+func (s *Service) createImport(ctx context.Context, userID, source, raw string, rows []PendingRow, issues []ImportIssue) (*PendingImport, error) {
+	rowsJSON, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	issuesJSON, err := json.Marshal(issues)
+	if err != nil {
+		return nil, err
+	}
+	imp := &PendingImport{Source: source, Status: ImportPending, RawOutput: raw, Rows: rows, Issues: issues}
+	err = s.DB.QueryRow(ctx, `
+INSERT INTO portfolio_imports (user_id, source, status, raw_output, rows, issues)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at
+`, userID, source, ImportPending, raw, rowsJSON, issuesJSON).Scan(&imp.ID, &imp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// GetImport loads an import owned by userID. An id that is not a UUID is reported as
+// ErrImportNotFound without reaching the database.
+func (s *Service) GetImport(ctx context.Context, userID, id string) (*PendingImport, error) {
+	if !uuidPattern.MatchString(id) {
+		return nil, ErrImportNotFound
+	}
+	var (
+		imp                  PendingImport
+		rowsJSON, issuesJSON []byte
+	)
+	err := s.DB.QueryRow(ctx, `
+SELECT id, source, status, COALESCE(raw_output, ''), rows, issues, mode, created_at, committed_at
+FROM portfolio_imports WHERE id = $1 AND user_id = $2
+`, id, userID).Scan(&imp.ID, &imp.Source, &imp.Status, &imp.RawOutput, &rowsJSON, &issuesJSON, &imp.Mode, &imp.CreatedAt, &imp.CommittedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rowsJSON, &imp.Rows); err != nil {
+		return nil, fmt.Errorf("decode rows: %w", err)
+	}
+	if err := json.Unmarshal(issuesJSON, &imp.Issues); err != nil {
+		return nil, fmt.Errorf("decode issues: %w", err)
+	}
+	return &imp, nil
+}
+
+// CommitImport writes a pending import to the portfolio. When edits is non-nil it replaces
+// the extracted rows entirely (the client sends back the reviewed table). All rows must
+// pass validation; otherwise an *InvalidRowsError is returned and nothing is written.
+// Rows for the same ticker are merged like in statement imports; the same ticker in two
+// currencies is a validation error.
+func (s *Service) CommitImport(ctx context.Context, userID, id string, edits []ImportRow, mode string) (*PendingImport, error) {
+	if mode == "" {
+		mode = ModeMerge
+	}
+	if mode != ModeMerge && mode != ModeReplace {
+		return nil, ErrInvalidMode
+	}
+	imp, err := s.GetImport(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if imp.Status != ImportPending {
+		return nil, ErrImportNotPending
+	}
+
+	rows := edits
+	if rows == nil {
+		rows = make([]ImportRow, 0, len(imp.Rows))
+		for _, r := range imp.Rows {
+			rows = append(rows, r.ImportRow)
+		}
+	}
+	var issues []ImportIssue
+	for i := range rows {
+		rows[i].Ticker = normalizeTicker(rows[i].Ticker)
+		if rows[i].Line == 0 {
+			rows[i].Line = i + 1
+		}
+		issues = append(issues, validateRow(rows[i])...)
+	}
+	if len(issues) > 0 {
+		return nil, &InvalidRowsError{Issues: issues}
+	}
+	merged := make([]ImportRow, 0, len(rows))
+	index := make(map[string]int, len(rows))
+	for _, r := range rows {
+		r.Currency, _ = normalizeCurrency(r.Currency) // validated above
+		var issue *ImportIssue
+		if merged, issue = mergeRow(merged, index, r); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	if len(issues) > 0 {
+		return nil, &InvalidRowsError{Issues: issues}
+	}
+	if _, issues = validateMerged(merged); len(issues) > 0 {
+		return nil, &InvalidRowsError{Issues: issues}
+	}
+	rows = merged
+
+	final := make([]PendingRow, 0, len(rows))
+	for _, r := range rows {
+		final = append(final, PendingRow{ImportRow: r, Confidence: 1})
+	}
+	rowsJSON, err := json.Marshal(final)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := writePositions(ctx, tx, userID, rows, mode); err != nil {
+		return nil, err
+	}
+	// Guard on status so two concurrent commits cannot both apply.
+	tag, err := tx.Exec(ctx, `
+UPDATE portfolio_imports SET status = $1, mode = $2, rows = $3, committed_at = now()
+WHERE id = $4 AND user_id = $5 AND status = $6
+`, ImportCommitted, mode, rowsJSON, id, userID, ImportPending)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrImportNotPending
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	imp.Status = ImportCommitted
+	imp.Mode = &mode
+	imp.Rows = final
+	imp.CommittedAt = &now
+	return imp, nil
+}
+
+// writePositions upserts rows inside tx. In replace mode the user's existing holdings
+// are removed first so the portfolio ends up matching the rows exactly.
+func writePositions(ctx context.Context, tx pgx.Tx, userID string, rows []ImportRow, mode string) error {
+	switch mode {
+	case "", ModeMerge:
+	case ModeReplace:
+		if _, err := tx.Exec(ctx, `DELETE FROM portfolio WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidMode
+	}
+
+	q := `
+	INSERT INTO portfolio (user_id, ticker, position, average_price, currency)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, ticker) DO UPDATE SET
+		position = EXCLUDED.position,
+		average_price = EXCLUDED.average_price,
+		currency = EXCLUDED.currency,
+		updated_at = now()
+	`
+	for _, r := range rows {
+		if _, err := tx.Exec(ctx, q, userID, r.Ticker, r.Position, r.AvgPrice, currencyOrDefault(r.Currency)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
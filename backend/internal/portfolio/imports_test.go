@@ -0,0 +1,178 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const importID = "3b0f5c2e-8d7a-4e1b-9c6f-2a4d8e0b7c15"
+
+func TestReviewRowsMismatchedArrays(t *testing.T) {
+	out := &PositionsOut{
+		Instruments: []string{"AAPL NASDAQ.NMS", "MSFT", "NVDA"},
+		Position:    []float64{10, 5},
+		AvgPrice:    []float64{150, 300, 400},
+	}
+	rows, issues := reviewRows(out)
+	require.Len(t, rows, 3)
+	assert.Equal(t, "AAPL", rows[0].Ticker)
+	assert.InDelta(t, 0.6, rows[0].Confidence, 1e-9)
+	assert.Equal(t, 0.0, rows[2].Position)
+	assert.Equal(t, 0.0, rows[2].Confidence)
+	// one alignment warning plus the missing position on row 3
+	require.Len(t, issues, 2)
+	assert.Equal(t, SeverityWarning, issues[0].Severity)
+	assert.Equal(t, 3, issues[1].Line)
+	assert.Equal(t, "position", issues[1].Field)
+}
+
+func TestReviewRowsDuplicates(t *testing.T) {
+	out := &PositionsOut{
+		Instruments: []string{"KO", "KO"},
+		Position:    []float64{1, 2},
+		AvgPrice:    []float64{60, 61},
+	}
+	rows, issues := reviewRows(out)
+	assert.Equal(t, 1.0, rows[0].Confidence)
+	assert.InDelta(t, 0.7, rows[1].Confidence, 1e-9)
+	require.Len(t, issues, 1)
+	assert.Equal(t, SeverityWarning, issues[0].Severity)
+}
+
+func TestCommitImportReplaceWithEdits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	stored, _ := json.Marshal([]PendingRow{{ImportRow: ImportRow{Line: 1, Ticker: "AAPL", Position: 1, AvgPrice: 0}}})
+	mode := (*string)(nil)
+	mock.ExpectQuery(`SELECT id, source, status`).
+		WithArgs(importID, "user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "source", "status", "raw_output", "rows", "issues", "mode", "created_at", "committed_at"}).
+			AddRow(importID, SourceScreenshot, ImportPending, "{}", stored, []byte(`[]`), mode, time.Now(), (*time.Time)(nil)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM portfolio WHERE user_id`).WithArgs("user-1").WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mock.ExpectExec(`INSERT INTO portfolio`).WithArgs("user-1", "AAPL", 1.0, 150.0, "USD").WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`UPDATE portfolio_imports SET status`).
+		WithArgs(ImportCommitted, ModeReplace, pgxmock.AnyArg(), importID, "user-1", ImportPending).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	imp, err := svc.CommitImport(context.Background(), "user-1", importID, []ImportRow{{Ticker: "aapl", Position: 1, AvgPrice: 150}}, ModeReplace)
+	require.NoError(t, err)
+	assert.Equal(t, ImportCommitted, imp.Status)
+	assert.Equal(t, "AAPL", imp.Rows[0].Ticker)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCommitImportRejectsInvalidRows(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	stored, _ := json.Marshal([]PendingRow{{ImportRow: ImportRow{Line: 1, Ticker: "AAPL", Position: 1, AvgPrice: 0}}})
+	mock.ExpectQuery(`SELECT id, source, status`).
+		WithArgs(importID, "user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "source", "status", "raw_output", "rows", "issues", "mode", "created_at", "committed_at"}).
+			AddRow(importID, SourceScreenshot, ImportPending, "{}", stored, []byte(`[]`), (*string)(nil), time.Now(), (*time.Time)(nil)))
+
+	_, err = svc.CommitImport(context.Background(), "user-1", importID, nil, "")
+	var invalid *InvalidRowsError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "average_price", invalid.Issues[0].Field)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetImportRejectsNonUUID(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	_, err = svc.GetImport(context.Background(), "user-1", "not-a-uuid")
+	assert.ErrorIs(t, err, ErrImportNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCommitImportMergesDuplicateTickers(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	stored, _ := json.Marshal([]PendingRow{
+		{ImportRow: ImportRow{Line: 1, Ticker: "KO", Position: 10, AvgPrice: 60}},
+		{ImportRow: ImportRow{Line: 2, Ticker: "KO", Position: 30, AvgPrice: 64}},
+	})
+	mock.ExpectQuery(`SELECT id, source, status`).
+		WithArgs(importID, "user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "source", "status", "raw_output", "rows", "issues", "mode", "created_at", "committed_at"}).
+			AddRow(importID, SourceScreenshot, ImportPending, "{}", stored, []byte(`[]`), (*string)(nil), time.Now(), (*time.Time)(nil)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO portfolio`).WithArgs("user-1", "KO", 40.0, 63.0, "USD").WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`UPDATE portfolio_imports SET status`).
+		WithArgs(ImportCommitted, ModeMerge, pgxmock.AnyArg(), importID, "user-1", ImportPending).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	imp, err := svc.CommitImport(context.Background(), "user-1", importID, nil, "")
+	require.NoError(t, err)
+	require.Len(t, imp.Rows, 1)
+	assert.Equal(t, 40.0, imp.Rows[0].Position)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCommitImportRejectsDuplicateTickerInTwoCurrencies(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	mock.ExpectQuery(`SELECT id, source, status`).
+		WithArgs(importID, "user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "source", "status", "raw_output", "rows", "issues", "mode", "created_at", "committed_at"}).
+			AddRow(importID, SourceScreenshot, ImportPending, "{}", []byte(`[]`), []byte(`[]`), (*string)(nil), time.Now(), (*time.Time)(nil)))
+
+	_, err = svc.CommitImport(context.Background(), "user-1", importID, []ImportRow{
+		{Ticker: "SHEL", Position: 5, AvgPrice: 30, Currency: "gbp"},
+		{Ticker: "SHEL", Position: 2, AvgPrice: 60},
+	}, "")
+	var invalid *InvalidRowsError
+	require.ErrorAs(t, err, &invalid)
+	require.Len(t, invalid.Issues, 1)
+	assert.Equal(t, 2, invalid.Issues[0].Line)
+	assert.Equal(t, "currency", invalid.Issues[0].Field)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCommitImportRejectsRowsThatMergeToNothing(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	mock.ExpectQuery(`SELECT id, source, status`).
+		WithArgs(importID, "user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "source", "status", "raw_output", "rows", "issues", "mode", "created_at", "committed_at"}).
+			AddRow(importID, SourceScreenshot, ImportPending, "{}", []byte(`[]`), []byte(`[]`), (*string)(nil), time.Now(), (*time.Time)(nil)))
+
+	_, err = svc.CommitImport(context.Background(), "user-1", importID, []ImportRow{
+		{Ticker: "KO", Position: 10, AvgPrice: 100},
+		{Ticker: "KO", Position: -5, AvgPrice: 200},
+	}, "")
+	var invalid *InvalidRowsError
+	require.ErrorAs(t, err, &invalid)
+	require.Len(t, invalid.Issues, 1)
+	assert.Equal(t, "average_price", invalid.Issues[0].Field)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is written")
+}
@@ -0,0 +1,114 @@
+package portfolio
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIExtractor talks to any OpenAI-compatible chat completions endpoint that accepts
+// image input (OpenAI itself, or a local server such as Ollama, vLLM or LM Studio).
+type OpenAIExtractor struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	HTTP    *http.Client
+}
+
+// NewOpenAIExtractor builds an extractor for baseURL (e.g. http://localhost:11434/v1).
+// apiKey may be empty for local servers that do not check it.
+func NewOpenAIExtractor(baseURL, apiKey, model string) (*OpenAIExtractor, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("openai: OPENAI_BASE_URL is empty")
+	}
+	if model == "" {
+		return nil, fmt.Errorf("openai: OPENAI_MODEL is empty")
+	}
+	return &OpenAIExtractor{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Model:   model,
+		HTTP:    &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type chatPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatRequest struct {
+	Model          string            `json:"model"`
+	Messages       []chatMessage     `json:"messages"`
+	Temperature    float64           `json:"temperature"`
+	ResponseFormat map[string]string `json:"response_format,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (o *OpenAIExtractor) Extract(ctx context.Context, image []byte) (string, error) {
+	dataURI := "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+	body, err := json.Marshal(chatRequest{
+		Model: o.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: instruction},
+			{Role: "user", Content: []chatPart{
+				{Type: "text", Text: userPrompt},
+				{Type: "image_url", ImageURL: &chatImageURL{URL: dataURI}},
+			}},
+		},
+		ResponseFormat: map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	resp, err := o.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("chat completions: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("chat completions: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode chat completion: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return "", fmt.Errorf("chat completions: no choices returned")
+	}
+	return out.Choices[0].Message.Content, nil
+}
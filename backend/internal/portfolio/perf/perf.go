@@ -0,0 +1,207 @@
+// Package perf computes portfolio performance statistics from a daily value series and the
+// external cash flows that moved money in or out of it. Everything here is pure arithmetic
+// so results are deterministic and easy to test; loading data is the caller's job.
+package perf
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// TradingDaysPerYear is used to annualize daily statistics.
+const TradingDaysPerYear = 252
+
+// Point is the portfolio (or benchmark) value at the close of Date.
+type Point struct {
+	Date  time.Time `json:"date"`
+	Value float64   `json:"value"`
+}
+
+// Flow is an external cash flow into (positive) or out of (negative) the portfolio on Date.
+// A purchase funded from outside the tracked holdings is an inflow; a sale is an outflow.
+type Flow struct {
+	Date   time.Time `json:"date"`
+	Amount float64   `json:"amount"`
+}
+
+// Metrics summarizes a value series over a period.
+type Metrics struct {
+	// TimeWeighted is the chain-linked return over the whole period (0.1 = +10%).
+	TimeWeighted float64 `json:"time_weighted_return"`
+	// Annualized is TimeWeighted scaled to a 365-day year.
+	Annualized float64 `json:"annualized_return"`
+	// Volatility is the annualized standard deviation of daily returns.
+	Volatility float64 `json:"volatility"`
+	// MaxDrawdown is the largest peak-to-trough decline of the flow-adjusted index, as a
+	// positive fraction.
+	MaxDrawdown float64 `json:"max_drawdown"`
+	// Sharpe is (annualized return - risk free) / volatility; nil when volatility is zero.
+	Sharpe *float64 `json:"sharpe"`
+	Days   int      `json:"days"`
+}
+
+// DailyReturns returns one return per consecutive pair of points. Flows dated on a point are
+// assumed to happen at that day's close, so they are removed from the ending value:
+// r = (V_t - F_t) / V_{t-1} - 1. Periods that start from a zero value yield 0.
+func DailyReturns(values []Point, flows []Flow) []float64 {
+	if len(values) < 2 {
+		return nil
+	}
+	byDay := flowsByDay(flows)
+	out := make([]float64, 0, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		prev := values[i-1].Value
+		if prev == 0 {
+			out = append(out, 0)
+			continue
+		}
+		f := byDay[dayKey(values[i].Date)]
+		out = append(out, (values[i].Value-f)/prev-1)
+	}
+	return out
+}
+
+// TWR chain-links daily returns into a time-weighted return for the whole series.
+func TWR(values []Point, flows []Flow) float64 {
+	return Compound(DailyReturns(values, flows))
+}
+
+// Compound links periodic returns: prod(1 + r) - 1.
+func Compound(returns []float64) float64 {
+	g := 1.0
+	for _, r := range returns {
+		g *= 1 + r
+	}
+	return g - 1
+}
+
+// Index turns periodic returns into a growth index starting at 1. It is the flow-free
+// series used for drawdown.
+func Index(returns []float64) []float64 {
+	out := make([]float64, len(returns)+1)
+	out[0] = 1
+	for i, r := range returns {
+		out[i+1] = out[i] * (1 + r)
+	}
+	return out
+}
+
+// Annualize converts a total return earned over days calendar days into a yearly rate.
+// Periods shorter than a day return the total unchanged.
+func Annualize(total float64, days int) float64 {
+	if days < 1 || total <= -1 {
+		return total
+	}
+	return math.Pow(1+total, 365.0/float64(days)) - 1
+}
+
+// Volatility is the sample standard deviation of returns scaled by sqrt(periodsPerYear).
+func Volatility(returns []float64, periodsPerYear int) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+	return StdDev(returns) * math.Sqrt(float64(periodsPerYear))
+}
+
+// Mean returns the arithmetic mean of xs.
+func Mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var s float64
+	for _, x := range xs {
+		s += x
+	}
+	return s / float64(len(xs))
+}
+
+// StdDev returns the sample (n-1) standard deviation of xs.
+func StdDev(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := Mean(xs)
+	var ss float64
+	for _, x := range xs {
+		ss += (x - m) * (x - m)
+	}
+	return math.Sqrt(ss / float64(len(xs)-1))
+}
+
+// MaxDrawdown returns the largest peak-to-trough decline in series as a positive fraction,
+// together with the indexes of the peak and the trough.
+func MaxDrawdown(series []float64) (dd float64, peak, trough int) {
+	hi, hiAt := math.Inf(-1), 0
+	for i, v := range series {
+		if v > hi {
+			hi, hiAt = v, i
+		}
+		if hi > 0 {
+			if d := (hi - v) / hi; d > dd {
+				dd, peak, trough = d, hiAt, i
+			}
+		}
+	}
+	return dd, peak, trough
+}
+
+// Sharpe returns the annualized Sharpe ratio for an annual return and volatility, or nil
+// when volatility is zero. riskFree is an annual rate (0.04 = 4%).
+func Sharpe(annualReturn, volatility, riskFree float64) *float64 {
+	if volatility == 0 {
+		return nil
+	}
+	s := (annualReturn - riskFree) / volatility
+	return &s
+}
+
+// Summarize computes the standard metrics for a value series and its external flows.
+func Summarize(values []Point, flows []Flow, riskFree float64) Metrics {
+	returns := DailyReturns(values, flows)
+	m := Metrics{TimeWeighted: Compound(returns)}
+	if len(values) > 0 {
+		m.Days = int(values[len(values)-1].Date.Sub(values[0].Date).Hours() / 24)
+	}
+	m.Annualized = Annualize(m.TimeWeighted, m.Days)
+	m.Volatility = Volatility(returns, TradingDaysPerYear)
+	m.MaxDrawdown, _, _ = MaxDrawdown(Index(returns))
+	m.Sharpe = Sharpe(m.Annualized, m.Volatility, riskFree)
+	return m
+}
+
+// Beta returns cov(a, b) / var(b) over the common prefix of two return series, or nil when
+// the benchmark has no variance.
+func Beta(a, b []float64) *float64 {
+	n := min(len(a), len(b))
+	if n < 2 {
+		return nil
+	}
+	a, b = a[:n], b[:n]
+	ma, mb := Mean(a), Mean(b)
+	var cov, vb float64
+	for i := 0; i < n; i++ {
+		cov += (a[i] - ma) * (b[i] - mb)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+	if vb == 0 {
+		return nil
+	}
+	beta := cov / vb
+	return &beta
+}
+
+func dayKey(t time.Time) string { return t.UTC().Format("2006-01-02") }
+
+func flowsByDay(flows []Flow) map[string]float64 {
+	m := make(map[string]float64, len(flows))
+	for _, f := range flows {
+		m[dayKey(f.Date)] += f.Amount
+	}
+	return m
+}
+
+// SortFlows orders flows by date, keeping same-day flows in input order.
+func SortFlows(flows []Flow) {
+	sort.SliceStable(flows, func(i, j int) bool { return flows[i].Date.Before(flows[j].Date) })
+}
@@ -0,0 +1,145 @@
+package perf
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTWRRemovesFlows(t *testing.T) {
+	values := []Point{
+		{day("2024-01-02"), 100},
+		{day("2024-01-03"), 110},
+		{day("2024-01-04"), 220}, // 100 added at the close
+		{day("2024-01-05"), 198},
+	}
+	flows := []Flow{{day("2024-01-04"), 100}}
+
+	r := DailyReturns(values, flows)
+	require.Len(t, r, 3)
+	assert.InDelta(t, 0.1, r[0], 1e-12)
+	assert.InDelta(t, 120.0/110-1, r[1], 1e-12)
+	assert.InDelta(t, -0.1, r[2], 1e-12)
+	assert.InDelta(t, 0.08, TWR(values, flows), 1e-12)
+}
+
+func TestDailyReturnsFromZero(t *testing.T) {
+	values := []Point{{day("2024-01-02"), 0}, {day("2024-01-03"), 50}, {day("2024-01-04"), 55}}
+	flows := []Flow{{day("2024-01-03"), 50}}
+	assert.InDelta(t, 0.1, TWR(values, flows), 1e-12)
+}
+
+func TestAnnualize(t *testing.T) {
+	assert.InDelta(t, 0.1, Annualize(0.21, 730), 1e-12)
+	assert.Equal(t, 0.05, Annualize(0.05, 0))
+}
+
+func TestVolatilityAndSharpe(t *testing.T) {
+	vol := Volatility([]float64{0.01, -0.01}, TradingDaysPerYear)
+	assert.InDelta(t, math.Sqrt(0.0002)*math.Sqrt(252), vol, 1e-12)
+	assert.Equal(t, 0.0, Volatility([]float64{0.01}, TradingDaysPerYear))
+
+	s := Sharpe(0.12, 0.2, 0.02)
+	require.NotNil(t, s)
+	assert.InDelta(t, 0.5, *s, 1e-12)
+	assert.Nil(t, Sharpe(0.12, 0, 0.02))
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	dd, peak, trough := MaxDrawdown([]float64{1, 1.2, 0.9, 1.1, 0.6, 1.3})
+	assert.InDelta(t, 0.5, dd, 1e-12)
+	assert.Equal(t, 1, peak)
+	assert.Equal(t, 4, trough)
+
+	dd, _, _ = MaxDrawdown([]float64{1, 2, 3})
+	assert.Equal(t, 0.0, dd)
+}
+
+func TestBeta(t *testing.T) {
+	bench := []float64{0.01, -0.02, 0.015, 0.0}
+	port := []float64{0.02, -0.04, 0.03, 0.0}
+	b := Beta(port, bench)
+	require.NotNil(t, b)
+	assert.InDelta(t, 2.0, *b, 1e-12)
+	assert.Nil(t, Beta(port, []float64{0, 0, 0, 0}))
+}
+
+func TestSummarize(t *testing.T) {
+	values := []Point{
+		{day("2024-01-01"), 100},
+		{day("2024-07-01"), 90},
+		{day("2025-01-01"), 121},
+	}
+	m := Summarize(values, nil, 0)
+	assert.InDelta(t, 0.21, m.TimeWeighted, 1e-12)
+	assert.Equal(t, 366, m.Days)
+	assert.InDelta(t, 0.1, m.MaxDrawdown, 1e-12)
+	assert.NotNil(t, m.Sharpe)
+}
+
+func TestXIRR(t *testing.T) {
+	r, err := XIRR([]Flow{{day("2021-01-01"), -1000}, {day("2022-01-01"), 1100}})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.1, r, 1e-9)
+
+	// Reference example from the spreadsheet XIRR documentation.
+	r, err = XIRR([]Flow{
+		{day("2008-01-01"), -10000},
+		{day("2008-03-01"), 2750},
+		{day("2008-10-30"), 4250},
+		{day("2009-02-15"), 3250},
+		{day("2009-04-01"), 2750},
+	})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.373362535, r, 1e-6)
+
+	// Losses are solved too.
+	r, err = XIRR([]Flow{{day("2021-01-01"), -1000}, {day("2022-01-01"), 500}})
+	require.NoError(t, err)
+	assert.InDelta(t, -0.5, r, 1e-9)
+
+	_, err = XIRR([]Flow{{day("2021-01-01"), 100}, {day("2022-01-01"), 100}})
+	assert.ErrorIs(t, err, ErrNoIRR)
+}
+
+func TestValueReplaysTrades(t *testing.T) {
+	prices := Prices{
+		"AAA": {{day("2024-01-02"), 10}, {day("2024-01-03"), 11}, {day("2024-01-05"), 12}},
+		"BBB": {{day("2024-01-05"), 50}},
+	}
+	trades := []Trade{
+		{Date: day("2023-12-29"), Ticker: "AAA", Quantity: 10, Amount: 95},
+		// Saturday trade lands on the next trading day.
+		{Date: day("2024-01-06"), Ticker: "BBB", Quantity: 2, Amount: 100},
+		{Date: day("2024-01-04"), Ticker: "AAA", Quantity: -5, Amount: -55},
+		{Date: day("2024-01-04"), Ticker: "CCC", Quantity: 1, Amount: 20},
+	}
+	dates := []time.Time{day("2024-01-02"), day("2024-01-03"), day("2024-01-05"), day("2024-01-08")}
+
+	values, flows, missing := Value(trades, prices, dates)
+	require.Len(t, values, 4)
+	assert.Equal(t, 100.0, values[0].Value)
+	assert.Equal(t, 110.0, values[1].Value)
+	// 5 AAA at 12 plus CCC at its trade price
+	assert.Equal(t, 80.0, values[2].Value)
+	assert.Equal(t, 180.0, values[3].Value)
+
+	require.Len(t, flows, 2)
+	assert.Equal(t, day("2024-01-05"), flows[0].Date)
+	assert.Equal(t, -35.0, flows[0].Amount)
+	assert.Equal(t, 100.0, flows[1].Amount)
+	assert.Equal(t, []string{"CCC"}, missing)
+
+	assert.Equal(t, []time.Time{day("2024-01-02"), day("2024-01-03"), day("2024-01-05")}, prices.Dates(day("2024-01-01"), day("2024-01-31")))
+}
@@ -0,0 +1,121 @@
+package perf
+
+import (
+	"sort"
+	"time"
+)
+
+// Trade changes the quantity held of Ticker on Date. Quantity is signed (sales are negative)
+// and Amount is the cash paid for it including fees (negative for sale proceeds).
+type Trade struct {
+	Date     time.Time
+	Ticker   string
+	Quantity float64
+	Amount   float64
+}
+
+// Prices holds daily closes per ticker, each slice sorted by date.
+type Prices map[string][]Point
+
+// On returns the last close of ticker on or before d.
+func (p Prices) On(ticker string, d time.Time) (float64, bool) {
+	pts := p[ticker]
+	i := sort.Search(len(pts), func(i int) bool { return pts[i].Date.After(d) })
+	if i == 0 {
+		return 0, false
+	}
+	return pts[i-1].Value, true
+}
+
+// Dates returns the sorted, de-duplicated dates with at least one close in [from, to].
+func (p Prices) Dates(from, to time.Time) []time.Time {
+	seen := map[int64]bool{}
+	var out []time.Time
+	for _, pts := range p {
+		for _, pt := range pts {
+			if pt.Date.Before(from) || pt.Date.After(to) || seen[pt.Date.Unix()] {
+				continue
+			}
+			seen[pt.Date.Unix()] = true
+			out = append(out, pt.Date)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+// Series returns the closes of ticker on each date (carrying the last close forward) as a
+// value series, skipping leading dates before its first close.
+func (p Prices) Series(ticker string, dates []time.Time) []Point {
+	out := make([]Point, 0, len(dates))
+	for _, d := range dates {
+		if v, ok := p.On(ticker, d); ok {
+			out = append(out, Point{Date: d, Value: v})
+		}
+	}
+	return out
+}
+
+// Value replays trades and marks the resulting holdings to market on each date. Trades
+// before dates[0] form the opening holdings; a trade after dates[i-1] and up to dates[i]
+// becomes an external flow on dates[i] (so weekend trades land on the next trading day).
+// Holdings without a close fall back to their last trade price and are reported in missing.
+func Value(trades []Trade, prices Prices, dates []time.Time) (values []Point, flows []Flow, missing []string) {
+	if len(dates) == 0 {
+		return nil, nil, nil
+	}
+	ts := make([]Trade, len(trades))
+	copy(ts, trades)
+	sort.SliceStable(ts, func(i, j int) bool { return ts[i].Date.Before(ts[j].Date) })
+
+	held := map[string]float64{}
+	lastPrice := map[string]float64{}
+	missingSet := map[string]bool{}
+	apply := func(t Trade) {
+		held[t.Ticker] += t.Quantity
+		if t.Quantity != 0 {
+			if px := t.Amount / t.Quantity; px > 0 {
+				lastPrice[t.Ticker] = px
+			}
+		}
+	}
+
+	k := 0
+	for ; k < len(ts) && ts[k].Date.Before(dates[0]); k++ {
+		apply(ts[k])
+	}
+
+	values = make([]Point, 0, len(dates))
+	for i, d := range dates {
+		var flow float64
+		for ; k < len(ts) && !ts[k].Date.After(d); k++ {
+			apply(ts[k])
+			if i > 0 {
+				flow += ts[k].Amount
+			}
+		}
+		if i > 0 && flow != 0 {
+			flows = append(flows, Flow{Date: d, Amount: flow})
+		}
+
+		var v float64
+		for tk, q := range held {
+			if q == 0 {
+				continue
+			}
+			px, ok := prices.On(tk, d)
+			if !ok {
+				px = lastPrice[tk]
+				missingSet[tk] = true
+			}
+			v += q * px
+		}
+		values = append(values, Point{Date: d, Value: v})
+	}
+
+	for tk := range missingSet {
+		missing = append(missing, tk)
+	}
+	sort.Strings(missing)
+	return values, flows, missing
+}
@@ -0,0 +1,82 @@
+package perf
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrNoIRR is returned when the cash flows have no sign change or the solver cannot converge.
+var ErrNoIRR = errors.New("irr: no solution for cash flows")
+
+// XIRR returns the annual money-weighted return for irregularly dated cash flows, from the
+// investor's point of view: contributions are negative, withdrawals and the ending value
+// are positive. Days are counted on an actual/365 basis from the first flow.
+func XIRR(flows []Flow) (float64, error) {
+	if len(flows) < 2 {
+		return 0, ErrNoIRR
+	}
+	cf := make([]Flow, len(flows))
+	copy(cf, flows)
+	SortFlows(cf)
+
+	var pos, neg bool
+	years := make([]float64, len(cf))
+	for i, f := range cf {
+		pos = pos || f.Amount > 0
+		neg = neg || f.Amount < 0
+		years[i] = cf[i].Date.Sub(cf[0].Date).Hours() / 24 / 365
+	}
+	if !pos || !neg {
+		return 0, ErrNoIRR
+	}
+
+	npv := func(r float64) (v, dv float64) {
+		for i, f := range cf {
+			d := math.Pow(1+r, years[i])
+			v += f.Amount / d
+			dv -= years[i] * f.Amount / (d * (1 + r))
+		}
+		return v, dv
+	}
+
+	// Newton converges in a handful of steps for ordinary portfolios.
+	r := 0.1
+	for i := 0; i < 50; i++ {
+		v, dv := npv(r)
+		if math.Abs(v) < 1e-7 {
+			return r, nil
+		}
+		if dv == 0 {
+			break
+		}
+		next := r - v/dv
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-r) < 1e-10 {
+			return next, nil
+		}
+		r = next
+	}
+
+	// Fall back to bisection over a wide bracket.
+	lo, hi := -0.9999, 10.0
+	vlo, _ := npv(lo)
+	vhi, _ := npv(hi)
+	if vlo*vhi > 0 {
+		return 0, ErrNoIRR
+	}
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		vm, _ := npv(mid)
+		if math.Abs(vm) < 1e-7 || hi-lo < 1e-12 {
+			return mid, nil
+		}
+		if vm*vlo < 0 {
+			hi = mid
+		} else {
+			lo, vlo = mid, vm
+		}
+	}
+	return (lo + hi) / 2, nil
+}
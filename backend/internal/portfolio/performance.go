@@ -0,0 +1,251 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio/perf"
+)
+
+// ErrNoPriceHistory is returned when there are no transactions or daily closes to value
+// the portfolio over the requested period.
+var ErrNoPriceHistory = errors.New("no transactions or price history for period")
+
+// priceLookback is how far before the period start closes are loaded, so holdings can be
+// valued on the first day even when it is a holiday.
+const priceLookback = 10 * 24 * time.Hour
+
+// PerformanceQuery selects the period and comparison for a performance report.
+type PerformanceQuery struct {
+	From      time.Time
+	To        time.Time
+	Benchmark string
+	// Base is the currency values are reported in; empty means the configured base.
+	Base string
+	// RiskFree is an annual rate used for the Sharpe ratio (0.04 = 4%).
+	RiskFree float64
+}
+
+// BenchmarkPerformance compares the portfolio with a single symbol over the same dates.
+type BenchmarkPerformance struct {
+	Symbol       string       `json:"symbol"`
+	Metrics      perf.Metrics `json:"metrics"`
+	ExcessReturn float64      `json:"excess_return"`
+	Beta         *float64     `json:"beta"`
+}
+
+// PerformanceReport is the result of Service.Performance. Values and flows are in
+// BaseCurrency, converted at the current rates listed in Rates.
+type PerformanceReport struct {
+	BaseCurrency string       `json:"base_currency"`
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"`
+	StartValue   float64      `json:"start_value"`
+	EndValue     float64      `json:"end_value"`
+	NetFlows     float64      `json:"net_flows"`
+	Metrics      perf.Metrics `json:"metrics"`
+	// MoneyWeighted is the XIRR of the opening value, trades and closing value; nil when
+	// it cannot be solved (e.g. no value at either end).
+	MoneyWeighted *float64              `json:"money_weighted_return"`
+	Benchmark     *BenchmarkPerformance `json:"benchmark,omitempty"`
+	// MissingPrices lists symbols that had no close for part of the period. Holdings fall
+	// back to their last trade price there.
+	MissingPrices []string `json:"missing_prices"`
+	// Rates are the base-currency value of one unit of each currency traded.
+	Rates map[string]float64 `json:"rates"`
+	// Warnings name currencies without a rate; their tickers are left out of the report.
+	Warnings []string `json:"warnings"`
+}
+
+// Performance values the transaction ledger at daily closes between q.From and q.To and
+// summarizes the result, optionally against q.Benchmark. Trades are converted into the base
+// currency from their own currency and closes from the currency the ticker trades in.
+func (s *Service) Performance(ctx context.Context, userID string, q PerformanceQuery) (*PerformanceReport, error) {
+	base, err := s.resolveBase(q.Base)
+	if err != nil {
+		return nil, err
+	}
+	q.From, q.To = truncateDay(q.From), truncateDay(q.To)
+	q.Benchmark = strings.ToUpper(strings.TrimSpace(q.Benchmark))
+
+	txs, err := s.ListTransactions(ctx, userID, q.To)
+	if err != nil {
+		return nil, err
+	}
+	rates, warnings := map[string]float64{}, []string{}
+	rate := s.converter(ctx, base, rates, &warnings)
+	priceCur := tradingCurrencies(txs)
+	trades := make([]perf.Trade, 0, len(txs))
+	symbols := map[string]bool{}
+	for _, t := range txs {
+		if _, ok := rate(priceCur[t.Ticker]); !ok {
+			continue
+		}
+		r, ok := rate(currencyOrDefault(t.Currency))
+		if !ok {
+			continue
+		}
+		trades = append(trades, perf.Trade{Date: t.TradeDate, Ticker: t.Ticker, Quantity: t.signedQuantity(), Amount: t.cashAmount() * r})
+		symbols[t.Ticker] = true
+	}
+	if len(trades) == 0 {
+		return nil, ErrNoPriceHistory
+	}
+	if q.Benchmark != "" {
+		symbols[q.Benchmark] = true
+	}
+
+	closes, adjusted, err := s.loadDailyPrices(ctx, keys(symbols), q.From.Add(-priceLookback), q.To)
+	if err != nil {
+		return nil, err
+	}
+	// The benchmark is only compared by its returns, which conversion at one rate leaves alone.
+	for sym, series := range closes {
+		cur, traded := priceCur[sym]
+		if !traded {
+			continue
+		}
+		r, ok := rate(cur)
+		if !ok {
+			continue
+		}
+		for i := range series {
+			series[i].Value *= r
+		}
+	}
+	held := perf.Prices{}
+	for sym := range symbols {
+		if sym != q.Benchmark || heldTicker(txs, sym) {
+			held[sym] = closes[sym]
+		}
+	}
+	dates := held.Dates(q.From, q.To)
+	if len(dates) == 0 {
+		return nil, ErrNoPriceHistory
+	}
+
+	values, flows, missing := perf.Value(trades, closes, dates)
+	rep := &PerformanceReport{
+		BaseCurrency:  base,
+		From:          dates[0],
+		To:            dates[len(dates)-1],
+		StartValue:    values[0].Value,
+		EndValue:      values[len(values)-1].Value,
+		Metrics:       perf.Summarize(values, flows, q.RiskFree),
+		MissingPrices: missing,
+		Rates:         rates,
+		Warnings:      warnings,
+	}
+	for _, f := range flows {
+		rep.NetFlows += f.Amount
+	}
+	rep.MoneyWeighted = moneyWeighted(values, flows)
+
+	if q.Benchmark != "" {
+		series := adjusted.Series(q.Benchmark, dates)
+		if len(series) == len(dates) {
+			m := perf.Summarize(series, nil, q.RiskFree)
+			rep.Benchmark = &BenchmarkPerformance{
+				Symbol:       q.Benchmark,
+				Metrics:      m,
+				ExcessReturn: rep.Metrics.TimeWeighted - m.TimeWeighted,
+				Beta:         perf.Beta(perf.DailyReturns(values, flows), perf.DailyReturns(series, nil)),
+			}
+		} else {
+			rep.MissingPrices = append(rep.MissingPrices, q.Benchmark)
+		}
+	}
+	if rep.MissingPrices == nil {
+		rep.MissingPrices = []string{}
+	}
+	sort.Strings(rep.Warnings)
+	return rep, nil
+}
+
+// tradingCurrencies maps each ticker in txs to the currency its closes are quoted in: that of
+// its buys and sells, or of its dividends when it has nothing else.
+func tradingCurrencies(txs []Transaction) map[string]string {
+	out := map[string]string{}
+	for _, t := range txs {
+		if _, ok := out[t.Ticker]; !ok && t.Type != TxDividend {
+			out[t.Ticker] = currencyOrDefault(t.Currency)
+		}
+	}
+	for _, t := range txs {
+		if _, ok := out[t.Ticker]; !ok {
+			out[t.Ticker] = currencyOrDefault(t.Currency)
+		}
+	}
+	return out
+}
+
+// moneyWeighted builds investor-side cash flows (contributions negative) around the
+// portfolio flows and solves for the XIRR.
+func moneyWeighted(values []perf.Point, flows []perf.Flow) *float64 {
+	cf := make([]perf.Flow, 0, len(flows)+2)
+	first, last := values[0], values[len(values)-1]
+	if first.Value != 0 {
+		cf = append(cf, perf.Flow{Date: first.Date, Amount: -first.Value})
+	}
+	for _, f := range flows {
+		cf = append(cf, perf.Flow{Date: f.Date, Amount: -f.Amount})
+	}
+	cf = append(cf, perf.Flow{Date: last.Date, Amount: last.Value})
+	r, err := perf.XIRR(cf)
+	if err != nil {
+		return nil
+	}
+	return &r
+}
+
+// loadDailyPrices returns split-adjusted closes and dividend-adjusted closes (falling back
+// to close) for symbols between from and to.
+func (s *Service) loadDailyPrices(ctx context.Context, symbols []string, from, to time.Time) (perf.Prices, perf.Prices, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT symbol, date, close, COALESCE(adj_close, close)
+FROM daily_prices
+WHERE symbol = ANY($1) AND date >= $2 AND date <= $3
+ORDER BY symbol, date
+`, symbols, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	closes, adjusted := perf.Prices{}, perf.Prices{}
+	for rows.Next() {
+		var (
+			sym        string
+			d          time.Time
+			close, adj float64
+		)
+		if err := rows.Scan(&sym, &d, &close, &adj); err != nil {
+			return nil, nil, err
+		}
+		d = truncateDay(d)
+		closes[sym] = append(closes[sym], perf.Point{Date: d, Value: close})
+		adjusted[sym] = append(adjusted[sym], perf.Point{Date: d, Value: adj})
+	}
+	return closes, adjusted, rows.Err()
+}
+
+func heldTicker(txs []Transaction, ticker string) bool {
+	for _, t := range txs {
+		if t.Ticker == ticker {
+			return true
+		}
+	}
+	return false
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,120 @@
+package portfolio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func d(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestPerformanceWithBenchmark(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	txCols := []string{"id", "ticker", "type", "quantity", "price", "fees", "currency", "trade_date", "note", "created_at"}
+	mock.ExpectQuery(`FROM portfolio_transactions`).
+		WithArgs("user-1", d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows(txCols).
+			AddRow("t1", "AAA", TxBuy, 10.0, 10.0, 0.0, "USD", d("2023-12-01"), (*string)(nil), time.Now()).
+			AddRow("t2", "AAA", TxBuy, 10.0, 11.0, 0.0, "USD", d("2024-01-03"), (*string)(nil), time.Now()))
+
+	priceCols := []string{"symbol", "date", "close", "adj_close"}
+	mock.ExpectQuery(`FROM daily_prices`).
+		WithArgs([]string{"AAA", "SPY"}, d("2024-01-02").Add(-priceLookback), d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows(priceCols).
+			AddRow("AAA", d("2024-01-02"), 10.0, 10.0).
+			AddRow("AAA", d("2024-01-03"), 11.0, 11.0).
+			AddRow("AAA", d("2024-01-04"), 12.1, 12.1).
+			AddRow("SPY", d("2024-01-02"), 400.0, 400.0).
+			AddRow("SPY", d("2024-01-03"), 404.0, 404.0).
+			AddRow("SPY", d("2024-01-04"), 408.04, 408.04))
+
+	rep, err := svc.Performance(context.Background(), "user-1", PerformanceQuery{From: d("2024-01-02"), To: d("2024-01-05"), Benchmark: "spy"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 100.0, rep.StartValue)
+	assert.InDelta(t, 242.0, rep.EndValue, 1e-9)
+	assert.InDelta(t, 110.0, rep.NetFlows, 1e-9)
+	// Two +10% days regardless of the mid-period purchase.
+	assert.InDelta(t, 0.21, rep.Metrics.TimeWeighted, 1e-9)
+	require.NotNil(t, rep.MoneyWeighted)
+	require.NotNil(t, rep.Benchmark)
+	assert.Equal(t, "SPY", rep.Benchmark.Symbol)
+	assert.InDelta(t, 0.0201, rep.Benchmark.Metrics.TimeWeighted, 1e-9)
+	assert.InDelta(t, 0.21-0.0201, rep.Benchmark.ExcessReturn, 1e-9)
+	assert.Empty(t, rep.MissingPrices)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPerformanceConvertsCurrencies(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	txCols := []string{"id", "ticker", "type", "quantity", "price", "fees", "currency", "trade_date", "note", "created_at"}
+	mock.ExpectQuery(`FROM portfolio_transactions`).
+		WithArgs("user-1", d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows(txCols).
+			AddRow("t1", "AAA", TxBuy, 10.0, 10.0, 0.0, "USD", d("2023-12-01"), (*string)(nil), time.Now()).
+			AddRow("t2", "SAP", TxBuy, 10.0, 100.0, 0.0, "EUR", d("2024-01-03"), (*string)(nil), time.Now()))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.1, time.Now()))
+	mock.ExpectQuery(`FROM daily_prices`).
+		WithArgs([]string{"AAA", "SAP"}, d("2024-01-02").Add(-priceLookback), d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "date", "close", "adj_close"}).
+			AddRow("AAA", d("2024-01-02"), 10.0, 10.0).
+			AddRow("AAA", d("2024-01-03"), 10.0, 10.0).
+			AddRow("AAA", d("2024-01-04"), 10.0, 10.0).
+			AddRow("SAP", d("2024-01-03"), 100.0, 100.0).
+			AddRow("SAP", d("2024-01-04"), 110.0, 110.0))
+
+	rep, err := svc.Performance(context.Background(), "user-1", PerformanceQuery{From: d("2024-01-02"), To: d("2024-01-05")})
+	require.NoError(t, err)
+	assert.Equal(t, "USD", rep.BaseCurrency)
+	assert.Equal(t, map[string]float64{"EUR": 1.1, "USD": 1}, rep.Rates)
+	assert.InDelta(t, 100.0, rep.StartValue, 1e-9)
+	// 100 USD of AAA plus 10 SAP at 110 EUR = 1210 USD.
+	assert.InDelta(t, 1310.0, rep.EndValue, 1e-9)
+	assert.InDelta(t, 1100.0, rep.NetFlows, 1e-9)
+	// Only SAP moved: +121 USD on 1200 USD held the day before.
+	assert.InDelta(t, 1310.0/1200-1, rep.Metrics.TimeWeighted, 1e-9)
+	assert.Empty(t, rep.Warnings)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPerformanceWithoutTransactions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	mock.ExpectQuery(`FROM portfolio_transactions`).
+		WithArgs("user-1", d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "ticker", "type", "quantity", "price", "fees", "currency", "trade_date", "note", "created_at"}))
+
+	_, err = svc.Performance(context.Background(), "user-1", PerformanceQuery{From: d("2024-01-02"), To: d("2024-01-05")})
+	assert.ErrorIs(t, err, ErrNoPriceHistory)
+}
+
+func TestTransactionValidation(t *testing.T) {
+	svc := &Service{}
+	_, err := svc.AddTransactions(context.Background(), "user-1", []Transaction{{Ticker: "AAPL", Type: "hold", Quantity: 1, Price: 1, TradeDate: d("2024-01-02")}})
+	var invalid *InvalidTransactionError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, 0, invalid.Index)
+
+	sell := Transaction{Type: TxSell, Quantity: 2, Price: 50, Fees: 1}
+	assert.Equal(t, -2.0, sell.signedQuantity())
+	assert.Equal(t, -99.0, sell.cashAmount())
+}
@@ -0,0 +1,329 @@
+// Package rebalance turns target weights and current holdings into a list of trades. It is
+// pure arithmetic over the inputs it is given; prices, positions and sectors are loaded by
+// the caller.
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const weightTolerance = 1e-6
+
+var (
+	ErrTargetsExceedOne = errors.New("target weights add up to more than 100%")
+	ErrNegativeTarget   = errors.New("target weights cannot be negative")
+	ErrNoTargets        = errors.New("no target weights set")
+	ErrEmptyPortfolio   = errors.New("portfolio has no value to rebalance")
+)
+
+// Holding is a current position marked at Price. Sector may be empty when unknown.
+type Holding struct {
+	Ticker   string
+	Quantity float64
+	Price    float64
+	Sector   string
+}
+
+// Targets are weights between 0 and 1 of the total portfolio value (holdings plus cash).
+// A ticker weight always wins; tickers without one share their sector's weight in
+// proportion to their current value (equally when none is held). Anything left over is
+// held as cash. Holdings covered by neither get a target of zero.
+type Targets struct {
+	Tickers map[string]float64
+	Sectors map[string]float64
+}
+
+// Options tune the generated trades.
+type Options struct {
+	// Cash is uninvested cash plus any new contribution available for buys.
+	Cash float64
+	// MinTrade drops trades whose absolute value is below this amount.
+	MinTrade float64
+	// WholeShares rounds trades to whole shares (buys down, sells to nearest, never more
+	// than held).
+	WholeShares bool
+	// NoSells only buys underweight positions with the available cash.
+	NoSells bool
+}
+
+// Trade is a suggested order. Shares is always positive; Action says which way.
+type Trade struct {
+	Ticker string  `json:"ticker"`
+	Action string  `json:"action"`
+	Shares float64 `json:"shares"`
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+}
+
+// Drift compares a position's weight with its target, before and after the trades.
+type Drift struct {
+	Ticker        string  `json:"ticker"`
+	Sector        string  `json:"sector,omitempty"`
+	CurrentWeight float64 `json:"current_weight"`
+	TargetWeight  float64 `json:"target_weight"`
+	Drift         float64 `json:"drift"`
+	PostWeight    float64 `json:"post_trade_weight"`
+}
+
+// SectorDrift is Drift aggregated by sector, reported when sector targets are set.
+type SectorDrift struct {
+	Sector        string  `json:"sector"`
+	CurrentWeight float64 `json:"current_weight"`
+	TargetWeight  float64 `json:"target_weight"`
+	Drift         float64 `json:"drift"`
+	PostWeight    float64 `json:"post_trade_weight"`
+}
+
+// Plan is the result of Compute.
+type Plan struct {
+	// BaseCurrency is the currency values and prices are in. Compute leaves it to the caller.
+	BaseCurrency string        `json:"base_currency,omitempty"`
+	TotalValue   float64       `json:"total_value"`
+	CashBefore   float64       `json:"cash_before"`
+	CashAfter    float64       `json:"cash_after"`
+	TargetCash   float64       `json:"target_cash_weight"`
+	Trades       []Trade       `json:"trades"`
+	Drift        []Drift       `json:"drift"`
+	SectorDrift  []SectorDrift `json:"sector_drift,omitempty"`
+	Warnings     []string      `json:"warnings"`
+}
+
+// Compute builds a rebalance plan. Holdings must have a positive price; tickers that only
+// appear in targets need an entry with zero quantity so they can be priced.
+func Compute(holdings []Holding, targets Targets, opts Options) (*Plan, error) {
+	if len(targets.Tickers) == 0 && len(targets.Sectors) == 0 {
+		return nil, ErrNoTargets
+	}
+	if err := Validate(targets); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{CashBefore: opts.Cash, Trades: []Trade{}, Drift: []Drift{}, Warnings: []string{}}
+	byTicker := map[string]*Holding{}
+	unpriced := map[string]bool{}
+	var hs []*Holding
+	for i := range holdings {
+		h := holdings[i]
+		if h.Price <= 0 {
+			if !unpriced[h.Ticker] {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s has no price and was left out", h.Ticker))
+			}
+			unpriced[h.Ticker] = true
+			continue
+		}
+		if prev, ok := byTicker[h.Ticker]; ok {
+			prev.Quantity += h.Quantity
+			continue
+		}
+		byTicker[h.Ticker] = &h
+		hs = append(hs, &h)
+	}
+	for t := range targets.Tickers {
+		if _, ok := byTicker[t]; !ok && !unpriced[t] {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s has a target but no price; it cannot be bought", t))
+		}
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Ticker < hs[j].Ticker })
+
+	total := opts.Cash
+	for _, h := range hs {
+		total += h.Quantity * h.Price
+	}
+	plan.TotalValue = total
+	if total <= 0 {
+		return nil, ErrEmptyPortfolio
+	}
+
+	weights := effectiveWeights(hs, targets)
+	sumTargets := 0.0
+	for _, w := range weights {
+		sumTargets += w
+	}
+	if sumTargets > 1+weightTolerance {
+		return nil, ErrTargetsExceedOne
+	}
+	plan.TargetCash = math.Max(0, 1-sumTargets)
+
+	// Desired change in value per ticker.
+	deltas := make(map[string]float64, len(hs))
+	for _, h := range hs {
+		deltas[h.Ticker] = weights[h.Ticker]*total - h.Quantity*h.Price
+	}
+	if opts.NoSells {
+		fundFromCash(hs, deltas, opts.Cash)
+	}
+
+	cash := opts.Cash
+	var buys []Trade
+	for _, h := range hs {
+		d := deltas[h.Ticker]
+		if d < 0 {
+			shares := -d / h.Price
+			if opts.WholeShares {
+				shares = math.Round(shares)
+			}
+			// Fully exit zero targets even when the last share rounds away.
+			if weights[h.Ticker] == 0 || shares > h.Quantity {
+				shares = h.Quantity
+			}
+			if shares <= 0 || shares*h.Price < opts.MinTrade {
+				continue
+			}
+			plan.Trades = append(plan.Trades, Trade{Ticker: h.Ticker, Action: "sell", Shares: shares, Price: h.Price, Value: shares * h.Price})
+			cash += shares * h.Price
+		} else if d > 0 {
+			buys = append(buys, Trade{Ticker: h.Ticker, Action: "buy", Shares: d / h.Price, Price: h.Price})
+		}
+	}
+
+	// Never spend more than the cash on hand after sells.
+	need := 0.0
+	for _, b := range buys {
+		need += b.Shares * b.Price
+	}
+	scale := 1.0
+	if need > cash && need > 0 {
+		scale = cash / need
+	}
+	for _, b := range buys {
+		b.Shares *= scale
+		if opts.WholeShares {
+			b.Shares = math.Floor(b.Shares + 1e-9)
+		}
+		b.Value = b.Shares * b.Price
+		if b.Shares <= 0 || b.Value < opts.MinTrade {
+			continue
+		}
+		plan.Trades = append(plan.Trades, b)
+		cash -= b.Value
+	}
+	plan.CashAfter = cash
+
+	post := map[string]float64{}
+	for _, h := range hs {
+		post[h.Ticker] = h.Quantity
+	}
+	for _, t := range plan.Trades {
+		if t.Action == "sell" {
+			post[t.Ticker] -= t.Shares
+		} else {
+			post[t.Ticker] += t.Shares
+		}
+	}
+	sectors := map[string]*SectorDrift{}
+	for _, h := range hs {
+		cur := h.Quantity * h.Price / total
+		after := post[h.Ticker] * h.Price / total
+		plan.Drift = append(plan.Drift, Drift{
+			Ticker:        h.Ticker,
+			Sector:        h.Sector,
+			CurrentWeight: cur,
+			TargetWeight:  weights[h.Ticker],
+			Drift:         cur - weights[h.Ticker],
+			PostWeight:    after,
+		})
+		if len(targets.Sectors) > 0 {
+			sd := sectors[h.Sector]
+			if sd == nil {
+				sd = &SectorDrift{Sector: h.Sector, TargetWeight: targets.Sectors[h.Sector]}
+				sectors[h.Sector] = sd
+			}
+			sd.CurrentWeight += cur
+			sd.PostWeight += after
+		}
+	}
+	for _, sd := range sectors {
+		sd.Drift = sd.CurrentWeight - sd.TargetWeight
+		plan.SectorDrift = append(plan.SectorDrift, *sd)
+	}
+	sort.Slice(plan.SectorDrift, func(i, j int) bool { return plan.SectorDrift[i].Sector < plan.SectorDrift[j].Sector })
+	sort.SliceStable(plan.Trades, func(i, j int) bool {
+		// Sells first so their proceeds fund the buys.
+		if plan.Trades[i].Action != plan.Trades[j].Action {
+			return plan.Trades[i].Action == "sell"
+		}
+		return plan.Trades[i].Ticker < plan.Trades[j].Ticker
+	})
+	return plan, nil
+}
+
+// effectiveWeights resolves ticker and sector targets into one weight per holding.
+func effectiveWeights(hs []*Holding, targets Targets) map[string]float64 {
+	out := make(map[string]float64, len(hs))
+	explicitBySector := map[string]float64{}
+	members := map[string][]*Holding{}
+	for _, h := range hs {
+		if w, ok := targets.Tickers[h.Ticker]; ok {
+			out[h.Ticker] = w
+			explicitBySector[h.Sector] += w
+			continue
+		}
+		if _, ok := targets.Sectors[h.Sector]; ok && h.Sector != "" {
+			members[h.Sector] = append(members[h.Sector], h)
+			continue
+		}
+		out[h.Ticker] = 0
+	}
+	for sector, hs := range members {
+		remaining := math.Max(0, targets.Sectors[sector]-explicitBySector[sector])
+		var value float64
+		for _, h := range hs {
+			value += h.Quantity * h.Price
+		}
+		for _, h := range hs {
+			if value > 0 {
+				out[h.Ticker] = remaining * h.Quantity * h.Price / value
+			} else {
+				out[h.Ticker] = remaining / float64(len(hs))
+			}
+		}
+	}
+	return out
+}
+
+// fundFromCash rewrites deltas so only underweight positions are bought, in proportion to
+// how far below target they are, using at most cash.
+func fundFromCash(hs []*Holding, deltas map[string]float64, cash float64) {
+	var deficit float64
+	for _, h := range hs {
+		if deltas[h.Ticker] > 0 {
+			deficit += deltas[h.Ticker]
+		}
+	}
+	scale := 1.0
+	if deficit > cash && deficit > 0 {
+		scale = cash / deficit
+	}
+	for _, h := range hs {
+		if deltas[h.Ticker] > 0 {
+			deltas[h.Ticker] *= scale
+		} else {
+			deltas[h.Ticker] = 0
+		}
+	}
+}
+
+// Validate checks that weights are non-negative and each set sums to at most 100%.
+func Validate(targets Targets) error {
+	if err := checkWeights(targets.Tickers); err != nil {
+		return err
+	}
+	return checkWeights(targets.Sectors)
+}
+
+func checkWeights(ws map[string]float64) error {
+	var sum float64
+	for _, w := range ws {
+		if w < 0 {
+			return ErrNegativeTarget
+		}
+		sum += w
+	}
+	if sum > 1+weightTolerance {
+		return ErrTargetsExceedOne
+	}
+	return nil
+}
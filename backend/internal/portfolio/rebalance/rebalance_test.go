@@ -0,0 +1,120 @@
+package rebalance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tradeFor(p *Plan, ticker string) *Trade {
+	for i := range p.Trades {
+		if p.Trades[i].Ticker == ticker {
+			return &p.Trades[i]
+		}
+	}
+	return nil
+}
+
+func TestComputeTickerTargets(t *testing.T) {
+	holdings := []Holding{
+		{Ticker: "AAA", Quantity: 70, Price: 10},
+		{Ticker: "BBB", Quantity: 3, Price: 10},
+		{Ticker: "OLD", Quantity: 1, Price: 5},
+		{Ticker: "NEW", Quantity: 0, Price: 20},
+	}
+	plan, err := Compute(holdings, Targets{Tickers: map[string]float64{"AAA": 0.5, "BBB": 0.3, "NEW": 0.2}}, Options{Cash: 265, WholeShares: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, plan.TotalValue)
+
+	// Sells come first.
+	require.Len(t, plan.Trades, 4)
+	assert.Equal(t, "sell", plan.Trades[0].Action)
+	assert.Equal(t, "sell", plan.Trades[1].Action)
+
+	aaa := tradeFor(plan, "AAA")
+	require.NotNil(t, aaa)
+	assert.Equal(t, Trade{Ticker: "AAA", Action: "sell", Shares: 20, Price: 10, Value: 200}, *aaa)
+	old := tradeFor(plan, "OLD")
+	require.NotNil(t, old)
+	assert.Equal(t, 1.0, old.Shares)
+
+	bbb := tradeFor(plan, "BBB")
+	require.NotNil(t, bbb)
+	assert.Equal(t, "buy", bbb.Action)
+	assert.Equal(t, 27.0, bbb.Shares)
+	nw := tradeFor(plan, "NEW")
+	require.NotNil(t, nw)
+	assert.Equal(t, 10.0, nw.Shares)
+	assert.InDelta(t, 0.0, plan.CashAfter, 1e-9)
+	assert.InDelta(t, 0.0, plan.TargetCash, 1e-9)
+}
+
+func TestComputeMinTradeAndNoSells(t *testing.T) {
+	holdings := []Holding{
+		{Ticker: "AAA", Quantity: 60, Price: 10},
+		{Ticker: "BBB", Quantity: 39, Price: 10},
+	}
+	targets := Targets{Tickers: map[string]float64{"AAA": 0.5, "BBB": 0.5}}
+
+	plan, err := Compute(holdings, targets, Options{Cash: 10, MinTrade: 150, WholeShares: true})
+	require.NoError(t, err)
+	// Selling 10 AAA (100) is below the minimum, and without it there is not enough cash
+	// for a single BBB share.
+	assert.Empty(t, plan.Trades)
+
+	// Without sells the contribution goes to the underweight position only, capped at cash.
+	plan, err = Compute(holdings, targets, Options{Cash: 200, NoSells: true, WholeShares: true})
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 1)
+	assert.Equal(t, Trade{Ticker: "BBB", Action: "buy", Shares: 20, Price: 10, Value: 200}, plan.Trades[0])
+	assert.InDelta(t, 0.0, plan.CashAfter, 1e-9)
+}
+
+func TestComputeFractionalShares(t *testing.T) {
+	plan, err := Compute([]Holding{{Ticker: "AAA", Quantity: 0, Price: 300}}, Targets{Tickers: map[string]float64{"AAA": 1}}, Options{Cash: 1000})
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 1)
+	assert.InDelta(t, 1000.0/300, plan.Trades[0].Shares, 1e-9)
+	assert.InDelta(t, 0.0, plan.CashAfter, 1e-9)
+}
+
+func TestComputeSectorTargets(t *testing.T) {
+	holdings := []Holding{
+		{Ticker: "AAPL", Quantity: 10, Price: 30, Sector: "Technology"},
+		{Ticker: "MSFT", Quantity: 10, Price: 10, Sector: "Technology"},
+		{Ticker: "XOM", Quantity: 60, Price: 10, Sector: "Energy"},
+	}
+	targets := Targets{
+		Tickers: map[string]float64{"MSFT": 0.1},
+		Sectors: map[string]float64{"Technology": 0.6, "Energy": 0.4},
+	}
+	plan, err := Compute(holdings, targets, Options{})
+	require.NoError(t, err)
+
+	weights := map[string]float64{}
+	for _, d := range plan.Drift {
+		weights[d.Ticker] = d.TargetWeight
+	}
+	// MSFT's explicit 10% comes out of Technology's 60%, the rest goes to AAPL.
+	assert.InDelta(t, 0.5, weights["AAPL"], 1e-9)
+	assert.InDelta(t, 0.1, weights["MSFT"], 1e-9)
+	assert.InDelta(t, 0.4, weights["XOM"], 1e-9)
+
+	require.Len(t, plan.SectorDrift, 2)
+	assert.Equal(t, "Energy", plan.SectorDrift[0].Sector)
+	assert.InDelta(t, 0.6, plan.SectorDrift[0].CurrentWeight, 1e-9)
+	assert.InDelta(t, 0.4, plan.SectorDrift[0].PostWeight, 1e-9)
+}
+
+func TestComputeValidation(t *testing.T) {
+	h := []Holding{{Ticker: "AAA", Quantity: 1, Price: 1}}
+	_, err := Compute(h, Targets{}, Options{})
+	assert.ErrorIs(t, err, ErrNoTargets)
+	_, err = Compute(h, Targets{Tickers: map[string]float64{"AAA": 0.8, "BBB": 0.3}}, Options{})
+	assert.ErrorIs(t, err, ErrTargetsExceedOne)
+	_, err = Compute(h, Targets{Tickers: map[string]float64{"AAA": -0.1}}, Options{})
+	assert.ErrorIs(t, err, ErrNegativeTarget)
+	_, err = Compute(nil, Targets{Tickers: map[string]float64{"AAA": 1}}, Options{})
+	assert.ErrorIs(t, err, ErrEmptyPortfolio)
+}
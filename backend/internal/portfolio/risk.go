@@ -0,0 +1,202 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio/perf"
+	"stockchallenge/backend/internal/portfolio/risk"
+)
+
+// ErrNoHoldings is returned when risk is requested for a portfolio with nothing to value.
+var ErrNoHoldings = errors.New("portfolio has no priced holdings")
+
+// defaultRiskTopN is how many holdings the concentration section lists by default.
+const defaultRiskTopN = 5
+
+// IndustrySource is implemented by sector sources that can also classify tickers by
+// industry. It is checked for on Service.Sectors rather than wired separately.
+type IndustrySource interface {
+	Industries(ctx context.Context, tickers []string) (map[string]string, error)
+}
+
+// RiskQuery selects the price window and parameters for a risk report.
+type RiskQuery struct {
+	From      time.Time
+	To        time.Time
+	Benchmark string
+	// TopN is how many holdings count towards the top-N weight (default 5).
+	TopN int
+	// Confidence is the VaR confidence level (default 0.95).
+	Confidence float64
+}
+
+// RiskBenchmark relates the current holdings to a benchmark symbol.
+type RiskBenchmark struct {
+	Symbol      string   `json:"symbol"`
+	Beta        *float64 `json:"beta"`
+	Correlation *float64 `json:"correlation"`
+}
+
+// RiskReport is the result of Service.Risk. Weights and exposures use current market values
+// in BaseCurrency; the return-based figures replay the current weights over daily closes.
+type RiskReport struct {
+	BaseCurrency  string             `json:"base_currency"`
+	From          time.Time          `json:"from"`
+	To            time.Time          `json:"to"`
+	Value         float64            `json:"value"`
+	Concentration risk.Concentration `json:"concentration"`
+	Sectors       []risk.Exposure    `json:"sectors"`
+	Industries    []risk.Exposure    `json:"industries"`
+	// Volatility is the annualized standard deviation of the replayed daily returns.
+	Volatility  float64        `json:"volatility"`
+	VaR         *risk.VaR      `json:"var"`
+	Benchmark   *RiskBenchmark `json:"benchmark,omitempty"`
+	Correlation risk.Matrix    `json:"correlation"`
+	// Coverage is the share of Value held in tickers with closes for the whole period. VaR,
+	// volatility, beta and correlations only use those.
+	Coverage      float64  `json:"coverage"`
+	MissingPrices []string `json:"missing_prices"`
+	Warnings      []string `json:"warnings"`
+}
+
+// Risk measures concentration, sector and industry exposure, beta, correlations and
+// one-day Value-at-Risk for the user's current positions.
+func (s *Service) Risk(ctx context.Context, userID string, q RiskQuery) (*RiskReport, error) {
+	q.From, q.To = truncateDay(q.From), truncateDay(q.To)
+	q.Benchmark = strings.ToUpper(strings.TrimSpace(q.Benchmark))
+	if q.TopN <= 0 {
+		q.TopN = defaultRiskTopN
+	}
+	if q.Confidence == 0 {
+		q.Confidence = risk.DefaultConfidence
+	}
+	if q.Confidence <= 0.5 || q.Confidence >= 1 {
+		return nil, risk.ErrInvalidConfidence
+	}
+
+	sum, err := s.Summary(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	var holdings []risk.Holding
+	for _, h := range sum.Holdings {
+		if h.MarketValueBase > 0 {
+			holdings = append(holdings, risk.Holding{Ticker: h.Ticker, Value: h.MarketValueBase, Sector: h.Sector, Industry: h.Industry})
+		}
+	}
+	if len(holdings) == 0 {
+		return nil, ErrNoHoldings
+	}
+	weights := risk.Weights(holdings)
+	rep := &RiskReport{
+		BaseCurrency:  sum.BaseCurrency,
+		From:          q.From,
+		To:            q.To,
+		Value:         sum.InvestedValue,
+		Concentration: risk.Concentrate(weights, q.TopN),
+		Warnings:      append([]string{}, sum.Warnings...),
+	}
+
+	if s.Sectors == nil {
+		rep.Warnings = append(rep.Warnings, "sector data unavailable; exposures are reported as Unknown")
+	} else if _, ok := s.Sectors.(IndustrySource); !ok {
+		rep.Warnings = append(rep.Warnings, "industry data unavailable; industry exposure is reported as Unknown")
+	}
+	rep.Sectors = risk.ExposureBy(holdings, func(h risk.Holding) string { return h.Sector })
+	rep.Industries = risk.ExposureBy(holdings, func(h risk.Holding) string { return h.Industry })
+
+	symbols := make([]string, 0, len(weights)+1)
+	for _, w := range weights {
+		symbols = append(symbols, w.Ticker)
+	}
+	if q.Benchmark != "" {
+		symbols = append(symbols, q.Benchmark)
+	}
+	_, adjusted, err := s.loadDailyPrices(ctx, symbols, q.From.Add(-priceLookback), q.To)
+	if err != nil {
+		return nil, err
+	}
+	held := perf.Prices{}
+	for _, w := range weights {
+		held[w.Ticker] = adjusted[w.Ticker]
+	}
+	dates := held.Dates(q.From, q.To)
+	if len(dates) > 0 {
+		rep.From, rep.To = dates[0], dates[len(dates)-1]
+	}
+
+	returns := map[string][]float64{}
+	covered := map[string]float64{}
+	tickers := []string{}
+	for _, w := range weights {
+		series := adjusted.Series(w.Ticker, dates)
+		if len(dates) < 2 || len(series) < len(dates) {
+			rep.MissingPrices = append(rep.MissingPrices, w.Ticker)
+			continue
+		}
+		returns[w.Ticker] = perf.DailyReturns(series, nil)
+		covered[w.Ticker] = w.Weight
+		tickers = append(tickers, w.Ticker)
+		rep.Coverage += w.Weight
+	}
+	rep.Correlation = risk.CorrelationMatrix(tickers, returns)
+
+	port := risk.PortfolioReturns(covered, returns)
+	rep.Volatility = perf.Volatility(port, perf.TradingDaysPerYear)
+	if rep.VaR, err = risk.ValueAtRisk(port, q.Confidence, rep.Value*rep.Coverage); err != nil {
+		return nil, err
+	}
+	if rep.VaR == nil {
+		rep.Warnings = append(rep.Warnings, "not enough price history for VaR, volatility or beta")
+	}
+
+	if q.Benchmark != "" {
+		series := adjusted.Series(q.Benchmark, dates)
+		if len(dates) >= 2 && len(series) == len(dates) {
+			bench := perf.DailyReturns(series, nil)
+			rep.Benchmark = &RiskBenchmark{
+				Symbol:      q.Benchmark,
+				Beta:        perf.Beta(port, bench),
+				Correlation: risk.Correlation(port, bench),
+			}
+		} else {
+			rep.MissingPrices = append(rep.MissingPrices, q.Benchmark)
+		}
+	}
+	if rep.MissingPrices == nil {
+		rep.MissingPrices = []string{}
+	}
+	sort.Strings(rep.MissingPrices)
+	return rep, nil
+}
+
+// classify fills in sector and industry on holdings from the sector source. Industries are
+// only known when the source also implements IndustrySource.
+func (s *Service) classify(ctx context.Context, holdings []HoldingValue) error {
+	if s.Sectors == nil || len(holdings) == 0 {
+		return nil
+	}
+	tickers := make([]string, len(holdings))
+	for i, h := range holdings {
+		tickers[i] = h.Ticker
+	}
+	sectors, err := s.Sectors.Sectors(ctx, tickers)
+	if err != nil {
+		return err
+	}
+	var industries map[string]string
+	if src, ok := s.Sectors.(IndustrySource); ok {
+		if industries, err = src.Industries(ctx, tickers); err != nil {
+			return err
+		}
+	}
+	for i := range holdings {
+		holdings[i].Sector = sectors[holdings[i].Ticker]
+		holdings[i].Industry = industries[holdings[i].Ticker]
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// Package risk measures how concentrated a set of holdings is and how much it could lose
+// on a bad day. Like perf and rebalance it only does arithmetic: holdings, classifications
+// and return series are loaded by the caller.
+package risk
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"stockchallenge/backend/internal/portfolio/perf"
+)
+
+// Unknown labels holdings that have no sector or industry.
+const Unknown = "Unknown"
+
+// DefaultConfidence is the VaR confidence level used when the caller does not pick one.
+const DefaultConfidence = 0.95
+
+var ErrInvalidConfidence = errors.New("confidence must be between 0.5 and 1")
+
+// Holding is a position marked to market in a single currency. Sector and Industry may be
+// empty when unknown.
+type Holding struct {
+	Ticker   string
+	Value    float64
+	Sector   string
+	Industry string
+}
+
+// Weight is a holding's share of the total value.
+type Weight struct {
+	Ticker string  `json:"ticker"`
+	Value  float64 `json:"value"`
+	Weight float64 `json:"weight"`
+}
+
+// Weights returns each holding with a positive value as a share of their sum, largest
+// first. Ties are broken by ticker so output is stable.
+func Weights(holdings []Holding) []Weight {
+	var total float64
+	for _, h := range holdings {
+		if h.Value > 0 {
+			total += h.Value
+		}
+	}
+	out := []Weight{}
+	if total == 0 {
+		return out
+	}
+	for _, h := range holdings {
+		if h.Value > 0 {
+			out = append(out, Weight{Ticker: h.Ticker, Value: h.Value, Weight: h.Value / total})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Value != out[j].Value {
+			return out[i].Value > out[j].Value
+		}
+		return out[i].Ticker < out[j].Ticker
+	})
+	return out
+}
+
+// Concentration summarizes how much of the portfolio sits in a few names.
+type Concentration struct {
+	TopN int      `json:"top_n"`
+	Top  []Weight `json:"top"`
+	// TopWeight is the combined weight of the TopN largest holdings.
+	TopWeight float64 `json:"top_weight"`
+	// HHI is the Herfindahl-Hirschman index, the sum of squared weights: 1/n for an
+	// equal-weighted portfolio of n names, 1 for a single holding.
+	HHI float64 `json:"hhi"`
+	// EffectiveHoldings is 1/HHI, the number of equal-weighted names with the same HHI.
+	EffectiveHoldings float64 `json:"effective_holdings"`
+}
+
+// Concentrate measures weights (as returned by Weights) keeping the n largest.
+func Concentrate(weights []Weight, n int) Concentration {
+	n = max(0, min(n, len(weights)))
+	c := Concentration{TopN: n, Top: weights[:n]}
+	for i, w := range weights {
+		if i < n {
+			c.TopWeight += w.Weight
+		}
+		c.HHI += w.Weight * w.Weight
+	}
+	if c.HHI > 0 {
+		c.EffectiveHoldings = 1 / c.HHI
+	}
+	return c
+}
+
+// Exposure is the value and weight held in one sector or industry.
+type Exposure struct {
+	Name     string   `json:"name"`
+	Value    float64  `json:"value"`
+	Weight   float64  `json:"weight"`
+	Holdings []string `json:"holdings"`
+}
+
+// ExposureBy groups positive-value holdings by key, largest first. Holdings with an empty
+// key are grouped under Unknown.
+func ExposureBy(holdings []Holding, key func(Holding) string) []Exposure {
+	byName := map[string]*Exposure{}
+	var total float64
+	for _, h := range holdings {
+		if h.Value <= 0 {
+			continue
+		}
+		name := key(h)
+		if name == "" {
+			name = Unknown
+		}
+		e, ok := byName[name]
+		if !ok {
+			e = &Exposure{Name: name}
+			byName[name] = e
+		}
+		e.Value += h.Value
+		e.Holdings = append(e.Holdings, h.Ticker)
+		total += h.Value
+	}
+	out := make([]Exposure, 0, len(byName))
+	for _, e := range byName {
+		e.Weight = e.Value / total
+		sort.Strings(e.Holdings)
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Value != out[j].Value {
+			return out[i].Value > out[j].Value
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Correlation returns the Pearson correlation of the common prefix of a and b, or nil when
+// either series has no variance or there are fewer than two observations.
+func Correlation(a, b []float64) *float64 {
+	n := min(len(a), len(b))
+	if n < 2 {
+		return nil
+	}
+	a, b = a[:n], b[:n]
+	ma, mb := perf.Mean(a), perf.Mean(b)
+	var cov, va, vb float64
+	for i := 0; i < n; i++ {
+		cov += (a[i] - ma) * (b[i] - mb)
+		va += (a[i] - ma) * (a[i] - ma)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+	if va == 0 || vb == 0 {
+		return nil
+	}
+	r := math.Max(-1, math.Min(1, cov/math.Sqrt(va*vb)))
+	return &r
+}
+
+// Matrix is a symmetric correlation matrix; Values[i][j] pairs Tickers[i] with Tickers[j].
+// Entries are null where a series has no variance.
+type Matrix struct {
+	Tickers []string     `json:"tickers"`
+	Values  [][]*float64 `json:"values"`
+}
+
+// CorrelationMatrix correlates the return series of tickers pairwise.
+func CorrelationMatrix(tickers []string, returns map[string][]float64) Matrix {
+	m := Matrix{Tickers: tickers, Values: make([][]*float64, len(tickers))}
+	for i := range tickers {
+		m.Values[i] = make([]*float64, len(tickers))
+	}
+	for i, a := range tickers {
+		for j := i; j < len(tickers); j++ {
+			c := Correlation(returns[a], returns[tickers[j]])
+			m.Values[i][j], m.Values[j][i] = c, c
+		}
+	}
+	return m
+}
+
+// PortfolioReturns combines per-ticker return series with fixed weights, i.e. what the
+// current holdings would have returned each day. Series are used up to the shortest one;
+// weights are rescaled to sum to 1 over the tickers that have a series.
+func PortfolioReturns(weights map[string]float64, returns map[string][]float64) []float64 {
+	n, total := -1, 0.0
+	for t, w := range weights {
+		r, ok := returns[t]
+		if !ok || w <= 0 {
+			continue
+		}
+		if n < 0 || len(r) < n {
+			n = len(r)
+		}
+		total += w
+	}
+	if n <= 0 || total == 0 {
+		return nil
+	}
+	out := make([]float64, n)
+	for t, w := range weights {
+		r, ok := returns[t]
+		if !ok || w <= 0 {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			out[i] += w / total * r[i]
+		}
+	}
+	return out
+}
+
+// VaR is a one-day Value-at-Risk estimate. Losses are positive fractions of Value, and
+// the amounts are those fractions applied to Value.
+type VaR struct {
+	Confidence   float64 `json:"confidence"`
+	Observations int     `json:"observations"`
+	Value        float64 `json:"value"`
+	// Historical is the loss not exceeded on Confidence of the observed days.
+	Historical       float64 `json:"historical"`
+	HistoricalAmount float64 `json:"historical_amount"`
+	// ExpectedShortfall is the average loss on the days at or beyond Historical.
+	ExpectedShortfall       float64 `json:"expected_shortfall"`
+	ExpectedShortfallAmount float64 `json:"expected_shortfall_amount"`
+	// Parametric assumes normally distributed returns with the sample mean and deviation.
+	Parametric       float64 `json:"parametric"`
+	ParametricAmount float64 `json:"parametric_amount"`
+}
+
+// ValueAtRisk estimates one-day VaR for value from a daily return series. It returns nil
+// with fewer than two observations.
+func ValueAtRisk(returns []float64, confidence, value float64) (*VaR, error) {
+	if confidence <= 0.5 || confidence >= 1 {
+		return nil, ErrInvalidConfidence
+	}
+	if len(returns) < 2 {
+		return nil, nil
+	}
+	v := &VaR{
+		Confidence:        confidence,
+		Observations:      len(returns),
+		Value:             value,
+		Historical:        HistoricalVaR(returns, confidence),
+		ExpectedShortfall: ExpectedShortfall(returns, confidence),
+		Parametric:        ParametricVaR(returns, confidence),
+	}
+	v.HistoricalAmount = v.Historical * value
+	v.ExpectedShortfallAmount = v.ExpectedShortfall * value
+	v.ParametricAmount = v.Parametric * value
+	return v, nil
+}
+
+// HistoricalVaR is the loss at the (1 - confidence) quantile of returns, floored at zero.
+func HistoricalVaR(returns []float64, confidence float64) float64 {
+	sorted := tail(returns, confidence)
+	if len(sorted) == 0 {
+		return 0
+	}
+	return math.Max(0, -sorted[len(sorted)-1])
+}
+
+// ExpectedShortfall is the mean loss over the worst (1 - confidence) of returns, floored at
+// zero.
+func ExpectedShortfall(returns []float64, confidence float64) float64 {
+	sorted := tail(returns, confidence)
+	if len(sorted) == 0 {
+		return 0
+	}
+	return math.Max(0, -perf.Mean(sorted))
+}
+
+// ParametricVaR is the variance-covariance estimate -(mean - z*sd), floored at zero.
+func ParametricVaR(returns []float64, confidence float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+	return math.Max(0, -(perf.Mean(returns) - NormalQuantile(confidence)*perf.StdDev(returns)))
+}
+
+// NormalQuantile is the inverse of the standard normal CDF.
+func NormalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// tail returns the worst ceil(n * (1 - confidence)) returns in ascending order (at least
+// one), so the last element is the quantile.
+func tail(returns []float64, confidence float64) []float64 {
+	if len(returns) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), returns...)
+	sort.Float64s(sorted)
+	k := int(math.Ceil(float64(len(sorted))*(1-confidence) - 1e-9))
+	return sorted[:max(1, min(k, len(sorted)))]
+}
@@ -0,0 +1,124 @@
+package risk
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcentration(t *testing.T) {
+	w := Weights([]Holding{
+		{Ticker: "B", Value: 250},
+		{Ticker: "A", Value: 500},
+		{Ticker: "C", Value: 250},
+		{Ticker: "D", Value: 0},
+	})
+	require.Len(t, w, 3)
+	assert.Equal(t, []string{"A", "B", "C"}, []string{w[0].Ticker, w[1].Ticker, w[2].Ticker})
+
+	c := Concentrate(w, 2)
+	assert.Equal(t, 2, c.TopN)
+	assert.InDelta(t, 0.75, c.TopWeight, 1e-12)
+	assert.InDelta(t, 0.25+0.0625+0.0625, c.HHI, 1e-12)
+	assert.InDelta(t, 1/0.375, c.EffectiveHoldings, 1e-12)
+
+	// n larger than the portfolio is clamped.
+	assert.Equal(t, 3, Concentrate(w, 10).TopN)
+	assert.Equal(t, Concentration{Top: []Weight{}}, Concentrate(Weights(nil), 5))
+}
+
+func TestExposureBy(t *testing.T) {
+	holdings := []Holding{
+		{Ticker: "XOM", Value: 300, Sector: "Energy"},
+		{Ticker: "CVX", Value: 100, Sector: "Energy"},
+		{Ticker: "AAPL", Value: 500, Sector: "Technology"},
+		{Ticker: "ZZZ", Value: 100},
+	}
+	e := ExposureBy(holdings, func(h Holding) string { return h.Sector })
+	require.Len(t, e, 3)
+	assert.Equal(t, "Technology", e[0].Name)
+	assert.InDelta(t, 0.5, e[0].Weight, 1e-12)
+	assert.Equal(t, "Energy", e[1].Name)
+	assert.InDelta(t, 0.4, e[1].Weight, 1e-12)
+	assert.Equal(t, []string{"CVX", "XOM"}, e[1].Holdings)
+	assert.Equal(t, Unknown, e[2].Name)
+}
+
+func TestCorrelationMatrix(t *testing.T) {
+	base := []float64{0.01, -0.02, 0.015, 0.003, -0.007}
+	double := make([]float64, len(base))
+	inverse := make([]float64, len(base))
+	for i, r := range base {
+		double[i], inverse[i] = 2*r, -r
+	}
+	m := CorrelationMatrix([]string{"A", "B", "C", "D"}, map[string][]float64{
+		"A": base, "B": double, "C": inverse, "D": {0, 0, 0, 0, 0},
+	})
+	require.Len(t, m.Values, 4)
+	assert.InDelta(t, 1, *m.Values[0][0], 1e-12)
+	assert.InDelta(t, 1, *m.Values[0][1], 1e-12)
+	assert.InDelta(t, -1, *m.Values[0][2], 1e-12)
+	assert.InDelta(t, -1, *m.Values[2][1], 1e-12)
+	assert.Nil(t, m.Values[0][3])
+	assert.Nil(t, m.Values[3][3])
+}
+
+func TestPortfolioReturns(t *testing.T) {
+	r := PortfolioReturns(
+		map[string]float64{"A": 0.3, "B": 0.1, "C": 0.6},
+		map[string][]float64{"A": {0.02, -0.01, 0.5}, "B": {-0.02, 0.03}},
+	)
+	// C has no history so A and B are rescaled to 75/25; A's extra day is dropped.
+	require.Len(t, r, 2)
+	assert.InDelta(t, 0.75*0.02+0.25*-0.02, r[0], 1e-12)
+	assert.InDelta(t, 0.75*-0.01+0.25*0.03, r[1], 1e-12)
+	assert.Nil(t, PortfolioReturns(map[string]float64{"C": 1}, nil))
+}
+
+func TestHistoricalVaR(t *testing.T) {
+	// -5.0%, -4.9%, ... +4.9%: the 5 worst days at 95% are -5.0% to -4.6%.
+	returns := make([]float64, 100)
+	for i := range returns {
+		returns[i] = float64(i-50) / 1000
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(returns), func(i, j int) { returns[i], returns[j] = returns[j], returns[i] })
+
+	v, err := ValueAtRisk(returns, 0.95, 10000)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, 100, v.Observations)
+	assert.InDelta(t, 0.046, v.Historical, 1e-12)
+	assert.InDelta(t, 460, v.HistoricalAmount, 1e-9)
+	assert.InDelta(t, 0.048, v.ExpectedShortfall, 1e-12)
+	assert.GreaterOrEqual(t, v.ExpectedShortfall, v.Historical)
+
+	// A portfolio that never loses has no VaR.
+	assert.Equal(t, 0.0, HistoricalVaR([]float64{0.01, 0.02, 0.03}, 0.95))
+
+	_, err = ValueAtRisk(returns, 1.5, 10000)
+	assert.ErrorIs(t, err, ErrInvalidConfidence)
+	v, err = ValueAtRisk([]float64{0.01}, 0.95, 10000)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestParametricVaRMatchesNormalSample(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	returns := make([]float64, 20000)
+	for i := range returns {
+		returns[i] = 0.0005 + 0.02*rng.NormFloat64()
+	}
+
+	assert.InDelta(t, 1.6449, NormalQuantile(0.95), 1e-4)
+	assert.InDelta(t, 2.3263, NormalQuantile(0.99), 1e-4)
+
+	want := -(0.0005 - 1.6449*0.02)
+	assert.InDelta(t, want, ParametricVaR(returns, 0.95), 0.001)
+	// On normal data the historical estimate converges on the parametric one.
+	assert.InDelta(t, ParametricVaR(returns, 0.95), HistoricalVaR(returns, 0.95), 0.001)
+	assert.Greater(t, ParametricVaR(returns, 0.99), ParametricVaR(returns, 0.95))
+	assert.False(t, math.IsNaN(ParametricVaR(returns[:2], 0.99)))
+}
@@ -0,0 +1,101 @@
+package portfolio
+
+import (
+	"context"
+	"testing"
+
+	"stockchallenge/backend/internal/portfolio/risk"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type classifier struct {
+	staticSectors
+	industries map[string]string
+}
+
+func (c classifier) Industries(ctx context.Context, tickers []string) (map[string]string, error) {
+	return c.industries, nil
+}
+
+func TestRisk(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil, nil)
+	svc.SetSectorSource(classifier{
+		staticSectors: staticSectors{"AAA": "Technology", "BBB": "Technology"},
+		industries:    map[string]string{"AAA": "Software"},
+	})
+
+	price := 10.0
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "currency", "position", "average_price", "price"}).
+			AddRow("AAA", "USD", 10.0, 8.0, &price).
+			AddRow("BBB", "USD", 30.0, 9.0, &price))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}))
+	mock.ExpectQuery(`FROM daily_prices`).
+		WithArgs([]string{"BBB", "AAA", "SPY"}, d("2024-01-02").Add(-priceLookback), d("2024-01-05")).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "date", "close", "adj_close"}).
+			AddRow("AAA", d("2024-01-02"), 10.0, 10.0).
+			AddRow("AAA", d("2024-01-03"), 11.0, 11.0).
+			AddRow("AAA", d("2024-01-04"), 9.9, 9.9).
+			AddRow("AAA", d("2024-01-05"), 10.395, 10.395).
+			AddRow("BBB", d("2024-01-03"), 10.0, 10.0).
+			AddRow("BBB", d("2024-01-04"), 10.0, 10.0).
+			AddRow("BBB", d("2024-01-05"), 10.0, 10.0).
+			AddRow("SPY", d("2024-01-02"), 400.0, 400.0).
+			AddRow("SPY", d("2024-01-03"), 420.0, 420.0).
+			AddRow("SPY", d("2024-01-04"), 399.0, 399.0).
+			AddRow("SPY", d("2024-01-05"), 408.975, 408.975))
+
+	rep, err := svc.Risk(context.Background(), "user-1", RiskQuery{From: d("2024-01-02"), To: d("2024-01-05"), Benchmark: "spy", TopN: 1, Confidence: 0.9})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.InDelta(t, 400, rep.Value, 1e-9)
+	assert.InDelta(t, 0.75, rep.Concentration.TopWeight, 1e-12)
+	assert.InDelta(t, 0.625, rep.Concentration.HHI, 1e-12)
+	require.Len(t, rep.Sectors, 1)
+	assert.Equal(t, "Technology", rep.Sectors[0].Name)
+	require.Len(t, rep.Industries, 2)
+	assert.Equal(t, risk.Unknown, rep.Industries[0].Name)
+	assert.Equal(t, "Software", rep.Industries[1].Name)
+
+	// BBB has no close on the first day, so only AAA (a quarter of the value) is replayed.
+	assert.Equal(t, []string{"BBB"}, rep.MissingPrices)
+	assert.InDelta(t, 0.25, rep.Coverage, 1e-12)
+	assert.Equal(t, []string{"AAA"}, rep.Correlation.Tickers)
+	require.NotNil(t, rep.VaR)
+	assert.Equal(t, 3, rep.VaR.Observations)
+	assert.InDelta(t, 0.1, rep.VaR.Historical, 1e-9)
+	assert.InDelta(t, 10, rep.VaR.HistoricalAmount, 1e-9)
+
+	// AAA moves exactly twice as much as SPY each day.
+	require.NotNil(t, rep.Benchmark)
+	require.NotNil(t, rep.Benchmark.Beta)
+	assert.InDelta(t, 2, *rep.Benchmark.Beta, 1e-9)
+	assert.InDelta(t, 1, *rep.Benchmark.Correlation, 1e-9)
+	assert.Empty(t, rep.Warnings)
+}
+
+func TestRiskValidation(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil, nil)
+
+	_, err = svc.Risk(context.Background(), "user-1", RiskQuery{Confidence: 1.2})
+	assert.ErrorIs(t, err, risk.ErrInvalidConfidence)
+
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "currency", "position", "average_price", "price"}))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}))
+	_, err = svc.Risk(context.Background(), "user-1", RiskQuery{From: d("2024-01-02"), To: d("2024-01-05")})
+	assert.ErrorIs(t, err, ErrNoHoldings)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -4,19 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"strings"
+	"time"
 
 	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/portfolio/rebalance"
 
-	"github.com/google/generative-ai-go/genai"
 	"go.uber.org/zap"
-	"google.golang.org/api/option"
 )
 
 // PortfolioService defines the interface for portfolio operations.
 type PortfolioService interface {
-	ExtractAndSavePortfolio(ctx context.Context, userID string, imageData []byte) (*PositionsOut, error)
+	ExtractPortfolio(ctx context.Context, userID string, imageData []byte) (*PendingImport, error)
+	GetImport(ctx context.Context, userID, id string) (*PendingImport, error)
+	CommitImport(ctx context.Context, userID, id string, edits []ImportRow, mode string) (*PendingImport, error)
+	ImportPositions(ctx context.Context, userID string, rows []ImportRow, mode string) error
+	AddTransactions(ctx context.Context, userID string, txs []Transaction) ([]Transaction, error)
+	ListTransactions(ctx context.Context, userID string, to time.Time) ([]Transaction, error)
+	Performance(ctx context.Context, userID string, q PerformanceQuery) (*PerformanceReport, error)
+	Risk(ctx context.Context, userID string, q RiskQuery) (*RiskReport, error)
+	History(ctx context.Context, userID string, from, to time.Time, withPositions bool) ([]Snapshot, error)
+	BackfillSnapshots(ctx context.Context, userID string, today time.Time) (int, error)
+	GetTargets(ctx context.Context, userID string) (*TargetAllocation, error)
+	SetTargets(ctx context.Context, userID string, t TargetAllocation) (*TargetAllocation, error)
+	Rebalance(ctx context.Context, userID string, opts RebalanceOptions) (*rebalance.Plan, error)
+	CashBalances(ctx context.Context, userID string) ([]CashBalance, error)
+	SetCashBalance(ctx context.Context, userID, currency string, amount float64) (*CashBalance, error)
+	Summary(ctx context.Context, userID, base string) (*Summary, error)
+	Income(ctx context.Context, userID, base string, today time.Time) (*IncomeReport, error)
+	RecordDividends(ctx context.Context, userID string, today time.Time) (int, error)
 }
 
 // PositionsOut schema that matches the required JSON
@@ -24,83 +40,45 @@ type PositionsOut struct {
 	Instruments []string  `json:"INSTRUMENTS"`
 	Position    []float64 `json:"POSITION"`
 	AvgPrice    []float64 `json:"AVG PRICE"`
+	Currency    []string  `json:"CURRENCY,omitempty"`
 }
 
-const instruction = `
-You are a precise data-extraction engine.
-
-Goal: Return ONLY a JSON object with three arrays: "INSTRUMENTS", "POSITION", "AVG PRICE".
-Use the table under the "Positions" header. Columns to read: INSTRUMENT (ticker only), POSITION, AVG PRICE.
-Ignore all other columns.
-
-Normalization:
-- INSTRUMENTS: extract the ticker only; drop venue/exchange suffixes (e.g., "NASDAQ.NMS", "NYSE", "ARCA").
-- POSITION and AVG PRICE: numbers only; drop currency letters/symbols; use "." as decimal; no thousands separators.
-- If a row lacks POSITION or AVG PRICE, omit that row.
-- Keep original visual order from top to bottom.
-
-Return just the JSON.
-`
-
 type Service struct {
-	DB    db.DBTX
-	Log   *zap.SugaredLogger
-	GenAI *genai.GenerativeModel
+	DB        db.DBTX
+	Log       *zap.SugaredLogger
+	Extractor Extractor
+	Sectors   SectorSource
+	FX        FXRateProvider
+	Dividends DividendProvider
+
+	fxTTL        time.Duration
+	baseCurrency string
 }
 
-func NewService(db db.DBTX, log *zap.SugaredLogger, apiKey, modelID string) (*Service, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		return nil, fmt.Errorf("genai.NewClient: %w", err)
-	}
+// NewService wires the portfolio service. extractor may be nil, in which case screenshot
+// uploads are disabled and ExtractPortfolio returns ErrExtractorUnavailable.
+func NewService(db db.DBTX, log *zap.SugaredLogger, extractor Extractor) *Service {
+	return &Service{
+		DB:        db,
+		Log:       log,
+		Extractor: extractor,
 
-	model := client.GenerativeModel(modelID)
-	model.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{genai.Text(instruction)},
-	}
-	model.GenerationConfig = genai.GenerationConfig{
-		ResponseMIMEType: "application/json",
-		ResponseSchema: &genai.Schema{
-			Type: genai.TypeObject,
-			Properties: map[string]*genai.Schema{
-				"INSTRUMENTS": {
-					Type:  genai.TypeArray,
-					Items: &genai.Schema{Type: genai.TypeString},
-				},
-				"POSITION": {
-					Type:  genai.TypeArray,
-					Items: &genai.Schema{Type: genai.TypeNumber},
-				},
-				"AVG PRICE": {
-					Type:  genai.TypeArray,
-					Items: &genai.Schema{Type: genai.TypeNumber},
-				},
-			},
-			Required: []string{"POSITION", "AVG PRICE"},
-		},
+		fxTTL:        12 * time.Hour,
+		baseCurrency: DefaultCurrency,
 	}
-
-	return &Service{
-		DB:    db,
-		Log:   log,
-		GenAI: model,
-	}, nil
 }
 
-func (s *Service) ExtractAndSavePortfolio(ctx context.Context, userID string, imageData []byte) (*PositionsOut, error) {
-	mimeType := http.DetectContentType(imageData)
-	format := extractImageFormat(mimeType)
-	resp, err := s.GenAI.GenerateContent(
-		ctx,
-		genai.ImageData(format, imageData),
-		genai.Text("Extract the three arrays from this screenshot."),
-	)
+// ExtractPortfolio runs the model over a screenshot and stores the result as a pending
+// import. Nothing is written to the portfolio until the import is committed.
+func (s *Service) ExtractPortfolio(ctx context.Context, userID string, imageData []byte) (*PendingImport, error) {
+	if s.Extractor == nil {
+		return nil, ErrExtractorUnavailable
+	}
+	jsonText, err := s.Extractor.Extract(ctx, imageData)
 	if err != nil {
-		return nil, fmt.Errorf("GenerateContent: %w", err)
+		return nil, err
 	}
-
-	jsonText := extractText(resp)
+	jsonText = stripCodeFence(jsonText)
 	if strings.TrimSpace(jsonText) == "" {
 		return nil, fmt.Errorf("model returned empty text")
 	}
@@ -110,66 +88,24 @@ func (s *Service) ExtractAndSavePortfolio(ctx context.Context, userID string, im
 		return nil, fmt.Errorf("unmarshal model JSON: %w, raw=%s", err, jsonText)
 	}
 
-	if err := s.savePortfolio(ctx, userID, &out); err != nil {
-		return nil, fmt.Errorf("failed to save portfolio: %w", err)
+	rows, issues := reviewRows(&out)
+	imp, err := s.createImport(ctx, userID, SourceScreenshot, jsonText, rows, issues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store import: %w", err)
 	}
-
-	return &out, nil
+	return imp, nil
 }
 
-func (s *Service) savePortfolio(ctx context.Context, userID string, data *PositionsOut) error {
+// ImportPositions writes positions parsed from a broker statement in a single transaction.
+func (s *Service) ImportPositions(ctx context.Context, userID string, rows []ImportRow, mode string) error {
 	tx, err := s.DB.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	q := `
-	INSERT INTO portfolio (user_id, ticker, position, average_price)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (user_id, ticker) DO UPDATE SET
-		position = EXCLUDED.position,
-		average_price = EXCLUDED.average_price,
-		updated_at = now()
-	`
-
-	for i := range data.Instruments {
-		if _, err := tx.Exec(ctx, q, userID, data.Instruments[i], data.Position[i], data.AvgPrice[i]); err != nil {
-			return err
-		}
+	if err := writePositions(ctx, tx, userID, rows, mode); err != nil {
+		return err
 	}
-
 	return tx.Commit(ctx)
 }
-
-// extractImageFormat converts MIME type to format string expected by Gemini AI
-func extractImageFormat(mimeType string) string {
-	switch mimeType {
-	case "image/jpeg":
-		return "jpeg"
-	case "image/png":
-		return "png"
-	case "image/webp":
-		return "webp"
-	case "image/gif":
-		return "gif"
-	default:
-		// Default to jpeg for unknown types
-		return "jpeg"
-	}
-}
-
-func extractText(resp *genai.GenerateContentResponse) string {
-	var b strings.Builder
-	for _, c := range resp.Candidates {
-		if c.Content == nil {
-			continue
-		}
-		for _, p := range c.Content.Parts {
-			if t, ok := p.(genai.Text); ok {
-				b.WriteString(string(t))
-			}
-		}
-	}
-	return b.String()
-}
@@ -0,0 +1,321 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio/perf"
+
+	"go.uber.org/zap"
+)
+
+// Snapshot sources.
+const (
+	SnapshotLive     = "live"
+	SnapshotBackfill = "backfill"
+)
+
+// SnapshotPosition is one holding inside a snapshot. Price is in Currency and Value in the
+// snapshot's base currency; Value is 0 (and left out of the totals) when there was no FX rate.
+// PriceMissing is set when no quote was available and the holding was valued at its average
+// price.
+type SnapshotPosition struct {
+	Ticker       string  `json:"ticker"`
+	Currency     string  `json:"currency,omitempty"`
+	Position     float64 `json:"position"`
+	Price        float64 `json:"price"`
+	Value        float64 `json:"value"`
+	PriceMissing bool    `json:"price_missing,omitempty"`
+}
+
+// Snapshot is the portfolio value at the end of Date, in the service's base currency.
+type Snapshot struct {
+	Date       time.Time          `json:"date"`
+	TotalValue float64            `json:"total_value"`
+	CostBasis  float64            `json:"cost_basis"`
+	Source     string             `json:"source"`
+	Positions  []SnapshotPosition `json:"positions,omitempty"`
+}
+
+// SnapshotAll records today's value for every user with positions, using the latest cached
+// quotes converted into the base currency. Re-running on the same day overwrites that day's
+// row.
+func (s *Service) SnapshotAll(ctx context.Context, day time.Time) (int, error) {
+	day = truncateDay(day)
+	rows, err := s.DB.Query(ctx, `
+SELECT p.user_id::STRING, p.ticker, p.currency, p.position, p.average_price, q.price
+FROM portfolio p
+LEFT JOIN quotes_cache q ON q.symbol = p.ticker
+ORDER BY p.user_id, p.ticker
+`)
+	if err != nil {
+		return 0, err
+	}
+	type held struct {
+		sp  SnapshotPosition
+		avg float64
+	}
+	byUser := map[string][]held{}
+	var users []string
+	for rows.Next() {
+		var (
+			userID string
+			h      held
+			price  *float64
+		)
+		if err := rows.Scan(&userID, &h.sp.Ticker, &h.sp.Currency, &h.sp.Position, &h.avg, &price); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		h.sp.Price, h.sp.PriceMissing = h.avg, price == nil
+		if price != nil {
+			h.sp.Price = *price
+		}
+		if _, ok := byUser[userID]; !ok {
+			users = append(users, userID)
+		}
+		byUser[userID] = append(byUser[userID], h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Rates are looked up once the rows are closed; they are shared by every user.
+	base, _ := s.resolveBase("")
+	var warnings []string
+	rate := s.converter(ctx, base, map[string]float64{}, &warnings)
+	for _, u := range users {
+		snap := &Snapshot{Date: day, Source: SnapshotLive, Positions: []SnapshotPosition{}}
+		for _, h := range byUser[u] {
+			if r, ok := rate(h.sp.Currency); ok {
+				h.sp.Value = h.sp.Position * h.sp.Price * r
+				snap.TotalValue += h.sp.Value
+				snap.CostBasis += h.sp.Position * h.avg * r
+			}
+			snap.Positions = append(snap.Positions, h.sp)
+		}
+		if err := s.saveSnapshot(ctx, u, snap, true); err != nil {
+			return 0, err
+		}
+	}
+	if s.Log != nil {
+		for _, w := range warnings {
+			s.Log.Warnf("portfolio snapshot: %s", w)
+		}
+	}
+	return len(users), nil
+}
+
+// BackfillSnapshots rebuilds daily snapshots from the transaction ledger and daily closes,
+// from the first trade up to the day before today. Earlier backfilled days are rebuilt, but
+// live snapshots are never replaced by reconstructed ones.
+func (s *Service) BackfillSnapshots(ctx context.Context, userID string, today time.Time) (int, error) {
+	end := truncateDay(today).AddDate(0, 0, -1)
+	txs, err := s.ListTransactions(ctx, userID, end)
+	if err != nil || len(txs) == 0 {
+		return 0, err
+	}
+	symbols := map[string]bool{}
+	for _, t := range txs {
+		symbols[t.Ticker] = true
+	}
+	start := txs[0].TradeDate
+	closes, _, err := s.loadDailyPrices(ctx, keys(symbols), start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	base, _ := s.resolveBase("")
+	var warnings []string
+	rate := s.converter(ctx, base, map[string]float64{}, &warnings)
+	for _, t := range txs {
+		rate(currencyOrDefault(t.Currency)) // look every rate up before replaying
+	}
+	if s.Log != nil {
+		for _, w := range warnings {
+			s.Log.Warnf("portfolio backfill for user %s: %s", userID, w)
+		}
+	}
+
+	written := 0
+	for _, snap := range replaySnapshots(txs, closes, closes.Dates(start, end), rate) {
+		if err := s.saveSnapshot(ctx, userID, &snap, false); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// BackfillAll runs BackfillSnapshots for every user with transactions.
+func (s *Service) BackfillAll(ctx context.Context, today time.Time) error {
+	rows, err := s.DB.Query(ctx, `SELECT DISTINCT user_id::STRING FROM portfolio_transactions`)
+	if err != nil {
+		return err
+	}
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err == nil {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+	for _, u := range users {
+		n, err := s.BackfillSnapshots(ctx, u, today)
+		if err != nil {
+			return err
+		}
+		if n > 0 && s.Log != nil {
+			s.Log.Infof("backfilled %d snapshots for user %s", n, u)
+		}
+	}
+	return nil
+}
+
+// replaySnapshots walks the ledger across dates and values the holdings at each close,
+// tracking cost basis with the average-cost method. Values are converted into the base
+// currency with rate (today's rates, not historical ones); holdings without a rate are left
+// out of the totals.
+func replaySnapshots(txs []Transaction, closes perf.Prices, dates []time.Time, rate func(cur string) (float64, bool)) []Snapshot {
+	held := map[string]float64{}
+	cost := map[string]float64{}
+	currency := map[string]string{}
+	out := make([]Snapshot, 0, len(dates))
+	k := 0
+	for _, d := range dates {
+		for ; k < len(txs) && !txs[k].TradeDate.After(d); k++ {
+			t := txs[k]
+			if t.Type == TxSell && held[t.Ticker] > 0 {
+				// Sells release cost in proportion to the shares sold.
+				frac := min(t.Quantity/held[t.Ticker], 1)
+				cost[t.Ticker] -= cost[t.Ticker] * frac
+			} else if t.Type == TxBuy {
+				cost[t.Ticker] += t.cashAmount()
+			}
+			held[t.Ticker] += t.signedQuantity()
+			currency[t.Ticker] = currencyOrDefault(t.Currency)
+		}
+
+		snap := Snapshot{Date: d, Source: SnapshotBackfill, Positions: []SnapshotPosition{}}
+		tickers := make([]string, 0, len(held))
+		for tk, q := range held {
+			if q != 0 {
+				tickers = append(tickers, tk)
+			}
+		}
+		sort.Strings(tickers)
+		for _, tk := range tickers {
+			q := held[tk]
+			sp := SnapshotPosition{Ticker: tk, Currency: currency[tk], Position: q}
+			if px, ok := closes.On(tk, d); ok {
+				sp.Price = px
+			} else {
+				sp.Price, sp.PriceMissing = cost[tk]/q, true
+			}
+			if r, ok := rate(sp.Currency); ok {
+				sp.Value = q * sp.Price * r
+				snap.TotalValue += sp.Value
+				snap.CostBasis += cost[tk] * r
+			}
+			snap.Positions = append(snap.Positions, sp)
+		}
+		out = append(out, snap)
+	}
+	return out
+}
+
+// saveSnapshot upserts a snapshot. Without overwrite only previously backfilled rows are
+// replaced.
+func (s *Service) saveSnapshot(ctx context.Context, userID string, snap *Snapshot, overwrite bool) error {
+	posJSON, err := json.Marshal(snap.Positions)
+	if err != nil {
+		return err
+	}
+	q := `
+INSERT INTO portfolio_snapshots (user_id, date, total_value, cost_basis, positions, source)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, date) DO UPDATE SET
+	total_value = EXCLUDED.total_value,
+	cost_basis = EXCLUDED.cost_basis,
+	positions = EXCLUDED.positions,
+	source = EXCLUDED.source,
+	created_at = now()
+`
+	if !overwrite {
+		q += `WHERE portfolio_snapshots.source = 'backfill'
+`
+	}
+	_, err = s.DB.Exec(ctx, q, userID, snap.Date, snap.TotalValue, snap.CostBasis, posJSON, snap.Source)
+	return err
+}
+
+// History returns the user's snapshots between from and to, oldest first. Positions are
+// only included when withPositions is set to keep the equity curve payload small.
+func (s *Service) History(ctx context.Context, userID string, from, to time.Time, withPositions bool) ([]Snapshot, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT date, total_value, cost_basis, source, positions
+FROM portfolio_snapshots
+WHERE user_id = $1 AND date >= $2 AND date <= $3
+ORDER BY date
+`, userID, truncateDay(from), truncateDay(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Snapshot, 0, 256)
+	for rows.Next() {
+		var (
+			snap    Snapshot
+			posJSON []byte
+		)
+		if err := rows.Scan(&snap.Date, &snap.TotalValue, &snap.CostBasis, &snap.Source, &posJSON); err != nil {
+			return nil, err
+		}
+		if withPositions {
+			if err := json.Unmarshal(posJSON, &snap.Positions); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, snap)
+	}
+	return out, rows.Err()
+}
+
+// StartSnapshotCron backfills from the ledger once, then snapshots every user immediately
+// and again on each tick until stop is closed.
+func StartSnapshotCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		n, err := svc.SnapshotAll(ctx, time.Now().UTC())
+		if err != nil {
+			log.Warnf("portfolio snapshot error: %v", err)
+			return
+		}
+		log.Infof("portfolio snapshot stored for %d users", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if err := svc.BackfillAll(ctx, time.Now().UTC()); err != nil {
+		log.Warnf("portfolio snapshot backfill error: %v", err)
+	}
+	cancel()
+	run()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			run()
+		case <-stop:
+			log.Infof("snapshot cron stopped")
+			return
+		}
+	}
+}
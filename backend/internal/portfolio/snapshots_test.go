@@ -0,0 +1,120 @@
+package portfolio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio/perf"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaySnapshotsAverageCost(t *testing.T) {
+	txs := []Transaction{
+		{Ticker: "AAA", Type: TxBuy, Quantity: 10, Price: 10, Fees: 0, TradeDate: d("2024-01-02")},
+		{Ticker: "AAA", Type: TxBuy, Quantity: 10, Price: 20, Fees: 0, TradeDate: d("2024-01-03")},
+		{Ticker: "AAA", Type: TxSell, Quantity: 5, Price: 25, Fees: 0, TradeDate: d("2024-01-04")},
+		{Ticker: "BBB", Type: TxBuy, Quantity: 1, Price: 40, Fees: 0, TradeDate: d("2024-01-04")},
+	}
+	closes := perf.Prices{"AAA": {
+		{Date: d("2024-01-02"), Value: 10},
+		{Date: d("2024-01-03"), Value: 20},
+		{Date: d("2024-01-04"), Value: 25},
+	}}
+
+	same := func(string) (float64, bool) { return 1, true }
+	snaps := replaySnapshots(txs, closes, closes.Dates(d("2024-01-01"), d("2024-01-31")), same)
+	require.Len(t, snaps, 3)
+	assert.Equal(t, 100.0, snaps[0].TotalValue)
+	assert.Equal(t, 400.0, snaps[1].TotalValue)
+	assert.Equal(t, 300.0, snaps[1].CostBasis)
+
+	last := snaps[2]
+	require.Len(t, last.Positions, 2)
+	// 15 AAA at 25 plus BBB without a close, valued at cost
+	assert.Equal(t, 415.0, last.TotalValue)
+	assert.Equal(t, 225.0+40.0, last.CostBasis)
+	assert.True(t, last.Positions[1].PriceMissing)
+	assert.Equal(t, SnapshotBackfill, last.Source)
+}
+
+func TestSnapshotAll(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	p := 200.0
+	mock.ExpectQuery(`FROM portfolio p\s+LEFT JOIN quotes_cache`).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "ticker", "currency", "position", "average_price", "price"}).
+			AddRow("user-1", "AAPL", "USD", 2.0, 150.0, &p).
+			AddRow("user-1", "XYZ", "USD", 1.0, 10.0, (*float64)(nil)).
+			AddRow("user-2", "AAPL", "USD", 1.0, 100.0, &p))
+	day := d("2024-03-01")
+	mock.ExpectExec(`INSERT INTO portfolio_snapshots`).
+		WithArgs("user-1", day, 410.0, 310.0, pgxmock.AnyArg(), SnapshotLive).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`INSERT INTO portfolio_snapshots`).
+		WithArgs("user-2", day, 200.0, 100.0, pgxmock.AnyArg(), SnapshotLive).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	n, err := svc.SnapshotAll(context.Background(), day.Add(15*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnapshotAllConvertsCurrencies(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+	svc.SetBaseCurrency("USD")
+
+	p, sap := 200.0, 100.0
+	mock.ExpectQuery(`FROM portfolio p\s+LEFT JOIN quotes_cache`).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "ticker", "currency", "position", "average_price", "price"}).
+			AddRow("user-1", "AAPL", "USD", 2.0, 150.0, &p).
+			AddRow("user-1", "SAP", "EUR", 3.0, 80.0, &sap).
+			AddRow("user-1", "TM", "JPY", 1.0, 2000.0, (*float64)(nil)))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.5, time.Now()))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("JPY", "USD").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("USD", "JPY").WillReturnError(pgx.ErrNoRows)
+	day := d("2024-03-01")
+	// 400 USD + 300 EUR at 1.5; the JPY position has no rate and is left out.
+	mock.ExpectExec(`INSERT INTO portfolio_snapshots`).
+		WithArgs("user-1", day, 400.0+450.0, 300.0+360.0, pgxmock.AnyArg(), SnapshotLive).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	n, err := svc.SnapshotAll(context.Background(), day)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplaySnapshotsConvertsCurrencies(t *testing.T) {
+	txs := []Transaction{
+		{Ticker: "AAA", Type: TxBuy, Quantity: 10, Price: 10, Currency: "USD", TradeDate: d("2024-01-02")},
+		{Ticker: "SAP", Type: TxBuy, Quantity: 2, Price: 100, Currency: "EUR", TradeDate: d("2024-01-02")},
+	}
+	closes := perf.Prices{
+		"AAA": {{Date: d("2024-01-02"), Value: 10}},
+		"SAP": {{Date: d("2024-01-02"), Value: 110}},
+	}
+	eur := func(cur string) (float64, bool) {
+		if cur == "EUR" {
+			return 1.5, true
+		}
+		return 1, true
+	}
+	snaps := replaySnapshots(txs, closes, closes.Dates(d("2024-01-01"), d("2024-01-31")), eur)
+	require.Len(t, snaps, 1)
+	assert.InDelta(t, 100+330, snaps[0].TotalValue, 1e-9)
+	assert.InDelta(t, 100+300, snaps[0].CostBasis, 1e-9)
+	assert.Equal(t, "EUR", snaps[0].Positions[1].Currency)
+}
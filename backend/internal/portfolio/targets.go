@@ -0,0 +1,246 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"stockchallenge/backend/internal/portfolio/rebalance"
+)
+
+// Target kinds stored in portfolio_targets.
+const (
+	TargetTicker = "ticker"
+	TargetSector = "sector"
+)
+
+// ErrSectorDataUnavailable is returned when sector targets are used but no SectorSource
+// is configured.
+var ErrSectorDataUnavailable = errors.New("sector data is not available")
+
+// SectorSource classifies tickers into sectors. Unknown tickers are left out of the map.
+type SectorSource interface {
+	Sectors(ctx context.Context, tickers []string) (map[string]string, error)
+}
+
+// SetSectorSource enables sector targets for rebalancing.
+func (s *Service) SetSectorSource(src SectorSource) {
+	s.Sectors = src
+}
+
+// TargetAllocation holds a user's target weights (0..1) by ticker and by sector.
+type TargetAllocation struct {
+	Tickers map[string]float64 `json:"tickers"`
+	Sectors map[string]float64 `json:"sectors"`
+}
+
+func (t *TargetAllocation) normalize() {
+	tickers := make(map[string]float64, len(t.Tickers))
+	for k, w := range t.Tickers {
+		tickers[normalizeTicker(k)] += w
+	}
+	sectors := make(map[string]float64, len(t.Sectors))
+	for k, w := range t.Sectors {
+		sectors[strings.TrimSpace(k)] += w
+	}
+	t.Tickers, t.Sectors = tickers, sectors
+}
+
+func (t *TargetAllocation) targets() rebalance.Targets {
+	return rebalance.Targets{Tickers: t.Tickers, Sectors: t.Sectors}
+}
+
+// RebalanceOptions are the per-request knobs for Rebalance.
+type RebalanceOptions struct {
+	// Targets overrides the stored targets for this request when set.
+	Targets *TargetAllocation
+	// Base is the currency the plan is priced in, and CashContribution and MinTrade are given
+	// in; the service default when empty.
+	Base string
+	// CashContribution is new money to invest alongside the current holdings and cash balances.
+	CashContribution float64
+	MinTrade         float64
+	WholeShares      bool
+	AllowSells       bool
+}
+
+// GetTargets returns the user's stored target weights.
+func (s *Service) GetTargets(ctx context.Context, userID string) (*TargetAllocation, error) {
+	rows, err := s.DB.Query(ctx, `SELECT kind, key, weight FROM portfolio_targets WHERE user_id = $1 ORDER BY kind, key`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := &TargetAllocation{Tickers: map[string]float64{}, Sectors: map[string]float64{}}
+	for rows.Next() {
+		var (
+			kind, key string
+			weight    float64
+		)
+		if err := rows.Scan(&kind, &key, &weight); err != nil {
+			return nil, err
+		}
+		if kind == TargetSector {
+			out.Sectors[key] = weight
+		} else {
+			out.Tickers[key] = weight
+		}
+	}
+	return out, rows.Err()
+}
+
+// SetTargets replaces the user's target weights.
+func (s *Service) SetTargets(ctx context.Context, userID string, t TargetAllocation) (*TargetAllocation, error) {
+	t.normalize()
+	if err := rebalance.Validate(t.targets()); err != nil {
+		return nil, err
+	}
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM portfolio_targets WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	q := `INSERT INTO portfolio_targets (user_id, kind, key, weight) VALUES ($1, $2, $3, $4)`
+	for k, w := range t.Tickers {
+		if _, err := tx.Exec(ctx, q, userID, TargetTicker, k, w); err != nil {
+			return nil, err
+		}
+	}
+	for k, w := range t.Sectors {
+		if _, err := tx.Exec(ctx, q, userID, TargetSector, k, w); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Rebalance compares the user's positions at cached quotes with their targets and returns
+// the trades that bring them back in line. Prices and cash balances are converted into the
+// base currency; positions and cash without an FX rate are left out and reported in the
+// plan's warnings.
+func (s *Service) Rebalance(ctx context.Context, userID string, opts RebalanceOptions) (*rebalance.Plan, error) {
+	base, err := s.resolveBase(opts.Base)
+	if err != nil {
+		return nil, err
+	}
+	targets := opts.Targets
+	if targets == nil {
+		stored, err := s.GetTargets(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		targets = stored
+	}
+	targets.normalize()
+
+	priced, err := s.pricedHoldings(ctx, userID, keysOf(targets.Tickers))
+	if err != nil {
+		return nil, err
+	}
+	cash, err := s.CashBalances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []string
+	rate := s.converter(ctx, base, map[string]float64{}, &warnings)
+	holdings := make([]rebalance.Holding, 0, len(priced))
+	for _, p := range priced {
+		if r, ok := rate(p.currency); ok {
+			p.Price *= r
+			holdings = append(holdings, p.Holding)
+		}
+	}
+	available := opts.CashContribution
+	for _, c := range cash {
+		if r, ok := rate(c.Currency); ok {
+			available += c.Amount * r
+		}
+	}
+
+	if len(targets.Sectors) > 0 {
+		if s.Sectors == nil {
+			return nil, ErrSectorDataUnavailable
+		}
+		tickers := make([]string, 0, len(holdings))
+		for _, h := range holdings {
+			tickers = append(tickers, h.Ticker)
+		}
+		sectors, err := s.Sectors.Sectors(ctx, tickers)
+		if err != nil {
+			return nil, err
+		}
+		for i := range holdings {
+			holdings[i].Sector = sectors[holdings[i].Ticker]
+		}
+	}
+
+	plan, err := rebalance.Compute(holdings, targets.targets(), rebalance.Options{
+		Cash:        available,
+		MinTrade:    opts.MinTrade,
+		WholeShares: opts.WholeShares,
+		NoSells:     !opts.AllowSells,
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.BaseCurrency = base
+	sort.Strings(warnings)
+	plan.Warnings = append(plan.Warnings, warnings...)
+	return plan, nil
+}
+
+// pricedHolding is a holding priced in its own currency.
+type pricedHolding struct {
+	rebalance.Holding
+	currency string
+}
+
+// pricedHoldings returns the user's positions plus zero-quantity entries for extra tickers,
+// priced from quotes_cache in the position's currency (the security's, or the default, for
+// tickers not held). Tickers without a cached quote get price 0 and are reported by
+// rebalance.Compute.
+func (s *Service) pricedHoldings(ctx context.Context, userID string, extra []string) ([]pricedHolding, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT t.ticker, COALESCE(p.position, 0), COALESCE(q.price, 0), COALESCE(p.currency, NULLIF(sec.currency, ''), $3)
+FROM (
+	SELECT ticker FROM portfolio WHERE user_id = $1
+	UNION
+	SELECT unnest($2::STRING[]) AS ticker
+) t
+LEFT JOIN portfolio p ON p.user_id = $1 AND p.ticker = t.ticker
+LEFT JOIN quotes_cache q ON q.symbol = t.ticker
+LEFT JOIN securities sec ON sec.symbol = t.ticker
+ORDER BY t.ticker
+`, userID, extra, DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []pricedHolding
+	for rows.Next() {
+		var h pricedHolding
+		if err := rows.Scan(&h.Ticker, &h.Quantity, &h.Price, &h.currency); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+func keysOf(m map[string]float64) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package portfolio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/portfolio/rebalance"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticSectors map[string]string
+
+func (s staticSectors) Sectors(ctx context.Context, tickers []string) (map[string]string, error) {
+	return s, nil
+}
+
+func TestRebalanceStoredTargets(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	mock.ExpectQuery(`FROM portfolio_targets`).
+		WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"kind", "key", "weight"}).
+			AddRow(TargetTicker, "AAPL", 0.5).
+			AddRow(TargetSector, "Energy", 0.5))
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).
+		WithArgs("user-1", []string{"AAPL"}, DefaultCurrency).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "price", "currency"}).
+			AddRow("AAPL", 10.0, 100.0, "USD").
+			AddRow("XOM", 0.0, 50.0, "USD"))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}))
+
+	// Sector targets need a classifier.
+	_, err = svc.Rebalance(context.Background(), "user-1", RebalanceOptions{WholeShares: true, AllowSells: true})
+	assert.ErrorIs(t, err, ErrSectorDataUnavailable)
+
+	svc.SetSectorSource(staticSectors{"AAPL": "Technology", "XOM": "Energy"})
+	mock.ExpectQuery(`FROM portfolio_targets`).
+		WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"kind", "key", "weight"}).
+			AddRow(TargetTicker, "AAPL", 0.5).
+			AddRow(TargetSector, "Energy", 0.5))
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).
+		WithArgs("user-1", []string{"AAPL"}, DefaultCurrency).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "price", "currency"}).
+			AddRow("AAPL", 10.0, 100.0, "USD").
+			AddRow("XOM", 0.0, 50.0, "USD"))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}))
+
+	plan, err := svc.Rebalance(context.Background(), "user-1", RebalanceOptions{CashContribution: 1000, WholeShares: true, AllowSells: true})
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 1)
+	assert.Equal(t, "XOM", plan.Trades[0].Ticker)
+	assert.Equal(t, 20.0, plan.Trades[0].Shares)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRebalanceConvertsCurrencies(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := &Service{DB: mock}
+
+	targets := &TargetAllocation{Tickers: map[string]float64{"AAPL": 0.5, "SAP": 0.5}}
+	mock.ExpectQuery(`LEFT JOIN quotes_cache`).
+		WithArgs("user-1", pgxmock.AnyArg(), DefaultCurrency).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "position", "price", "currency"}).
+			AddRow("AAPL", 10.0, 100.0, "USD").
+			AddRow("SAP", 0.0, 100.0, "EUR"))
+	mock.ExpectQuery(`FROM cash_balances`).WithArgs("user-1").
+		WillReturnRows(pgxmock.NewRows([]string{"currency", "amount", "updated_at"}).
+			AddRow("EUR", 1000.0, time.Now()))
+	mock.ExpectQuery(`FROM fx_rates`).WithArgs("EUR", "USD").
+		WillReturnRows(pgxmock.NewRows([]string{"rate", "updated_at"}).AddRow(1.5, time.Now()))
+
+	// 1000 USD of AAPL plus 1000 EUR of cash (1500 USD): SAP at 150 USD gets half of 2500.
+	plan, err := svc.Rebalance(context.Background(), "user-1", RebalanceOptions{Targets: targets, AllowSells: true})
+	require.NoError(t, err)
+	assert.Equal(t, "USD", plan.BaseCurrency)
+	assert.InDelta(t, 2500, plan.TotalValue, 1e-9)
+	assert.InDelta(t, 1500, plan.CashBefore, 1e-9)
+	var sap *rebalance.Trade
+	for i := range plan.Trades {
+		if plan.Trades[i].Ticker == "SAP" {
+			sap = &plan.Trades[i]
+		}
+	}
+	require.NotNil(t, sap)
+	assert.Equal(t, "buy", sap.Action)
+	assert.InDelta(t, 150, sap.Price, 1e-9)
+	assert.InDelta(t, 1250.0/150, sap.Shares, 1e-9)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = svc.Rebalance(context.Background(), "user-1", RebalanceOptions{Base: "euro"})
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+}
+
+func TestSetTargetsRejectsOverweight(t *testing.T) {
+	svc := &Service{}
+	_, err := svc.SetTargets(context.Background(), "user-1", TargetAllocation{Tickers: map[string]float64{"aapl": 0.6, "AAPL ": 0.6}})
+	assert.Error(t, err)
+}
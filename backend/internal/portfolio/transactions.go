@@ -0,0 +1,152 @@
+package portfolio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transaction types.
+const (
+	TxBuy      = "buy"
+	TxSell     = "sell"
+	TxDividend = "dividend"
+)
+
+// Transaction is a dated trade or dividend in the user's ledger. The ledger feeds performance reporting
+// and does not change the positions table, which keeps reflecting the latest import.
+type Transaction struct {
+	ID        string    `json:"id"`
+	Ticker    string    `json:"ticker"`
+	Type      string    `json:"type"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Fees      float64   `json:"fees"`
+	Currency  string    `json:"currency"`
+	TradeDate time.Time `json:"trade_date"`
+	Note      *string   `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InvalidTransactionError reports the first problem found in a batch of transactions.
+type InvalidTransactionError struct {
+	Index  int
+	Reason string
+}
+
+func (e *InvalidTransactionError) Error() string {
+	return fmt.Sprintf("transaction %d: %s", e.Index, e.Reason)
+}
+
+// normalize validates t in place. Quantity is stored as a positive number; the type carries
+// the direction.
+func (t *Transaction) normalize(i int) error {
+	t.Ticker = normalizeTicker(t.Ticker)
+	t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+	switch {
+	case t.Type != TxBuy && t.Type != TxSell && t.Type != TxDividend:
+		return &InvalidTransactionError{i, "type must be buy, sell or dividend"}
+	case !tickerPattern.MatchString(t.Ticker):
+		return &InvalidTransactionError{i, fmt.Sprintf("invalid ticker %q", t.Ticker)}
+	case t.Quantity <= 0:
+		return &InvalidTransactionError{i, "quantity must be positive"}
+	case t.Price <= 0:
+		return &InvalidTransactionError{i, "price must be positive"}
+	case t.Fees < 0:
+		return &InvalidTransactionError{i, "fees cannot be negative"}
+	case t.TradeDate.IsZero():
+		return &InvalidTransactionError{i, "trade_date is required"}
+	}
+	cur, err := normalizeCurrency(t.Currency)
+	if err != nil {
+		return &InvalidTransactionError{i, fmt.Sprintf("invalid currency %q", t.Currency)}
+	}
+	t.Currency = currencyOrDefault(cur)
+	t.TradeDate = truncateDay(t.TradeDate)
+	return nil
+}
+
+// signedQuantity is positive for buys and negative for sells. Dividends do not change the
+// shares held.
+func (t Transaction) signedQuantity() float64 {
+	switch t.Type {
+	case TxSell:
+		return -t.Quantity
+	case TxDividend:
+		return 0
+	}
+	return t.Quantity
+}
+
+// cashAmount is the cash that left the user's pocket: cost plus fees for buys, minus the
+// net proceeds for sells and dividends (fees on a dividend are withholding tax).
+func (t Transaction) cashAmount() float64 {
+	if t.Type == TxSell || t.Type == TxDividend {
+		return -(t.Quantity*t.Price - t.Fees)
+	}
+	return t.Quantity*t.Price + t.Fees
+}
+
+// AddTransactions validates and stores txs in one database transaction.
+func (s *Service) AddTransactions(ctx context.Context, userID string, txs []Transaction) ([]Transaction, error) {
+	for i := range txs {
+		if err := txs[i].normalize(i); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range txs {
+		t := &txs[i]
+		err := tx.QueryRow(ctx, `
+INSERT INTO portfolio_transactions (user_id, ticker, type, quantity, price, fees, currency, trade_date, note)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, created_at
+`, userID, t.Ticker, t.Type, t.Quantity, t.Price, t.Fees, t.Currency, t.TradeDate, t.Note).Scan(&t.ID, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// ListTransactions returns the user's transactions dated on or before to, oldest first.
+// A zero to means no upper bound.
+func (s *Service) ListTransactions(ctx context.Context, userID string, to time.Time) ([]Transaction, error) {
+	if to.IsZero() {
+		to = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	rows, err := s.DB.Query(ctx, `
+SELECT id, ticker, type, quantity, price, fees, currency, trade_date, note, created_at
+FROM portfolio_transactions
+WHERE user_id = $1 AND trade_date <= $2
+ORDER BY trade_date, created_at
+`, userID, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Transaction, 0, 64)
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.Ticker, &t.Type, &t.Quantity, &t.Price, &t.Fees, &t.Currency, &t.TradeDate, &t.Note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -2,6 +2,7 @@ package rec
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"strings"
 	"time"
@@ -110,6 +111,10 @@ type Recommendation struct {
 	TargetFrom      *float64   `json:"target_from,omitempty"`
 	TargetTo        *float64   `json:"target_to,omitempty"`
 	PriceDelta      *float64   `json:"price_target_delta,omitempty"`
+	Exchange        string     `json:"exchange,omitempty"`
+	Sector          string     `json:"sector,omitempty"`
+	Industry        string     `json:"industry,omitempty"`
+	MarketCap       *float64   `json:"market_cap,omitempty"`
 	CurrentPrice    *float64   `json:"current_price,omitempty"`
 	PercentUpside   *float64   `json:"percent_upside,omitempty"`
 	EPS             *float64   `json:"eps,omitempty"`
@@ -122,16 +127,42 @@ type Recommendation struct {
 	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
+// Filter restricts recommendations to tickers classified in the securities table.
+// Empty fields match everything; matching is case-insensitive.
+type Filter struct {
+	Sector   string
+	Industry string
+}
+
 func (s *Service) TopN(ctx context.Context, n int) ([]Recommendation, error) {
+	return s.TopNFiltered(ctx, n, Filter{})
+}
+
+// TopNFiltered is TopN over the tickers matching f.
+func (s *Service) TopNFiltered(ctx context.Context, n int, f Filter) ([]Recommendation, error) {
 	if n <= 0 || n > 50 {
 		n = 5
 	}
+	var (
+		conds []string
+		args  []any
+	)
+	for _, c := range []struct{ col, val string }{{"sector", f.Sector}, {"industry", f.Industry}} {
+		if v := strings.TrimSpace(c.val); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("lower(%s) = lower($%d)", c.col, len(args)))
+		}
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE ticker IN (SELECT symbol FROM securities WHERE " + strings.Join(conds, " AND ") + ")\n"
+	}
 	rows, err := s.db.Query(ctx, `
 SELECT ticker, company, brokerage, rating_from, rating_to, target_from, target_to, price_target_delta, last_rating_change_at, updated_at
 FROM stocks
-ORDER BY updated_at DESC
+`+where+`ORDER BY updated_at DESC
 LIMIT 500
-`)
+`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -181,34 +212,38 @@ LIMIT 500
 		return a.Score > b.Score
 	})
 
-	// Phase 2: price-aware enrichment for top-K only, then resort by new score.
-	// Use either a live provider or the DB cache when enabled.
-	if len(recs) > 0 && (s.prices != nil || s.useCache) {
+	// Phase 2: price and fundamentals enrichment for the top-K tickers in one batch, then a
+	// price-aware bonus and a resort by the new score.
+	if len(recs) > 0 {
 		k := s.topK
 		if k > len(recs) {
 			k = len(recs)
 		}
-		enrichSet := make(map[string]struct{}, k)
+		top := make([]string, 0, k)
+		inTop := make(map[string]struct{}, k)
 		for i := 0; i < k; i++ {
-			enrichSet[recs[i].Ticker] = struct{}{}
-		}
-		priceByTicker := make(map[string]float64, k)
-		for t := range enrichSet {
-			if p, ok := s.getQuote(ctx, t); ok && p > 0 {
-				priceByTicker[t] = p
+			if _, ok := inTop[recs[i].Ticker]; !ok {
+				inTop[recs[i].Ticker] = struct{}{}
+				top = append(top, recs[i].Ticker)
 			}
 		}
-		for i := 0; i < k; i++ {
-			if p, ok := priceByTicker[recs[i].Ticker]; ok {
-				cp := p
-				recs[i].CurrentPrice = &cp
-				if recs[i].TargetTo != nil && *recs[i].TargetTo > 0 {
-					up := (*recs[i].TargetTo / cp) - 1.0
-					recs[i].PercentUpside = &up
-					bonus := math.Tanh(up*2.0) * 2.0
-					recs[i].Score += bonus
-					recs[i].ScoreReasons = append(recs[i].ScoreReasons, "relative upside vs price")
-				}
+		enriched := s.enrichTop(ctx, top)
+		for i := range recs {
+			if _, ok := inTop[recs[i].Ticker]; !ok {
+				continue
+			}
+			e := enriched[recs[i].Ticker]
+			recs[i].EPS, recs[i].Growth, recs[i].Intrinsic, recs[i].IntrinsicValue2 = e.EPS, e.Growth, e.Intrinsic, e.Intrinsic2
+			if e.Price == nil {
+				continue
+			}
+			cp := *e.Price
+			recs[i].CurrentPrice = &cp
+			if up := e.Upside(recs[i].TargetTo); up != nil {
+				recs[i].PercentUpside = up
+				bonus := math.Tanh(*up*2.0) * 2.0
+				recs[i].Score += bonus
+				recs[i].ScoreReasons = append(recs[i].ScoreReasons, "relative upside vs price")
 			}
 		}
 		// Resort by score after enrichment
@@ -220,34 +255,90 @@ LIMIT 500
 		})
 	}
 
-	// Phase 3: fundamentals enrichment (EPS and basic intrinsic value) for top-K
-	if len(recs) > 0 {
-		k := s.topK
-		if k > len(recs) {
-			k = len(recs)
-		}
-		for i := 0; i < k; i++ {
-			if eps, growth, ok := s.getGrahamValuation(ctx, recs[i].Ticker); ok {
-				e := eps
-				g := growth
-				recs[i].EPS = &e
-				recs[i].Growth = &g
-				// Graham formula: V = EPS * (8.5 + 2g)
-				iv := e * (8.5 + 2*g*100)
-				recs[i].Intrinsic = &iv
+	if len(recs) > n {
+		recs = recs[:n]
+	}
+	s.attachReference(ctx, recs)
+	return recs, nil
+}
 
+// enrichTop enriches tickers from the caches in one batch. Tickers the caches know nothing
+// about, or whose cached price or valuation is older than its TTL, are asked of the price and
+// valuation providers one by one; with warm caches (see WarmCachesForTopK) that is none of them.
+func (s *Service) enrichTop(ctx context.Context, tickers []string) map[string]Enrichment {
+	out, ages, err := s.lookupBatch(ctx, tickers)
+	if err != nil {
+		out, ages = make(map[string]Enrichment, len(tickers)), cacheAges{}
+	}
+	for _, t := range tickers {
+		e := out[t]
+		if s.prices != nil && (e.Price == nil || s.staleQuote(ages.quotes[t])) {
+			if p, ok := s.getQuote(ctx, t); ok && p > 0 {
+				e.Price = &p
+			}
+		}
+		if s.grahamValuationProvider != nil && (e.EPS == nil || s.staleValuation(ages.fundamentals[t])) {
+			if eps, growth, ok := s.getGrahamValuation(ctx, t); ok {
+				e.EPS, e.Growth = &eps, &growth
+				iv := eps * (8.5 + 2*growth*100)
+				e.Intrinsic = &iv
+				e.Intrinsic2 = nil
 				if by, ok := s.getBondYield(ctx); ok && by > 0 {
 					iv2 := iv * 4.4 / by
-					recs[i].IntrinsicValue2 = &iv2
+					e.Intrinsic2 = &iv2
 				}
 			}
 		}
+		out[t] = e
 	}
+	return out
+}
 
-	if len(recs) > n {
-		recs = recs[:n]
+// staleQuote reports whether a quote cached at asOf is older than the quote TTL.
+func (s *Service) staleQuote(asOf time.Time) bool {
+	return s.quotesTTL > 0 && !asOf.IsZero() && time.Since(asOf) > s.quotesTTL
+}
+
+// staleValuation reports whether fundamentals cached at updatedAt are older than their TTL.
+func (s *Service) staleValuation(updatedAt time.Time) bool {
+	return s.grahamValuationTTL > 0 && !updatedAt.IsZero() && time.Since(updatedAt) > s.grahamValuationTTL
+}
+
+// attachReference copies exchange, sector, industry and market cap from the securities
+// table. Missing reference data is not an error; the fields are just left empty.
+func (s *Service) attachReference(ctx context.Context, recs []Recommendation) {
+	if len(recs) == 0 {
+		return
+	}
+	tickers := make([]string, len(recs))
+	for i, r := range recs {
+		tickers[i] = r.Ticker
+	}
+	rows, err := s.db.Query(ctx, `SELECT symbol, exchange, sector, industry, market_cap FROM securities WHERE symbol = ANY($1)`, tickers)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type ref struct {
+		exchange, sector, industry string
+		marketCap                  *float64
+	}
+	refs := make(map[string]ref, len(recs))
+	for rows.Next() {
+		var (
+			sym string
+			r   ref
+		)
+		if err := rows.Scan(&sym, &r.exchange, &r.sector, &r.industry, &r.marketCap); err == nil {
+			refs[sym] = r
+		}
+	}
+	for i := range recs {
+		if r, ok := refs[recs[i].Ticker]; ok {
+			recs[i].Exchange, recs[i].Sector, recs[i].Industry, recs[i].MarketCap = r.exchange, r.sector, r.industry, r.marketCap
+		}
 	}
-	return recs, nil
 }
 
 // getQuote returns a price from cache if fresh, otherwise calls provider and upserts cache when enabled.
@@ -333,6 +424,122 @@ func (s *Service) EnrichTicker(ctx context.Context, ticker string, targetTo *flo
 	return
 }
 
+// Enrichment is the price and valuation data EnrichTicker reports for one ticker. Fields
+// are nil when the value is not known.
+type Enrichment struct {
+	Price      *float64
+	EPS        *float64
+	Growth     *float64
+	Intrinsic  *float64
+	Intrinsic2 *float64
+}
+
+// Upside returns targetTo / Price - 1, or nil without a positive price and target.
+func (e Enrichment) Upside(targetTo *float64) *float64 {
+	if e.Price == nil || targetTo == nil || *targetTo <= 0 {
+		return nil
+	}
+	up := (*targetTo / *e.Price) - 1.0
+	return &up
+}
+
+// EnrichBatch returns enrichment for many tickers with one quotes_cache and one fundamentals
+// query, for list pages. Unlike EnrichTicker it only reads the caches: stale values are used
+// as they are and missing ones are left out rather than fetched from providers. Tickers
+// without any data are absent from the map.
+func (s *Service) EnrichBatch(ctx context.Context, tickers []string) (map[string]Enrichment, error) {
+	out, _, err := s.lookupBatch(ctx, tickers)
+	return out, err
+}
+
+// cacheAges holds when each ticker's cached quote (as_of) and fundamentals (updated_at) were
+// written, as read by lookupBatch.
+type cacheAges struct {
+	quotes       map[string]time.Time
+	fundamentals map[string]time.Time
+}
+
+// lookupBatch does the cache reads of EnrichBatch and also reports how old each entry is.
+func (s *Service) lookupBatch(ctx context.Context, tickers []string) (map[string]Enrichment, cacheAges, error) {
+	out := make(map[string]Enrichment, len(tickers))
+	ages := cacheAges{quotes: map[string]time.Time{}, fundamentals: map[string]time.Time{}}
+	if len(tickers) == 0 {
+		return out, ages, nil
+	}
+	if s.prices != nil || s.useCache {
+		rows, err := s.db.Query(ctx, `SELECT symbol, price, as_of FROM quotes_cache WHERE symbol = ANY($1)`, tickers)
+		if err != nil {
+			return nil, ages, err
+		}
+		for rows.Next() {
+			var (
+				symbol string
+				price  float64
+				asOf   time.Time
+			)
+			if err := rows.Scan(&symbol, &price, &asOf); err != nil {
+				rows.Close()
+				return nil, ages, err
+			}
+			if price > 0 {
+				out[symbol] = Enrichment{Price: &price}
+				ages.quotes[symbol] = asOf
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, ages, err
+		}
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT ticker, eps_avg, growth_estimate, updated_at FROM fundamentals WHERE ticker = ANY($1)`, tickers)
+	if err != nil {
+		return nil, ages, err
+	}
+	var valued []string
+	for rows.Next() {
+		var (
+			ticker      string
+			eps, growth *float64
+			updatedAt   time.Time
+		)
+		if err := rows.Scan(&ticker, &eps, &growth, &updatedAt); err != nil {
+			rows.Close()
+			return nil, ages, err
+		}
+		if eps == nil || growth == nil {
+			continue
+		}
+		e := out[ticker]
+		e.EPS, e.Growth = eps, growth
+		// g is stored as a decimal in DB; Graham formula expects percent
+		iv := *eps * (8.5 + 2*(*growth)*100)
+		e.Intrinsic = &iv
+		out[ticker] = e
+		ages.fundamentals[ticker] = updatedAt
+		valued = append(valued, ticker)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, ages, err
+	}
+
+	// The bond yield may need a query or a provider call, so it is fetched once the rows are
+	// closed, and only when something was valued.
+	if len(valued) == 0 {
+		return out, ages, nil
+	}
+	if by, ok := s.getBondYield(ctx); ok && by > 0 {
+		for _, t := range valued {
+			e := out[t]
+			iv2 := *e.Intrinsic * 4.4 / by
+			e.Intrinsic2 = &iv2
+			out[t] = e
+		}
+	}
+	return out, ages, nil
+}
+
 // getGrahamValuation returns eps_avg and growth_estimate from cache if fresh, otherwise calls provider
 // and upserts cache when enabled. Returns false if not available.
 func (s *Service) getGrahamValuation(ctx context.Context, ticker string) (float64, float64, bool) {
@@ -0,0 +1,207 @@
+package rec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrichBatch(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	svc := NewService(mock)
+	svc.EnableQuoteCache(time.Hour)
+	svc.SetCorporateBondYieldProvider(fakeBondYield(5.5))
+
+	tickers := []string{"AAA", "BBB", "CCC"}
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT symbol, price, as_of FROM quotes_cache WHERE symbol = ANY($1)")).
+		WithArgs(tickers).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "price", "as_of"}).AddRow("AAA", 100.0, now).AddRow("BBB", 0.0, now))
+	eps, growth := 5.0, 0.1
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ticker, eps_avg, growth_estimate, updated_at FROM fundamentals WHERE ticker = ANY($1)")).
+		WithArgs(tickers).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "eps_avg", "growth_estimate", "updated_at"}).
+			AddRow("AAA", &eps, &growth, now).
+			AddRow("CCC", &eps, (*float64)(nil), now))
+
+	got, err := svc.EnrichBatch(context.Background(), tickers)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// BBB has no usable price and CCC no growth estimate, so only AAA is enriched.
+	require.Len(t, got, 1)
+	a := got["AAA"]
+	assert.Equal(t, 100.0, *a.Price)
+	assert.InDelta(t, 5*(8.5+20), *a.Intrinsic, 1e-9)
+	assert.InDelta(t, 5*(8.5+20)*4.4/5.5, *a.Intrinsic2, 1e-9)
+	target := 120.0
+	assert.InDelta(t, 0.2, *a.Upside(&target), 1e-9)
+	assert.Nil(t, a.Upside(nil))
+
+	// Matches the single-ticker path.
+	price, up, _, _, iv, iv2 := (&Service{db: &countingDB{}, useCache: true, quotesTTL: time.Hour, corporateBondYieldProvider: fakeBondYield(5.5), bondYieldCacheTTL: time.Hour}).
+		EnrichTicker(context.Background(), "AAA", &target)
+	assert.Equal(t, *a.Price, *price)
+	assert.InDelta(t, *a.Upside(&target), *up, 1e-9)
+	assert.InDelta(t, *a.Intrinsic, *iv, 1e-9)
+	assert.InDelta(t, *a.Intrinsic2, *iv2, 1e-9)
+}
+
+func TestEnrichBatchQueryCount(t *testing.T) {
+	tickers := benchTickers(50)
+
+	perRow := &countingDB{}
+	svc := newBenchService(perRow)
+	for _, tk := range tickers {
+		svc.EnrichTicker(context.Background(), tk, nil)
+	}
+	assert.Equal(t, 2*len(tickers), perRow.queries)
+
+	batched := &countingDB{}
+	got, err := newBenchService(batched).EnrichBatch(context.Background(), tickers)
+	require.NoError(t, err)
+	assert.Len(t, got, len(tickers))
+	assert.Equal(t, 2, batched.queries)
+}
+
+func BenchmarkEnrichTicker(b *testing.B) {
+	tickers := benchTickers(50)
+	db := &countingDB{}
+	svc := newBenchService(db)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, tk := range tickers {
+			svc.EnrichTicker(context.Background(), tk, nil)
+		}
+	}
+	b.ReportMetric(float64(db.queries)/float64(b.N), "queries/op")
+}
+
+func BenchmarkEnrichBatch(b *testing.B) {
+	tickers := benchTickers(50)
+	db := &countingDB{}
+	svc := newBenchService(db)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.EnrichBatch(context.Background(), tickers); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(db.queries)/float64(b.N), "queries/op")
+}
+
+type fakeBondYield float64
+
+func (f fakeBondYield) GetAAACorporateBondYield(ctx context.Context) (float64, error) {
+	return float64(f), nil
+}
+
+func newBenchService(db *countingDB) *Service {
+	svc := NewService(db)
+	svc.EnableQuoteCache(time.Hour)
+	svc.SetCorporateBondYieldProvider(fakeBondYield(5.5))
+	return svc
+}
+
+func benchTickers(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("T%03d", i)
+	}
+	return out
+}
+
+// countingDB answers the cache lookups of EnrichTicker and EnrichBatch with a fresh quote of
+// 100 and fundamentals of EPS 5 / growth 10% for every ticker, and counts the queries made.
+type countingDB struct {
+	queries int
+}
+
+func (d *countingDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (d *countingDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	d.queries++
+	tickers, _ := args[0].([]string)
+	rows := &fakeRows{}
+	for _, tk := range tickers {
+		switch {
+		case strings.Contains(sql, "FROM quotes_cache"):
+			rows.data = append(rows.data, []any{tk, 100.0, time.Now()})
+		case strings.Contains(sql, "FROM fundamentals"):
+			eps, growth := 5.0, 0.1
+			rows.data = append(rows.data, []any{tk, &eps, &growth, time.Now()})
+		}
+	}
+	return rows, nil
+}
+
+func (d *countingDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	d.queries++
+	rows := &fakeRows{}
+	switch {
+	case strings.Contains(sql, "FROM quotes_cache"):
+		rows.data = [][]any{{100.0, time.Now()}}
+	case strings.Contains(sql, "FROM fundamentals"):
+		rows.data = [][]any{{5.0, 0.1, time.Now()}}
+	}
+	return rows
+}
+
+func (d *countingDB) Begin(context.Context) (pgx.Tx, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (d *countingDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, fmt.Errorf("not supported")
+}
+
+// fakeRows serves in-memory rows as both pgx.Rows and pgx.Row.
+type fakeRows struct {
+	data [][]any
+	pos  int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *fakeRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.data)
+}
+
+func (r *fakeRows) Values() ([]any, error) {
+	return r.data[r.pos-1], nil
+}
+
+// Scan reads the current row, or the first one when used as a pgx.Row.
+func (r *fakeRows) Scan(dest ...any) error {
+	if r.pos == 0 {
+		if len(r.data) == 0 {
+			return pgx.ErrNoRows
+		}
+		r.pos = 1
+	}
+	for i, v := range r.data[r.pos-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
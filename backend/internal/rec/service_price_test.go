@@ -76,12 +76,17 @@ func TestTopNUsesCacheWithoutProvider(t *testing.T) {
     mock.ExpectQuery("SELECT ticker, company, brokerage, rating_from, rating_to, target_from, target_to, price_target_delta, last_rating_change_at, updated_at FROM stocks").
         WillReturnRows(rows)
 
-    // Expect a cache lookup for the price
+    // Expect one batched cache lookup for prices and one for fundamentals
     price := 100.0
-    asOf := time.Now()
-    mock.ExpectQuery(regexp.QuoteMeta("SELECT price, as_of FROM quotes_cache WHERE symbol = $1")).
-        WithArgs("TEST").
-        WillReturnRows(pgxmock.NewRows([]string{"price", "as_of"}).AddRow(price, asOf))
+    mock.ExpectQuery(regexp.QuoteMeta("SELECT symbol, price, as_of FROM quotes_cache WHERE symbol = ANY($1)")).
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"symbol", "price", "as_of"}).AddRow("TEST", price, updatedAt))
+    mock.ExpectQuery(regexp.QuoteMeta("SELECT ticker, eps_avg, growth_estimate, updated_at FROM fundamentals WHERE ticker = ANY($1)")).
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"ticker", "eps_avg", "growth_estimate", "updated_at"}))
+    mock.ExpectQuery("FROM securities WHERE symbol = ANY").
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"symbol", "exchange", "sector", "industry", "market_cap"}))
 
     recs, err := svc.TopN(context.Background(), 5)
     assert.NoError(t, err)
@@ -89,4 +94,55 @@ func TestTopNUsesCacheWithoutProvider(t *testing.T) {
     assert.NotNil(t, recs[0].CurrentPrice)
     assert.NotNil(t, recs[0].PercentUpside)
     assert.InDelta(t, 0.20, *recs[0].PercentUpside, 0.05)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTopNRefreshesStaleQuotes(t *testing.T) {
+    mock, err := pgxmock.NewPool()
+    if err != nil {
+        t.Fatalf("failed mock: %v", err)
+    }
+    defer mock.Close()
+
+    svc := NewService(mock)
+    svc.EnableQuoteCache(time.Hour)
+    svc.SetPriceProvider(fakePriceProvider{})
+
+    updatedAt := time.Now()
+    targetTo := 120.0
+    rows := pgxmock.NewRows([]string{
+        "ticker", "company", "brokerage", "rating_from", "rating_to",
+        "target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at",
+    }).AddRow(
+        "TEST", "Test Company", "UBS Group", "Neutral", "Buy",
+        nil, &targetTo, nil, func() *time.Time { t := updatedAt.Add(-24 * time.Hour); return &t }(), updatedAt,
+    )
+    mock.ExpectQuery("SELECT ticker, company, brokerage, rating_from, rating_to, target_from, target_to, price_target_delta, last_rating_change_at, updated_at FROM stocks").
+        WillReturnRows(rows)
+
+    // The cached quote is two hours old with a one hour TTL, so the provider is asked again
+    stale := updatedAt.Add(-2 * time.Hour)
+    mock.ExpectQuery(regexp.QuoteMeta("SELECT symbol, price, as_of FROM quotes_cache WHERE symbol = ANY($1)")).
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"symbol", "price", "as_of"}).AddRow("TEST", 50.0, stale))
+    mock.ExpectQuery(regexp.QuoteMeta("SELECT ticker, eps_avg, growth_estimate, updated_at FROM fundamentals WHERE ticker = ANY($1)")).
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"ticker", "eps_avg", "growth_estimate", "updated_at"}))
+    mock.ExpectQuery(regexp.QuoteMeta("SELECT price, as_of FROM quotes_cache WHERE symbol = $1")).
+        WithArgs("TEST").
+        WillReturnRows(pgxmock.NewRows([]string{"price", "as_of"}).AddRow(50.0, stale))
+    mock.ExpectExec("INSERT INTO quotes_cache").
+        WithArgs("TEST", 100.0).
+        WillReturnResult(pgxmock.NewResult("INSERT", 1))
+    mock.ExpectQuery("FROM securities WHERE symbol = ANY").
+        WithArgs([]string{"TEST"}).
+        WillReturnRows(pgxmock.NewRows([]string{"symbol", "exchange", "sector", "industry", "market_cap"}))
+
+    recs, err := svc.TopN(context.Background(), 5)
+    assert.NoError(t, err)
+    assert.Len(t, recs, 1)
+    if assert.NotNil(t, recs[0].CurrentPrice) {
+        assert.Equal(t, 100.0, *recs[0].CurrentPrice)
+    }
+    assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,44 @@
+package rec
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopNFilteredBySectorAttachesReference(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock)
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM stocks\nWHERE ticker IN (SELECT symbol FROM securities WHERE lower(sector) = lower($1))\nORDER BY updated_at DESC")).
+		WithArgs("energy").
+		WillReturnRows(pgxmock.NewRows([]string{
+			"ticker", "company", "brokerage", "rating_from", "rating_to",
+			"target_from", "target_to", "price_target_delta", "last_rating_change_at", "updated_at",
+		}).
+			AddRow("XOM", "Exxon Mobil", "UBS Group", "Neutral", "Buy", nil, nil, nil, nil, now).
+			AddRow("CVX", "Chevron", "UBS Group", "Buy", "Neutral", nil, nil, nil, nil, now))
+	mc := 4.5e11
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT symbol, exchange, sector, industry, market_cap FROM securities WHERE symbol = ANY($1)")).
+		WithArgs([]string{"XOM", "CVX"}).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "exchange", "sector", "industry", "market_cap"}).
+			AddRow("XOM", "NYSE", "Energy", "Oil & Gas Integrated", &mc))
+
+	recs, err := svc.TopNFiltered(context.Background(), 5, Filter{Sector: " energy "})
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "XOM", recs[0].Ticker)
+	assert.Equal(t, "Energy", recs[0].Sector)
+	assert.Equal(t, "NYSE", recs[0].Exchange)
+	assert.Equal(t, &mc, recs[0].MarketCap)
+	assert.Empty(t, recs[1].Sector)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,111 @@
+package rec
+
+import (
+	"context"
+	"time"
+
+	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/events"
+
+	"go.uber.org/zap"
+)
+
+// watchBatch caps the rows read per table and poll; the rest are read on the next poll.
+const watchBatch = 1000
+
+// CacheWatcher publishes what is written to quotes_cache and fundamentals, by this service's
+// cache refreshes or by the external Fundamentals API, as quote and recommendation_update
+// events.
+type CacheWatcher struct {
+	db     db.DBTX
+	events events.Publisher
+	// Latest updated_at seen per table; zero until the first Poll.
+	quotesSeen, fundamentalsSeen time.Time
+	started                      bool
+}
+
+func NewCacheWatcher(db db.DBTX, p events.Publisher) *CacheWatcher {
+	return &CacheWatcher{db: db, events: p}
+}
+
+// Poll publishes rows updated since the previous Poll. The first Poll only records where the
+// tables are, so existing rows are not replayed.
+func (w *CacheWatcher) Poll(ctx context.Context) error {
+	if !w.started {
+		var quotes, fundamentals *time.Time
+		err := w.db.QueryRow(ctx, `SELECT (SELECT max(updated_at) FROM quotes_cache), (SELECT max(updated_at) FROM fundamentals)`).
+			Scan(&quotes, &fundamentals)
+		if err != nil {
+			return err
+		}
+		if quotes != nil {
+			w.quotesSeen = *quotes
+		}
+		if fundamentals != nil {
+			w.fundamentalsSeen = *fundamentals
+		}
+		w.started = true
+		return nil
+	}
+
+	rows, err := w.db.Query(ctx, `
+SELECT symbol, price, as_of, updated_at FROM quotes_cache
+WHERE updated_at > $1 ORDER BY updated_at LIMIT $2
+`, w.quotesSeen, watchBatch)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var q events.Quote
+		var updated time.Time
+		if err := rows.Scan(&q.Ticker, &q.Price, &q.AsOf, &updated); err != nil {
+			rows.Close()
+			return err
+		}
+		w.quotesSeen = updated
+		w.events.Publish(events.TypeQuote, q.Ticker, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = w.db.Query(ctx, `
+SELECT ticker, updated_at FROM fundamentals
+WHERE updated_at > $1 ORDER BY updated_at LIMIT $2
+`, w.fundamentalsSeen, watchBatch)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ticker string
+		var updated time.Time
+		if err := rows.Scan(&ticker, &updated); err != nil {
+			return err
+		}
+		w.fundamentalsSeen = updated
+		w.events.Publish(events.TypeRecommendationUpdate, ticker,
+			events.RecommendationUpdate{Reason: events.ReasonFundamentals, Tickers: []string{ticker}})
+	}
+	return rows.Err()
+}
+
+// StartCacheWatch polls w every interval until stop is closed.
+func StartCacheWatch(w *CacheWatcher, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), every)
+			if err := w.Poll(ctx); err != nil {
+				log.Warnf("cache watch error: %v", err)
+			}
+			cancel()
+		case <-stop:
+			log.Infof("cache watch stopped")
+			return
+		}
+	}
+}
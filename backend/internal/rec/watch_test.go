@@ -0,0 +1,62 @@
+package rec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/events"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheWatcherPublishesUpdates(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	bus := events.NewBus(0)
+	sub := bus.Subscribe(8)
+	defer sub.Close()
+	w := NewCacheWatcher(mock, bus)
+	ctx := context.Background()
+
+	// The first poll only finds where the tables are.
+	t0 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT \(SELECT max\(updated_at\) FROM quotes_cache\)`).
+		WillReturnRows(pgxmock.NewRows([]string{"q", "f"}).AddRow(&t0, (*time.Time)(nil)))
+	require.NoError(t, w.Poll(ctx))
+	assert.Empty(t, sub.C)
+
+	t1, t2 := t0.Add(time.Minute), t0.Add(2*time.Minute)
+	mock.ExpectQuery(`FROM quotes_cache\s+WHERE updated_at > \$1`).
+		WithArgs(t0, watchBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "price", "as_of", "updated_at"}).
+			AddRow("AAPL", 190.5, t1, t1).
+			AddRow("MSFT", 410.0, t2, t2))
+	mock.ExpectQuery(`FROM fundamentals\s+WHERE updated_at > \$1`).
+		WithArgs(time.Time{}, watchBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "updated_at"}).AddRow("AAPL", t1))
+	require.NoError(t, w.Poll(ctx))
+
+	e := <-sub.C
+	assert.Equal(t, events.TypeQuote, e.Type)
+	assert.Equal(t, events.Quote{Ticker: "AAPL", Price: 190.5, AsOf: t1}, e.Data)
+	assert.Equal(t, "MSFT", (<-sub.C).Ticker)
+	e = <-sub.C
+	assert.Equal(t, events.TypeRecommendationUpdate, e.Type)
+	assert.Equal(t, events.RecommendationUpdate{Reason: events.ReasonFundamentals, Tickers: []string{"AAPL"}}, e.Data)
+
+	// The next poll starts after the newest rows seen.
+	mock.ExpectQuery(`FROM quotes_cache\s+WHERE updated_at > \$1`).
+		WithArgs(t2, watchBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "price", "as_of", "updated_at"}))
+	mock.ExpectQuery(`FROM fundamentals\s+WHERE updated_at > \$1`).
+		WithArgs(t1, watchBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"ticker", "updated_at"}))
+	require.NoError(t, w.Poll(ctx))
+	assert.Empty(t, sub.C)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
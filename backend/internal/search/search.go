@@ -0,0 +1,181 @@
+// Package search ranks tickers and company names for the stock search and autocomplete.
+//
+// Ranking happens in SQL so paginated stock searches and suggestions order the same way:
+// an exact symbol first, then symbol prefixes, company-name prefixes, word prefixes inside
+// the name and plain substrings, with trigram similarity of the name ordering matches within
+// a tier and admitting misspelled names ("aple" finds Apple).
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"stockchallenge/backend/internal/db"
+)
+
+const (
+	// DefaultSuggestLimit is how many suggestions are returned when no limit is given.
+	DefaultSuggestLimit = 8
+	maxSuggestLimit     = 25
+	maxQueryLen         = 64
+	// watchlistBoost lifts symbols the user watches above otherwise equal matches.
+	watchlistBoost = 0.5
+)
+
+// Sources a suggestion can come from, in the order they are reported.
+const (
+	SourceStocks     = "stocks"
+	SourceSecurities = "securities"
+	SourceWatchlist  = "watchlist"
+)
+
+// Normalize trims q, collapses inner whitespace, drops LIKE wildcards and caps the length, so
+// user input matches literally. It returns "" when nothing searchable is left.
+func Normalize(q string) string {
+	q = strings.Map(func(r rune) rune {
+		switch r {
+		case '%', '_', '\\':
+			return ' '
+		}
+		return r
+	}, q)
+	q = strings.Join(strings.Fields(q), " ")
+	if r := []rune(q); len(r) > maxQueryLen {
+		q = strings.TrimSpace(string(r[:maxQueryLen]))
+	}
+	return q
+}
+
+// Match returns a SQL condition that holds when the symbol or name column contains the
+// search parameter p (a placeholder such as "$1"), or the name is similar to it by trigrams.
+// An empty name column only matches on the symbol.
+func Match(symbol, name, p string) string {
+	conds := []string{symbol + ` ILIKE '%' || ` + p + ` || '%'`}
+	if name != "" {
+		conds = append(conds, name+` ILIKE '%' || `+p+` || '%'`, name+` % `+p)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// Relevance returns a SQL expression scoring how well a row matches p; higher is better.
+// The tiers are exact symbol (4), symbol prefix (3), name prefix (2), word prefix in the
+// name (1.5) and substring (1); the name's trigram similarity (0..1) is added on top.
+func Relevance(symbol, name, p string) string {
+	if name == "" {
+		return `CASE
+    WHEN upper(` + symbol + `) = upper(` + p + `) THEN 4
+    WHEN ` + symbol + ` ILIKE ` + p + ` || '%' THEN 3
+    WHEN ` + symbol + ` ILIKE '%' || ` + p + ` || '%' THEN 1
+    ELSE 0
+  END::FLOAT8`
+	}
+	return `CASE
+    WHEN upper(` + symbol + `) = upper(` + p + `) THEN 4
+    WHEN ` + symbol + ` ILIKE ` + p + ` || '%' THEN 3
+    WHEN ` + name + ` ILIKE ` + p + ` || '%' THEN 2
+    WHEN ` + name + ` ILIKE '% ' || ` + p + ` || '%' THEN 1.5
+    WHEN ` + symbol + ` ILIKE '%' || ` + p + ` || '%' OR ` + name + ` ILIKE '%' || ` + p + ` || '%' THEN 1
+    ELSE 0
+  END::FLOAT8 + similarity(` + name + `, ` + p + `)`
+}
+
+// Suggestion is one autocomplete entry. A symbol found in several places is reported once
+// with all of its sources.
+type Suggestion struct {
+	Symbol   string   `json:"symbol"`
+	Name     string   `json:"name"`
+	Exchange string   `json:"exchange,omitempty"`
+	Sources  []string `json:"sources"`
+	Score    float64  `json:"score"`
+}
+
+type Service struct {
+	DB db.DBTX
+}
+
+func NewService(db db.DBTX) *Service {
+	return &Service{DB: db}
+}
+
+// Suggest returns up to limit symbols matching q from tracked stocks, reference data and the
+// watchlist, best first. q should already be normalized; an empty q has no suggestions.
+func (s *Service) Suggest(ctx context.Context, q string, limit int) ([]Suggestion, error) {
+	out := []Suggestion{}
+	if q == "" {
+		return out, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+	limit = min(limit, maxSuggestLimit)
+
+	// The same symbol can come back from every source, so fetch more candidates than needed.
+	rows, err := s.DB.Query(ctx, `
+SELECT symbol, name, exchange, source, score FROM (
+  SELECT ticker AS symbol, company AS name, '' AS exchange, '`+SourceStocks+`' AS source, `+Relevance("ticker", "company", "$1")+` AS score
+  FROM stocks WHERE `+Match("ticker", "company", "$1")+`
+  UNION ALL
+  SELECT symbol, name, exchange, '`+SourceSecurities+`', `+Relevance("symbol", "name", "$1")+`
+  FROM securities WHERE `+Match("symbol", "name", "$1")+`
+  UNION ALL
+  SELECT ticker, '', '', '`+SourceWatchlist+`', `+Relevance("ticker", "", "$1")+`
+  FROM watchlist WHERE `+Match("ticker", "", "$1")+`
+) AS candidates
+ORDER BY score DESC, symbol
+LIMIT $2
+`, q, limit*3)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bySymbol := map[string]*Suggestion{}
+	for rows.Next() {
+		var (
+			symbol, name, exchange, source string
+			score                          float64
+		)
+		if err := rows.Scan(&symbol, &name, &exchange, &source, &score); err != nil {
+			return nil, err
+		}
+		sg, ok := bySymbol[symbol]
+		if !ok {
+			sg = &Suggestion{Symbol: symbol}
+			bySymbol[symbol] = sg
+		}
+		sg.Sources = append(sg.Sources, source)
+		sg.Score = max(sg.Score, score)
+		// Reference data has the canonical name; tracked stocks fill in otherwise.
+		if name != "" && (sg.Name == "" || source == SourceSecurities) {
+			sg.Name = name
+		}
+		if exchange != "" {
+			sg.Exchange = exchange
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rank := map[string]int{SourceStocks: 0, SourceSecurities: 1, SourceWatchlist: 2}
+	for _, sg := range bySymbol {
+		sort.Slice(sg.Sources, func(i, j int) bool { return rank[sg.Sources[i]] < rank[sg.Sources[j]] })
+		for _, src := range sg.Sources {
+			if src == SourceWatchlist {
+				sg.Score += watchlistBoost
+			}
+		}
+		out = append(out, *sg)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Symbol < out[j].Symbol
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
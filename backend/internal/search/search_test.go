@@ -0,0 +1,64 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "apple inc", Normalize("  apple \t inc "))
+	assert.Equal(t, "a b", Normalize("a%_b"))
+	assert.Equal(t, "", Normalize(" % "))
+	assert.Len(t, Normalize(strings.Repeat("x", 100)), maxQueryLen)
+}
+
+func TestMatchAndRelevance(t *testing.T) {
+	assert.Equal(t, `(ticker ILIKE '%' || $1 || '%' OR company ILIKE '%' || $1 || '%' OR company % $1)`, Match("ticker", "company", "$1"))
+	assert.Equal(t, `(ticker ILIKE '%' || $2 || '%')`, Match("ticker", "", "$2"))
+	assert.Contains(t, Relevance("ticker", "company", "$1"), "similarity(company, $1)")
+	assert.NotContains(t, Relevance("ticker", "", "$1"), "similarity")
+}
+
+func TestSuggest(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock)
+
+	out, err := svc.Suggest(context.Background(), "", 5)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	mock.ExpectQuery(`FROM stocks WHERE .* UNION ALL .* FROM securities WHERE .* UNION ALL .* FROM watchlist WHERE .* ORDER BY score DESC, symbol LIMIT \$2`).
+		WithArgs("app", 9).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "source", "score"}).
+			AddRow("APP", "AppLovin", "", SourceStocks, 3.4).
+			AddRow("AAPL", "Apple Inc", "", SourceStocks, 2.5).
+			AddRow("AAPL", "Apple Inc.", "NASDAQ", SourceSecurities, 2.5).
+			AddRow("APPF", "", "", SourceWatchlist, 3.0).
+			AddRow("APPN", "Appian Corp", "", SourceStocks, 3.3))
+
+	out, err = svc.Suggest(context.Background(), "app", 3)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// Watched APPF (3.0 + 0.5) beats the other prefix matches; AAPL is merged and cut.
+	require.Len(t, out, 3)
+	assert.Equal(t, "APPF", out[0].Symbol)
+	assert.Equal(t, []string{SourceWatchlist}, out[0].Sources)
+	assert.Equal(t, "APP", out[1].Symbol)
+	assert.Equal(t, "APPN", out[2].Symbol)
+
+	mock.ExpectQuery(`FROM stocks WHERE`).WithArgs("aapl", 75).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol", "name", "exchange", "source", "score"}).
+			AddRow("AAPL", "Apple Inc", "", SourceStocks, 4.5).
+			AddRow("AAPL", "Apple Inc.", "NASDAQ", SourceSecurities, 4.5))
+	out, err = svc.Suggest(context.Background(), "aapl", 100)
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	assert.Equal(t, Suggestion{Symbol: "AAPL", Name: "Apple Inc.", Exchange: "NASDAQ", Sources: []string{SourceStocks, SourceSecurities}, Score: 4.5}, out[0])
+}
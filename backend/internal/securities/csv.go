@@ -0,0 +1,111 @@
+package securities
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// headerAliases maps accepted header names to canonical columns.
+var headerAliases = map[string]string{
+	"symbol":             "symbol",
+	"ticker":             "symbol",
+	"name":               "name",
+	"company":            "name",
+	"exchange":           "exchange",
+	"sector":             "sector",
+	"industry":           "industry",
+	"country":            "country",
+	"currency":           "currency",
+	"market_cap":         "market_cap",
+	"marketcap":          "market_cap",
+	"shares_outstanding": "shares_outstanding",
+	"shares":             "shares_outstanding",
+}
+
+// ParseCSV reads securities from a CSV with a header row. Columns (any order,
+// case-insensitive): symbol (or ticker), name, exchange, sector, industry, country,
+// currency, market_cap and shares_outstanding. Only symbol is required; blank numeric
+// cells are stored as unknown.
+func ParseCSV(r io.Reader) ([]Security, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if canon, ok := headerAliases[key]; ok {
+			col[canon] = i
+		}
+	}
+	if _, ok := col["symbol"]; !ok {
+		return nil, errors.New(`missing "symbol" column`)
+	}
+	get := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	number := func(rec []string, name string) (*float64, error) {
+		v := strings.ReplaceAll(get(rec, name), ",", "")
+		if v == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q is not a number", name, v)
+		}
+		return &f, nil
+	}
+
+	var out []Security
+	line := 1
+	for {
+		rec, err := cr.Read()
+		line++
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if strings.Join(rec, "") == "" {
+			continue
+		}
+		sec := Security{
+			Symbol:   get(rec, "symbol"),
+			Name:     get(rec, "name"),
+			Exchange: get(rec, "exchange"),
+			Sector:   get(rec, "sector"),
+			Industry: get(rec, "industry"),
+			Country:  get(rec, "country"),
+			Currency: get(rec, "currency"),
+			Source:   SourceCSV,
+		}
+		if sec.MarketCap, err = number(rec, "market_cap"); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if sec.SharesOutstanding, err = number(rec, "shares_outstanding"); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := sec.normalize(len(out)); err != nil {
+			var inv *InvalidSecurityError
+			if errors.As(err, &inv) {
+				return nil, fmt.Errorf("line %d: %s", line, inv.Reason)
+			}
+			return nil, err
+		}
+		out = append(out, sec)
+	}
+	return out, nil
+}
@@ -0,0 +1,416 @@
+// Package securities keeps reference data for listed symbols (name, exchange, sector,
+// industry, size) so stocks, recommendations and holdings can be grouped and filtered.
+// Rows come from a CSV seed and are refreshed from a market data provider.
+package securities
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"stockchallenge/backend/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Row sources.
+const (
+	SourceSeed     = "seed"
+	SourceCSV      = "csv"
+	SourceProvider = "provider"
+)
+
+// refreshAge is how old provider data may get before Sync fetches it again.
+const refreshAge = 30 * 24 * time.Hour
+
+// syncBatch caps provider calls per Sync to stay inside free API quotas.
+const syncBatch = 100
+
+//go:embed seed.csv
+var defaultSeed []byte
+
+var (
+	tickerPattern   = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-/]{0,11}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+var ErrNotFound = errors.New("security not found")
+
+// Security is the reference record for one symbol. Empty strings mean unknown.
+type Security struct {
+	Symbol            string    `json:"symbol"`
+	Name              string    `json:"name"`
+	Exchange          string    `json:"exchange"`
+	Sector            string    `json:"sector"`
+	Industry          string    `json:"industry"`
+	Country           string    `json:"country"`
+	Currency          string    `json:"currency"`
+	MarketCap         *float64  `json:"market_cap"`
+	SharesOutstanding *float64  `json:"shares_outstanding"`
+	Source            string    `json:"source"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// InvalidSecurityError reports the first problem found in a batch of securities.
+type InvalidSecurityError struct {
+	Index  int
+	Reason string
+}
+
+func (e *InvalidSecurityError) Error() string {
+	return fmt.Sprintf("security %d: %s", e.Index, e.Reason)
+}
+
+func normalizeTicker(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+func (sec *Security) normalize(i int) error {
+	sec.Symbol = normalizeTicker(sec.Symbol)
+	if !tickerPattern.MatchString(sec.Symbol) {
+		return &InvalidSecurityError{i, fmt.Sprintf("invalid symbol %q", sec.Symbol)}
+	}
+	sec.Name = strings.TrimSpace(sec.Name)
+	sec.Exchange = strings.ToUpper(strings.TrimSpace(sec.Exchange))
+	sec.Sector = strings.TrimSpace(sec.Sector)
+	sec.Industry = strings.TrimSpace(sec.Industry)
+	sec.Country = strings.ToUpper(strings.TrimSpace(sec.Country))
+	sec.Currency = strings.ToUpper(strings.TrimSpace(sec.Currency))
+	if sec.Currency == "" {
+		sec.Currency = "USD"
+	}
+	if !currencyPattern.MatchString(sec.Currency) {
+		return &InvalidSecurityError{i, fmt.Sprintf("invalid currency %q", sec.Currency)}
+	}
+	if sec.MarketCap != nil && *sec.MarketCap < 0 {
+		return &InvalidSecurityError{i, "market_cap cannot be negative"}
+	}
+	if sec.SharesOutstanding != nil && *sec.SharesOutstanding < 0 {
+		return &InvalidSecurityError{i, "shares_outstanding cannot be negative"}
+	}
+	if sec.Source == "" {
+		sec.Source = SourceCSV
+	}
+	return nil
+}
+
+// Provider returns reference data for one symbol, or nil when the vendor does not know it.
+// Implemented by internal/marketdata providers.
+type Provider interface {
+	Profile(ctx context.Context, symbol string) (*Security, error)
+}
+
+// Service reads and maintains the securities table.
+type Service struct {
+	DB       db.DBTX
+	Log      *zap.SugaredLogger
+	Provider Provider
+}
+
+func NewService(db db.DBTX, log *zap.SugaredLogger) *Service {
+	return &Service{DB: db, Log: log}
+}
+
+// SetProvider enables Sync.
+func (s *Service) SetProvider(p Provider) {
+	s.Provider = p
+}
+
+const columns = `symbol, name, exchange, sector, industry, country, currency, market_cap, shares_outstanding, source, updated_at`
+
+func scanSecurities(rows pgx.Rows) ([]Security, error) {
+	defer rows.Close()
+	out := []Security{}
+	for rows.Next() {
+		var sec Security
+		if err := rows.Scan(&sec.Symbol, &sec.Name, &sec.Exchange, &sec.Sector, &sec.Industry, &sec.Country,
+			&sec.Currency, &sec.MarketCap, &sec.SharesOutstanding, &sec.Source, &sec.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, sec)
+	}
+	return out, rows.Err()
+}
+
+// Upsert validates and stores securities, replacing existing rows.
+func (s *Service) Upsert(ctx context.Context, secs []Security) (int, error) {
+	return s.write(ctx, secs, `UPSERT INTO securities (`+columns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now())`)
+}
+
+// Seed stores securities that are not in the table yet, leaving existing rows alone so a
+// restart does not undo provider refreshes or manual imports.
+func (s *Service) Seed(ctx context.Context, secs []Security) (int, error) {
+	return s.write(ctx, secs, `INSERT INTO securities (`+columns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now()) ON CONFLICT (symbol) DO NOTHING`)
+}
+
+// SeedDefaults loads the CSV bundled with the binary through Seed.
+func (s *Service) SeedDefaults(ctx context.Context) (int, error) {
+	secs, err := ParseCSV(strings.NewReader(string(defaultSeed)))
+	if err != nil {
+		return 0, fmt.Errorf("default seed: %w", err)
+	}
+	for i := range secs {
+		secs[i].Source = SourceSeed
+	}
+	return s.Seed(ctx, secs)
+}
+
+func (s *Service) write(ctx context.Context, secs []Security, q string) (int, error) {
+	for i := range secs {
+		if err := secs[i].normalize(i); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, sec := range secs {
+		tag, err := s.DB.Exec(ctx, q, sec.Symbol, sec.Name, sec.Exchange, sec.Sector, sec.Industry, sec.Country,
+			sec.Currency, sec.MarketCap, sec.SharesOutstanding, sec.Source)
+		if err != nil {
+			return n, err
+		}
+		n += int(tag.RowsAffected())
+	}
+	return n, nil
+}
+
+// Get returns one security or ErrNotFound.
+func (s *Service) Get(ctx context.Context, symbol string) (*Security, error) {
+	rows, err := s.DB.Query(ctx, `SELECT `+columns+` FROM securities WHERE symbol = $1`, normalizeTicker(symbol))
+	if err != nil {
+		return nil, err
+	}
+	secs, err := scanSecurities(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(secs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &secs[0], nil
+}
+
+// Lookup returns the known securities among symbols, keyed by symbol.
+func (s *Service) Lookup(ctx context.Context, symbols []string) (map[string]Security, error) {
+	out := map[string]Security{}
+	if len(symbols) == 0 {
+		return out, nil
+	}
+	rows, err := s.DB.Query(ctx, `SELECT `+columns+` FROM securities WHERE symbol = ANY($1)`, symbols)
+	if err != nil {
+		return nil, err
+	}
+	secs, err := scanSecurities(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, sec := range secs {
+		out[sec.Symbol] = sec
+	}
+	return out, nil
+}
+
+// Filter narrows List. Empty fields match everything; matching is case-insensitive.
+type Filter struct {
+	Sector   string
+	Industry string
+	Exchange string
+	Country  string
+}
+
+// List returns securities matching f ordered by market cap (largest first, unknown last),
+// then symbol.
+func (s *Service) List(ctx context.Context, f Filter) ([]Security, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT `+columns+` FROM securities
+WHERE ($1 = '' OR lower(sector) = lower($1))
+  AND ($2 = '' OR lower(industry) = lower($2))
+  AND ($3 = '' OR lower(exchange) = lower($3))
+  AND ($4 = '' OR lower(country) = lower($4))
+ORDER BY market_cap DESC, symbol
+`, strings.TrimSpace(f.Sector), strings.TrimSpace(f.Industry), strings.TrimSpace(f.Exchange), strings.TrimSpace(f.Country))
+	if err != nil {
+		return nil, err
+	}
+	return scanSecurities(rows)
+}
+
+// SectorCount is one sector with its industries, for building filters.
+type SectorCount struct {
+	Sector     string   `json:"sector"`
+	Count      int      `json:"count"`
+	Industries []string `json:"industries"`
+}
+
+// SectorBreakdown lists classified sectors with how many securities and which industries
+// fall under each.
+func (s *Service) SectorBreakdown(ctx context.Context) ([]SectorCount, error) {
+	rows, err := s.DB.Query(ctx, `
+SELECT sector, industry, count(*) FROM securities
+WHERE sector != ''
+GROUP BY sector, industry
+ORDER BY sector, industry
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []SectorCount{}
+	for rows.Next() {
+		var (
+			sector, industry string
+			n                int
+		)
+		if err := rows.Scan(&sector, &industry, &n); err != nil {
+			return nil, err
+		}
+		if len(out) == 0 || out[len(out)-1].Sector != sector {
+			out = append(out, SectorCount{Sector: sector, Industries: []string{}})
+		}
+		last := &out[len(out)-1]
+		last.Count += n
+		if industry != "" {
+			last.Industries = append(last.Industries, industry)
+		}
+	}
+	return out, rows.Err()
+}
+
+// Sectors maps tickers to their sector. Unknown or unclassified tickers are left out.
+// Satisfies portfolio.SectorSource.
+func (s *Service) Sectors(ctx context.Context, tickers []string) (map[string]string, error) {
+	return s.classify(ctx, tickers, func(sec Security) string { return sec.Sector })
+}
+
+// Industries maps tickers to their industry. Satisfies portfolio.IndustrySource.
+func (s *Service) Industries(ctx context.Context, tickers []string) (map[string]string, error) {
+	return s.classify(ctx, tickers, func(sec Security) string { return sec.Industry })
+}
+
+func (s *Service) classify(ctx context.Context, tickers []string, field func(Security) string) (map[string]string, error) {
+	secs, err := s.Lookup(ctx, tickers)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(secs))
+	for sym, sec := range secs {
+		if v := field(sec); v != "" {
+			out[sym] = v
+		}
+	}
+	return out, nil
+}
+
+// Sync fetches provider data for tracked symbols (stocks, holdings and the watchlist) that
+// have no row yet or whose row is older than refreshAge. Rows imported from CSV are treated
+// as authoritative and left alone. Symbols the provider has no profile for are marked as
+// attempted (see markAttempted). It returns how many rows were written from profiles.
+func (s *Service) Sync(ctx context.Context) (int, error) {
+	if s.Provider == nil {
+		return 0, nil
+	}
+	rows, err := s.DB.Query(ctx, `
+SELECT t.symbol FROM (
+	SELECT ticker AS symbol FROM stocks
+	UNION SELECT ticker FROM portfolio
+	UNION SELECT ticker FROM watchlist
+) t
+LEFT JOIN securities s ON s.symbol = t.symbol
+WHERE s.symbol IS NULL OR (s.source != $1 AND s.updated_at < $2)
+ORDER BY s.updated_at, t.symbol
+LIMIT $3
+`, SourceCSV, time.Now().Add(-refreshAge), syncBatch)
+	if err != nil {
+		return 0, err
+	}
+	var due []string
+	for rows.Next() {
+		var sym string
+		if err := rows.Scan(&sym); err == nil {
+			due = append(due, sym)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var (
+		found  []Security
+		missed []string
+	)
+	for _, sym := range due {
+		sec, err := s.Provider.Profile(ctx, sym)
+		if err != nil {
+			if s.Log != nil {
+				s.Log.Warnf("security profile %s: %v", sym, err)
+			}
+			missed = append(missed, sym)
+			continue
+		}
+		if sec == nil {
+			missed = append(missed, sym)
+			continue
+		}
+		sec.Symbol, sec.Source = sym, SourceProvider
+		if err := sec.normalize(len(found)); err != nil {
+			if s.Log != nil {
+				s.Log.Warnf("skipping provider profile %s: %v", sym, err)
+			}
+			missed = append(missed, sym)
+			continue
+		}
+		found = append(found, *sec)
+	}
+	n, err := s.Upsert(ctx, found)
+	if err != nil {
+		return n, err
+	}
+	return n, s.markAttempted(ctx, missed)
+}
+
+// markAttempted records that the provider had nothing usable for symbols, so they wait
+// refreshAge like any other row instead of heading every batch (NULL updated_at sorts
+// first). A placeholder row is created for symbols without one; existing rows keep their data.
+func (s *Service) markAttempted(ctx context.Context, symbols []string) error {
+	for _, sym := range symbols {
+		if _, err := s.DB.Exec(ctx, `
+INSERT INTO securities (symbol, source, updated_at) VALUES ($1, $2, now())
+ON CONFLICT (symbol) DO UPDATE SET updated_at = now()
+`, sym, SourceProvider); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartCron runs Sync immediately and then every interval until stop is closed.
+func StartCron(svc *Service, every time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if n, err := svc.Sync(ctx); err != nil {
+			log.Warnf("securities sync error: %v", err)
+		} else if n > 0 {
+			log.Infof("securities: refreshed %d from provider", n)
+		}
+	}
+	run()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			run()
+		case <-stop:
+			log.Infof("securities cron stopped")
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package securities
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secCols = []string{"symbol", "name", "exchange", "sector", "industry", "country", "currency", "market_cap", "shares_outstanding", "source", "updated_at"}
+
+func TestParseCSV(t *testing.T) {
+	in := "\ufeffTicker,Company,Exchange,Sector,Industry,Country,Currency,MarketCap,Shares\n" +
+		"aapl,Apple Inc.,nasdaq,Technology,Consumer Electronics,us,usd,\"3,000,000,000,000\",15000000000\n" +
+		"\n" +
+		"SAP,SAP SE,XETRA,Technology,Software - Application,DE,EUR,,\n"
+
+	secs, err := ParseCSV(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Len(t, secs, 2)
+	assert.Equal(t, "AAPL", secs[0].Symbol)
+	assert.Equal(t, "NASDAQ", secs[0].Exchange)
+	assert.Equal(t, "US", secs[0].Country)
+	assert.Equal(t, "USD", secs[0].Currency)
+	require.NotNil(t, secs[0].MarketCap)
+	assert.Equal(t, 3e12, *secs[0].MarketCap)
+	assert.Equal(t, 15e9, *secs[0].SharesOutstanding)
+	assert.Equal(t, SourceCSV, secs[0].Source)
+	assert.Nil(t, secs[1].MarketCap)
+	assert.Equal(t, "EUR", secs[1].Currency)
+
+	_, err = ParseCSV(strings.NewReader("name,sector\nApple,Technology\n"))
+	assert.ErrorContains(t, err, "symbol")
+	_, err = ParseCSV(strings.NewReader("symbol,currency\nAAPL,dollars\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = ParseCSV(strings.NewReader("symbol,market_cap\nAAPL,big\n"))
+	assert.ErrorContains(t, err, "not a number")
+}
+
+func TestDefaultSeedIsValid(t *testing.T) {
+	secs, err := ParseCSV(strings.NewReader(string(defaultSeed)))
+	require.NoError(t, err)
+	assert.NotEmpty(t, secs)
+	for _, s := range secs {
+		assert.NotEmpty(t, s.Sector, s.Symbol)
+		assert.NotEmpty(t, s.Industry, s.Symbol)
+	}
+}
+
+func TestSectorsAndIndustries(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil)
+
+	rows := func() *pgxmock.Rows {
+		return pgxmock.NewRows(secCols).
+			AddRow("AAPL", "Apple Inc.", "NASDAQ", "Technology", "Consumer Electronics", "US", "USD", (*float64)(nil), (*float64)(nil), SourceSeed, time.Now()).
+			AddRow("SPY", "SPDR S&P 500", "NYSE", "", "", "US", "USD", (*float64)(nil), (*float64)(nil), SourceCSV, time.Now())
+	}
+	tickers := []string{"AAPL", "SPY", "ZZZ"}
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).WithArgs(tickers).WillReturnRows(rows())
+	mock.ExpectQuery(`FROM securities WHERE symbol = ANY`).WithArgs(tickers).WillReturnRows(rows())
+
+	sectors, err := svc.Sectors(context.Background(), tickers)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"AAPL": "Technology"}, sectors)
+	industries, err := svc.Industries(context.Background(), tickers)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"AAPL": "Consumer Electronics"}, industries)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type fakeProvider map[string]*Security
+
+func (f fakeProvider) Profile(ctx context.Context, symbol string) (*Security, error) {
+	if symbol == "FAIL" {
+		return nil, errors.New("boom")
+	}
+	return f[symbol], nil
+}
+
+func TestSync(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+	svc := NewService(mock, nil)
+
+	// Without a provider there is nothing to do.
+	n, err := svc.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	mc := 2.5e11
+	svc.SetProvider(fakeProvider{"XOM": {Name: "Exxon Mobil", Exchange: "nyse", Sector: "Energy", Industry: "Oil & Gas Integrated", Country: "US", MarketCap: &mc}})
+	mock.ExpectQuery(`LEFT JOIN securities s`).
+		WithArgs(SourceCSV, pgxmock.AnyArg(), syncBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"symbol"}).AddRow("FAIL").AddRow("UNKNOWN").AddRow("XOM"))
+	mock.ExpectExec(`UPSERT INTO securities`).
+		WithArgs("XOM", "Exxon Mobil", "NYSE", "Energy", "Oil & Gas Integrated", "US", "USD", &mc, (*float64)(nil), SourceProvider).
+		WillReturnResult(pgxmock.NewResult("UPSERT", 1))
+	// Symbols without a profile get a placeholder so they do not head the next batch again.
+	for _, sym := range []string{"FAIL", "UNKNOWN"} {
+		mock.ExpectExec(`INSERT INTO securities \(symbol, source, updated_at\)`).
+			WithArgs(sym, SourceProvider).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	}
+
+	n, err = svc.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
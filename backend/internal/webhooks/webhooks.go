@@ -0,0 +1,666 @@
+// Package webhooks posts events to subscribed URLs. Deliveries are queued in the database and
+// retried with exponential backoff until they succeed or run out of attempts (dead-lettered).
+// Every request is signed with the subscription's secret so receivers can verify it.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"stockchallenge/backend/internal/alerts"
+	"stockchallenge/backend/internal/db"
+	"stockchallenge/backend/internal/events"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// EventTypes are the events a subscription can receive.
+var EventTypes = []string{
+	events.TypeIngestComplete,
+	events.TypeRatingChange,
+	events.TypeAlert,
+	events.TypeRecommendationUpdate,
+}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Request headers. SignatureHeader is "sha256=" followed by the hex HMAC-SHA256, keyed with
+// the subscription secret, of TimestampHeader + "." + the body.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Defaults for NewService.
+const (
+	DefaultMaxAttempts = 10
+	DefaultBackoff     = 30 * time.Second
+	DefaultMaxBackoff  = 4 * time.Hour
+)
+
+// claimBatch is how many due deliveries DeliverDue claims at a time.
+const claimBatch = 100
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ErrBlockedAddress is returned when a webhook would connect to a loopback, private,
+// link-local or unspecified address outside the allowed networks.
+var ErrBlockedAddress = errors.New("webhook address is not public")
+
+// Sign returns the SignatureHeader value for a request.
+func Sign(secret, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at timestamp.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Subscription is a URL that receives EventTypes (every supported type when empty). The
+// secret is only returned when the subscription is created.
+type Subscription struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// InvalidSubscriptionError reports why a subscription was refused.
+type InvalidSubscriptionError struct {
+	Reason string
+}
+
+func (e *InvalidSubscriptionError) Error() string { return e.Reason }
+
+func (sub *Subscription) normalize() error {
+	sub.URL = strings.TrimSpace(sub.URL)
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &InvalidSubscriptionError{"url must be an absolute http or https URL"}
+	}
+	sub.Secret = strings.TrimSpace(sub.Secret)
+	if sub.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		sub.Secret = hex.EncodeToString(b)
+	} else if len(sub.Secret) < 16 {
+		return &InvalidSubscriptionError{"secret must be at least 16 characters"}
+	}
+	types := []string{}
+	seen := map[string]bool{}
+	for _, t := range sub.EventTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !supported(t) {
+			return &InvalidSubscriptionError{fmt.Sprintf("unknown event type %q; use %s", t, strings.Join(EventTypes, ", "))}
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sub.EventTypes = types
+	return nil
+}
+
+// checkHost refuses a URL whose host is a literal address, or localhost, that deliveries
+// could not reach anyway. Names are checked when a delivery connects.
+func (s *Service) checkHost(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return &InvalidSubscriptionError{"url must be an absolute http or https URL"}
+	}
+	host := u.Hostname()
+	addrs := []netip.Addr{}
+	if strings.EqualFold(host, "localhost") {
+		addrs = append(addrs, netip.MustParseAddr("127.0.0.1"), netip.IPv6Loopback())
+	} else if a, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, a)
+	}
+	for _, a := range addrs {
+		if s.permitted(a) {
+			return nil
+		}
+	}
+	if len(addrs) > 0 {
+		return &InvalidSubscriptionError{"url must point to a public address"}
+	}
+	return nil
+}
+
+func supported(typ string) bool {
+	for _, t := range EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event queued for one subscription, and the outcome of its last attempt.
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"` // nil unless pending
+	LastStatus     *int            `json:"last_status"`     // HTTP status of the last attempt
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// Service stores subscriptions and delivers queued events.
+type Service struct {
+	DB          db.DBTX
+	Log         *zap.SugaredLogger
+	Client      *http.Client
+	MaxAttempts int           // attempts before a delivery is dead-lettered
+	Backoff     time.Duration // wait after the first failed attempt, doubled after each one
+	MaxBackoff  time.Duration
+	now         func() time.Time
+	allowed     []netip.Prefix
+}
+
+// NewService returns a service whose client only connects to public addresses; see
+// AllowNetworks. The address is checked after DNS resolution, on every connection, so
+// redirects and rebinding cannot reach internal hosts either.
+func NewService(db db.DBTX, log *zap.SugaredLogger) *Service {
+	s := &Service{
+		DB:          db,
+		Log:         log,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		now:         time.Now,
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: s.checkDial}
+	s.Client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+	return s
+}
+
+// AllowNetworks lets deliveries reach the given CIDRs (e.g. "10.0.0.0/8") although they are
+// not public, for receivers on an internal network.
+func (s *Service) AllowNetworks(cidrs []string) error {
+	var allowed []netip.Prefix
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return fmt.Errorf("invalid network %q: %w", c, err)
+		}
+		allowed = append(allowed, p.Masked())
+	}
+	s.allowed = allowed
+	return nil
+}
+
+// permitted reports whether deliveries may connect to addr.
+func (s *Service) permitted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.allowed {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return !(addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified())
+}
+
+// checkDial refuses connections to addresses that are not permitted. It runs with the
+// resolved address, just before connecting.
+func (s *Service) checkDial(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !s.permitted(ap.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ap.Addr())
+	}
+	return nil
+}
+
+// backoff is the wait before retrying a delivery that has failed attempts times.
+func (s *Service) backoff(attempts int) time.Duration {
+	d := s.Backoff
+	for i := 1; i < attempts && d < s.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.MaxBackoff {
+		d = s.MaxBackoff
+	}
+	return d
+}
+
+const subCols = `id::STRING, url, event_types, enabled, created_at`
+
+func scanSubscriptions(rows pgx.Rows) ([]Subscription, error) {
+	defer rows.Close()
+	out := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.EventTypes, &sub.Enabled, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		if sub.EventTypes == nil {
+			sub.EventTypes = []string{}
+		}
+		out = append(out, sub)
+	}
+	return out, rows.Err()
+}
+
+// Create validates and stores a subscription for userID, generating a secret when none is
+// given. The returned subscription includes the secret.
+func (s *Service) Create(ctx context.Context, userID string, sub Subscription) (*Subscription, error) {
+	if err := sub.normalize(); err != nil {
+		return nil, err
+	}
+	if err := s.checkHost(sub.URL); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query(ctx, `
+INSERT INTO webhook_subscriptions (user_id, url, secret, event_types, enabled)
+VALUES ($1, $2, $3, $4, true)
+RETURNING `+subCols, userID, sub.URL, sub.Secret, sub.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := scanSubscriptions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	subs[0].Secret = sub.Secret
+	return &subs[0], nil
+}
+
+// List returns userID's subscriptions, oldest first, without their secrets.
+func (s *Service) List(ctx context.Context, userID string) ([]Subscription, error) {
+	rows, err := s.DB.Query(ctx, `SELECT `+subCols+` FROM webhook_subscriptions WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscriptions(rows)
+}
+
+// Delete removes a subscription and its deliveries. It returns pgx.ErrNoRows when userID has
+// no such subscription.
+func (s *Service) Delete(ctx context.Context, userID, id string) error {
+	if !uuidPattern.MatchString(id) {
+		return pgx.ErrNoRows
+	}
+	tag, err := s.DB.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	_, err = s.DB.Exec(ctx, `DELETE FROM webhook_deliveries WHERE subscription_id = $1`, id)
+	return err
+}
+
+// DeliveryQuery filters the delivery log.
+type DeliveryQuery struct {
+	Status string // pending, delivered or dead; all when empty
+	Limit  int
+}
+
+// Deliveries returns the delivery log of one of userID's subscriptions, newest first. It
+// returns pgx.ErrNoRows when userID has no such subscription.
+func (s *Service) Deliveries(ctx context.Context, userID, subscriptionID string, q DeliveryQuery) ([]Delivery, error) {
+	if !uuidPattern.MatchString(subscriptionID) {
+		return nil, pgx.ErrNoRows
+	}
+	var one int
+	if err := s.DB.QueryRow(ctx, `SELECT 1 FROM webhook_subscriptions WHERE id = $1 AND user_id = $2`, subscriptionID, userID).Scan(&one); err != nil {
+		return nil, err
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	rows, err := s.DB.Query(ctx, `
+SELECT id::STRING, subscription_id::STRING, event_type, payload, status, attempts, next_attempt_at,
+       last_status, last_error, created_at, delivered_at
+FROM webhook_deliveries
+WHERE subscription_id = $1 AND ($2 = '' OR status = $2)
+ORDER BY created_at DESC, id
+LIMIT $3
+`, subscriptionID, q.Status, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var payload string
+		var next time.Time
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventType, &payload, &d.Status, &d.Attempts, &next,
+			&d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		if d.Status == StatusPending {
+			d.NextAttemptAt = &next
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Redeliver queues a delivery of one of userID's subscriptions again, due now and with a fresh
+// set of attempts; typically a dead one. It returns pgx.ErrNoRows when there is no such
+// delivery.
+func (s *Service) Redeliver(ctx context.Context, userID, id string) error {
+	if !uuidPattern.MatchString(id) {
+		return pgx.ErrNoRows
+	}
+	tag, err := s.DB.Exec(ctx, `
+UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = $1
+WHERE id = $2 AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE user_id = $3)
+`, s.now().UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// Enqueue queues e for every enabled subscription that wants its type. Alerts only go to the
+// subscriptions of the rule's owner. It returns the number of deliveries queued.
+func (s *Service) Enqueue(ctx context.Context, e events.Event) (int, error) {
+	if !supported(e.Type) {
+		return 0, nil
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	owner := ""
+	if a, ok := e.Data.(alerts.Event); ok {
+		owner = a.UserID
+	}
+	tag, err := s.DB.Exec(ctx, `
+INSERT INTO webhook_deliveries (subscription_id, event_type, payload, next_attempt_at)
+SELECT id, $1, $2, $3 FROM webhook_subscriptions
+WHERE enabled AND (cardinality(event_types) = 0 OR $1 = ANY(event_types))
+  AND ($4 = '' OR user_id::STRING = $4)
+`, e.Type, string(payload), s.now().UTC(), owner)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+type claimed struct {
+	id, subscriptionID, eventType, payload string
+	attempts                               int
+}
+
+// DeliverDue sends the pending deliveries that are due and records the outcomes. A failed
+// delivery is retried after an exponential backoff, or dead-lettered after MaxAttempts. It
+// returns how many were delivered and how many failed.
+func (s *Service) DeliverDue(ctx context.Context) (delivered, failed int, err error) {
+	for {
+		batch, err := s.claim(ctx)
+		if err != nil {
+			return delivered, failed, err
+		}
+		if len(batch) == 0 {
+			return delivered, failed, nil
+		}
+		ids := make([]string, len(batch))
+		for i, d := range batch {
+			ids[i] = d.subscriptionID
+		}
+		subs, err := s.endpoints(ctx, ids)
+		if err != nil {
+			return delivered, failed, err
+		}
+		for _, d := range batch {
+			ok, err := s.attempt(ctx, d, subs)
+			if err != nil {
+				return delivered, failed, err
+			}
+			if ok {
+				delivered++
+			} else {
+				failed++
+			}
+		}
+		if len(batch) < claimBatch {
+			return delivered, failed, nil
+		}
+	}
+}
+
+// claim takes due deliveries by pushing their next attempt past the time sending them can
+// take, so another worker does not send them too.
+func (s *Service) claim(ctx context.Context) ([]claimed, error) {
+	now := s.now().UTC()
+	rows, err := s.DB.Query(ctx, `
+UPDATE webhook_deliveries SET next_attempt_at = $2
+WHERE id IN (
+  SELECT id FROM webhook_deliveries
+  WHERE status = 'pending' AND next_attempt_at <= $1
+  ORDER BY next_attempt_at
+  LIMIT $3
+)
+RETURNING id::STRING, subscription_id::STRING, event_type, payload, attempts
+`, now, now.Add(2*s.Client.Timeout+time.Minute), claimBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []claimed
+	for rows.Next() {
+		var d claimed
+		if err := rows.Scan(&d.id, &d.subscriptionID, &d.eventType, &d.payload, &d.attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+type endpoint struct {
+	url, secret string
+}
+
+// endpoints returns the URL and secret of the enabled subscriptions among ids.
+func (s *Service) endpoints(ctx context.Context, ids []string) (map[string]endpoint, error) {
+	rows, err := s.DB.Query(ctx, `SELECT id::STRING, url, secret FROM webhook_subscriptions WHERE id = ANY($1::UUID[]) AND enabled`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]endpoint{}
+	for rows.Next() {
+		var id string
+		var ep endpoint
+		if err := rows.Scan(&id, &ep.url, &ep.secret); err != nil {
+			return nil, err
+		}
+		out[id] = ep
+	}
+	return out, rows.Err()
+}
+
+// attempt sends one delivery and records the outcome. The error is only for failing to record.
+func (s *Service) attempt(ctx context.Context, d claimed, subs map[string]endpoint) (bool, error) {
+	attempts := d.attempts + 1
+	now := s.now().UTC()
+	var code *int
+	var sendErr error
+	ep, ok := subs[d.subscriptionID]
+	if !ok {
+		// Deleted or disabled since it was queued: nothing to retry.
+		attempts = s.MaxAttempts
+		sendErr = fmt.Errorf("subscription removed or disabled")
+	} else {
+		var status int
+		status, sendErr = s.send(ctx, ep, d)
+		if status != 0 {
+			code = &status
+		}
+	}
+
+	status, next := StatusDelivered, now
+	var lastErr *string
+	var deliveredAt *time.Time
+	if sendErr == nil {
+		deliveredAt = &now
+	} else {
+		msg := sendErr.Error()
+		lastErr = &msg
+		status, next = StatusPending, now.Add(s.backoff(attempts))
+		if attempts >= s.MaxAttempts {
+			status = StatusDead
+		}
+		if s.Log != nil {
+			s.Log.Warnf("webhook delivery %s attempt %d failed (%s): %v", d.id, attempts, status, sendErr)
+		}
+	}
+	_, err := s.DB.Exec(ctx, `
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, next_attempt_at = $4, last_status = $5, last_error = $6, delivered_at = $7
+WHERE id = $1
+`, d.id, status, attempts, next, code, lastErr, deliveredAt)
+	return sendErr == nil, err
+}
+
+// send posts the payload and returns the response status; any status outside 2xx is an error.
+func (s *Service) send(ctx context.Context, ep endpoint, d claimed) (int, error) {
+	body := []byte(d.payload)
+	ts := strconv.FormatInt(s.now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.eventType)
+	req.Header.Set(DeliveryHeader, d.id)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, Sign(ep.secret, ts, body))
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// The body is drained for connection reuse but never stored: the delivery log is readable
+	// through the API and must not relay what the receiver answered.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Run queues every supported event published on bus and delivers due deliveries: right after
+// queueing and every retry interval, until stop is closed. Events published while the worker
+// was behind the bus are not queued.
+func Run(svc *Service, bus *events.Bus, retry time.Duration, log *zap.SugaredLogger, stop <-chan struct{}) {
+	kick := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(retry)
+		defer t.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if ok, failed, err := svc.DeliverDue(ctx); err != nil {
+				log.Warnf("webhook delivery error: %v", err)
+			} else if ok+failed > 0 {
+				log.Infof("webhooks: %d delivered, %d failed", ok, failed)
+			}
+			cancel()
+			select {
+			case <-kick:
+			case <-t.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	sub := bus.Subscribe(0)
+	defer func() { sub.Close() }()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				log.Warnf("webhooks worker fell behind the event bus; some events were not queued")
+				sub = bus.Subscribe(0)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			n, err := svc.Enqueue(ctx, e)
+			cancel()
+			if err != nil {
+				log.Warnf("webhook enqueue error for %s: %v", e.Type, err)
+			} else if n > 0 {
+				select {
+				case kick <- struct{}{}:
+				default:
+				}
+			}
+		case <-stop:
+			<-done
+			log.Infof("webhooks worker stopped")
+			return
+		}
+	}
+}
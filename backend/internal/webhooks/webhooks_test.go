@@ -0,0 +1,191 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stockchallenge/backend/internal/alerts"
+	"stockchallenge/backend/internal/events"
+
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T) (*Service, pgxmock.PgxPoolIface, time.Time) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	t.Cleanup(mock.Close)
+	svc := NewService(mock, zap.NewNop().Sugar())
+	now := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	return svc, mock, now
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"alert"}`)
+	sig := Sign("s3cret-s3cret-s3cret", "1700000000", body)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, sig)
+	assert.True(t, Verify("s3cret-s3cret-s3cret", "1700000000", body, sig))
+	assert.False(t, Verify("s3cret-s3cret-s3cret", "1700000001", body, sig), "timestamp is signed")
+	assert.False(t, Verify("another-secret-value", "1700000000", body, sig))
+}
+
+func TestSubscriptionValidation(t *testing.T) {
+	for _, sub := range []Subscription{
+		{URL: "ftp://example.com/hook"},
+		{URL: "/relative"},
+		{URL: "https://example.com/hook", Secret: "short"},
+		{URL: "https://example.com/hook", EventTypes: []string{"quote"}},
+	} {
+		err := sub.normalize()
+		var invalid *InvalidSubscriptionError
+		assert.ErrorAs(t, err, &invalid, sub)
+	}
+
+	sub := Subscription{URL: " https://example.com/hook ", EventTypes: []string{"Alert", "alert", "rating_change"}}
+	require.NoError(t, sub.normalize())
+	assert.Equal(t, "https://example.com/hook", sub.URL)
+	assert.Len(t, sub.Secret, 64, "generated")
+	assert.Equal(t, []string{events.TypeAlert, events.TypeRatingChange}, sub.EventTypes)
+}
+
+func TestBackoff(t *testing.T) {
+	svc := NewService(nil, nil)
+	svc.Backoff, svc.MaxBackoff = time.Minute, 10*time.Minute
+	var got []time.Duration
+	for n := 1; n <= 6; n++ {
+		got = append(got, svc.backoff(n))
+	}
+	assert.Equal(t, []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}, got)
+}
+
+func TestEnqueue(t *testing.T) {
+	svc, mock, now := newTestService(t)
+
+	n, err := svc.Enqueue(context.Background(), events.Event{Type: events.TypeQuote, Ticker: "AAPL"})
+	require.NoError(t, err)
+	assert.Zero(t, n, "quotes are not offered")
+
+	e := events.Event{ID: 7, Type: events.TypeAlert, Ticker: "AAPL", Time: now,
+		Data: alerts.Event{ID: "e1", AlertID: "a1", Ticker: "AAPL", Message: "AAPL crossed above 200.00", UserID: "u1"}}
+	payload, _ := json.Marshal(e)
+	mock.ExpectExec(`INSERT INTO webhook_deliveries`).
+		WithArgs(events.TypeAlert, string(payload), now, "u1").
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	n, err = svc.Enqueue(context.Background(), e)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotContains(t, string(payload), "u1")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeliverDue(t *testing.T) {
+	svc, mock, now := newTestService(t)
+	svc.MaxAttempts = 3
+	require.NoError(t, svc.AllowNetworks([]string{"127.0.0.0/8", "::1/128"}))
+
+	const secret = "0123456789abcdef0123"
+	type received struct {
+		header http.Header
+		body   string
+	}
+	got := make(chan received, 4)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Clone(), string(b)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ok.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	mock.ExpectQuery(`UPDATE webhook_deliveries SET next_attempt_at`).
+		WithArgs(now, pgxmock.AnyArg(), claimBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "subscription_id", "event_type", "payload", "attempts"}).
+			AddRow("d1", "s-ok", events.TypeIngestComplete, `{"type":"ingest_complete"}`, 0).
+			AddRow("d2", "s-down", events.TypeRatingChange, `{"type":"rating_change"}`, 0).
+			AddRow("d3", "s-down", events.TypeRatingChange, `{"type":"rating_change"}`, 2).
+			AddRow("d4", "s-gone", events.TypeAlert, `{"type":"alert"}`, 0))
+	mock.ExpectQuery(`SELECT id::STRING, url, secret FROM webhook_subscriptions`).
+		WithArgs([]string{"s-ok", "s-down", "s-down", "s-gone"}).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "url", "secret"}).
+			AddRow("s-ok", ok.URL, secret).
+			AddRow("s-down", down.URL, secret))
+	update := func(id, status string, attempts int, next time.Time, code *int, lastErr any, delivered *time.Time) {
+		mock.ExpectExec(`UPDATE webhook_deliveries\s+SET status`).
+			WithArgs(id, status, attempts, next, code, lastErr, delivered).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	}
+	c204, c503 := 204, 503
+	status503 := "status=503" // the receiver's body is not kept
+	update("d1", StatusDelivered, 1, now, &c204, (*string)(nil), &now)
+	update("d2", StatusPending, 1, now.Add(DefaultBackoff), &c503, &status503, nil)
+	update("d3", StatusDead, 3, now.Add(4*DefaultBackoff), &c503, &status503, nil)
+	update("d4", StatusDead, 3, now.Add(4*DefaultBackoff), nil, pgxmock.AnyArg(), nil)
+
+	delivered, failed, err := svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 3, failed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	r := <-got
+	assert.Equal(t, `{"type":"ingest_complete"}`, r.body)
+	assert.Equal(t, events.TypeIngestComplete, r.header.Get(EventHeader))
+	assert.Equal(t, "d1", r.header.Get(DeliveryHeader))
+	assert.Equal(t, "1772463600", r.header.Get(TimestampHeader))
+	assert.True(t, Verify(secret, r.header.Get(TimestampHeader), []byte(r.body), r.header.Get(SignatureHeader)))
+}
+
+func TestPrivateAddressesAreRefused(t *testing.T) {
+	svc, mock, now := newTestService(t)
+
+	for _, u := range []string{"http://169.254.169.254/latest/meta-data", "http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://10.0.0.5/hook"} {
+		_, err := svc.Create(context.Background(), "u1", Subscription{URL: u})
+		var invalid *InvalidSubscriptionError
+		assert.ErrorAs(t, err, &invalid, u)
+	}
+
+	var hit atomic.Bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit.Store(true)
+	}))
+	defer internal.Close()
+	mock.ExpectQuery(`UPDATE webhook_deliveries SET next_attempt_at`).
+		WithArgs(now, pgxmock.AnyArg(), claimBatch).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "subscription_id", "event_type", "payload", "attempts"}).
+			AddRow("d1", "s1", events.TypeAlert, `{"type":"alert"}`, 0))
+	mock.ExpectQuery(`SELECT id::STRING, url, secret FROM webhook_subscriptions`).
+		WithArgs([]string{"s1"}).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "url", "secret"}).AddRow("s1", internal.URL, "0123456789abcdef0123"))
+	mock.ExpectExec(`UPDATE webhook_deliveries\s+SET status`).
+		WithArgs("d1", StatusPending, 1, now.Add(DefaultBackoff), (*int)(nil), pgxmock.AnyArg(), (*time.Time)(nil)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	_, failed, err := svc.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, failed)
+	assert.False(t, hit.Load(), "the request never reaches a loopback receiver")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = svc.send(context.Background(), endpoint{url: internal.URL, secret: "0123456789abcdef0123"}, claimed{id: "d1"})
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+
+	require.NoError(t, svc.AllowNetworks([]string{"127.0.0.0/8"}))
+	assert.NoError(t, svc.checkHost("http://localhost/hook"))
+	status, err := svc.send(context.Background(), endpoint{url: internal.URL, secret: "0123456789abcdef0123"}, claimed{id: "d1"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, hit.Load(), "allowed networks are reachable")
+	assert.Error(t, svc.AllowNetworks([]string{"10.0.0.0/33"}))
+}
@@ -7,6 +7,7 @@ import psycopg
 import uvicorn
 from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
+from yahooquery import Ticker
 import datetime as dt
 
 from eps_metric_free import compute_metrics, yahoo_price
@@ -32,6 +33,11 @@ class UpdateQuotesRequest(BaseModel):
     symbols: Optional[List[str]] = None
 
 
+class UpdateHistoryRequest(BaseModel):
+    symbols: Optional[List[str]] = None
+    start: Optional[str] = None  # YYYY-MM-DD; defaults to one year ago
+
+
 @app.post("/api/update/fundamentals")
 def update_fundamentals(req: UpdateFundamentalsRequest):
     if not req.symbols:
@@ -160,6 +166,55 @@ ON CONFLICT (symbol) DO UPDATE SET price=EXCLUDED.price, as_of=EXCLUDED.as_of, u
         print(f"[quotes] failed symbols: {failed_symbols}", flush=True)
     return {"updated": updated, "errors": errors, "symbols": syms, "failed_symbols": failed_symbols}
 
+@app.post("/api/update/history")
+def update_history(req: UpdateHistoryRequest):
+    """Backfill daily closes into daily_prices for performance reporting."""
+    syms = req.symbols
+    if not syms:
+        # default to every ticker in the transaction ledger
+        with get_db() as conn, conn.cursor() as cur:
+            cur.execute("SELECT DISTINCT ticker FROM portfolio_transactions")
+            syms = [r[0] for r in cur.fetchall()]
+    if not syms:
+        raise HTTPException(status_code=400, detail="no symbols")
+    start = req.start or (dt.date.today() - dt.timedelta(days=365)).isoformat()
+    rows_written = 0
+    failed_symbols = []
+    with get_db() as conn:
+        for sym in syms:
+            sym = sym.strip().upper()
+            try:
+                df = Ticker(sym).history(start=start, interval="1d")
+                if not hasattr(df, "iterrows") or df.empty:
+                    failed_symbols.append(sym)
+                    continue
+                df = df.reset_index()
+                with conn.cursor() as cur:
+                    for _, r in df.iterrows():
+                        close = r.get("close")
+                        if close is None or close != close:  # NaN
+                            continue
+                        adj = r.get("adjclose")
+                        if adj is not None and adj != adj:
+                            adj = None
+                        day = r["date"]
+                        day = day.date() if hasattr(day, "date") else day
+                        cur.execute(
+                            """
+INSERT INTO daily_prices(symbol, date, close, adj_close, updated_at)
+VALUES (%s, %s, %s, %s, now())
+ON CONFLICT (symbol, date) DO UPDATE SET close=EXCLUDED.close, adj_close=EXCLUDED.adj_close, updated_at=EXCLUDED.updated_at
+""",
+                            (sym, day, float(close), None if adj is None else float(adj)),
+                        )
+                        rows_written += 1
+            except Exception as e:
+                failed_symbols.append(sym)
+                print(f"[history] {sym} error: {e}", flush=True)
+    print(f"[history] rows={rows_written} symbols={len(syms)} failed={failed_symbols}", flush=True)
+    return {"rows": rows_written, "symbols": syms, "failed_symbols": failed_symbols}
+
+
 @app.get("/healthz")
 def healthz():
     # Basic readiness; DB connection tested lazily in endpoints
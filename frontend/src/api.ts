@@ -0,0 +1,30 @@
+import axios from 'axios';
+
+// All requests go to the versioned API; the unversioned /api paths are deprecated.
+export const api = axios.create({ baseURL: '/api/v1' });
+
+// Error object the backend answers with as { error: ApiError }.
+export type ApiError = {
+  code: string;
+  message: string;
+  details?: unknown;
+  request_id: string;
+};
+
+// apiError returns the backend's error object from a failed request, if it sent one.
+export function apiError(e: any): ApiError | null {
+  const err = e?.response?.data?.error;
+  return err && typeof err === 'object' && typeof err.message === 'string' ? err : null;
+}
+
+// errorMessage is a message to show for a failed request, with the request id for support.
+export function errorMessage(e: any, fallback = 'Request failed'): string {
+  const err = apiError(e);
+  if (err) return err.request_id ? `${err.message} (request ${err.request_id})` : err.message;
+  return e?.message || fallback;
+}
+
+// isNotFound tells a missing resource apart from a failing backend.
+export function isNotFound(e: any): boolean {
+  return e?.response?.status === 404;
+}
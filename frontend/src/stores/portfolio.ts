@@ -1,5 +1,5 @@
 import { defineStore } from 'pinia';
-import axios from 'axios';
+import { api, errorMessage } from '../api';
 
 export type PortfolioItem = {
   ticker: string;
@@ -339,7 +339,7 @@ export const usePortfolioStore = defineStore('portfolio', {
 
     async updateItemPrice(item: PortfolioItem) {
       try {
-        const response = await axios.get(`/api/stocks/${item.ticker}`);
+        const response = await api.get(`/stocks/${item.ticker}`);
         const data = response.data;
         if (data.current_price) {
           item.currentPrice = data.current_price;
@@ -351,7 +351,7 @@ export const usePortfolioStore = defineStore('portfolio', {
         }
       } catch (e) {
         try {
-          const quoteResponse = await axios.get(`/api/quotes/${item.ticker}`);
+          const quoteResponse = await api.get(`/quotes/${item.ticker}`);
           const quoteData = quoteResponse.data;
           if (quoteData.current_price) {
             item.currentPrice = quoteData.current_price;
@@ -378,13 +378,23 @@ export const usePortfolioStore = defineStore('portfolio', {
       formData.append('image', file);
 
       try {
-        const response = await axios.post('/api/portfolio/upload', formData, {
+        const response = await api.post('/portfolio/upload', formData, {
           headers: {
             'Content-Type': 'multipart/form-data',
           },
         });
 
-        if (response.status === 200) {
+        // Upload creates a pending import; commit it (merging with existing holdings)
+        // only when every extracted row passed validation.
+        const pending = response.data;
+        const errors = (pending.issues || []).filter((i: any) => i.severity === 'error');
+        if (errors.length > 0) {
+          this.portfolioUploadError = `Could not read ${errors.length} field(s) from the screenshot. Please review import ${pending.id} or enter positions manually.`;
+          return;
+        }
+        const commit = await api.post(`/portfolio/imports/${pending.id}/commit`, { mode: 'merge' });
+
+        if (commit.status === 200) {
           if (isDemo) {
             await this.fetchBackendDemoPortfolio();
           } else {
@@ -393,7 +403,7 @@ export const usePortfolioStore = defineStore('portfolio', {
         }
       } catch (error: any) {
         console.error('Portfolio upload failed:', error);
-        this.portfolioUploadError = error.response?.data?.error || 'Failed to upload portfolio image. Please try again.';
+        this.portfolioUploadError = errorMessage(error, 'Failed to upload portfolio image. Please try again.');
       } finally {
         this.portfolioUploading = false;
       }
@@ -401,7 +411,7 @@ export const usePortfolioStore = defineStore('portfolio', {
 
     async fetchBackendPortfolio() {
       try {
-        const response = await axios.get('/api/portfolio');
+        const response = await api.get('/portfolio');
         const backendPortfolio = response.data.items || [];
         
         const existingTickers = new Set(this.portfolioItems.map(item => item.ticker));
@@ -427,7 +437,7 @@ export const usePortfolioStore = defineStore('portfolio', {
 
     async fetchBackendDemoPortfolio() {
       try {
-        const response = await axios.get('/api/portfolio');
+        const response = await api.get('/portfolio');
         const backendPortfolio = response.data.items || [];
         
         const existingTickers = new Set(this.demoPortfolioItems.map(item => item.ticker));
@@ -1,5 +1,5 @@
 import { defineStore } from 'pinia';
-import axios from 'axios';
+import { api, errorMessage, isNotFound } from '../api';
 
 export type StockItem = {
   id: string;
@@ -83,24 +83,16 @@ export const useStockStore = defineStore('stock', {
         const limit = params.pageSize || 20;
         const enrich = params.enrich === true;
 
-        let url = '/api/stocks';
-        const query: Record<string, any> = { page, limit };
+        // /stocks combines search and sorting, including enriched fields like percent_upside
+        const query: Record<string, any> = { page, limit, sort: field, order };
         if (enrich) query.enrich = 'true';
+        if (search) query.q = search;
 
-        if (search) {
-          url = '/api/stocks/search';
-          query.q = search;
-        } else if (field !== 'updated_at' || order !== 'DESC') {
-          url = '/api/stocks/sort';
-          query.field = field;
-          query.order = order;
-        }
-
-        const { data } = await axios.get(url, { params: query, timeout: enrich ? 15000 : 5000 });
+        const { data } = await api.get('/stocks', { params: query, timeout: enrich ? 15000 : 5000 });
         this.stocks.items = data.items ?? [];
         this.stocks.total = data.total ?? this.stocks.items.length;
       } catch (e: any) {
-        this.stocks.error = e?.message || 'Failed to load';
+        this.stocks.error = errorMessage(e, 'Failed to load');
       } finally {
         this.stocks.loading = false;
       }
@@ -110,10 +102,10 @@ export const useStockStore = defineStore('stock', {
       this.recommendations.error = null;
       try {
         // Bound the wait so UI doesn't spin forever on slow upstreams
-        const { data } = await axios.get('/api/recommendations', { timeout: 5000 });
+        const { data } = await api.get('/recommendations', { timeout: 5000 });
         this.recommendations.items = data.items ?? [];
       } catch (e: any) {
-        this.recommendations.error = e?.message || 'Failed to load';
+        this.recommendations.error = errorMessage(e, 'Failed to load');
       } finally {
         this.recommendations.loading = false;
       }
@@ -123,12 +115,16 @@ export const useStockStore = defineStore('stock', {
       this.detail.error = null;
       try {
         // First try the stocks endpoint (for stocks with recommendations)
-        const { data } = await axios.get(`/api/stocks/${ticker}`);
+        const { data } = await api.get(`/stocks/${ticker}`);
         this.detail.item = data;
       } catch (e: any) {
+        if (!isNotFound(e)) {
+          this.detail.error = errorMessage(e, 'Failed to load');
+          return;
+        }
         try {
-          // If stocks endpoint fails (e.g., ETFs not in stocks table), try quotes endpoint
-          const quoteResponse = await axios.get(`/api/quotes/${ticker}`);
+          // Not in the stocks table (e.g., ETFs): try the quotes endpoint
+          const quoteResponse = await api.get(`/quotes/${ticker}`);
           const quoteData = quoteResponse.data;
           
           // Transform quote data to match StockItem interface
@@ -163,17 +159,17 @@ export const useStockStore = defineStore('stock', {
       this.watchlist.loading = true;
       this.watchlist.error = null;
       try {
-        const { data } = await axios.get('/api/watchlist');
+        const { data } = await api.get('/watchlist');
         this.watchlist.items = data.items ?? [];
       } catch (e: any) {
-        this.watchlist.error = e?.message || 'Failed to load';
+        this.watchlist.error = errorMessage(e, 'Failed to load');
       } finally {
         this.watchlist.loading = false;
       }
     },
     async addToWatchlist(ticker: string) {
       try {
-        await axios.post('/api/watchlist', { ticker });
+        await api.post('/watchlist', { ticker });
         await this.fetchWatchlist(); // Refresh watchlist
       } catch (e: any) {
         console.error('Failed to add to watchlist', e);
@@ -181,7 +177,7 @@ export const useStockStore = defineStore('stock', {
     },
     async removeFromWatchlist(ticker: string) {
       try {
-        await axios.delete(`/api/watchlist/${ticker}`);
+        await api.delete(`/watchlist/${ticker}`);
         await this.fetchWatchlist(); // Refresh watchlist
       } catch (e: any) {
         console.error('Failed to remove from watchlist', e);